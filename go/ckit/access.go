@@ -0,0 +1,125 @@
+package ckit
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// AccessList allows or denies requests by client address. The list is read
+// from a file with one rule per line, "allow" or "deny" followed by an
+// address or a network in CIDR notation; empty lines and lines starting with
+// # are ignored:
+//
+//	# campus network only, but not the crawler
+//	allow 141.30.0.0/16
+//	deny 141.30.12.34
+//
+// Deny rules take precedence. Without allow rules, all addresses, that are
+// not denied, are allowed. Behind a proxy, cf. Server.TrustedProxies.
+type AccessList struct {
+	Filename string
+
+	mu          sync.RWMutex
+	allow, deny []*net.IPNet
+}
+
+// LoadAccessList reads an access list from a file.
+func LoadAccessList(filename string) (*AccessList, error) {
+	l := &AccessList{Filename: filename}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload reads the file again, e.g. after a change; on error, the current
+// rules stay in place.
+func (l *AccessList) Reload() error {
+	f, err := os.Open(l.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	allow, deny, err := parseAccessList(bufio.NewScanner(f))
+	if err != nil {
+		return fmt.Errorf("%s: %w", l.Filename, err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.allow, l.deny = allow, deny
+	return nil
+}
+
+// parseAccessList parses rules, line by line.
+func parseAccessList(sc *bufio.Scanner) (allow, deny []*net.IPNet, err error) {
+	var i int
+	for sc.Scan() {
+		i++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("line %d: want rule and address, got %q", i, line)
+		}
+		n, err := parseNetwork(fields[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", i, err)
+		}
+		switch fields[0] {
+		case "allow":
+			allow = append(allow, n)
+		case "deny":
+			deny = append(deny, n)
+		default:
+			return nil, nil, fmt.Errorf("line %d: unknown rule: %s", i, fields[0])
+		}
+	}
+	return allow, deny, sc.Err()
+}
+
+// Allowed returns true, if requests from an address are allowed.
+func (l *AccessList) Allowed(ip net.IP) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, n := range l.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(l.allow) == 0 {
+		return true
+	}
+	for _, n := range l.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware rejects requests from addresses, that are not allowed, with
+// 403 Forbidden. Internal requests, e.g. for events or jobs, are let through.
+func (l *AccessList) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isInternal(r.Context()) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if ip := net.ParseIP(host); ip == nil || !l.Allowed(ip) {
+			http.Error(w, `{"msg": "forbidden", "status": 403}`, http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
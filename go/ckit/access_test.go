@@ -0,0 +1,121 @@
+package ckit
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+)
+
+func TestAccessList(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "access.txt")
+	rules := "# campus\nallow 141.30.0.0/16\n\ndeny 141.30.12.34\n"
+	if err := ioutil.WriteFile(filename, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := LoadAccessList(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		ip   string
+		want bool
+	}{
+		{"141.30.1.1", true},
+		{"141.30.12.34", false},
+		{"8.8.8.8", false},
+	}
+	for _, c := range cases {
+		if got := l.Allowed(net.ParseIP(c.ip)); got != c.want {
+			t.Fatalf("%s: got %v, want %v", c.ip, got, c.want)
+		}
+	}
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = net.JoinHostPort(c.ip, "1234")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Code == http.StatusOK; got != c.want {
+			t.Fatalf("%s: got status %v", c.ip, w.Code)
+		}
+	}
+	// Without allow rules, everything, that is not denied, is allowed.
+	if err := ioutil.WriteFile(filename, []byte("deny 141.30.12.34\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !l.Allowed(net.ParseIP("8.8.8.8")) || l.Allowed(net.ParseIP("141.30.12.34")) {
+		t.Fatalf("reload: rules not applied")
+	}
+	// Broken files keep the current rules.
+	if err := ioutil.WriteFile(filename, []byte("block 8.8.8.8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reload(); err == nil {
+		t.Fatalf("reload: got nil, want error")
+	}
+	if !l.Allowed(net.ParseIP("8.8.8.8")) {
+		t.Fatalf("reload: got new rules after error")
+	}
+}
+
+func TestAccessListInternal(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "access.txt")
+	if err := ioutil.WriteFile(filename, []byte("allow 127.0.0.0/8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := LoadAccessList(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newMemoryStore()
+	h := newHarness(t, func(s *Server) {
+		s.Cache = store
+		s.Jobs = NewJobs(t.TempDir(), 1)
+		s.Middleware = []Middleware{l.Middleware}
+	})
+	defer h.srv.Jobs.Close()
+	id, _ := h.someID(t)
+	// Events, jobs and refreshes run requests without a client address.
+	resp, b := h.do(t, "GET", "/id/"+id+"/events")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(b), "event: result") {
+		t.Fatalf("events: got %v, %s", resp.StatusCode, b)
+	}
+	resp, err = h.client.Post(h.ts.URL+"/jobs", "application/json", strings.NewReader(`{"id": "`+id+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job Job
+	err = json.NewDecoder(resp.Body).Decode(&job)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; job.Status != JobDone; i++ {
+		if i == 100 || job.Status == JobFailed {
+			t.Fatalf("job did not finish: %v", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+		_, b = h.do(t, "GET", "/jobs/"+job.ID)
+		if err := json.Unmarshal(b, &job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, b = h.do(t, "GET", job.Result)
+	if strings.Contains(string(b), "forbidden") {
+		t.Fatalf("job: got %s", b)
+	}
+	if ok, err := h.srv.refresh(context.Background(), id, 0); !ok || err != nil {
+		t.Fatalf("refresh: got %v, %v, want true, nil", ok, err)
+	}
+}
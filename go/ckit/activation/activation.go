@@ -0,0 +1,54 @@
+//go:build linux
+
+// Package activation implements the receiving end of systemd socket
+// activation, cf. sd_listen_fds(3). With socket activation, systemd owns the
+// listening socket and passes it to labed on start; connections arriving
+// while labed restarts (e.g. after a data rotation) are queued by the kernel
+// instead of being refused.
+//
+//	# /etc/systemd/system/labed.socket
+//	[Socket]
+//	ListenStream=0.0.0.0:8000
+package activation
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd, SD_LISTEN_FDS_START.
+const listenFdsStart = 3
+
+// Listeners returns the listeners passed by systemd. If the process has not
+// been socket activated, Listeners returns an empty slice and no error. The
+// environment variables are unset, so they are not passed on to child
+// processes.
+func Listeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds == 0 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	var listeners []net.Listener
+	for fd := listenFdsStart; fd < listenFdsStart+nfds; fd++ {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+		ln, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("activation: fd %d: %w", fd, err)
+		}
+		// FileListener dups the descriptor, so we can close the original.
+		f.Close()
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package activation
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestListenersNotActivated(t *testing.T) {
+	var cases = []struct {
+		pid string
+		fds string
+	}{
+		{"", ""},
+		{"1", "1"},
+		{strconv.Itoa(os.Getpid()), "0"},
+		{strconv.Itoa(os.Getpid()), "x"},
+	}
+	for _, c := range cases {
+		os.Setenv("LISTEN_PID", c.pid)
+		os.Setenv("LISTEN_FDS", c.fds)
+		ls, err := Listeners()
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if len(ls) != 0 {
+			t.Fatalf("got %d listeners, want 0", len(ls))
+		}
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+}
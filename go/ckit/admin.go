@@ -0,0 +1,35 @@
+package ckit
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// hasBearerToken returns true, if a request carries a given, non-empty
+// bearer token.
+func hasBearerToken(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	var (
+		got  = []byte(r.Header.Get("Authorization"))
+		want = []byte("Bearer " + token)
+	)
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// admin restricts a handler to clients sending the admin token, cf.
+// Server.AdminToken. Without a configured token, admin routes are disabled.
+func (s *Server) admin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case s.AdminToken == "":
+			httpErrLogf(w, http.StatusForbidden, "admin routes disabled, no admin token configured")
+		case !hasBearerToken(r, s.AdminToken):
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			httpErrLogf(w, http.StatusUnauthorized, "admin token required")
+		default:
+			h(w, r)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package ckit
+
+import (
+	"github.com/segmentio/encoding/json"
+)
+
+// AgeHistogram counts citations by age in years, that is the publication
+// year of the citing document minus the year of the cited document.
+type AgeHistogram map[int]int
+
+// parseYear returns the first plausible year from a value, e.g. 2019 from
+// 2019, "2019-03-01" or ["2019"].
+func parseYear(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case float64:
+		if v >= 1000 && v <= 9999 {
+			return int(v), true
+		}
+	case string:
+		for i := 0; i+4 <= len(v); i++ {
+			year, ok := 0, true
+			for j := i; j < i+4; j++ {
+				if v[j] < '0' || v[j] > '9' {
+					ok = false
+					break
+				}
+				year = year*10 + int(v[j]-'0')
+			}
+			if ok && year >= 1000 && !isDigitAt(v, i-1) && !isDigitAt(v, i+4) {
+				return year, true
+			}
+		}
+	case []interface{}:
+		for _, w := range v {
+			if year, ok := parseYear(w); ok {
+				return year, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// blobYear returns the publication year of a document, from the first of
+// Server.YearFields, that contains a year.
+func (s *Server) blobYear(b []byte) (int, bool) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return 0, false
+	}
+	for _, f := range s.YearFields {
+		if year, ok := parseYear(doc[f]); ok {
+			return year, true
+		}
+	}
+	return 0, false
+}
+
+// recordYear keeps the publication year of an included document.
+func (r *Response) recordYear(id string, year int) {
+	if r.years == nil {
+		r.years = make(map[string]int)
+	}
+	r.years[id] = year
+}
+
+// updateCitationAges computes the citation age histograms for the documents
+// cited by the requested one ("citing") and the documents citing it
+// ("cited"), if the publication year of the requested document is known.
+func (r *Response) updateCitationAges(citing, cited []string) {
+	if r.year == 0 {
+		return
+	}
+	r.Extra.CitationAges = map[string]AgeHistogram{
+		"citing": make(AgeHistogram),
+		"cited":  make(AgeHistogram),
+	}
+	for _, id := range citing {
+		if year, ok := r.years[id]; ok {
+			r.Extra.CitationAges["citing"][r.year-year]++
+		}
+	}
+	for _, id := range cited {
+		if year, ok := r.years[id]; ok {
+			r.Extra.CitationAges["cited"][year-r.year]++
+		}
+	}
+}
+
+// isDigitAt returns true, if there is a digit at a given position.
+func isDigitAt(s string, i int) bool {
+	return i >= 0 && i < len(s) && s[i] >= '0' && s[i] <= '9'
+}
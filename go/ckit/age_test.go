@@ -0,0 +1,65 @@
+package ckit
+
+import (
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+)
+
+func TestParseYear(t *testing.T) {
+	var cases = []struct {
+		v    interface{}
+		want int
+		ok   bool
+	}{
+		{nil, 0, false},
+		{2019.0, 2019, true},
+		{12.0, 0, false},
+		{"2019", 2019, true},
+		{"2019-03-01", 2019, true},
+		{"ca. 1850", 1850, true},
+		{"12345 2001", 2001, true},
+		{"n.d.", 0, false},
+		{[]interface{}{"", "2020"}, 2020, true},
+		{true, 0, false},
+	}
+	for _, c := range cases {
+		got, ok := parseYear(c.v)
+		if got != c.want || ok != c.ok {
+			t.Fatalf("parseYear(%v): got %v, %v, want %v, %v", c.v, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestCitationAges(t *testing.T) {
+	var (
+		s        = &Server{YearFields: []string{"publishDateSort", "publishDate"}}
+		response = &Response{year: 2010}
+		blobs    = map[string]string{
+			"1": `{"publishDateSort": "2005"}`,
+			"2": `{"publishDate": ["2008"]}`,
+			"3": `{"title": "no year"}`,
+			"4": `{"publishDateSort": "2012"}`,
+			"5": `{"publishDateSort": 2012}`,
+		}
+	)
+	for id, blob := range blobs {
+		if _, err := s.prepareBlob(response, id, []byte(blob)); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+	}
+	response.updateCitationAges([]string{"1", "2", "3"}, []string{"4", "5", "6"})
+	b, err := json.Marshal(response.Extra.CitationAges)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	want := `{"cited":{"2":2},"citing":{"2":1,"5":1}}`
+	if string(b) != want {
+		t.Fatalf("got %s, want %s", b, want)
+	}
+	response = &Response{}
+	response.updateCitationAges([]string{"1"}, nil)
+	if response.Extra.CitationAges != nil {
+		t.Fatalf("got %v, want nil", response.Extra.CitationAges)
+	}
+}
@@ -0,0 +1,142 @@
+package ckit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/segmentio/encoding/json"
+)
+
+// DefaultAnalyticsDriver is the database/sql driver of an analytical
+// database; it is not part of labed and must be added to the build.
+const DefaultAnalyticsDriver = "duckdb"
+
+// OpenAnalytics opens an analytical database, e.g. a DuckDB file with a
+// copy of the citation edges in a map(k, v) table, like the oci database.
+// The driver must be registered, e.g. by importing
+// github.com/marcboeker/go-duckdb.
+func OpenAnalytics(driver, dsn string) (*sqlx.DB, error) {
+	if !SliceContains(sql.Drivers(), driver) {
+		return nil, fmt.Errorf("analytics: driver %q not available, add it to the build, e.g. github.com/marcboeker/go-duckdb", driver)
+	}
+	return sqlx.Open(driver, dsn)
+}
+
+// analyticsDB returns the database for queries, that scan many edges: the
+// analytical database, if set, otherwise the oci database.
+func (s *Server) analyticsDB() *sqlx.DB {
+	if s.Analytics != nil {
+		return s.Analytics
+	}
+	return s.ociDB()
+}
+
+// PrefixCount is a DOI or a DOI prefix with a number of edges.
+type PrefixCount struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// PrefixTop contains the most cited DOI under a DOI prefix and the prefixes
+// citing it most often, usually publishers.
+type PrefixTop struct {
+	Prefix         string        `json:"prefix"`
+	Cited          []PrefixCount `json:"cited"`
+	CitingPrefixes []PrefixCount `json:"citing_prefixes"`
+	Extra          struct {
+		Cached bool    `json:"cached"`
+		Took   float64 `json:"took"`
+	} `json:"extra"`
+}
+
+// prefixTop finds the n most cited DOI under a prefix and the n prefixes
+// citing it most often. The queries group all edges to a prefix, which a
+// columnar database does much faster than sqlite.
+func (s *Server) prefixTop(ctx context.Context, prefix string, n int) (*PrefixTop, error) {
+	var (
+		pt     = &PrefixTop{Prefix: prefix, Cited: []PrefixCount{}, CitingPrefixes: []PrefixCount{}}
+		lo, hi = prefixRange(prefix)
+		db     = s.analyticsDB()
+	)
+	ctx, cancel := withTimeout(ctx, s.OciTimeout)
+	defer cancel()
+	t := time.Now()
+	if err := s.selectContext(ctx, db, &pt.Cited, `
+		SELECT v AS key, COUNT(*) AS count FROM map
+		WHERE v >= ? AND v < ?
+		GROUP BY v ORDER BY count DESC, key LIMIT ?`, lo, hi, n); err != nil {
+		return nil, err
+	}
+	if err := s.selectContext(ctx, db, &pt.CitingPrefixes, `
+		SELECT substr(k, 1, instr(k, '/') - 1) AS key, COUNT(*) AS count FROM map
+		WHERE v >= ? AND v < ? AND instr(k, '/') > 0
+		GROUP BY key ORDER BY count DESC, key LIMIT ?`, lo, hi, n); err != nil {
+		return nil, err
+	}
+	s.Stats.MeasureSinceWithLabels("sql_query", t, nil)
+	return pt, nil
+}
+
+// handlePrefixTop returns the most cited DOI under a DOI prefix and the
+// prefixes citing it most often, e.g. /prefix/10.1073/top?n=20; n defaults to
+// 10. Results are kept for an hour, like prefix statistics.
+func (s *Server) handlePrefixTop() http.HandlerFunc {
+	var cache topCache
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			started = time.Now()
+			prefix  = mux.Vars(r)["prefix"]
+			n       = 10
+			err     error
+		)
+		if !prefixPattern.MatchString(prefix) {
+			httpErrLogf(w, http.StatusBadRequest, "prefix: invalid DOI prefix: %q", prefix)
+			return
+		}
+		if v := r.URL.Query().Get("n"); v != "" {
+			if n, err = strconv.Atoi(v); err != nil || n < 1 || n > maxTopN {
+				httpErrLogf(w, http.StatusBadRequest, "prefix: n must be between 1 and %d", maxTopN)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		key := fmt.Sprintf("%s@%d", prefix, n)
+		if b, ok := cache.get(key); ok {
+			var pt PrefixTop
+			if err := json.Unmarshal(b, &pt); err == nil {
+				pt.Extra.Cached = true
+				pt.Extra.Took = time.Since(started).Seconds()
+				json.NewEncoder(w).Encode(pt)
+				return
+			}
+		}
+		pt, err := s.prefixTop(r.Context(), prefix, n)
+		switch {
+		case errors.Is(err, context.Canceled):
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			httpErrLogf(w, http.StatusGatewayTimeout, "prefix: %w", err)
+			return
+		case err != nil:
+			httpErrLogf(w, http.StatusInternalServerError, "prefix: %w", err)
+			return
+		}
+		pt.Extra.Took = time.Since(started).Seconds()
+		b, err := json.Marshal(pt)
+		if err != nil {
+			httpErrLog(w, http.StatusInternalServerError, err)
+			return
+		}
+		b = append(b, '\n')
+		cache.set(key, b)
+		s.Stats.MeasureSinceWithLabels("prefix_top", started, nil)
+		w.Write(b)
+	}
+}
@@ -0,0 +1,76 @@
+package ckit
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/fakedata"
+)
+
+func TestOpenAnalytics(t *testing.T) {
+	if _, err := OpenAnalytics("no-such-driver", "x.db"); err == nil {
+		t.Fatalf("got nil, want error for unknown driver")
+	}
+	db, err := OpenAnalytics(sqliteDriver, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+}
+
+func TestPrefixTop(t *testing.T) {
+	// Citing DOI in k, cited DOI in v, like the oci database.
+	edges := []fakedata.Row{
+		{Key: "10.1/a", Value: "10.2/x"},
+		{Key: "10.1/b", Value: "10.2/x"},
+		{Key: "10.3/c", Value: "10.2/x"},
+		{Key: "10.1/a", Value: "10.2/y"},
+		{Key: "10.1/a", Value: "10.20/z"},
+	}
+	analytics := memoryDatabase(t, edges)
+	for _, withAnalytics := range []bool{false, true} {
+		h := newHarness(t, func(s *Server) {
+			if withAnalytics {
+				s.Analytics = analytics
+			}
+		})
+		resp, b := h.do(t, "GET", "/prefix/10.2/top?n=1")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %v, want 200: %s", resp.StatusCode, b)
+		}
+		var pt PrefixTop
+		if err := json.Unmarshal(b, &pt); err != nil {
+			t.Fatal(err)
+		}
+		if !withAnalytics {
+			// The fake citation graph has no DOI under this prefix.
+			if len(pt.Cited) != 0 || len(pt.CitingPrefixes) != 0 {
+				t.Fatalf("got %v, want empty lists from oci database", pt)
+			}
+			continue
+		}
+		if len(pt.Cited) != 1 || pt.Cited[0] != (PrefixCount{Key: "10.2/x", Count: 3}) {
+			t.Fatalf("got %v, want 10.2/x with 3 citations", pt.Cited)
+		}
+		if len(pt.CitingPrefixes) != 1 || pt.CitingPrefixes[0] != (PrefixCount{Key: "10.1", Count: 3}) {
+			t.Fatalf("got %v, want 10.1 with 3 citations", pt.CitingPrefixes)
+		}
+		var ps PrefixStats
+		resp, b = h.do(t, "GET", "/prefix/10.2/stats")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %v, want 200: %s", resp.StatusCode, b)
+		}
+		if err := json.Unmarshal(b, &ps); err != nil {
+			t.Fatal(err)
+		}
+		if ps.Cited != 4 || ps.DOI != 2 {
+			t.Fatalf("got %d cited, %d DOI, want 4, 2", ps.Cited, ps.DOI)
+		}
+		for _, path := range []string{"/prefix/10.2/top?n=0", "/prefix/10.2/top?n=x", "/prefix/11.2/top"} {
+			if resp, _ := h.do(t, "GET", path); resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("%s: got %v, want 400", path, resp.StatusCode)
+			}
+		}
+	}
+}
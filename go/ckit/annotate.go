@@ -0,0 +1,73 @@
+package ckit
+
+import (
+	"bytes"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/set"
+)
+
+// annotate adds a field to a document included in the response, identified
+// by its local id or, for unmatched documents, by its DOI. Annotations are
+// added to the blob as is; the blob wins, if it contains the same field.
+func (r *Response) annotate(key, field string, value interface{}) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	if r.annotations == nil {
+		r.annotations = make(map[string][]byte)
+	}
+	fragment := r.annotations[key]
+	if len(fragment) > 0 {
+		fragment = append(fragment, ',')
+	}
+	fragment = append(fragment, '"')
+	fragment = append(fragment, field...)
+	fragment = append(fragment, `":`...)
+	r.annotations[key] = append(fragment, b...)
+}
+
+// annotateDOI annotates documents with a value per DOI: matched documents by
+// their local ids, other documents, e.g. unmatched ones, by DOI.
+func (r *Response) annotateDOI(ids []Map, matched, dois set.Set, field string, value func(doi string) (interface{}, bool)) {
+	for _, v := range ids {
+		if x, ok := value(v.Value); ok {
+			r.annotate(v.Key, field, x)
+		}
+	}
+	for doi := range dois {
+		if matched.Contains(doi) {
+			continue
+		}
+		if x, ok := value(doi); ok {
+			r.annotate(doi, field, x)
+		}
+	}
+}
+
+// annotated returns a blob with the annotations for a given key.
+func (r *Response) annotated(key string, b []byte) []byte {
+	fragment, ok := r.annotations[key]
+	if !ok {
+		return b
+	}
+	return insertFields(b, fragment)
+}
+
+// insertFields adds a fragment of fields, like `"a":1,"b":2` to a JSON
+// object; other values are returned as is.
+func insertFields(b, fragment []byte) []byte {
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) < 2 || trimmed[0] != '{' {
+		return b
+	}
+	rest := bytes.TrimSpace(trimmed[1:])
+	result := make([]byte, 0, len(trimmed)+len(fragment)+2)
+	result = append(result, '{')
+	result = append(result, fragment...)
+	if rest[0] != '}' {
+		result = append(result, ',')
+	}
+	return append(result, rest...)
+}
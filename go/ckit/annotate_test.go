@@ -0,0 +1,35 @@
+package ckit
+
+import "testing"
+
+func TestInsertFields(t *testing.T) {
+	var cases = []struct {
+		blob     string
+		fragment string
+		result   string
+	}{
+		{`{"id":"1"}`, `"oa":"gold"`, `{"oa":"gold","id":"1"}`},
+		{` { "id":"1"} `, `"oa":"gold"`, `{"oa":"gold","id":"1"}`},
+		{`{}`, `"oa":"gold"`, `{"oa":"gold"}`},
+		{`{ }`, `"a":1,"b":2`, `{"a":1,"b":2}`},
+		{`[1]`, `"oa":"gold"`, `[1]`},
+		{`"x"`, `"oa":"gold"`, `"x"`},
+	}
+	for _, c := range cases {
+		if result := string(insertFields([]byte(c.blob), []byte(c.fragment))); result != c.result {
+			t.Fatalf("%s: got %s, want %s", c.blob, result, c.result)
+		}
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	var r Response
+	if b := r.annotated("1", []byte(`{"id":"1"}`)); string(b) != `{"id":"1"}` {
+		t.Fatalf("got %s, want blob unchanged", b)
+	}
+	r.annotate("1", "oa", "gold")
+	r.annotate("1", "retracted", true)
+	if b := r.annotated("1", []byte(`{"id":"1"}`)); string(b) != `{"oa":"gold","retracted":true,"id":"1"}` {
+		t.Fatalf("got %s", b)
+	}
+}
@@ -0,0 +1,235 @@
+package ckit
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// APIKeyHeader carries the API key of a client, cf. APIKeys.
+const APIKeyHeader = "X-Api-Key"
+
+var (
+	ErrAPIKeyRequired = errors.New("api key required")
+	ErrAPIKeyInvalid  = errors.New("invalid api key")
+	ErrQuotaExceeded  = errors.New("daily quota exceeded")
+)
+
+// APIKey is a client, e.g. a partner institution, with daily quotas for
+// requests and response bytes; zero means unlimited.
+type APIKey struct {
+	Name     string `json:"name"`
+	Key      string `json:"key"`
+	Requests int64  `json:"requests,omitempty"`
+	Bytes    int64  `json:"bytes,omitempty"`
+}
+
+// Usage is the usage of an API key on a day (UTC), with its quotas.
+type Usage struct {
+	Name          string `json:"name"`
+	Day           string `json:"day"`
+	Requests      int64  `json:"requests"`
+	Bytes         int64  `json:"bytes"`
+	QuotaRequests int64  `json:"quota_requests,omitempty"`
+	QuotaBytes    int64  `json:"quota_bytes,omitempty"`
+}
+
+// exceeded returns true, if any quota has been used up.
+func (u *Usage) exceeded() bool {
+	return (u.QuotaRequests > 0 && u.Requests >= u.QuotaRequests) ||
+		(u.QuotaBytes > 0 && u.Bytes >= u.QuotaBytes)
+}
+
+// APIKeys counts requests and response bytes per API key and enforces daily
+// quotas; counters are kept in memory and start from zero every day (UTC)
+// and on restart. Clients send their key in the X-Api-Key header.
+type APIKeys struct {
+	// Required rejects requests without a key; otherwise, these are served
+	// without limits, e.g. for an internal catalog frontend.
+	Required bool
+
+	mu    sync.Mutex
+	keys  []APIKey
+	day   string
+	usage map[string]*Usage // by name
+	now   func() time.Time
+}
+
+// ReadAPIKeys reads a JSON list of keys from a file, e.g.
+// [{"name": "tu-berlin", "key": "...", "requests": 100000}].
+func ReadAPIKeys(filename string) (*APIKeys, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var keys []APIKey
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("api keys: %w", err)
+	}
+	return NewAPIKeys(keys)
+}
+
+// NewAPIKeys sets up counters for a list of keys; names and keys must be
+// unique and not empty.
+func NewAPIKeys(keys []APIKey) (*APIKeys, error) {
+	var (
+		names = make(map[string]bool)
+		seen  = make(map[string]bool)
+	)
+	for _, k := range keys {
+		switch {
+		case k.Name == "" || k.Key == "":
+			return nil, fmt.Errorf("api keys: name and key required")
+		case names[k.Name]:
+			return nil, fmt.Errorf("api keys: duplicate name: %s", k.Name)
+		case seen[k.Key]:
+			return nil, fmt.Errorf("api keys: duplicate key for %s", k.Name)
+		}
+		names[k.Name], seen[k.Key] = true, true
+	}
+	return &APIKeys{keys: keys, now: time.Now}, nil
+}
+
+// lookup returns the API key matching a request key, in constant time per
+// key, or nil.
+func (a *APIKeys) lookup(key string) *APIKey {
+	var found *APIKey
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(a.keys[i].Key), []byte(key)) == 1 {
+			found = &a.keys[i]
+		}
+	}
+	return found
+}
+
+// current returns the usage of a key today; a.mu must be held.
+func (a *APIKeys) current(k *APIKey) *Usage {
+	if day := a.now().UTC().Format("2006-01-02"); day != a.day {
+		a.day, a.usage = day, make(map[string]*Usage)
+	}
+	u, ok := a.usage[k.Name]
+	if !ok {
+		u = &Usage{Name: k.Name, Day: a.day, QuotaRequests: k.Requests, QuotaBytes: k.Bytes}
+		a.usage[k.Name] = u
+	}
+	return u
+}
+
+// begin accounts a request to an API key; it fails, if the key is invalid or
+// a quota is used up. The returned key is nil for requests without a key.
+func (a *APIKeys) begin(r *http.Request) (*APIKey, Usage, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		if a.Required {
+			return nil, Usage{}, ErrAPIKeyRequired
+		}
+		return nil, Usage{}, nil
+	}
+	k := a.lookup(key)
+	if k == nil {
+		return nil, Usage{}, ErrAPIKeyInvalid
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u := a.current(k)
+	if u.exceeded() {
+		return k, *u, ErrQuotaExceeded
+	}
+	u.Requests++
+	return k, *u, nil
+}
+
+// addBytes adds response bytes to the usage of a key.
+func (a *APIKeys) addBytes(k *APIKey, n int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.current(k).Bytes += n
+}
+
+// Usage returns the usage of all keys today, by name.
+func (a *APIKeys) Usage() []Usage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	result := make([]Usage, len(a.keys))
+	for i := range a.keys {
+		result[i] = *a.current(&a.keys[i])
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// usageOf returns the usage of the key sent with a request.
+func (a *APIKeys) usageOf(r *http.Request) (Usage, error) {
+	k := a.lookup(r.Header.Get(APIKeyHeader))
+	if k == nil {
+		return Usage{}, ErrAPIKeyInvalid
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return *a.current(k), nil
+}
+
+// Middleware counts requests and response bytes per key and rejects requests
+// without a valid key (401), if required, and over quota (429); the latter
+// until the next day (UTC). Internal requests, e.g. for events or jobs, are
+// accounted with the request, that caused them, and are not counted again.
+func (a *APIKeys) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isInternal(r.Context()) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		k, u, err := a.begin(r)
+		switch {
+		case err == ErrQuotaExceeded:
+			now := a.now().UTC()
+			midnight := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+			w.Header().Set("Retry-After", strconv.Itoa(int(midnight.Sub(now).Seconds())+1))
+			httpErrLogf(w, http.StatusTooManyRequests, "%s: %w: %d requests, %d bytes", u.Name, err, u.Requests, u.Bytes)
+			return
+		case err != nil:
+			httpErrLog(w, http.StatusUnauthorized, err)
+			return
+		case k == nil:
+			h.ServeHTTP(w, r)
+			return
+		}
+		sized := &sizeWriter{ResponseWriter: w}
+		defer func() { a.addBytes(k, sized.n) }()
+		h.ServeHTTP(sized, r)
+	})
+}
+
+// handleUsage reports the usage of the key sent with the request today;
+// with the admin token, the usage of all keys.
+func (s *Server) handleUsage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.APIKeys == nil {
+			http.Error(w, "not configured", 400)
+			return
+		}
+		var v interface{}
+		if hasBearerToken(r, s.AdminToken) {
+			v = s.APIKeys.Usage()
+		} else {
+			u, err := s.APIKeys.usageOf(r)
+			if err != nil {
+				httpErrLog(w, http.StatusUnauthorized, err)
+				return
+			}
+			v = u
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			httpErrLog(w, http.StatusInternalServerError, err)
+		}
+	}
+}
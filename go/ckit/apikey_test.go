@@ -0,0 +1,191 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+)
+
+func TestAPIKeys(t *testing.T) {
+	keys, err := NewAPIKeys([]APIKey{
+		{Name: "a", Key: "ka", Requests: 2},
+		{Name: "b", Key: "kb", Bytes: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 2, 1, 12, 0, 0, 0, time.UTC)
+	keys.now = func() time.Time { return now }
+	h := keys.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "0123456789")
+	}))
+	do := func(key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		if key != "" {
+			r.Header.Set(APIKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	var cases = []struct {
+		key  string
+		want int
+	}{
+		{"", 200},
+		{"nope", 401},
+		{"ka", 200},
+		{"ka", 200},
+		{"ka", 429}, // request quota
+		{"kb", 200},
+		{"kb", 429}, // byte quota
+	}
+	for i, c := range cases {
+		if w := do(c.key); w.Code != c.want {
+			t.Fatalf("[%d] %q: got %v, want %v", i, c.key, w.Code, c.want)
+		}
+	}
+	if w := do("ka"); w.Header().Get("Retry-After") != "43201" {
+		t.Fatalf("got retry after %q, want 43201", w.Header().Get("Retry-After"))
+	}
+	usage := keys.Usage()
+	if len(usage) != 2 || usage[0].Requests != 2 || usage[1].Bytes != 10 {
+		t.Fatalf("got %+v", usage)
+	}
+	// Quotas reset the next day.
+	now = now.Add(24 * time.Hour)
+	if w := do("ka"); w.Code != 200 {
+		t.Fatalf("next day: got %v, want 200", w.Code)
+	}
+	keys.Required = true
+	if w := do(""); w.Code != 401 {
+		t.Fatalf("required: got %v, want 401", w.Code)
+	}
+}
+
+func TestNewAPIKeys(t *testing.T) {
+	for _, keys := range [][]APIKey{
+		{{Name: "a"}},
+		{{Name: "a", Key: "k"}, {Name: "a", Key: "l"}},
+		{{Name: "a", Key: "k"}, {Name: "b", Key: "k"}},
+	} {
+		if _, err := NewAPIKeys(keys); err == nil {
+			t.Fatalf("%v: got nil, want error", keys)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	keys, err := NewAPIKeys([]APIKey{{Name: "a", Key: "ka"}, {Name: "b", Key: "kb"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHarness(t, func(s *Server) {
+		s.APIKeys = keys
+		s.AdminToken = "secret"
+		s.Middleware = []Middleware{keys.Middleware}
+	})
+	// The harness sends the admin token.
+	resp, b := h.do(t, "GET", "/usage")
+	if resp.StatusCode != 200 {
+		t.Fatalf("got %v, want 200", resp.StatusCode)
+	}
+	want := `[{"name":"a","day":"` + time.Now().UTC().Format("2006-01-02") + `","requests":0,"bytes":0}`
+	if len(b) < len(want) || string(b[:len(want)]) != want {
+		t.Fatalf("got %s, want prefix %s", b, want)
+	}
+	req, err := http.NewRequest("GET", h.ts.URL+"/usage", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(APIKeyHeader, "kb")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("got %v, want 200", resp.StatusCode)
+	}
+	if u := keys.Usage(); u[1].Requests != 1 || u[1].Bytes == 0 {
+		t.Fatalf("got %+v, want one request with bytes", u[1])
+	}
+}
+
+func TestAPIKeysInternal(t *testing.T) {
+	keys, err := NewAPIKeys([]APIKey{{Name: "a", Key: "ka"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.Required = true
+	h := newHarness(t, func(s *Server) {
+		s.APIKeys = keys
+		s.Cache = newMemoryStore()
+		s.Jobs = NewJobs(t.TempDir(), 1)
+		s.Middleware = []Middleware{keys.Middleware}
+	})
+	defer h.srv.Jobs.Close()
+	id, _ := h.someID(t)
+	send := func(method, path, body string) []byte {
+		t.Helper()
+		req, err := http.NewRequest(method, h.ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(APIKeyHeader, "ka")
+		resp, err := h.client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("%s %s: got %v, %s", method, path, resp.StatusCode, b)
+		}
+		return b
+	}
+	// An event stream counts once, with the bytes of the stream.
+	b := send("GET", "/id/"+id+"/events", "")
+	if !strings.Contains(string(b), "event: result") {
+		t.Fatalf("events: got %s", b)
+	}
+	if u := keys.Usage(); u[0].Requests != 1 || u[0].Bytes != int64(len(b)) {
+		t.Fatalf("events: got %+v, want one request with %d bytes", u[0], len(b))
+	}
+	// Jobs and refreshes do not need a key of their own.
+	var job Job
+	if err := json.Unmarshal(send("POST", "/jobs", `{"id": "`+id+`"}`), &job); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; job.Status != JobDone; i++ {
+		if i == 100 || job.Status == JobFailed {
+			t.Fatalf("job did not finish: %v", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if err := json.Unmarshal(send("GET", "/jobs/"+job.ID, ""), &job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var result []struct {
+		Status int `json:"status"`
+	}
+	if err := json.Unmarshal(send("GET", job.Result, ""), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 || result[0].Status != 0 {
+		t.Fatalf("job: got %v, want one response", result)
+	}
+	if ok, err := h.srv.refresh(context.Background(), id, 0); !ok || err != nil {
+		t.Fatalf("refresh: got %v, %v, want true, nil", ok, err)
+	}
+}
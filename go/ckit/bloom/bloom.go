@@ -0,0 +1,72 @@
+// Package bloom implements a bloom filter for strings, a set which can tell
+// for sure, that a string has not been added, cf.
+// https://en.wikipedia.org/wiki/Bloom_filter.
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Filter is a bloom filter. Add is not thread-safe; a filter can be tested
+// concurrently, once all strings have been added.
+type Filter struct {
+	bits []uint64
+	m    uint64 // number of bits
+	k    uint64 // number of hash functions
+}
+
+// New creates a filter for about n strings, with a given false positive
+// rate p, e.g. 0.01.
+func New(n int, p float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/float64(n)*math.Ln2))
+	words := (uint64(m) + 63) / 64
+	return &Filter{
+		bits: make([]uint64, words),
+		m:    words * 64,
+		k:    uint64(k),
+	}
+}
+
+// hashes returns two hash values of s, from which the k bit positions are
+// derived, cf. Kirsch and Mitzenmacher, "Less Hashing, Same Performance".
+func hashes(s string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	v := h.Sum64()
+	return v & 0xffffffff, v>>32 | 1
+}
+
+// Add adds a string.
+func (f *Filter) Add(s string) {
+	h1, h2 := hashes(s)
+	for i := uint64(0); i < f.k; i++ {
+		j := (h1 + i*h2) % f.m
+		f.bits[j/64] |= 1 << (j % 64)
+	}
+}
+
+// Test returns false, if s has not been added; true means, that s has
+// probably been added.
+func (f *Filter) Test(s string) bool {
+	h1, h2 := hashes(s)
+	for i := uint64(0); i < f.k; i++ {
+		j := (h1 + i*h2) % f.m
+		if f.bits[j/64]&(1<<(j%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Size returns the size of the filter in bytes.
+func (f *Filter) Size() int {
+	return len(f.bits) * 8
+}
@@ -0,0 +1,37 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestFilter(t *testing.T) {
+	is := is.New(t)
+
+	f := New(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add(fmt.Sprintf("id-%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		is.True(f.Test(fmt.Sprintf("id-%d", i))) // no false negatives
+	}
+	var fp int
+	for i := 0; i < 10000; i++ {
+		if f.Test(fmt.Sprintf("other-%d", i)) {
+			fp++
+		}
+	}
+	is.True(fp < 300) // about 1% false positives, expected 100
+	is.True(f.Size() > 0)
+}
+
+func TestFilterDefaults(t *testing.T) {
+	is := is.New(t)
+
+	f := New(0, 0)
+	is.True(!f.Test("a"))
+	f.Add("a")
+	is.True(f.Test("a"))
+}
@@ -0,0 +1,31 @@
+package ckit
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// BuildInfo describes the running binary; values are set at build time, cf.
+// Makefile.
+type BuildInfo struct {
+	// Version is the git commit.
+	Version string `json:"version"`
+	// Tag is the output of git describe, e.g. v1.2.0-3-g1a2b3c4.
+	Tag       string `json:"tag"`
+	Buildtime string `json:"buildtime"`
+	GoVersion string `json:"go_version"`
+}
+
+// handleBuildInfo returns information about the running binary.
+func (s *Server) handleBuildInfo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := s.BuildInfo
+		info.GoVersion = runtime.Version()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(info); err != nil {
+			httpErrLog(w, http.StatusInternalServerError, err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package ckit
+
+import (
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/segmentio/encoding/json"
+)
+
+func TestBuildInfo(t *testing.T) {
+	srv := &Server{
+		Router:    mux.NewRouter(),
+		BuildInfo: BuildInfo{Version: "1a2b3c4", Tag: "v1.2.0", Buildtime: "2022-01-30T12:00:00Z"},
+	}
+	srv.Routes()
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/buildinfo", nil))
+	var info BuildInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if info.Tag != "v1.2.0" || info.GoVersion != runtime.Version() {
+		t.Fatalf("unexpected build info: %#v", info)
+	}
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rr.Body.String(), "Version: v1.2.0 1a2b3c4") {
+		t.Fatalf("want version on index page, got %s", rr.Body.String())
+	}
+}
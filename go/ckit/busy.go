@@ -0,0 +1,89 @@
+package ckit
+
+import (
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+// DefaultBusyRetries is the number of retries of a query, that failed with a
+// busy or locked database; the first retry waits busyWait, every further
+// retry twice as long as the one before, about 300ms in total.
+const (
+	DefaultBusyRetries = 5
+	busyWait           = 10 * time.Millisecond
+)
+
+// isBusy returns true for SQLITE_BUSY and SQLITE_LOCKED errors, which are
+// transient, e.g. while a rotation or a backup touches a database file.
+func isBusy(err error) bool {
+	var e sqlite3.Error
+	return errors.As(err, &e) && (e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked)
+}
+
+// retryBusy runs f and retries it up to n times with exponential backoff, as
+// long as it fails with a busy or locked database and the context is not
+// done.
+func retryBusy(ctx context.Context, n int, f func() error) error {
+	wait := busyWait
+	for i := 0; ; i++ {
+		err := f()
+		if err == nil || i >= n || !isBusy(err) {
+			return err
+		}
+		log.Printf("database busy, retry %d/%d in %s: %v", i+1, n, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wait *= 2
+	}
+}
+
+// busyRetries returns the number of retries for busy databases, cf.
+// Server.BusyRetries.
+func (s *Server) busyRetries() int {
+	return busyRetryCount(s.BusyRetries)
+}
+
+// busyRetryCount turns a configured number of retries into the actual
+// number: zero means DefaultBusyRetries, negative values disable retries.
+func busyRetryCount(n int) int {
+	switch {
+	case n == 0:
+		return DefaultBusyRetries
+	case n < 0:
+		return 0
+	}
+	return n
+}
+
+// getContext is like GetContext on db, but retries busy databases.
+func (s *Server) getContext(ctx context.Context, db *sqlx.DB, dest interface{}, query string, args ...interface{}) error {
+	t := time.Now()
+	err := retryBusy(ctx, s.busyRetries(), func() error {
+		return db.GetContext(ctx, dest, query, args...)
+	})
+	recordTiming(ctx, "sql", query, len(args), t, err)
+	return err
+}
+
+// selectContext is like SelectContext on db, but retries busy databases.
+// Rows scanned before a failure are discarded, since Select appends to
+// dest.
+func (s *Server) selectContext(ctx context.Context, db *sqlx.DB, dest interface{}, query string, args ...interface{}) error {
+	t := time.Now()
+	err := retryBusy(ctx, s.busyRetries(), func() error {
+		v := reflect.ValueOf(dest).Elem()
+		v.Set(reflect.Zero(v.Type()))
+		return db.SelectContext(ctx, dest, query, args...)
+	})
+	recordTiming(ctx, "sql", query, len(args), t, err)
+	return err
+}
@@ -0,0 +1,88 @@
+package ckit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestIsBusy(t *testing.T) {
+	var cases = []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("database is locked"), false},
+		{sqlite3.Error{Code: sqlite3.ErrBusy}, true},
+		{sqlite3.Error{Code: sqlite3.ErrLocked}, true},
+		{fmt.Errorf("select (10): %w", sqlite3.Error{Code: sqlite3.ErrBusy}), true},
+		{sqlite3.Error{Code: 1}, false},
+	}
+	for i, c := range cases {
+		if got := isBusy(c.err); got != c.want {
+			t.Fatalf("[%d] got %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestRetryBusy(t *testing.T) {
+	var (
+		busy  = sqlite3.Error{Code: sqlite3.ErrBusy}
+		other = errors.New("disk I/O error")
+	)
+	var cases = []struct {
+		retries int
+		errs    []error // returned by subsequent calls, nil afterwards
+		calls   int
+		err     error
+	}{
+		{3, nil, 1, nil},
+		{3, []error{busy, busy}, 3, nil},
+		{1, []error{busy, busy}, 2, busy},
+		{0, []error{busy}, 1, busy},
+		{3, []error{other}, 1, other},
+		{3, []error{busy, other}, 2, other},
+	}
+	for i, c := range cases {
+		var calls int
+		err := retryBusy(context.Background(), c.retries, func() error {
+			calls++
+			if calls <= len(c.errs) {
+				return c.errs[calls-1]
+			}
+			return nil
+		})
+		if err != c.err || calls != c.calls {
+			t.Fatalf("[%d] got %v after %d calls, want %v after %d", i, err, calls, c.err, c.calls)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := retryBusy(ctx, 3, func() error { return busy }); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestBusyRetries(t *testing.T) {
+	for _, c := range []struct{ v, want int }{{0, DefaultBusyRetries}, {-1, 0}, {2, 2}} {
+		if got := (&Server{BusyRetries: c.v}).busyRetries(); got != c.want {
+			t.Fatalf("%d: got %d, want %d", c.v, got, c.want)
+		}
+	}
+}
+
+func TestFetchGroupBusyRetries(t *testing.T) {
+	g := &FetchGroup{BusyRetries: -1}
+	if err := g.FromFiles("testdata/id_metadata.db"); err != nil {
+		t.Fatal(err)
+	}
+	defer closeFetcher(g)
+	for _, sf := range sqliteBackends(g) {
+		if sf.BusyRetries != -1 {
+			t.Fatalf("got %d, want -1", sf.BusyRetries)
+		}
+	}
+}
@@ -34,6 +34,8 @@ package cache
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"sync"
@@ -48,9 +50,26 @@ import (
 var (
 	ErrCacheMiss             = errors.New("cache miss")
 	ErrReadOnly              = errors.New("read only")
+	ErrTooLarge              = errors.New("value too large")
 	DefaultMaxFileSize int64 = 1 << 36
 )
 
+// Store is the interface of a response cache, implemented by the sqlite
+// based Cache and by Memcache.
+type Store interface {
+	// Get returns ErrCacheMiss, if there is no value for a key.
+	Get(key string) ([]byte, error)
+	// GetWithTime also returns the time the value was set.
+	GetWithTime(key string) ([]byte, time.Time, error)
+	// Set may return ErrReadOnly or ErrTooLarge, in which case the value
+	// is not cached, but nothing is wrong with the cache per se.
+	Set(key string, value []byte) error
+	Delete(key string) error
+	Flush() error
+	ItemCount() (int, error)
+	Close() error
+}
+
 // Cache is a minimalistic cache based on sqlite. In the future, values could
 // be transparently compressed as well.
 type Cache struct {
@@ -72,10 +91,51 @@ func New(path string) (*Cache, error) {
 	if err := c.init(); err != nil {
 		return nil, err
 	}
+	// With a write-ahead log, readers, e.g. a snapshot, do not block writers
+	// and vice versa; the mode is stored in the file.
+	if _, err := c.db.Exec(`PRAGMA journal_mode = WAL`); err != nil {
+		return nil, err
+	}
+	if err := c.migrate(); err != nil {
+		return nil, err
+	}
 	c.startSizeWatcher()
 	return c, nil
 }
 
+// NewFromSnapshot creates a new cache at a given path, initialized with the
+// contents of a snapshot, if the snapshot file exists. This allows to keep a
+// warmed cache across restarts.
+func NewFromSnapshot(path, snapshot string) (*Cache, error) {
+	if _, err := os.Stat(snapshot); err == nil {
+		if err := copyFile(path, snapshot); err != nil {
+			return nil, fmt.Errorf("restore snapshot: %w", err)
+		}
+		log.Printf("[cache] restored snapshot from %s", snapshot)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return New(path)
+}
+
+// copyFile copies the contents of src to dst, truncating dst.
+func copyFile(dst, src string) error {
+	sf, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	df, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(df, sf); err != nil {
+		df.Close()
+		return err
+	}
+	return df.Close()
+}
+
 // startSizeWatcher sets up a goroutine that will watch the filesize
 // periodically and will switch to read-only mode, if a given size has been
 // exceeded. This is a counter-measure to http flood type of attacks.
@@ -106,12 +166,27 @@ PRAGMA journal_mode = OFF;
 PRAGMA synchronous = 0;
 PRAGMA locking_mode = EXCLUSIVE;
 PRAGMA temp_store = MEMORY;
-CREATE TABLE IF NOT EXISTS map (k TEXT, v TEXT);
+CREATE TABLE IF NOT EXISTS map (k TEXT, v TEXT, t INTEGER);
 CREATE INDEX IF NOT EXISTS idx_k ON map(k);
 	`
 	return tabutils.RunScript(c.Path, s, "initialized database")
 }
 
+// migrate adds the timestamp column to caches created by earlier versions,
+// e.g. when restoring an older snapshot.
+func (c *Cache) migrate() error {
+	var n int
+	row := c.db.QueryRow(`SELECT count(*) FROM pragma_table_info('map') WHERE name = 't'`)
+	if err := row.Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err := c.db.Exec(`ALTER TABLE map ADD COLUMN t INTEGER`)
+	return err
+}
+
 // Close closes the underlying database.
 func (c *Cache) Close() error {
 	return c.db.Close()
@@ -125,6 +200,31 @@ func (c *Cache) Flush() error {
 	return err
 }
 
+// Snapshot writes a consistent copy of the cache to a given path. The copy is
+// written to a temporary file first and then renamed, so an existing snapshot
+// is only replaced by a complete one. The copy is read in a single read
+// transaction, which sees the cache as of its start, so the cache is not
+// locked and can be used while a snapshot is written.
+func (c *Cache) Snapshot(path string) error {
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// VACUUM INTO requires sqlite 3.27 or later.
+	if _, err := c.db.Exec(`VACUUM INTO ?`, tmp); err != nil {
+		return fmt.Errorf("vacuum into %s: %w", tmp, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Delete removes the value for a key, if any.
+func (c *Cache) Delete(key string) error {
+	c.Lock()
+	defer c.Unlock()
+	_, err := c.db.Exec(`DELETE FROM map WHERE k = ?`, key)
+	return err
+}
+
 // ItemCount returns the number of entries in the cache.
 func (c *Cache) ItemCount() (int, error) {
 	row := c.db.QueryRow(`SELECT count(k) FROM map`)
@@ -135,33 +235,48 @@ func (c *Cache) ItemCount() (int, error) {
 	return v, nil
 }
 
-// Set key value pair.
+// Set key value pair, replacing any existing value.
 func (c *Cache) Set(key string, value []byte) error {
 	c.Lock()
 	defer c.Unlock()
 	if c.readOnly {
 		return ErrReadOnly
 	}
-	s := `INSERT into map (k, v) VALUES (?, ?)`
-	_, err := c.db.Exec(s, key, value)
+	if _, err := c.db.Exec(`DELETE FROM map WHERE k = ?`, key); err != nil {
+		return err
+	}
+	s := `INSERT into map (k, v, t) VALUES (?, ?, ?)`
+	_, err := c.db.Exec(s, key, value, time.Now().Unix())
 	return err
 }
 
 // Get value for a key.
 func (c *Cache) Get(key string) ([]byte, error) {
+	v, _, err := c.GetWithTime(key)
+	return v, err
+}
+
+// GetWithTime returns the value for a key and the time it was set. Values
+// from caches created by earlier versions have a zero time.
+func (c *Cache) GetWithTime(key string) ([]byte, time.Time, error) {
 	var (
-		row = c.db.QueryRow(`SELECT v FROM map WHERE k = ?`, key)
+		row = c.db.QueryRow(`SELECT v, t FROM map WHERE k = ?`, key)
 		v   string
+		t   sql.NullInt64
 	)
-	if err := row.Scan(&v); err != nil {
+	if err := row.Scan(&v, &t); err != nil {
 		if err == sql.ErrNoRows {
-			return nil, ErrCacheMiss
+			return nil, time.Time{}, ErrCacheMiss
 		}
-		return nil, err
+		return nil, time.Time{}, err
 	}
 	if v == "" {
-		return nil, ErrCacheMiss
+		return nil, time.Time{}, ErrCacheMiss
+	}
+	var modified time.Time
+	if t.Valid {
+		modified = time.Unix(t.Int64, 0)
 	}
 	// TODO: can we read into a byte slice directly?
-	return []byte(v), nil
+	return []byte(v), modified, nil
 }
@@ -2,7 +2,9 @@ package cache
 
 import (
 	"io/ioutil"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestCache(t *testing.T) {
@@ -40,7 +42,82 @@ func TestCache(t *testing.T) {
 			t.Fatalf("want abc, got %v", v)
 		}
 	}
+	if _, modified, err := cache.GetWithTime("a"); err != nil {
+		t.Fatalf("failed to get value: %v", err)
+	} else {
+		if time.Since(modified) > time.Minute {
+			t.Fatalf("want recent modification time, got %v", modified)
+		}
+	}
+	if err := cache.Delete("a"); err != nil {
+		t.Fatalf("failed to delete value: %v", err)
+	}
+	if _, err := cache.Get("a"); err != ErrCacheMiss {
+		t.Fatalf("want %v, got %v", ErrCacheMiss, err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("failed to close db: %v", err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		snapshot = filepath.Join(dir, "snapshot")
+	)
+	cache, err := New(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	if err := cache.Set("a", []byte("abc")); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	if err := cache.Snapshot(snapshot); err != nil {
+		t.Fatalf("failed to write snapshot: %v", err)
+	}
 	if err := cache.Close(); err != nil {
 		t.Fatalf("failed to close db: %v", err)
 	}
+	restored, err := NewFromSnapshot(filepath.Join(dir, "b"), snapshot)
+	if err != nil {
+		t.Fatalf("failed to restore snapshot: %v", err)
+	}
+	defer restored.Close()
+	if v, err := restored.Get("a"); err != nil {
+		t.Fatalf("failed to get value: %v", err)
+	} else {
+		if string(v) != "abc" {
+			t.Fatalf("want abc, got %v", v)
+		}
+	}
+}
+
+func TestSnapshotUnlocked(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := New(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer cache.Close()
+	if err := cache.Set("a", []byte("abc")); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	// A snapshot does not wait for writers holding the cache lock.
+	cache.Lock()
+	done := make(chan error, 1)
+	go func() {
+		done <- cache.Snapshot(filepath.Join(dir, "snapshot"))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("failed to write snapshot: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("snapshot blocked by cache lock")
+	}
+	cache.Unlock()
+	if err := cache.Set("b", []byte("def")); err != nil {
+		t.Fatalf("failed to set value after snapshot: %v", err)
+	}
 }
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+)
+
+// DefaultHitCounterMaxSize is the default maximum number of keys tracked by a
+// HitCounter.
+const DefaultHitCounterMaxSize = 1 << 16
+
+// HitCounter counts requests per key, so we can find the most requested keys,
+// e.g. to keep their cached values fresh. The counter is bounded; if it is
+// full, all counts are halved and keys with a zero count are dropped.
+type HitCounter struct {
+	MaxSize int
+	mu      sync.Mutex
+	m       map[string]int
+}
+
+// NewHitCounter creates a new counter with a default maximum size.
+func NewHitCounter() *HitCounter {
+	return &HitCounter{
+		MaxSize: DefaultHitCounterMaxSize,
+		m:       make(map[string]int),
+	}
+}
+
+// Hit records a request for a key.
+func (c *HitCounter) Hit(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.m[key]; !ok && len(c.m) >= c.MaxSize {
+		c.decay()
+		if len(c.m) >= c.MaxSize {
+			return
+		}
+	}
+	c.m[key]++
+}
+
+// Top returns up to n keys with the most hits, most requested first.
+func (c *HitCounter) Top(n int) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.m))
+	for k := range c.m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if c.m[keys[i]] == c.m[keys[j]] {
+			return keys[i] < keys[j]
+		}
+		return c.m[keys[i]] > c.m[keys[j]]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// Decay halves all counts, so older requests weigh less than recent ones.
+func (c *HitCounter) Decay() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.decay()
+}
+
+// decay halves all counts and drops keys with a zero count, caller must hold
+// the lock.
+func (c *HitCounter) decay() {
+	for k, v := range c.m {
+		if v < 2 {
+			delete(c.m, k)
+		} else {
+			c.m[k] = v / 2
+		}
+	}
+}
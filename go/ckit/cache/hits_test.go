@@ -0,0 +1,25 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHitCounter(t *testing.T) {
+	c := NewHitCounter()
+	c.MaxSize = 3
+	for _, k := range []string{"a", "b", "b", "c", "c", "c"} {
+		c.Hit(k)
+	}
+	if got, want := c.Top(2), []string{"c", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	c.Hit("d") // counter is full, decays first, dropping "a"
+	if got, want := c.Top(10), []string{"b", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	c.Decay()
+	if got, want := c.Top(10), []string{}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,363 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultMemcacheTimeout is the default timeout for a single memcached
+	// operation, including connection setup.
+	DefaultMemcacheTimeout = 2 * time.Second
+	// DefaultMemcacheMaxValueSize is the default item size limit of memcached.
+	DefaultMemcacheMaxValueSize = 1 << 20
+	// DefaultMemcacheMaxIdleConns is the default number of idle connections
+	// kept per server.
+	DefaultMemcacheMaxIdleConns = 8
+
+	errMemcacheNotStored = errors.New("memcache: not stored")
+)
+
+// maxMemcacheKeyLength is the maximum key length memcached accepts.
+const maxMemcacheKeyLength = 250
+
+// Memcache is a cache backed by one or more memcached servers, which can be
+// shared between labed instances. Keys are distributed across servers by
+// hash. It speaks the memcached text protocol, cf.
+// https://github.com/memcached/memcached/blob/master/doc/protocol.txt.
+//
+// The time a value was set is stored in the item flags, as unix timestamp.
+type Memcache struct {
+	Servers []string
+	// Prefix is prepended to all keys, e.g. to share memcached with other
+	// applications.
+	Prefix       string
+	Timeout      time.Duration
+	MaxValueSize int
+	MaxIdleConns int
+	// Expiration in seconds, zero means no expiration.
+	Expiration int
+
+	mu   sync.Mutex
+	idle map[string][]*memcacheConn
+}
+
+// memcacheConn is a connection to a memcached server.
+type memcacheConn struct {
+	nc   net.Conn
+	rw   *bufio.ReadWriter
+	addr string
+}
+
+// NewMemcache creates a new memcached backed cache for a list of servers,
+// given as host:port.
+func NewMemcache(servers ...string) (*Memcache, error) {
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("memcache: no servers given")
+	}
+	return &Memcache{
+		Servers:      servers,
+		Timeout:      DefaultMemcacheTimeout,
+		MaxValueSize: DefaultMemcacheMaxValueSize,
+		MaxIdleConns: DefaultMemcacheMaxIdleConns,
+		idle:         make(map[string][]*memcacheConn),
+	}, nil
+}
+
+// key turns a cache key into a valid memcached key; keys which are too long
+// or contain whitespace or control characters are hashed.
+func (m *Memcache) key(key string) string {
+	k := m.Prefix + key
+	if len(k) > maxMemcacheKeyLength || strings.IndexFunc(k, func(r rune) bool {
+		return r <= ' ' || r == 0x7f
+	}) >= 0 {
+		h := sha1.Sum([]byte(key))
+		k = m.Prefix + hex.EncodeToString(h[:])
+	}
+	return k
+}
+
+// server returns the server responsible for a memcached key.
+func (m *Memcache) server(key string) string {
+	return m.Servers[int(crc32.ChecksumIEEE([]byte(key))%uint32(len(m.Servers)))]
+}
+
+// conn returns an idle or new connection to a server.
+func (m *Memcache) conn(addr string) (*memcacheConn, error) {
+	var c *memcacheConn
+	m.mu.Lock()
+	if cs := m.idle[addr]; len(cs) > 0 {
+		c = cs[len(cs)-1]
+		m.idle[addr] = cs[:len(cs)-1]
+	}
+	m.mu.Unlock()
+	if c == nil {
+		nc, err := net.DialTimeout("tcp", addr, m.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("memcache: %w", err)
+		}
+		c = &memcacheConn{
+			nc:   nc,
+			rw:   bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc)),
+			addr: addr,
+		}
+	}
+	if err := c.nc.SetDeadline(time.Now().Add(m.Timeout)); err != nil {
+		c.nc.Close()
+		return nil, fmt.Errorf("memcache: %w", err)
+	}
+	return c, nil
+}
+
+// release puts a connection back into the idle pool, if err is nil and the
+// pool is not full; otherwise the connection is closed.
+func (m *Memcache) release(c *memcacheConn, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil && len(m.idle[c.addr]) < m.MaxIdleConns {
+		m.idle[c.addr] = append(m.idle[c.addr], c)
+		return
+	}
+	c.nc.Close()
+}
+
+// do runs a function with a connection to a server. Protocol level errors
+// like ErrCacheMiss leave the connection in a usable state, other errors
+// close it.
+func (m *Memcache) do(addr string, f func(c *memcacheConn) error) error {
+	c, err := m.conn(addr)
+	if err != nil {
+		return err
+	}
+	err = f(c)
+	switch err {
+	case nil, ErrCacheMiss, errMemcacheNotStored:
+		m.release(c, nil)
+	default:
+		m.release(c, err)
+	}
+	return err
+}
+
+// readLine reads a single response line, without the trailing CRLF, and
+// turns error replies into errors.
+func (c *memcacheConn) readLine() (string, error) {
+	line, err := c.rw.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("memcache: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch {
+	case line == "ERROR",
+		strings.HasPrefix(line, "CLIENT_ERROR"),
+		strings.HasPrefix(line, "SERVER_ERROR"):
+		return "", fmt.Errorf("memcache: %s", line)
+	}
+	return line, nil
+}
+
+// Get value for a key.
+func (m *Memcache) Get(key string) ([]byte, error) {
+	v, _, err := m.GetWithTime(key)
+	return v, err
+}
+
+// GetWithTime returns the value for a key and the time it was set.
+func (m *Memcache) GetWithTime(key string) (value []byte, modified time.Time, err error) {
+	k := m.key(key)
+	err = m.do(m.server(k), func(c *memcacheConn) error {
+		if _, err := fmt.Fprintf(c.rw, "get %s\r\n", k); err != nil {
+			return err
+		}
+		if err := c.rw.Flush(); err != nil {
+			return err
+		}
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "END" {
+			return ErrCacheMiss
+		}
+		// VALUE <key> <flags> <bytes>
+		fields := strings.Fields(line)
+		if len(fields) != 4 || fields[0] != "VALUE" {
+			return fmt.Errorf("memcache: unexpected reply: %q", line)
+		}
+		flags, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return fmt.Errorf("memcache: invalid flags: %w", err)
+		}
+		size, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return fmt.Errorf("memcache: invalid size: %w", err)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(c.rw, buf); err != nil {
+			return fmt.Errorf("memcache: %w", err)
+		}
+		if !bytes.HasSuffix(buf, []byte("\r\n")) {
+			return fmt.Errorf("memcache: corrupt value")
+		}
+		if line, err = c.readLine(); err != nil {
+			return err
+		}
+		if line != "END" {
+			return fmt.Errorf("memcache: unexpected reply: %q", line)
+		}
+		value = buf[:size]
+		if flags > 0 {
+			modified = time.Unix(int64(flags), 0)
+		}
+		return nil
+	})
+	return value, modified, err
+}
+
+// Set key value pair. Returns ErrTooLarge, if the value exceeds the maximum
+// item size.
+func (m *Memcache) Set(key string, value []byte) error {
+	if len(value) > m.MaxValueSize {
+		return ErrTooLarge
+	}
+	k := m.key(key)
+	return m.do(m.server(k), func(c *memcacheConn) error {
+		flags := uint32(time.Now().Unix())
+		if _, err := fmt.Fprintf(c.rw, "set %s %d %d %d\r\n", k, flags, m.Expiration, len(value)); err != nil {
+			return err
+		}
+		if _, err := c.rw.Write(value); err != nil {
+			return err
+		}
+		if _, err := c.rw.WriteString("\r\n"); err != nil {
+			return err
+		}
+		if err := c.rw.Flush(); err != nil {
+			return err
+		}
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "STORED":
+			return nil
+		case "NOT_STORED":
+			return errMemcacheNotStored
+		default:
+			return fmt.Errorf("memcache: unexpected reply: %q", line)
+		}
+	})
+}
+
+// Delete removes the value for a key, if any.
+func (m *Memcache) Delete(key string) error {
+	k := m.key(key)
+	return m.do(m.server(k), func(c *memcacheConn) error {
+		if _, err := fmt.Fprintf(c.rw, "delete %s\r\n", k); err != nil {
+			return err
+		}
+		if err := c.rw.Flush(); err != nil {
+			return err
+		}
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "DELETED", "NOT_FOUND":
+			return nil
+		default:
+			return fmt.Errorf("memcache: unexpected reply: %q", line)
+		}
+	})
+}
+
+// Flush empties the cache. Note that this removes all items from all
+// servers, not only the ones with our prefix.
+func (m *Memcache) Flush() error {
+	for _, addr := range m.Servers {
+		err := m.do(addr, func(c *memcacheConn) error {
+			if _, err := c.rw.WriteString("flush_all\r\n"); err != nil {
+				return err
+			}
+			if err := c.rw.Flush(); err != nil {
+				return err
+			}
+			line, err := c.readLine()
+			if err != nil {
+				return err
+			}
+			if line != "OK" {
+				return fmt.Errorf("memcache: unexpected reply: %q", line)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ItemCount returns the number of items on all servers, including items of
+// other applications.
+func (m *Memcache) ItemCount() (int, error) {
+	var total int
+	for _, addr := range m.Servers {
+		err := m.do(addr, func(c *memcacheConn) error {
+			if _, err := c.rw.WriteString("stats\r\n"); err != nil {
+				return err
+			}
+			if err := c.rw.Flush(); err != nil {
+				return err
+			}
+			for {
+				line, err := c.readLine()
+				if err != nil {
+					return err
+				}
+				if line == "END" {
+					return nil
+				}
+				// STAT <name> <value>
+				fields := strings.Fields(line)
+				if len(fields) == 3 && fields[1] == "curr_items" {
+					n, err := strconv.Atoi(fields[2])
+					if err != nil {
+						return fmt.Errorf("memcache: invalid item count: %w", err)
+					}
+					total += n
+				}
+			}
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// Close closes all idle connections.
+func (m *Memcache) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for addr, cs := range m.idle {
+		for _, c := range cs {
+			c.nc.Close()
+		}
+		delete(m.idle, addr)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMemcached implements the parts of the memcached text protocol we use.
+type fakeMemcached struct {
+	mu    sync.Mutex
+	items map[string]fakeItem
+}
+
+type fakeItem struct {
+	flags string
+	value []byte
+}
+
+func (f *fakeMemcached) serve(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.handle(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (f *fakeMemcached) handle(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+		f.mu.Lock()
+		switch fields[0] {
+		case "get":
+			if item, ok := f.items[fields[1]]; ok {
+				fmt.Fprintf(rw, "VALUE %s %s %d\r\n%s\r\n", fields[1], item.flags, len(item.value), item.value)
+			}
+			rw.WriteString("END\r\n")
+		case "set":
+			size, _ := strconv.Atoi(fields[4])
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, buf); err != nil {
+				f.mu.Unlock()
+				return
+			}
+			f.items[fields[1]] = fakeItem{flags: fields[2], value: buf[:size]}
+			rw.WriteString("STORED\r\n")
+		case "delete":
+			if _, ok := f.items[fields[1]]; ok {
+				delete(f.items, fields[1])
+				rw.WriteString("DELETED\r\n")
+			} else {
+				rw.WriteString("NOT_FOUND\r\n")
+			}
+		case "flush_all":
+			f.items = make(map[string]fakeItem)
+			rw.WriteString("OK\r\n")
+		case "stats":
+			fmt.Fprintf(rw, "STAT pid 1\r\nSTAT curr_items %d\r\nEND\r\n", len(f.items))
+		default:
+			rw.WriteString("ERROR\r\n")
+		}
+		f.mu.Unlock()
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func TestMemcache(t *testing.T) {
+	var (
+		a = &fakeMemcached{items: make(map[string]fakeItem)}
+		b = &fakeMemcached{items: make(map[string]fakeItem)}
+	)
+	m, err := NewMemcache(a.serve(t), b.serve(t))
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	defer m.Close()
+	m.Prefix = "labe:"
+	m.MaxValueSize = 16
+	if _, err := m.Get("a"); err != ErrCacheMiss {
+		t.Fatalf("want %v, got %v", ErrCacheMiss, err)
+	}
+	var keys = []string{"a", "b", "c", "with space", strings.Repeat("x", 300)}
+	for _, k := range keys {
+		if err := m.Set(k, []byte("abc")); err != nil {
+			t.Fatalf("failed to set value: %v", err)
+		}
+	}
+	if err := m.Set("d", []byte(strings.Repeat("x", 17))); err != ErrTooLarge {
+		t.Fatalf("want %v, got %v", ErrTooLarge, err)
+	}
+	for _, k := range keys {
+		v, modified, err := m.GetWithTime(k)
+		if err != nil {
+			t.Fatalf("failed to get value: %v", err)
+		}
+		if string(v) != "abc" {
+			t.Fatalf("want abc, got %v", v)
+		}
+		if time.Since(modified) > time.Minute {
+			t.Fatalf("want recent modification time, got %v", modified)
+		}
+	}
+	if size, err := m.ItemCount(); err != nil {
+		t.Fatalf("failed to get number of entries: %v", err)
+	} else {
+		if size != len(keys) {
+			t.Fatalf("want %d, got %v", len(keys), size)
+		}
+	}
+	if err := m.Delete("a"); err != nil {
+		t.Fatalf("failed to delete value: %v", err)
+	}
+	if _, err := m.Get("a"); err != ErrCacheMiss {
+		t.Fatalf("want %v, got %v", ErrCacheMiss, err)
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if size, err := m.ItemCount(); err != nil {
+		t.Fatalf("failed to get number of entries: %v", err)
+	} else {
+		if size != 0 {
+			t.Fatalf("want 0, got %v", size)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultTTLSetMaxSize is the default maximum number of keys in a TTLSet.
+const DefaultTTLSetMaxSize = 1 << 20
+
+// TTLSet is an in-memory set of keys, which expire after a fixed duration.
+// It is used for negative caching, e.g. to remember identifiers, for which we
+// found nothing. The set is bounded; if it is full, expired keys are removed
+// and, if that does not help, new keys are dropped until keys expire.
+type TTLSet struct {
+	TTL     time.Duration
+	MaxSize int
+	mu      sync.Mutex
+	m       map[string]time.Time
+}
+
+// NewTTLSet creates a new set, keys expire after the given duration.
+func NewTTLSet(ttl time.Duration) *TTLSet {
+	return &TTLSet{
+		TTL:     ttl,
+		MaxSize: DefaultTTLSetMaxSize,
+		m:       make(map[string]time.Time),
+	}
+}
+
+// Add adds a key, resetting its expiry, if it already exists.
+func (s *TTLSet) Add(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if _, ok := s.m[key]; !ok && len(s.m) >= s.MaxSize {
+		s.removeExpired(now)
+		if len(s.m) >= s.MaxSize {
+			return
+		}
+	}
+	s.m[key] = now.Add(s.TTL)
+}
+
+// Contains returns true, if the key is in the set and has not expired yet.
+func (s *TTLSet) Contains(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.m[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(t) {
+		delete(s.m, key)
+		return false
+	}
+	return true
+}
+
+// Remove removes a key, if it exists.
+func (s *TTLSet) Remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, key)
+}
+
+// Len returns the number of keys, including expired keys not yet removed.
+func (s *TTLSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.m)
+}
+
+// Flush removes all keys.
+func (s *TTLSet) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.m = make(map[string]time.Time)
+}
+
+// removeExpired removes all expired keys, caller must hold the lock.
+func (s *TTLSet) removeExpired(now time.Time) {
+	for k, t := range s.m {
+		if now.After(t) {
+			delete(s.m, k)
+		}
+	}
+}
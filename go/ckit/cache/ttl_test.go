@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLSet(t *testing.T) {
+	s := NewTTLSet(50 * time.Millisecond)
+	s.MaxSize = 2
+	s.Add("a")
+	if !s.Contains("a") {
+		t.Fatalf("got false, want true")
+	}
+	if s.Contains("b") {
+		t.Fatalf("got true, want false")
+	}
+	s.Add("b")
+	s.Add("c") // set is full, dropped
+	if s.Contains("c") {
+		t.Fatalf("got true, want false (full set)")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if s.Contains("a") {
+		t.Fatalf("got true, want false (expired)")
+	}
+	s.Add("c")
+	s.Add("d") // set is full, removes expired keys first
+	if !s.Contains("c") || !s.Contains("d") {
+		t.Fatalf("got false, want true")
+	}
+	if s.Len() != 2 {
+		t.Fatalf("got %d, want 2", s.Len())
+	}
+	s.Remove("c")
+	if s.Contains("c") {
+		t.Fatalf("got true, want false (removed)")
+	}
+	s.Flush()
+	if s.Len() != 0 {
+		t.Fatalf("got %d, want 0", s.Len())
+	}
+}
@@ -0,0 +1,68 @@
+// Package cite converts citation data from other sources than OCI, e.g.
+// Crossref Event Data, into citing and cited pairs, which can be turned into
+// an edge database with makta.
+package cite
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Edge is a citation: Citing cites Cited. Works are identified by DOI, if
+// possible, otherwise by URL.
+type Edge struct {
+	Citing string
+	Cited  string
+}
+
+// doiPrefixes are removed from identifiers to get a plain DOI.
+var doiPrefixes = []string{
+	"https://doi.org/",
+	"http://doi.org/",
+	"https://dx.doi.org/",
+	"http://dx.doi.org/",
+	"doi:",
+}
+
+// NormalizeID returns a lowercase DOI for DOI given as URL or with a "doi:"
+// prefix; other identifiers, e.g. URLs of Wikipedia pages, are returned as
+// is.
+func NormalizeID(s string) string {
+	s = strings.TrimSpace(s)
+	lower := strings.ToLower(s)
+	for _, prefix := range doiPrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return strings.TrimPrefix(lower, prefix)
+		}
+	}
+	if strings.HasPrefix(lower, "10.") {
+		return lower
+	}
+	return s
+}
+
+// TSVWriter writes edges as tab separated values, as expected by makta.
+type TSVWriter struct {
+	w *bufio.Writer
+}
+
+// NewTSVWriter returns a buffered writer, call Flush when done.
+func NewTSVWriter(w io.Writer) *TSVWriter {
+	return &TSVWriter{w: bufio.NewWriter(w)}
+}
+
+// Write writes an edge; edges with tabs or newlines in identifiers are
+// skipped.
+func (w *TSVWriter) Write(e Edge) error {
+	if strings.ContainsAny(e.Citing+e.Cited, "\t\n") {
+		return nil
+	}
+	_, err := w.w.WriteString(e.Citing + "\t" + e.Cited + "\n")
+	return err
+}
+
+// Flush writes buffered data.
+func (w *TSVWriter) Flush() error {
+	return w.w.Flush()
+}
@@ -0,0 +1,81 @@
+package cite
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeID(t *testing.T) {
+	var cases = []struct {
+		s      string
+		result string
+	}{
+		{"https://doi.org/10.1/ABC", "10.1/abc"},
+		{"http://dx.doi.org/10.1/a", "10.1/a"},
+		{"doi:10.1/a", "10.1/a"},
+		{"10.1/A", "10.1/a"},
+		{"https://en.wikipedia.org/wiki/Citation", "https://en.wikipedia.org/wiki/Citation"},
+	}
+	for _, c := range cases {
+		if result := NormalizeID(c.s); result != c.result {
+			t.Fatalf("%s: got %s, want %s", c.s, result, c.result)
+		}
+	}
+}
+
+func TestEventData(t *testing.T) {
+	events := `{"source_id": "wikipedia", "subj_id": "https://en.wikipedia.org/wiki/X", "obj_id": "https://doi.org/10.1/A", "relation_type_id": "references"}
+{"source_id": "crossref", "subj_id": "https://doi.org/10.1/b", "obj_id": "https://doi.org/10.1/c", "relation_type_id": "is_referenced_by"}
+
+{"source_id": "crossref", "subj_id": "https://doi.org/10.1/b", "obj_id": "https://doi.org/10.1/d", "relation_type_id": "has_preprint"}
+{"source_id": "twitter", "subj_id": "https://twitter.com/x", "obj_id": "https://doi.org/10.1/e", "relation_type_id": "discusses"}
+`
+	var buf bytes.Buffer
+	w := NewTSVWriter(&buf)
+	if err := EventData(strings.NewReader(events), nil, w.Write); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "https://en.wikipedia.org/wiki/X\t10.1/a\n10.1/c\t10.1/b\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+	var n int
+	if err := EventData(strings.NewReader(events), []string{"crossref"}, func(Edge) error {
+		n++
+		return nil
+	}); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if n != 1 {
+		t.Fatalf("got %d edges, want 1", n)
+	}
+	if err := EventData(strings.NewReader("{\n"), nil, w.Write); err == nil {
+		t.Fatalf("want error for broken input")
+	}
+}
+
+func TestDataCite(t *testing.T) {
+	records := `{"id": "10.5061/dryad.1", "attributes": {"doi": "10.5061/DRYAD.1", "relatedIdentifiers": [` +
+		`{"relatedIdentifier": "10.1/a", "relatedIdentifierType": "DOI", "relationType": "IsCitedBy"}, ` +
+		`{"relatedIdentifier": "https://doi.org/10.1/b", "relatedIdentifierType": "DOI", "relationType": "References"}, ` +
+		`{"relatedIdentifier": "10.1/c", "relatedIdentifierType": "DOI", "relationType": "IsSupplementTo"}, ` +
+		`{"relatedIdentifier": "https://example.com", "relatedIdentifierType": "URL", "relationType": "Cites"}]}}
+{"doi": "10.5281/zenodo.2", "relatedIdentifiers": [{"relatedIdentifier": "10.1/d", "relatedIdentifierType": "DOI", "relationType": "Cites"}]}
+`
+	var buf bytes.Buffer
+	w := NewTSVWriter(&buf)
+	if err := DataCite(strings.NewReader(records), w.Write); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "10.1/a\t10.5061/dryad.1\n10.5061/dryad.1\t10.1/b\n10.5281/zenodo.2\t10.1/d\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+}
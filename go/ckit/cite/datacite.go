@@ -0,0 +1,83 @@
+package cite
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// DataCiteAttributes are the fields of a DataCite record we need, cf.
+// https://support.datacite.org/docs/api.
+type DataCiteAttributes struct {
+	DOI                string `json:"doi"`
+	RelatedIdentifiers []struct {
+		RelatedIdentifier     string `json:"relatedIdentifier"`
+		RelatedIdentifierType string `json:"relatedIdentifierType"`
+		RelationType          string `json:"relationType"`
+	} `json:"relatedIdentifiers"`
+}
+
+// Edges returns the citations between DOI expressed by the related
+// identifiers of a record.
+func (a *DataCiteAttributes) Edges() (edges []Edge) {
+	doi := NormalizeID(a.DOI)
+	if doi == "" {
+		return nil
+	}
+	for _, rel := range a.RelatedIdentifiers {
+		if !strings.EqualFold(rel.RelatedIdentifierType, "DOI") {
+			continue
+		}
+		other := NormalizeID(rel.RelatedIdentifier)
+		if other == "" || other == doi {
+			continue
+		}
+		switch rel.RelationType {
+		case "Cites", "References":
+			edges = append(edges, Edge{Citing: doi, Cited: other})
+		case "IsCitedBy", "IsReferencedBy":
+			edges = append(edges, Edge{Citing: other, Cited: doi})
+		}
+	}
+	return edges
+}
+
+// DataCite reads DataCite records, one JSON object per line, and calls f for
+// each citation. Records can be API resources, with the fields under
+// "attributes", or plain attributes.
+func DataCite(r io.Reader, f func(Edge) error) error {
+	br := bufio.NewReader(r)
+	for i := 1; ; i++ {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var doc struct {
+			Attributes *DataCiteAttributes `json:"attributes"`
+		}
+		if err := json.Unmarshal(line, &doc); err != nil {
+			return fmt.Errorf("line %d: %w", i, err)
+		}
+		if doc.Attributes == nil {
+			doc.Attributes = &DataCiteAttributes{}
+			if err := json.Unmarshal(line, doc.Attributes); err != nil {
+				return fmt.Errorf("line %d: %w", i, err)
+			}
+		}
+		for _, edge := range doc.Attributes.Edges() {
+			if err := f(edge); err != nil {
+				return err
+			}
+		}
+	}
+}
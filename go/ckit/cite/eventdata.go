@@ -0,0 +1,80 @@
+package cite
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// Event is a Crossref Event Data event, cf.
+// https://www.eventdata.crossref.org/guide/data/events/.
+type Event struct {
+	ID             string `json:"id"`
+	SourceID       string `json:"source_id"`
+	SubjID         string `json:"subj_id"`
+	ObjID          string `json:"obj_id"`
+	RelationTypeID string `json:"relation_type_id"`
+}
+
+// Edge returns the citation expressed by an event, if any.
+func (e *Event) Edge() (Edge, bool) {
+	var (
+		subj = NormalizeID(e.SubjID)
+		obj  = NormalizeID(e.ObjID)
+	)
+	if subj == "" || obj == "" || subj == obj {
+		return Edge{}, false
+	}
+	switch e.RelationTypeID {
+	case "references", "cites":
+		return Edge{Citing: subj, Cited: obj}, true
+	case "is_referenced_by", "is_cited_by":
+		return Edge{Citing: obj, Cited: subj}, true
+	default:
+		return Edge{}, false
+	}
+}
+
+// EventData reads events, one JSON object per line, and calls f for each
+// citation. If sources are given, only events from these sources are
+// considered, e.g. "wikipedia", "crossref" or "datacite".
+func EventData(r io.Reader, sources []string, f func(Edge) error) error {
+	br := bufio.NewReader(r)
+	for i := 1; ; i++ {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return fmt.Errorf("line %d: %w", i, err)
+		}
+		if len(sources) > 0 && !contains(sources, ev.SourceID) {
+			continue
+		}
+		if edge, ok := ev.Edge(); ok {
+			if err := f(edge); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// contains returns true, if a string is in a slice.
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
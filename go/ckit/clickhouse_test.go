@@ -0,0 +1,200 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/fakedata"
+)
+
+// parseClickHouseArray parses an Array(String) parameter value, as written
+// by clickhouseArray.
+func parseClickHouseArray(s string) (result []string) {
+	var (
+		sb     strings.Builder
+		quoted bool
+	)
+	for i := 1; i < len(s)-1; i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+			switch s[i] {
+			case 't':
+				sb.WriteByte('\t')
+			case 'n':
+				sb.WriteByte('\n')
+			default:
+				sb.WriteByte(s[i])
+			}
+		case c == '\'' && quoted:
+			result = append(result, sb.String())
+			sb.Reset()
+			quoted = false
+		case c == '\'':
+			quoted = true
+		case quoted:
+			sb.WriteByte(c)
+		}
+	}
+	return result
+}
+
+// fakeClickHouse answers the queries of a ClickHouseEdgeStore from a list of
+// edges in the labe database.
+func fakeClickHouse(edges *[]fakedata.Row) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			io.WriteString(w, "Ok.\n")
+			return
+		}
+		if r.URL.Query().Get("database") != "labe" {
+			http.Error(w, "Code: 81. DB::Exception: Database default does not exist", http.StatusNotFound)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		var (
+			q    = string(b)
+			doi  = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n").Replace(r.URL.Query().Get("param_doi"))
+			rows []Map
+		)
+		switch {
+		case strings.Contains(q, "uniqExact"):
+			citing, cited := make(map[string]bool), make(map[string]bool)
+			for _, row := range *edges {
+				if row.Key == doi {
+					citing[row.Value] = true
+				}
+				if row.Value == doi {
+					cited[row.Key] = true
+				}
+			}
+			fmt.Fprintf(w, "{\"citing\":%d,\"cited\":%d}\n", len(citing), len(cited))
+			return
+		case strings.Contains(q, "arrayJoin"):
+			dois := make(map[string]bool)
+			for _, v := range parseClickHouseArray(r.URL.Query().Get("param_dois")) {
+				dois[v] = true
+			}
+			forward := strings.Contains(q, "WHERE k IN")
+			for _, row := range *edges {
+				if (forward && dois[row.Key]) || (!forward && dois[row.Value]) {
+					rows = append(rows, Map{Key: row.Key, Value: row.Value})
+				}
+			}
+		case strings.Contains(q, "WHERE k = {doi:String}"):
+			for _, row := range *edges {
+				if row.Key == doi {
+					rows = append(rows, Map{Key: row.Key, Value: row.Value})
+				}
+			}
+		case strings.Contains(q, "WHERE v = {doi:String}"):
+			for _, row := range *edges {
+				if row.Value == doi {
+					rows = append(rows, Map{Key: row.Key, Value: row.Value})
+				}
+			}
+		default:
+			http.Error(w, "Code: 62. DB::Exception: Syntax error", http.StatusBadRequest)
+			return
+		}
+		for _, m := range rows {
+			b, _ := json.Marshal(map[string]string{"k": m.Key, "v": m.Value})
+			fmt.Fprintf(w, "%s\n", b)
+		}
+	}))
+}
+
+func TestClickHouseArray(t *testing.T) {
+	dois := []string{"10.1/a", `10.1/it's`, `10.1/back\slash`, "10.1/tab\there"}
+	got := parseClickHouseArray(clickhouseArray(dois))
+	if fmt.Sprint(got) != fmt.Sprint(dois) {
+		t.Fatalf("got %q, want %q", got, dois)
+	}
+	if s := clickhouseArray(nil); s != "[]" {
+		t.Fatalf("got %s, want []", s)
+	}
+}
+
+func TestClickHouseEdgeStore(t *testing.T) {
+	edges := []fakedata.Row{
+		{Key: "10.1/a", Value: "10.1/b"},
+		{Key: "10.1/a", Value: "10.1/c"},
+		{Key: "10.1/c", Value: "10.1/b"},
+		{Key: `10.1/it's`, Value: "10.1/a"},
+	}
+	ts := fakeClickHouse(&edges)
+	defer ts.Close()
+	var (
+		ctx = context.Background()
+		c   = &ClickHouseEdgeStore{URL: ts.URL + "/?database=labe"}
+	)
+	if err := c.Ping(); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	citing, cited, err := c.Edges(ctx, "10.1/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(citing) != 2 || len(cited) != 1 || cited[0].Key != `10.1/it's` {
+		t.Fatalf("got %v, %v, want 2 citing, 1 cited", citing, cited)
+	}
+	n, m, err := c.Counts(ctx, "10.1/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 || m != 2 {
+		t.Fatalf("got %d, %d, want 0, 2", n, m)
+	}
+	neighbors, err := c.Neighbors(ctx, []string{"10.1/b", "10.1/a"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, v := range neighbors {
+		keys = append(keys, v.Key)
+	}
+	sort.Strings(keys)
+	if want := "[10.1/a 10.1/c 10.1/it's]"; fmt.Sprint(keys) != want {
+		t.Fatalf("got %v, want %v", keys, want)
+	}
+	c.URL = ts.URL + "/?database=default"
+	if _, _, err := c.Edges(ctx, "10.1/a"); err == nil || !strings.Contains(err.Error(), "Code: 81") {
+		t.Fatalf("got %v, want ClickHouse error", err)
+	}
+}
+
+func TestIntegrationClickHouseEdgeStore(t *testing.T) {
+	var edges []fakedata.Row
+	ts := fakeClickHouse(&edges)
+	defer ts.Close()
+	h := newHarness(t, func(s *Server) {
+		// Without edges in the oci database, they can only come from ClickHouse.
+		s.OciDatabase = memoryDatabase(t, nil)
+		s.Edges = &ClickHouseEdgeStore{URL: ts.URL + "/?database=labe"}
+	})
+	edges = h.data.Citations
+	id, doi := h.someID(t)
+	e := h.expect(doi)
+	checkResponse(t, id, h.getResponse(t, "/id/"+id), e)
+	resp, _ := h.do(t, "HEAD", "/id/"+id)
+	if got := resp.Header.Get("X-Cited-Count"); got != fmt.Sprint(e.inbound) {
+		t.Fatalf("got X-Cited-Count %s, want %d", got, e.inbound)
+	}
+	resp, b := h.do(t, "GET", "/healthz")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("healthz: got %v, want 200: %s", resp.StatusCode, b)
+	}
+	ts.Close()
+	resp, b = h.do(t, "GET", "/healthz")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("healthz: got %v, want 503: %s", resp.StatusCode, b)
+	}
+}
@@ -0,0 +1,369 @@
+// Package client implements a client for the labed API, so services do not
+// need to assemble URLs and decode responses themselves.
+//
+//	c := client.New("http://localhost:8000")
+//	resp, err := c.Resolve(ctx, "ai-49-aHR0c...", client.Options{})
+//	if err == client.ErrNotFound { ... }
+//
+// Failed requests are retried, cf. Client.Retries.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit"
+)
+
+const (
+	DefaultTimeout     = 60 * time.Second
+	DefaultRetries     = 3
+	DefaultRetryWait   = 500 * time.Millisecond
+	DefaultConcurrency = 8
+	// maxErrorBody limits the part of an error response kept in an Error.
+	maxErrorBody = 1024
+)
+
+// ErrNotFound is returned, if an id or DOI is unknown or has no citations.
+var ErrNotFound = errors.New("not found")
+
+// Error is returned for unexpected responses, e.g. a 413, if a response
+// would be too large, or a 500.
+type Error struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("labed: %s: %s", http.StatusText(e.StatusCode), strings.TrimSpace(e.Body))
+}
+
+// Client for a labed server. Settings must not be changed after the first
+// request; a client is safe for concurrent use.
+type Client struct {
+	// Server is the base URL of labed, including a path prefix, if any, e.g.
+	// http://localhost:8000 or https://example.com/labe/v1.
+	Server     string
+	HTTPClient *http.Client
+	// Timeout limits a single attempt, zero means no limit; the context
+	// passed to a method limits all attempts together.
+	Timeout time.Duration
+	// Retries is the number of retries after network errors and responses
+	// with status 429, 502, 503 or 504. RetryWait is the time to wait
+	// before the first retry; it doubles with each retry, unless the server
+	// sends a Retry-After header.
+	Retries   int
+	RetryWait time.Duration
+	// Concurrency is the number of requests in flight in Batch.
+	Concurrency int
+}
+
+// New returns a client with default settings.
+func New(server string) *Client {
+	return &Client{
+		Server:      server,
+		HTTPClient:  ckit.NewHTTPClient(DefaultConcurrency, 0),
+		Timeout:     DefaultTimeout,
+		Retries:     DefaultRetries,
+		RetryWait:   DefaultRetryWait,
+		Concurrency: DefaultConcurrency,
+	}
+}
+
+// Options for a response; the zero value requests the server defaults.
+type Options struct {
+	// NoBlobs requests local id and DOI only, instead of index data.
+	NoBlobs bool
+	// OmitUnmatched leaves out unmatched documents; counts are kept.
+	OmitUnmatched bool
+	// Sorted orders documents by DOI.
+	Sorted bool
+	// Offset selects a page of a truncated response.
+	Offset int
+	// Institution, e.g. an ISIL, and Filter restrict the documents, cf.
+	// ckit.ParseFilter.
+	Institution string
+	Filter      string
+}
+
+// values returns the options as query parameters.
+func (o Options) values() url.Values {
+	v := url.Values{}
+	if o.NoBlobs {
+		v.Set("blobs", "false")
+	}
+	if o.OmitUnmatched {
+		v.Set("unmatched", "false")
+	}
+	if o.Sorted {
+		v.Set("sorted", "true")
+	}
+	if o.Offset > 0 {
+		v.Set("offset", strconv.Itoa(o.Offset))
+	}
+	if o.Institution != "" {
+		v.Set("i", o.Institution)
+	}
+	if o.Filter != "" {
+		v.Set("filter", o.Filter)
+	}
+	return v
+}
+
+// Counts are the number of citing, cited and unmatched DOI of a document.
+type Counts struct {
+	Citing    int
+	Cited     int
+	Unmatched int
+}
+
+// Resolve returns the response for a local id.
+func (c *Client) Resolve(ctx context.Context, id string, opts Options) (*ckit.Response, error) {
+	b, err := c.resolve(ctx, id, opts)
+	if err != nil {
+		return nil, err
+	}
+	return decode(b)
+}
+
+// ResolveDOI returns the response for the local document with a given DOI.
+func (c *Client) ResolveDOI(ctx context.Context, doi string, opts Options) (*ckit.Response, error) {
+	segments := strings.Split(doi, "/")
+	for i, v := range segments {
+		segments[i] = url.PathEscape(v)
+	}
+	_, b, err := c.do(ctx, "GET", "/doi/"+strings.Join(segments, "/"), opts.values())
+	if err != nil {
+		return nil, err
+	}
+	return decode(b)
+}
+
+// Counts returns the number of citing, cited and unmatched DOI of a local
+// id, without fetching documents.
+func (c *Client) Counts(ctx context.Context, id string) (*Counts, error) {
+	resp, _, err := c.do(ctx, "HEAD", "/id/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		counts Counts
+		fields = []struct {
+			header string
+			v      *int
+		}{
+			{"X-Citing-Count", &counts.Citing},
+			{"X-Cited-Count", &counts.Cited},
+			{"X-Unmatched-Count", &counts.Unmatched},
+		}
+	)
+	for _, f := range fields {
+		if *f.v, err = strconv.Atoi(resp.Header.Get(f.header)); err != nil {
+			return nil, fmt.Errorf("labed: %s: %w", f.header, err)
+		}
+	}
+	return &counts, nil
+}
+
+// Result of a single lookup in a batch. Err is ErrNotFound for unknown ids.
+type Result struct {
+	ID       string
+	Response *ckit.Response
+	Err      error
+	raw      []byte
+}
+
+// Batch resolves ids concurrently and calls f for each result, in the order
+// in which the results arrive; f is never called concurrently. If f returns
+// an error, Batch stops and returns it.
+func (c *Client) Batch(ctx context.Context, ids []string, opts Options, f func(Result) error) error {
+	return c.batch(ctx, ids, opts, true, f)
+}
+
+// BatchNDJSON resolves ids concurrently and writes the responses to w, one
+// JSON document per line, as they arrive. Unknown ids are skipped; any other
+// error stops the batch.
+func (c *Client) BatchNDJSON(ctx context.Context, ids []string, opts Options, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	err := c.batch(ctx, ids, opts, false, func(r Result) error {
+		switch {
+		case r.Err == ErrNotFound:
+			return nil
+		case r.Err != nil:
+			return fmt.Errorf("%s: %w", r.ID, r.Err)
+		}
+		if _, err := bw.Write(bytes.TrimSpace(r.raw)); err != nil {
+			return err
+		}
+		return bw.WriteByte('\n')
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// batch runs the lookups for a batch; with decodeResponse unset, results
+// carry the raw response body only.
+func (c *Client) batch(ctx context.Context, ids []string, opts Options, decodeResponse bool, f func(Result) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		queue   = make(chan string)
+		results = make(chan Result)
+		wg      sync.WaitGroup
+		n       = c.Concurrency
+	)
+	if n < 1 {
+		n = 1
+	}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range queue {
+				r := Result{ID: id}
+				r.raw, r.Err = c.resolve(ctx, id, opts)
+				if r.Err == nil && decodeResponse {
+					r.Response, r.Err = decode(r.raw)
+					r.raw = nil
+				}
+				select {
+				case results <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(queue)
+		for _, id := range ids {
+			select {
+			case queue <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	for r := range results {
+		if err := f(r); err != nil {
+			cancel()
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// resolve returns the raw response for a local id.
+func (c *Client) resolve(ctx context.Context, id string, opts Options) ([]byte, error) {
+	_, b, err := c.do(ctx, "GET", "/id/"+url.PathEscape(id), opts.values())
+	return b, err
+}
+
+// decode parses a response body.
+func decode(b []byte) (*ckit.Response, error) {
+	var resp ckit.Response
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("labed: decode: %w", err)
+	}
+	return &resp, nil
+}
+
+// do performs a request and returns the response with its body read;
+// failed attempts are retried.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values) (*http.Response, []byte, error) {
+	link := strings.TrimRight(c.Server, "/") + path
+	if len(query) > 0 {
+		link = link + "?" + query.Encode()
+	}
+	wait := c.RetryWait
+	for attempt := 0; ; attempt++ {
+		resp, b, err := c.attempt(ctx, method, link)
+		if err == nil {
+			switch {
+			case resp.StatusCode == http.StatusOK:
+				return resp, b, nil
+			case resp.StatusCode == http.StatusNotFound:
+				return nil, nil, ErrNotFound
+			}
+			if len(b) > maxErrorBody {
+				b = b[:maxErrorBody]
+			}
+			err = &Error{StatusCode: resp.StatusCode, Body: string(b)}
+		}
+		if attempt >= c.Retries || !retryable(resp, err) || ctx.Err() != nil {
+			return nil, nil, err
+		}
+		d := wait
+		if resp != nil {
+			if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 {
+				d = time.Duration(s) * time.Second
+			}
+		}
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+		wait *= 2
+	}
+}
+
+// attempt performs a single request, limited by the client timeout.
+func (c *Client) attempt(ctx context.Context, method, link string) (*http.Response, []byte, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, link, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, b, nil
+}
+
+// retryable returns true for network errors and responses, that indicate an
+// overloaded or restarting server.
+func retryable(resp *http.Response, err error) bool {
+	if resp == nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
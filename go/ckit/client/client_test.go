@@ -0,0 +1,196 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit"
+)
+
+// testServer answers like labed: ids starting with "x" are unknown, "doi"
+// redirects to "id", "flaky" fails twice with 503 and "broken" always fails.
+func testServer(t *testing.T) (*httptest.Server, *int64) {
+	var flaky int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("/id/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/id/")
+		switch {
+		case strings.HasPrefix(id, "x"):
+			http.NotFound(w, r)
+			return
+		case id == "flaky" && atomic.AddInt64(&flaky, 1) <= 2:
+			http.Error(w, "restarting", http.StatusServiceUnavailable)
+			return
+		case id == "broken":
+			http.Error(w, "disk I/O error", http.StatusInternalServerError)
+			return
+		}
+		if r.Method == "HEAD" {
+			w.Header().Set("X-Citing-Count", "3")
+			w.Header().Set("X-Cited-Count", "2")
+			w.Header().Set("X-Unmatched-Count", "1")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ckit.Response{
+			ID:  id,
+			DOI: "10.1/" + id + "?" + r.URL.RawQuery,
+		})
+	})
+	mux.HandleFunc("/doi/", func(w http.ResponseWriter, r *http.Request) {
+		doi := strings.TrimPrefix(r.URL.Path, "/doi/")
+		if doi != "10.1/a b" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/id/a?"+r.URL.RawQuery, http.StatusTemporaryRedirect)
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts, &flaky
+}
+
+func testClient(ts *httptest.Server) *Client {
+	c := New(ts.URL)
+	c.RetryWait = time.Millisecond
+	return c
+}
+
+func TestResolve(t *testing.T) {
+	ts, flaky := testServer(t)
+	c := testClient(ts)
+	ctx := context.Background()
+	resp, err := c.Resolve(ctx, "a", Options{NoBlobs: true, Offset: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != "a" || resp.DOI != "10.1/a?blobs=false&offset=10" {
+		t.Fatalf("got %v, %v", resp.ID, resp.DOI)
+	}
+	if _, err := c.Resolve(ctx, "x1", Options{}); err != ErrNotFound {
+		t.Fatalf("got %v, want %v", err, ErrNotFound)
+	}
+	if resp, err = c.Resolve(ctx, "flaky", Options{}); err != nil || resp.ID != "flaky" {
+		t.Fatalf("got %v, %v", resp, err)
+	}
+	if n := atomic.LoadInt64(flaky); n != 3 {
+		t.Fatalf("got %d attempts, want 3", n)
+	}
+	var e *Error
+	if _, err := c.Resolve(ctx, "broken", Options{}); !errors.As(err, &e) || e.StatusCode != 500 {
+		t.Fatalf("got %v, want 500", err)
+	}
+	// Retries are exhausted.
+	atomic.StoreInt64(flaky, 0)
+	c.Retries = 1
+	if _, err := c.Resolve(ctx, "flaky", Options{}); !errors.As(err, &e) || e.StatusCode != 503 {
+		t.Fatalf("got %v, want 503", err)
+	}
+}
+
+func TestResolveDOI(t *testing.T) {
+	ts, _ := testServer(t)
+	c := testClient(ts)
+	resp, err := c.ResolveDOI(context.Background(), "10.1/a b", Options{Sorted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != "a" || resp.DOI != "10.1/a?sorted=true" {
+		t.Fatalf("got %v, %v", resp.ID, resp.DOI)
+	}
+	if _, err := c.ResolveDOI(context.Background(), "10.1/b", Options{}); err != ErrNotFound {
+		t.Fatalf("got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestCounts(t *testing.T) {
+	ts, _ := testServer(t)
+	c := testClient(ts)
+	counts, err := c.Counts(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *counts != (Counts{Citing: 3, Cited: 2, Unmatched: 1}) {
+		t.Fatalf("got %v", counts)
+	}
+	if _, err := c.Counts(context.Background(), "x1"); err != ErrNotFound {
+		t.Fatalf("got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ts, _ := testServer(t)
+	c := testClient(ts)
+	var ids []string
+	for i := 0; i < 50; i++ {
+		ids = append(ids, fmt.Sprintf("a%d", i), fmt.Sprintf("x%d", i))
+	}
+	var found, notFound int
+	err := c.Batch(context.Background(), ids, Options{}, func(r Result) error {
+		switch {
+		case r.Err == ErrNotFound:
+			notFound++
+		case r.Err != nil:
+			return r.Err
+		case r.Response.ID != r.ID:
+			return fmt.Errorf("got %s, want %s", r.Response.ID, r.ID)
+		default:
+			found++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != 50 || notFound != 50 {
+		t.Fatalf("got %d found, %d not found", found, notFound)
+	}
+	// Errors from the callback stop the batch.
+	stop := errors.New("stop")
+	if err := c.Batch(context.Background(), ids, Options{}, func(r Result) error { return stop }); err != stop {
+		t.Fatalf("got %v, want %v", err, stop)
+	}
+	// Empty batches return immediately.
+	if err := c.Batch(context.Background(), nil, Options{}, func(r Result) error { return stop }); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+}
+
+func TestBatchNDJSON(t *testing.T) {
+	ts, _ := testServer(t)
+	c := testClient(ts)
+	var buf bytes.Buffer
+	if err := c.BatchNDJSON(context.Background(), []string{"a", "x", "b"}, Options{}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		got     []string
+		scanner = bufio.NewScanner(&buf)
+	)
+	for scanner.Scan() {
+		var resp ckit.Response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, resp.ID)
+	}
+	sort.Strings(got)
+	if strings.Join(got, " ") != "a b" {
+		t.Fatalf("got %v", got)
+	}
+	c.Retries = 0
+	if err := c.BatchNDJSON(context.Background(), []string{"a", "broken"}, Options{}, &buf); err == nil {
+		t.Fatalf("got nil, want error")
+	}
+}
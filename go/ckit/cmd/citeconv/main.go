@@ -0,0 +1,54 @@
+// citeconv converts citation data from sources other than OCI into tab
+// separated citing and cited identifiers, which makta turns into an edge
+// database for labed (-edges).
+//
+//	$ zstdcat events.jsonl.zst | citeconv -f eventdata | makta -o eventdata.db
+//	$ zstdcat datacite.jsonl.zst | citeconv -f datacite | makta -o datacite.db
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/slub/labe/go/ckit/cite"
+)
+
+var (
+	Version   string
+	Buildtime string
+
+	format      = flag.String("f", "eventdata", "input format: eventdata (Crossref Event Data events), datacite (DataCite records); one JSON object per line")
+	sources     = flag.String("s", "", "eventdata: only use events from these comma separated sources, e.g. wikipedia,crossref")
+	showVersion = flag.Bool("version", false, "show version and exit")
+)
+
+func main() {
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("citeconv %s %s\n", Version, Buildtime)
+		os.Exit(0)
+	}
+	var only []string
+	if *sources != "" {
+		only = strings.Split(*sources, ",")
+	}
+	w := cite.NewTSVWriter(os.Stdout)
+	var err error
+	switch *format {
+	case "eventdata":
+		err = cite.EventData(os.Stdin, only, w.Write)
+	case "datacite":
+		err = cite.DataCite(os.Stdin, w.Write)
+	default:
+		log.Fatalf("invalid format: %s", *format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
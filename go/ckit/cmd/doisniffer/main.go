@@ -25,6 +25,8 @@ var (
 	numWorkers      = flag.Int("w", runtime.NumCPU(), "number of workers")
 	batchSize       = flag.Int("b", 5000, "batch size")
 	showVersion     = flag.Bool("version", false, "show version and exit")
+	emitPairs       = flag.Bool("T", false, "emit (id, doi) TSV with normalized DOI, e.g. for makta, instead of documents")
+	emitAllPairs    = flag.Bool("A", false, "with -T, emit a line for each distinct DOI of a document, not only the first")
 )
 
 func main() {
@@ -38,11 +40,14 @@ func main() {
 		log.Fatal(err)
 	}
 	sniffer := &doi.Sniffer{
-		Reader:        os.Stdin,
-		Writer:        os.Stdout,
-		SkipUnmatched: !*noSkipUnmatched,
-		UpdateKey:     *updateKey,
-		IdentifierKey: *identifierKey,
+		Reader:         os.Stdin,
+		Writer:         os.Stdout,
+		SkipUnmatched:  !*noSkipUnmatched,
+		UpdateKey:      *updateKey,
+		ForceOverwrite: *forceOverwrite,
+		IdentifierKey:  *identifierKey,
+		Pairs:          *emitPairs,
+		AllPairs:       *emitAllPairs,
 		MapSniffer: &doi.MapSniffer{
 			Pattern:    regexp.MustCompile(doi.PatDOI),
 			IgnoreKeys: ignore,
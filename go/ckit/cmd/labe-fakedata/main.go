@@ -0,0 +1,79 @@
+// labe-fakedata generates small, consistent identifier, citation and index
+// databases, which labed can serve, for tests and demos.
+//
+//	$ labe-fakedata -o /tmp/fake -n 10000 -match 0.3
+//	$ labed -i /tmp/fake/id_doi.db -o /tmp/fake/doi_doi.db -m /tmp/fake/id_metadata.db
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/slub/labe/go/ckit/fakedata"
+)
+
+var (
+	Version   string
+	Buildtime string
+
+	defaults = fakedata.DefaultConfig()
+
+	outputDir    = flag.String("o", ".", "output directory")
+	numDocs      = flag.Int("n", defaults.Documents, "number of local documents")
+	matchRate    = flag.Float64("match", defaults.MatchRate, "fraction of all DOI, which belong to a local document")
+	citations    = flag.Int("c", defaults.Citations, "mean number of citations per DOI")
+	distribution = flag.String("dist", defaults.Distribution, "distribution of cited DOI: uniform or zipf")
+	skew         = flag.Float64("skew", defaults.Skew, "skew of the zipf distribution, greater than 1")
+	institutions = flag.String("institutions", strings.Join(defaults.Institutions, ","), "comma separated institutions, assigned in turn")
+	seed         = flag.Int64("seed", defaults.Seed, "random seed")
+	writeTSV     = flag.Bool("tsv", false, "write TSV files as well, e.g. for makta")
+	showVersion  = flag.Bool("version", false, "show version and exit")
+)
+
+func main() {
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("labe-fakedata %s %s\n", Version, Buildtime)
+		os.Exit(0)
+	}
+	config := fakedata.Config{
+		Documents:    *numDocs,
+		MatchRate:    *matchRate,
+		Citations:    *citations,
+		Distribution: *distribution,
+		Skew:         *skew,
+		Seed:         *seed,
+	}
+	if *institutions != "" {
+		config.Institutions = strings.Split(*institutions, ",")
+	}
+	data, err := fakedata.Generate(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	if err := data.WriteDatabases(context.Background(), *outputDir); err != nil {
+		log.Fatal(err)
+	}
+	if *writeTSV {
+		for name, rows := range map[string][]fakedata.Row{
+			"id_doi.tsv":      data.Identifiers,
+			"doi_doi.tsv":     data.Citations,
+			"id_metadata.tsv": data.Documents,
+		} {
+			if err := fakedata.WriteTSV(filepath.Join(*outputDir, name), rows); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	dois, edges, matched := data.Stats()
+	log.Printf("%d documents, %d DOI, %d citations (%d between local documents) written to %s",
+		len(data.Identifiers), dois, edges, matched, *outputDir)
+}
@@ -0,0 +1,118 @@
+// labe-loadtest replays requests for identifiers read from a file against a
+// labed URL, with ramped concurrency, and prints latency percentiles, error
+// rates and throughput per interval and for the whole run. It replaces
+//
+//	$ zstdcat ids.tsv.zst | parallel -j 40 "curl -s http://localhost:8000/id/{}" | pv -l > /dev/null
+//
+// with
+//
+//	$ zstdcat ids.tsv.zst | labe-loadtest -c 40
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit"
+	"github.com/slub/labe/go/ckit/loadtest"
+)
+
+var (
+	Version   string
+	Buildtime string
+
+	urlTemplate    = flag.String("u", "http://localhost:8000/id/%s", "URL template, %s is replaced with an identifier")
+	idFile         = flag.String("f", "", "file with one identifier per line (first column, tab separated), default: stdin")
+	limit          = flag.Int("n", 0, "replay at most n identifiers, 0 means all")
+	maxConcurrency = flag.Int("c", 16, "maximum number of concurrent requests")
+	startWorkers   = flag.Int("c-start", 1, "number of concurrent requests at start")
+	stepWorkers    = flag.Int("c-step", 1, "number of concurrent requests to add after each ramp interval, 0 for constant concurrency")
+	rampInterval   = flag.Duration("ramp", 10*time.Second, "ramp interval")
+	reportInterval = flag.Duration("i", 5*time.Second, "report interval")
+	timeout        = flag.Duration("timeout", 30*time.Second, "timeout for a single request")
+	jsonOutput     = flag.Bool("json", false, "print reports as JSON lines")
+	showVersion    = flag.Bool("version", false, "show version and exit")
+)
+
+func main() {
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("labe-loadtest %s %s\n", Version, Buildtime)
+		os.Exit(0)
+	}
+	var r io.Reader = os.Stdin
+	if *idFile != "" {
+		f, err := os.Open(*idFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+	ids, err := readIdentifiers(r, *limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("replaying %d ids against %s", len(ids), *urlTemplate)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	runner := &loadtest.Runner{
+		URL:            *urlTemplate,
+		Client:         ckit.NewHTTPClient(*maxConcurrency, *timeout),
+		Start:          *startWorkers,
+		Step:           *stepWorkers,
+		Max:            *maxConcurrency,
+		RampInterval:   *rampInterval,
+		ReportInterval: *reportInterval,
+		Report:         printReport,
+	}
+	total, err := runner.Run(ctx, ids)
+	if err != nil && err != context.Canceled {
+		log.Fatal(err)
+	}
+	if !*jsonOutput {
+		fmt.Println(strings.Repeat("-", 40))
+	}
+	printReport(total)
+}
+
+// readIdentifiers reads the first column of each non-empty line.
+func readIdentifiers(r io.Reader, limit int) ([]string, error) {
+	var (
+		ids []string
+		br  = bufio.NewScanner(r)
+	)
+	for br.Scan() {
+		line := strings.TrimSpace(br.Text())
+		if line == "" {
+			continue
+		}
+		if i := strings.Index(line, "\t"); i >= 0 {
+			line = line[:i]
+		}
+		ids = append(ids, line)
+		if limit > 0 && len(ids) == limit {
+			break
+		}
+	}
+	return ids, br.Err()
+}
+
+func printReport(r loadtest.Report) {
+	if *jsonOutput {
+		if err := json.NewEncoder(os.Stdout).Encode(r); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	fmt.Println(r)
+}
@@ -9,11 +9,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -26,7 +28,10 @@ import (
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/slub/labe/go/ckit"
+	"github.com/slub/labe/go/ckit/activation"
 	"github.com/slub/labe/go/ckit/cache"
+	"github.com/slub/labe/go/ckit/degree"
+	"github.com/slub/labe/go/ckit/notify"
 	"github.com/slub/labe/go/ckit/xflag"
 	"github.com/thoas/stats"
 )
@@ -36,18 +41,106 @@ var (
 	identifierDatabasePath = flag.String("i", "", "identifier database path (id-doi mapping)")
 	ociDatabasePath        = flag.String("o", "", "oci as a database path (citations)")
 	enableStopWatch        = flag.Bool("stopwatch", false, "enable stopwatch (debug)")
+	enableDebug            = flag.Bool("debug", false, "log every sql query and index data fetch of a request")
+	stopWatchJSON          = flag.Bool("stopwatch-json", false, "log stopwatch timings as a single JSON line per request instead of a table")
+	stopWatchThreshold     = flag.Duration("stopwatch-min", 0, "only log stopwatch tables of requests taking at least this long, e.g. 500ms")
+	enableBench            = flag.Bool("bench", false, "allow clients to request per-phase timings in extra.bench with bench=1, bypassing the cache")
 	enableGzip             = flag.Bool("z", false, "enable gzip compression middleware")
+	enableZstdPassThrough  = flag.Bool("zp", false, "send cached responses zstd compressed to clients accepting it (cannot be used with -z)")
 	enableCache            = flag.Bool("c", false, "enable caching of expensive responses")
 	cacheTriggerDuration   = flag.Duration("ct", 250*time.Millisecond, "cache trigger duration")
+	enableSingleFlight     = flag.Bool("sf", false, "collapse concurrent requests for the same id into one")
+	notFoundTTL            = flag.Duration("nt", 5*time.Minute, "how long to remember ids and DOI without results (0 disables)")
 	cacheMaxFileSize       = flag.Int64("cx", 1<<36, "maximum filesize cache in bytes")
+	cacheSnapshotPath      = flag.String("cs", "", "cache snapshot path, restored at startup and written periodically and on shutdown (off, if empty)")
+	cacheSnapshotInterval  = flag.Duration("csi", 1*time.Hour, "cache snapshot interval")
+	cacheControlToken      = flag.String("cache-control-token", "", "bearer token that allows clients to bypass or limit cached responses via Cache-Control (off, if empty)")
+	adminToken             = flag.String("admin-token", "", "bearer token for admin routes, e.g. DELETE /cache (admin routes disabled, if empty)")
+	refreshHotCount        = flag.Int("rn", 0, "number of most requested cached ids to refresh in the background (0 disables)")
+	refreshHotInterval     = flag.Duration("ri", 1*time.Hour, "background refresh interval for most requested ids")
+	refreshHotMaxAge       = flag.Duration("ra", 0, "maximum age of cached responses for most requested ids, younger ones are not refreshed (0 refreshes on every interval)")
 	showVersion            = flag.Bool("version", false, "show version and exit")
 	accessLogFile          = flag.String("a", "", "path to access log file (off, if empty)")
 	logFile                = flag.String("logfile", "", "application log file (stderr if empty)")
 	quiet                  = flag.Bool("q", false, "no application logging at all")
+	pathPrefix             = flag.String("prefix", "", "mount all routes under a given path prefix, e.g. /labe/v1")
+	httpMaxIdlePerHost     = flag.Int("u-max-idle", ckit.DefaultMaxIdleConnsPerHost, "maximum idle (keep-alive) connections per index metadata service host")
+	httpTimeout            = flag.Duration("u-timeout", 5*time.Second, "timeout for a single index metadata service request")
+	rangeCachePages        = flag.Int("mr-cache", ckit.DefaultRangeCachePages, "pages of each remote index metadata database (-mr) to keep in memory")
+	identifierTimeout      = flag.Duration("i-timeout", 10*time.Second, "identifier database query timeout per request (0 disables)")
+	ociTimeout             = flag.Duration("o-timeout", 30*time.Second, "oci database query timeout per request (0 disables)")
+	indexDataConcurrency   = flag.Int("blob-concurrency", 0, "maximum number of concurrent index metadata fetches across all requests (0 means unlimited)")
+	indexDataTimeout       = flag.Duration("blob-timeout", 60*time.Second, "index metadata fetch timeout for all blobs of a request (0 disables)")
+	sqliteMmapSize         = flag.Int64("mmap-size", 0, "memory map up to this many bytes of each sqlite database, e.g. 68719476736 for 64GB (0 keeps the sqlite default)")
+	sqliteCacheSize        = flag.Int64("cache-size", 0, "sqlite page cache size per connection, in pages if positive, in KiB if negative (0 keeps the sqlite default)")
+	trustedProxies         = flag.String("trusted-proxies", "", "comma separated addresses or networks of reverse proxies, e.g. 127.0.0.1,10.0.0.0/8; the client address of their requests is taken from X-Forwarded-For or X-Real-IP")
+	sqliteTempStore        = flag.String("temp-store", "", "sqlite temp store: default, file or memory (empty keeps the sqlite default)")
+	identifierInMemory     = flag.Bool("identifier-memory", false, "load the identifier database into memory at startup and on rotation")
+	clickHouseURL          = flag.String("edges-clickhouse", "", "ClickHouse HTTP interface URL, e.g. http://localhost:8123/?database=labe, to read citation edges from instead of the oci database")
+	clickHouseTable        = flag.String("edges-clickhouse-table", ckit.DefaultClickHouseTable, "ClickHouse table with citation edges, columns k (citing) and v (cited)")
+	analyticsDSN           = flag.String("analytics", "", "analytical database with a copy of the oci edges, e.g. a DuckDB file, used for /prefix endpoints instead of the oci database")
+	analyticsDriver        = flag.String("analytics-driver", ckit.DefaultAnalyticsDriver, "database/sql driver for -analytics, which must be added to the build")
+	countsDatabasePath     = flag.String("counts", "", "path to a counts database of the oci database, cf. ocicounts, used for counts, /top and size estimates")
+	degreesPath            = flag.String("degrees", "", "path to a degree table of the oci database, cf. ocidegree, to answer counts without queries")
+	identifierFilterRate   = flag.Float64("bloom", 0, "build a bloom filter over identifiers and DOI with this false positive rate, e.g. 0.01, to answer misses without a query (0 disables)")
+	busyRetries            = flag.Int("busy-retries", ckit.DefaultBusyRetries, "retries with backoff for queries failing with a busy or locked database (0 disables)")
+	degradedMode           = flag.Bool("degraded", false, "if index metadata cannot be fetched, respond with local ids and DOI only, marked extra.degraded, instead of an error")
+	jobWorkers             = flag.Int("jobs", 0, "number of workers for asynchronous jobs, POST /jobs (0 disables)")
+	jobTTL                 = flag.Duration("jobs-ttl", ckit.DefaultJobTTL, "how long to keep finished job results")
+	jobsPerClient          = flag.Int("jobs-per-client", ckit.DefaultMaxJobsPerClient, "maximum number of pending jobs per client address (0 means no limit)")
+	rankedPath             = flag.String("ranked", "", "path to DOI ranked by citation count, e.g. OpenCitationsRanked/current, enables /top")
+	maxRankedScan          = flag.Int("ranked-scan", ckit.DefaultMaxRankedScan, "maximum number of ranked DOI to look at for a most cited list")
+	maxPathDepth           = flag.Int("path-depth", ckit.DefaultMaxPathDepth, "maximum number of citations between two records for /path")
+	maxEdges               = flag.Int("max-edges", 0, "maximum number of citing and cited documents per response, larger responses are truncated and can be paged with offset or cursor (0 disables)")
+	maxResponseSize        = flag.Int64("max-response-size", 0, "reject requests with an estimated response size larger than this with 413, in bytes (0 disables)")
+	estimatedBlobSize      = flag.Int64("blob-size-estimate", ckit.DefaultEstimatedBlobSize, "assumed average index metadata size in bytes, used to estimate response sizes")
+	shutdownTimeout        = flag.Duration("shutdown-timeout", 30*time.Second, "time to wait for in-flight requests on shutdown")
+	corsMethods            = flag.String("cors-methods", "GET,HEAD,OPTIONS", "CORS allowed methods, comma separated")
+	corsMaxAge             = flag.Int("cors-max-age", 600, "CORS preflight max age in seconds")
+	middlewareChain        = flag.String("middleware", "stats,log,access,cors,keys,gzip", "middleware in order, outermost first; log, access, cors, keys and gzip apply only if enabled by -a, -access-list, -cors, -api-keys and -z")
+	apiKeysFile            = flag.String("api-keys", "", "JSON file with API keys and their daily quotas, e.g. [{\"name\": \"tu-berlin\", \"key\": \"...\", \"requests\": 100000, \"bytes\": 0}], cf. /usage")
+	apiKeysRequired        = flag.Bool("api-keys-required", false, "reject requests without an API key (-api-keys)")
+	accessListFile         = flag.String("access-list", "", "file with allow and deny rules by client address or network, one per line, e.g. deny 10.0.0.0/8; reloaded on SIGHUP")
+	memcachedPrefix        = flag.String("mc-prefix", "labe:", "key prefix for memcached")
+	explainQueries         = flag.Bool("explain", false, "log the sqlite query plans of the main queries at startup and warn about table scans, e.g. due to a missing index")
+	verifyDatabases        = flag.Bool("verify", false, "check databases on startup (tables, indices, rows, sqlite quick_check) and exit, if broken; may take a while")
+	manifestPath           = flag.String("manifest", "", "JSON file naming identifier, oci and index databases, used instead of -i, -o and -m; enables POST /rotate")
+	manifestWatch          = flag.Duration("manifest-watch", 0, "check manifest for changes and rotate automatically at this interval (0 disables)")
+	liveSolrURL            = flag.String("live-solr", "", "SOLR select handler URL, e.g. http://localhost:8983/solr/biblio/select, to look up unmatched DOI in")
+	liveElasticURL         = flag.String("live-es", "", "elasticsearch search URL, e.g. http://localhost:9200/biblio/_search, to look up unmatched DOI in")
+	liveField              = flag.String("live-field", "doi_str_mv", "DOI field in the live index")
+	oaDatabasePath         = flag.String("oa", "", "sqlite3 database mapping DOI to open access status (e.g. from Unpaywall), adds an oa field to all documents")
+	retractionsPath        = flag.String("retractions", "", "retraction list (Retraction Watch CSV or one DOI per line), adds a retraction field to retracted works")
+	selfCitations          = flag.Bool("self-citations", false, "flag citing and cited documents sharing an author or journal with the requested document")
+	selfAuthorFields       = flag.String("self-authors", "author,author2", "comma separated author fields in index data, for -self-citations")
+	selfJournalFields      = flag.String("self-journals", "container_title", "comma separated journal fields in index data, for -self-citations")
+	noCase                 = flag.Bool("nocase", false, "case insensitive DOI lookups, requires case insensitive indices (makta -N)")
+	sortedResponses        = flag.Bool("sorted", false, "sort citing, cited and unmatched documents by DOI in all responses, so they can be compared between data builds")
+	omitUnmatched          = flag.Bool("omit-unmatched", false, "leave unmatched documents out of responses by default, clients can ask for them with unmatched=true; counts are kept")
+	maxDataAge             = flag.Duration("max-data-age", 0, "report the service as degraded in /health, if any database is older, e.g. 768h (0 disables)")
+	staleCheckInterval     = flag.Duration("stale-check", time.Hour, "check databases against their expected refresh interval (-stale-after) at this interval, log an error and publish a stale event, if exceeded (0 disables)")
+	yearFields             = flag.String("year-fields", "", "comma separated index data fields with a publication year, e.g. publishDateSort, enables citation age histograms and /id/{id}/timeline")
+	enableOpenAlex         = flag.Bool("openalex", false, "enrich unmatched DOI with metadata from OpenAlex (https://openalex.org/)")
+	openAlexMailto         = flag.String("openalex-mailto", "", "email address to send to OpenAlex, to get into the polite pool")
+	openAlexCachePath      = flag.String("openalex-cache", "", "sqlite3 file to keep OpenAlex results in across restarts (no caching, if empty)")
+	schemaPath             = flag.String("schema", "", "JSON schema (subset: type, required, properties, items) to validate index data blobs against; use {} to check JSON syntax only")
+	schemaDrop             = flag.Bool("schema-drop", false, "drop index data blobs, that do not match -schema, instead of only listing them in extra.invalid")
 
 	sqliteFetcherPaths xflag.Array // allows to specify multiple database to get catalog metadata from
+	corsOrigins        xflag.Array // allowed CORS origins, CORS is off, if empty
+	httpFetcherURLs    xflag.Array // index data services, e.g. microblob
+	rangeFetcherURLs   xflag.Array // remote index data databases, read with range requests
+	memcachedServers   xflag.Array // shared cache, used instead of sqlite, if set
+	eventURLs          xflag.Array // where to publish data update events
+	webhookURLs        xflag.Array // called after data went live
+	corpusManifests    xflag.Array // additional corpora, name=manifest.json
+	edgeSources        xflag.Array // additional citation databases, name=path
+	stripRules         xflag.Array // fields to remove from index data blobs
+	transformerNames   xflag.Array // rewrite index data blobs, name:config
+	refreshIntervals   xflag.Array // expected dataset update intervals, name=duration
 
 	Version   string // set by makefile
+	Tag       string // set by makefile
 	Buildtime string // set by makefile
 	Help      string = `usage: labed [OPTION]
 
@@ -101,8 +194,40 @@ Examples:
 `
 )
 
+// Exit codes, so orchestration can tell configuration errors, which a
+// restart will not fix, from missing data and unavailable backends; other
+// errors exit with 1.
+const (
+	exitConfig      = 2 // invalid flags or configuration files
+	exitData        = 3 // a database or data file is missing or invalid
+	exitUnavailable = 4 // a backend, e.g. an index data service, is not available
+)
+
+// fatal logs and exits with a given code, like log.Fatal.
+func fatal(code int, v ...interface{}) {
+	log.Print(v...)
+	os.Exit(code)
+}
+
+// fatalf logs and exits with a given code, like log.Fatalf.
+func fatalf(code int, format string, v ...interface{}) {
+	log.Printf(format, v...)
+	os.Exit(code)
+}
+
 func main() {
-	flag.Var(&sqliteFetcherPaths, "m", "index metadata cache sqlite3 path (repeatable)")
+	flag.Var(&sqliteFetcherPaths, "m", "index metadata cache sqlite3 path or shard manifest, cf. makta -shards (repeatable)")
+	flag.Var(&httpFetcherURLs, "u", "index metadata service URL template, e.g. http://localhost:8820/%s (repeatable)")
+	flag.Var(&rangeFetcherURLs, "mr", "index metadata cache sqlite3 URL on an HTTP server, read with range requests (experimental, repeatable)")
+	flag.Var(&memcachedServers, "mc", "memcached server host:port to use as cache instead of sqlite, requires -c (repeatable)")
+	flag.Var(&eventURLs, "events", "publish data update events to nats://host:port/subject or via a Kafka REST proxy to kafka+http://host:port/topic (repeatable)")
+	flag.Var(&webhookURLs, "webhook", "URL to POST dataset metadata to, after new data went live or when data is stale (repeatable)")
+	flag.Var(&refreshIntervals, "stale-after", "expected refresh interval of a database, given as name=duration, e.g. oci=768h; names are identifier, oci and index; defaults: identifier=48h, index=48h, oci=768h (repeatable)")
+	flag.Var(&corpusManifests, "corpus", "serve an additional corpus under /c/{name}, given as name=manifest.json (repeatable)")
+	flag.Var(&stripRules, "strip", "remove a field from index data blobs in responses, e.g. fulltext, or only if larger than N bytes, e.g. abstract:2048 (repeatable)")
+	flag.Var(&transformerNames, "transform", "rewrite index data blobs with a compiled-in transformer, given as name:config, e.g. rename:title_full=title (repeatable)")
+	flag.Var(&edgeSources, "edges", "additional citation database, e.g. from Crossref Event Data, given as name=path, merged with -o and labeled by name (repeatable)")
+	flag.Var(&corsOrigins, "cors", "enable CORS for a given origin, use * for any (repeatable)")
 	flag.Usage = func() {
 		fmt.Printf(strings.Replace(Help, `{{ .listenAddr }}`, *listenAddr, -1))
 		fmt.Println("Flags")
@@ -111,9 +236,12 @@ func main() {
 	}
 	flag.Parse()
 	if *showVersion {
-		fmt.Printf("labed %v %v\n", Version, Buildtime)
+		fmt.Printf("labed %v %v %v\n", Tag, Version, Buildtime)
 		os.Exit(0)
 	}
+	if *enableGzip && *enableZstdPassThrough {
+		fatal(exitConfig, "-z and -zp cannot be used together")
+	}
 	var (
 		logWriter                       io.Writer = os.Stderr
 		identifierDatabase, ociDatabase *sqlx.DB
@@ -135,84 +263,412 @@ func main() {
 		}
 		log.SetOutput(logWriter)
 	}
+	// With a manifest, the databases to use are listed there.
+	var datasets *ckit.Datasets
+	if *manifestPath != "" {
+		if datasets, err = ckit.ReadManifest(*manifestPath); err != nil {
+			fatal(exitData, err)
+		}
+		*identifierDatabasePath, *ociDatabasePath = datasets.Identifier, datasets.Oci
+		*degreesPath, *countsDatabasePath = datasets.Degrees, datasets.Counts
+		if len(datasets.Index) > 0 {
+			sqliteFetcherPaths = xflag.Array(datasets.Index)
+		}
+	} else {
+		datasets = &ckit.Datasets{
+			Identifier: *identifierDatabasePath,
+			Oci:        *ociDatabasePath,
+			Index:      sqliteFetcherPaths,
+			Degrees:    *degreesPath,
+			Counts:     *countsDatabasePath,
+		}
+	}
 	// Setup database connections.
-	if identifierDatabase, err = ckit.OpenDatabase(*identifierDatabasePath); err != nil {
-		log.Fatal(err)
+	if err := ckit.SetSqliteOptions(ckit.SqliteOptions{
+		MmapSize:  *sqliteMmapSize,
+		CacheSize: *sqliteCacheSize,
+		TempStore: *sqliteTempStore,
+	}); err != nil {
+		fatal(exitConfig, err)
+	}
+	if *identifierInMemory {
+		started := time.Now()
+		identifierDatabase, err = ckit.LoadDatabase(context.Background(), *identifierDatabasePath)
+		if err != nil {
+			fatal(exitData, err)
+		}
+		log.Printf("loaded identifier database into memory in %s", time.Since(started))
+	} else if identifierDatabase, err = ckit.OpenDatabase(*identifierDatabasePath); err != nil {
+		fatal(exitData, err)
 	}
 	if ociDatabase, err = ckit.OpenDatabase(*ociDatabasePath); err != nil {
-		log.Fatal(err)
+		fatal(exitData, err)
+	}
+	// The flag counts retries, so zero disables them; the server and the
+	// fetchers take zero as the default and a negative value as disabled.
+	retries := *busyRetries
+	if retries == 0 {
+		retries = -1
 	}
 	// Setup index data fetcher.
 	switch {
-	case len(sqliteFetcherPaths) > 0:
-		g := &ckit.FetchGroup{}
+	case len(sqliteFetcherPaths) > 0 || len(httpFetcherURLs) > 0 || len(rangeFetcherURLs) > 0:
+		g := &ckit.FetchGroup{BusyRetries: retries}
 		if err := g.FromFiles(sqliteFetcherPaths...); err != nil {
-			log.Fatal(err)
+			fatal(exitData, err)
+		}
+		// Each HTTP backend gets its own client and connection pool.
+		for _, u := range httpFetcherURLs {
+			g.Backends = append(g.Backends, &ckit.HTTPFetcher{
+				URL:    u,
+				Client: ckit.NewHTTPClient(*httpMaxIdlePerHost, *httpTimeout),
+			})
+			g.Names = append(g.Names, u)
+		}
+		for _, u := range rangeFetcherURLs {
+			f, err := ckit.OpenRangeFetcher(u, ckit.NewHTTPClient(*httpMaxIdlePerHost, *httpTimeout), *rangeCachePages)
+			if err != nil {
+				fatal(exitUnavailable, err)
+			}
+			g.Backends = append(g.Backends, f)
+			g.Names = append(g.Names, u)
 		}
 		fetcher = g
-		log.Printf("[ok] setup group fetcher over %d database(s): %v",
-			len(g.Backends), sqliteFetcherPaths)
+		log.Printf("[ok] setup group fetcher over %d backend(s): %v %v %v",
+			len(g.Backends), sqliteFetcherPaths, httpFetcherURLs, rangeFetcherURLs)
+		if *indexDataConcurrency > 0 {
+			log.Printf("[ok] limiting concurrent index data fetches to %d", *indexDataConcurrency)
+		}
 	default:
-		log.Fatal("need at least one sqlite3 metadata index database (-m)")
+		fatal(exitConfig, "need at least one sqlite3 metadata index database (-m, -mr) or service (-u)")
 	}
 	// Setup server.
 	srv := &ckit.Server{
-		IdentifierDatabase: identifierDatabase,
-		OciDatabase:        ociDatabase,
-		IndexData:          fetcher,
-		Router:             mux.NewRouter(),
-		StopWatchEnabled:   *enableStopWatch,
-		Stats:              stats.New(),
+		IdentifierDatabase:   identifierDatabase,
+		OciDatabase:          ociDatabase,
+		IndexData:            fetcher,
+		IndexDataConcurrency: *indexDataConcurrency,
+		Router:               mux.NewRouter(),
+		StopWatchEnabled:     *enableStopWatch,
+		Debug:                *enableDebug,
+		StopWatchThreshold:   *stopWatchThreshold,
+		StopWatchJSON:        *stopWatchJSON,
+		Bench:                *enableBench,
+		SingleFlight:         *enableSingleFlight,
+		Stats:                stats.New(),
+		PathPrefix:           *pathPrefix,
+		IdentifierTimeout:    *identifierTimeout,
+		OciTimeout:           *ociTimeout,
+		IndexDataTimeout:     *indexDataTimeout,
+		BusyRetries:          retries,
+		IdentifierInMemory:   *identifierInMemory,
+		IdentifierFilterRate: *identifierFilterRate,
+		Degraded:             *degradedMode,
+		MaxEdges:             *maxEdges,
+		RankedPath:           *rankedPath,
+		MaxRankedScan:        *maxRankedScan,
+		MaxPathDepth:         *maxPathDepth,
+		MaxResponseSize:      *maxResponseSize,
+		EstimatedBlobSize:    *estimatedBlobSize,
+		NoCase:               *noCase,
+		OmitUnmatched:        *omitUnmatched,
+		SortedResponses:      *sortedResponses,
+		MaxDataAge:           *maxDataAge,
+		BuildInfo: ckit.BuildInfo{
+			Version:   Version,
+			Tag:       Tag,
+			Buildtime: Buildtime,
+		},
+		Manifest:   *manifestPath,
+		Datasets:   datasets,
+		AdminToken: *adminToken,
+	}
+	if srv.TrustedProxies, err = ckit.ParseTrustedProxies(*trustedProxies); err != nil {
+		fatal(exitConfig, err)
+	}
+	if *countsDatabasePath != "" {
+		if srv.CountsDatabase, err = ckit.OpenDatabase(*countsDatabasePath); err != nil {
+			fatal(exitData, err)
+		}
+	}
+	if *clickHouseURL != "" {
+		if *noCase {
+			fatal(exitConfig, "-nocase is not supported with -edges-clickhouse")
+		}
+		srv.Edges = &ckit.ClickHouseEdgeStore{
+			URL:    *clickHouseURL,
+			Table:  *clickHouseTable,
+			Client: ckit.NewHTTPClient(*httpMaxIdlePerHost, *httpTimeout),
+		}
+		log.Printf("[ok] reading citation edges from ClickHouse table %s", *clickHouseTable)
+	}
+	if *analyticsDSN != "" {
+		if srv.Analytics, err = ckit.OpenAnalytics(*analyticsDriver, *analyticsDSN); err != nil {
+			fatal(exitConfig, err)
+		}
+		log.Printf("[ok] using %s analytics database %s", *analyticsDriver, *analyticsDSN)
+	}
+	if *degreesPath != "" {
+		if srv.Degrees, err = degree.Open(*degreesPath); err != nil {
+			fatal(exitData, err)
+		}
+		log.Printf("loaded degree table with %d DOI", srv.Degrees.Len())
+		if srv.Degrees.NoCase() != srv.NoCase {
+			log.Printf("warning: degree table and -nocase differ, cf. ocidegree -N")
+		}
+	}
+	if srv.IdentifierFilterRate > 0 {
+		if err := srv.BuildIdentifierFilter(context.Background()); err != nil {
+			fatal(exitData, err)
+		}
+	}
+	// Setup blob slimming.
+	for _, v := range stripRules {
+		rule, err := ckit.ParseStripRule(v)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		srv.StripRules = append(srv.StripRules, rule)
+	}
+	if len(srv.StripRules) > 0 {
+		log.Printf("[ok] stripping fields from index data: %v", srv.StripRules)
+	}
+	// Setup blob transformers.
+	for _, v := range transformerNames {
+		t, err := ckit.NewTransformer(v)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		srv.Transformers = append(srv.Transformers, t)
+	}
+	if len(srv.Transformers) > 0 {
+		log.Printf("[ok] transforming index data with: %v", transformerNames)
+	}
+	// Setup live index lookups for unmatched DOI.
+	switch {
+	case *liveSolrURL != "" && *liveElasticURL != "":
+		fatal(exitConfig, "use either -live-solr or -live-es")
+	case *liveSolrURL != "":
+		srv.LiveIndex = &ckit.SolrIndex{
+			URL:    *liveSolrURL,
+			Field:  *liveField,
+			Client: ckit.NewHTTPClient(*httpMaxIdlePerHost, *httpTimeout),
+		}
+		log.Printf("[ok] looking up unmatched DOI in %s", *liveSolrURL)
+	case *liveElasticURL != "":
+		srv.LiveIndex = &ckit.ElasticIndex{
+			URL:    *liveElasticURL,
+			Field:  *liveField,
+			Client: ckit.NewHTTPClient(*httpMaxIdlePerHost, *httpTimeout),
+		}
+		log.Printf("[ok] looking up unmatched DOI in %s", *liveElasticURL)
+	}
+	// Setup additional edge sources.
+	for _, v := range edgeSources {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[0] == ckit.OciSourceName {
+			fatalf(exitConfig, "edges: want name=path with a name other than %s, got %s", ckit.OciSourceName, v)
+		}
+		db, err := ckit.OpenDatabase(parts[1])
+		if err != nil {
+			fatal(exitData, err)
+		}
+		srv.EdgeSources = append(srv.EdgeSources, ckit.EdgeSource{Name: parts[0], DB: db})
+		log.Printf("[ok] merging edges from %s (%s)", parts[0], parts[1])
+	}
+	// Setup open access status lookups.
+	if *oaDatabasePath != "" {
+		if srv.OADatabase, err = ckit.OpenDatabase(*oaDatabasePath); err != nil {
+			fatal(exitData, err)
+		}
+		log.Printf("[ok] adding open access status from %s", *oaDatabasePath)
+	}
+	// Setup retraction flags; the list is small enough to keep in memory.
+	if *retractionsPath != "" {
+		f, err := os.Open(*retractionsPath)
+		if err != nil {
+			fatal(exitData, err)
+		}
+		srv.Retractions, err = ckit.ReadRetractions(f)
+		f.Close()
+		if err != nil {
+			fatal(exitData, err)
+		}
+		log.Printf("[ok] flagging %d retracted works from %s", len(srv.Retractions), *retractionsPath)
+	}
+	// Setup self-citation detection.
+	if *selfCitations {
+		srv.SelfCitation = &ckit.SelfCitation{
+			AuthorFields:  strings.Split(*selfAuthorFields, ","),
+			JournalFields: strings.Split(*selfJournalFields, ","),
+		}
+		log.Printf("[ok] flagging self-citations by %s and %s", *selfAuthorFields, *selfJournalFields)
+	}
+	// Setup publication years, for citation ages.
+	if *yearFields != "" {
+		srv.YearFields = strings.Split(*yearFields, ",")
+		log.Printf("[ok] computing citation ages from %s", *yearFields)
+	}
+	// Setup OpenAlex enrichment, with an optional persistent cache.
+	if *enableOpenAlex {
+		srv.OpenAlex = &ckit.OpenAlex{
+			Mailto: *openAlexMailto,
+			Client: ckit.NewHTTPClient(*httpMaxIdlePerHost, *httpTimeout),
+		}
+		if *openAlexCachePath != "" {
+			c, err := cache.New(*openAlexCachePath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer c.Close()
+			srv.OpenAlex.Cache = c
+		}
+		log.Printf("[ok] enriching unmatched DOI with OpenAlex")
+	}
+	// Setup blob validation.
+	if *schemaPath != "" {
+		if srv.BlobSchema, err = ckit.ReadSchema(*schemaPath); err != nil {
+			fatal(exitConfig, err)
+		}
+		srv.DropInvalid = *schemaDrop
+		log.Printf("[ok] validating index data against %s", *schemaPath)
+	}
+	// Setup negative caching; cheap, in-memory.
+	if *notFoundTTL > 0 {
+		srv.NotFound = cache.NewTTLSet(*notFoundTTL)
 	}
 	// Setup caching. Albeit the cache will be persistant, treat it like an
-	// emphemeral thing, e.g. the cache file does not survive the process.
-	if *enableCache {
+	// emphemeral thing, e.g. the cache file does not survive the process. A
+	// memcached based cache can be shared between labed instances.
+	switch {
+	case *enableCache && len(memcachedServers) > 0:
+		m, err := cache.NewMemcache(memcachedServers...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer m.Close()
+		m.Prefix = *memcachedPrefix
+		srv.Cache = m
+		log.Printf("[ok] using memcached cache: %v", memcachedServers)
+	case *enableCache:
 		f, err := ioutil.TempFile("", "labed-cache-")
 		if err != nil {
 			log.Fatal(err)
 		}
-		// Cleanup on exit and ...
+		// Cleanup on exit; SIGTERM (e.g. via systemd restart) triggers a
+		// graceful shutdown, after which deferred functions run.
 		defer func() {
-			f.Close()
-			os.Remove(f.Name())
-		}()
-		// ... also react to SIGTERM (e.g. via systemd restart) with removal of
-		// old file.
-		ch := make(chan os.Signal, 1)
-		signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
-		go func() {
-			<-ch
-			log.Printf("[..] attempting graceful shutdown")
 			cerr := f.Close()
 			rerr := os.Remove(f.Name())
-			switch {
-			case cerr != nil || rerr != nil:
+			if cerr != nil || rerr != nil {
 				log.Printf("[xx] cleanup failed: %v %v", cerr, rerr)
-				os.Exit(1)
-			default:
-				log.Printf("[ok] shutdown successful")
-				os.Exit(0)
 			}
 		}()
-		// Setup cache and attach to our handler.
-		c, err := cache.New(f.Name())
+		// Setup cache and attach to our handler. With a snapshot, we start
+		// with a warm cache.
+		var c *cache.Cache
+		if *cacheSnapshotPath != "" {
+			c, err = cache.NewFromSnapshot(f.Name(), *cacheSnapshotPath)
+		} else {
+			c, err = cache.New(f.Name())
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
 		defer c.Close()
 		c.MaxFileSize = *cacheMaxFileSize
 		srv.Cache = c
+		if *cacheSnapshotPath != "" {
+			// Write a last snapshot after all in-flight requests are done;
+			// runs before the cache is closed.
+			defer func() {
+				if err := c.Snapshot(*cacheSnapshotPath); err != nil {
+					log.Printf("[xx] cache snapshot: %v", err)
+				} else {
+					log.Printf("[ok] wrote cache snapshot to %s", *cacheSnapshotPath)
+				}
+			}()
+			go func() {
+				ticker := time.NewTicker(*cacheSnapshotInterval)
+				defer ticker.Stop()
+				for range ticker.C {
+					if err := c.Snapshot(*cacheSnapshotPath); err != nil {
+						log.Printf("[xx] cache snapshot: %v", err)
+					}
+				}
+			}()
+		}
+	}
+	if srv.Cache != nil {
 		srv.CacheTriggerDuration = *cacheTriggerDuration
+		srv.ZstdPassThrough = *enableZstdPassThrough
+		srv.CacheControlToken = *cacheControlToken
+		if *refreshHotCount > 0 {
+			srv.Hot = cache.NewHitCounter()
+			srv.RefreshMaxAge = *refreshHotMaxAge
+			go srv.RefreshHot(context.Background(), *refreshHotInterval, *refreshHotCount)
+		}
 	}
-	srv.Routes()
-	if err := srv.Ping(); err != nil {
-		log.Fatal(err)
+	// Setup asynchronous jobs, results are kept in temporary files.
+	if *jobWorkers > 0 {
+		srv.Jobs = ckit.NewJobs(os.TempDir(), *jobWorkers)
+		srv.Jobs.TTL = *jobTTL
+		srv.Jobs.MaxPerClient = *jobsPerClient
+		defer srv.Jobs.Close()
 	}
-	fmt.Fprintln(os.Stderr, strings.Replace(Banner, `{{ .listenAddr }}`, *listenAddr, -1))
-	log.Printf("[ok] labed ≋ starting %s %s http://%s", Version, Buildtime, *listenAddr)
-	var h http.Handler = srv
-	if *enableGzip {
-		h = handlers.CompressHandler(srv)
+	// Expected refresh intervals, to notice failed updates.
+	if len(refreshIntervals) > 0 {
+		srv.RefreshIntervals = make(map[string]time.Duration)
+		for k, v := range ckit.DefaultRefreshIntervals {
+			srv.RefreshIntervals[k] = v
+		}
+		for _, v := range refreshIntervals {
+			name, d, err := ckit.ParseRefreshInterval(v)
+			if err != nil {
+				fatalf(exitConfig, "stale-after: %v", err)
+			}
+			srv.RefreshIntervals[name] = d
+		}
+	}
+	// Setup data update events; webhooks only get notified about new and
+	// stale data.
+	if len(eventURLs) > 0 || len(webhookURLs) > 0 {
+		srv.Notifier = &notify.Notifier{}
+		for _, u := range eventURLs {
+			p, err := notify.Open(u)
+			if err != nil {
+				log.Fatal(err)
+			}
+			srv.Notifier.Publishers = append(srv.Notifier.Publishers, p)
+		}
+		for _, u := range webhookURLs {
+			p := notify.Only(&notify.Webhook{URL: u}, notify.EventStarted, notify.EventRotated, notify.EventStale)
+			srv.Notifier.Publishers = append(srv.Notifier.Publishers, p)
+		}
+		defer srv.Notifier.Wait()
+		log.Printf("[ok] publishing events to: %v %v", eventURLs, webhookURLs)
+	}
+	// Additional corpora, served under /c/{name}.
+	for _, v := range corpusManifests {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			fatalf(exitConfig, "corpus: want name=manifest.json, got %s", v)
+		}
+		c, err := srv.NewCorpus(parts[0], parts[1])
+		if err != nil {
+			fatal(exitData, err)
+		}
+		if srv.Corpora == nil {
+			srv.Corpora = make(map[string]*ckit.Server)
+		}
+		srv.Corpora[parts[0]] = c
+		log.Printf("[ok] serving corpus %s from %s", parts[0], parts[1])
+	}
+	// Middleware, as named by -middleware; log, cors and gzip stay disabled
+	// (nil), unless configured.
+	registry := ckit.MiddlewareRegistry{"stats": nil, "log": nil, "access": nil, "cors": nil, "keys": nil, "gzip": nil}
+	if srv.Stats != nil {
+		registry["stats"] = srv.Stats.Handler
 	}
 	if *accessLogFile != "" {
 		f, err := os.OpenFile(*accessLogFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 644)
@@ -220,10 +676,131 @@ func main() {
 			log.Fatal(err)
 		}
 		defer f.Close()
-		h = handlers.LoggingHandler(f, h)
+		registry["log"] = func(h http.Handler) http.Handler {
+			return handlers.LoggingHandler(f, h)
+		}
 	}
-	if srv.Stats != nil {
-		h = srv.Stats.Handler(h)
+	if *accessListFile != "" {
+		acl, err := ckit.LoadAccessList(*accessListFile)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		registry["access"] = acl.Middleware
+		// Reload the rules on SIGHUP, e.g. to block a crawler.
+		go func() {
+			ch := make(chan os.Signal, 1)
+			signal.Notify(ch, syscall.SIGHUP)
+			for range ch {
+				if err := acl.Reload(); err != nil {
+					log.Printf("[xx] access list reload failed, keeping current rules: %v", err)
+					continue
+				}
+				log.Printf("[ok] reloaded access list from %s", acl.Filename)
+			}
+		}()
+		log.Printf("[ok] access list enabled: %s", acl.Filename)
+	}
+	if *apiKeysFile != "" {
+		if srv.APIKeys, err = ckit.ReadAPIKeys(*apiKeysFile); err != nil {
+			fatal(exitConfig, err)
+		}
+		srv.APIKeys.Required = *apiKeysRequired
+		registry["keys"] = srv.APIKeys.Middleware
+		log.Printf("[ok] api keys enabled: %s", *apiKeysFile)
+	}
+	if len(corsOrigins) > 0 {
+		registry["cors"] = ckit.Middleware(handlers.CORS(
+			handlers.AllowedOrigins(corsOrigins),
+			handlers.AllowedMethods(strings.Split(*corsMethods, ",")),
+			handlers.MaxAge(*corsMaxAge),
+		))
+		log.Printf("[ok] CORS enabled for: %v", corsOrigins)
+	}
+	if *enableGzip {
+		registry["gzip"] = handlers.CompressHandler
+	}
+	if srv.Middleware, err = registry.Parse(*middlewareChain); err != nil {
+		fatal(exitConfig, err)
+	}
+	if *accessListFile != "" && !registry.Uses(*middlewareChain, "access") {
+		fatal(exitConfig, "-access-list requires access in -middleware")
+	}
+	if *apiKeysFile != "" && !registry.Uses(*middlewareChain, "keys") {
+		fatal(exitConfig, "-api-keys requires keys in -middleware")
+	}
+	srv.Routes()
+	if err := srv.Ping(); err != nil {
+		fatal(exitUnavailable, err)
+	}
+	if *verifyDatabases {
+		started := time.Now()
+		if err := srv.VerifyDatabases(context.Background(), true); err != nil {
+			fatalf(exitData, "[xx] verify: %v", err)
+		}
+		log.Printf("[ok] verified databases in %s", time.Since(started))
+	}
+	if *explainQueries {
+		plans, err := srv.Explain(context.Background())
+		if err != nil {
+			log.Fatalf("[xx] explain: %v", err)
+		}
+		for _, p := range plans {
+			if p.Indexed {
+				log.Printf("[ok] query plan · %s", p)
+			} else {
+				log.Printf("[xx] query plan without index, expect slow requests · %s", p)
+			}
+		}
+	}
+	// Announce the datasets we went live with.
+	started := notify.NewEvent(notify.EventStarted)
+	started.Datasets = datasets.Notify()
+	srv.Notifier.Notify(started)
+	if *manifestPath != "" && *manifestWatch > 0 {
+		go srv.WatchManifest(context.Background(), *manifestWatch)
+	}
+	if *staleCheckInterval > 0 {
+		go srv.WatchStale(context.Background(), *staleCheckInterval)
+	}
+	fmt.Fprintln(os.Stderr, strings.Replace(Banner, `{{ .listenAddr }}`, *listenAddr+srv.PathPrefix, -1))
+	log.Printf("[ok] labed ≋ starting %s %s %s http://%s%s", Tag, Version, Buildtime, *listenAddr, srv.PathPrefix)
+	// Use a socket passed by systemd, if any, so restarts do not drop
+	// connections; otherwise listen on the given address.
+	listeners, err := activation.Listeners()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var ln net.Listener
+	switch len(listeners) {
+	case 0:
+		if ln, err = net.Listen("tcp", *listenAddr); err != nil {
+			log.Fatal(err)
+		}
+	case 1:
+		ln = listeners[0]
+		log.Printf("[ok] using socket activation: %s", ln.Addr())
+	default:
+		log.Fatalf("expected a single activation socket, got %d", len(listeners))
+	}
+	var (
+		server = &http.Server{Handler: srv}
+		done   = make(chan struct{})
+	)
+	go func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+		<-ch
+		log.Printf("[..] attempting graceful shutdown")
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("[xx] shutdown: %v", err)
+		}
+		close(done)
+	}()
+	if err := server.Serve(ln); err != http.ErrServerClosed {
+		log.Fatal(err)
 	}
-	log.Fatal(http.ListenAndServe(*listenAddr, h))
+	<-done
+	log.Printf("[ok] shutdown successful")
 }
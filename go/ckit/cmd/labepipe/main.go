@@ -0,0 +1,314 @@
+// labepipe runs the data pipeline for labed: download and convert OCI, fetch
+// and convert Solr index data, build the identifier database, verify all
+// databases and rotate them into place through a manifest. Tasks that are
+// done are skipped, so an interrupted run continues where it stopped.
+//
+//	$ labepipe -oci-url https://... -index ai=http://... -short ai
+//
+// Requires curl, unzip, zstd, solrdump (https://github.com/ubleipzig/solrdump)
+// and the tabjson, doisniffer and makta tools.
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/slub/labe/go/ckit"
+	"github.com/slub/labe/go/ckit/pipeline"
+	"github.com/slub/labe/go/ckit/xflag"
+)
+
+var (
+	Version   string
+	Buildtime string
+
+	baseDir     = flag.String("dir", defaultDir(), "base directory for all outputs")
+	date        = flag.String("date", time.Now().Format("2006-01-02"), "date of the Solr snapshot, part of output names")
+	ociURL      = flag.String("oci-url", "", "OCI download URL, e.g. a figshare CSV dump; a new URL means a new OCI version")
+	shortNames  = flag.String("short", "", "comma separated index names, for which to fetch only a few fields (id, title, author, format, url, doi_str_mv, institution)")
+	labedURL    = flag.String("labed", "", "labed URL, e.g. http://localhost:8000; if set, ask labed to rotate after the manifest has been updated")
+	labedToken  = flag.String("labed-token", "", "admin token of labed, sent as bearer token with the rotate request")
+	listTasks   = flag.Bool("l", false, "list tasks and exit")
+	dryRun      = flag.Bool("n", false, "dry run, only show what would run")
+	noSizeCheck = flag.Bool("no-size-check", false, "do not check minimum output sizes, e.g. for small test data")
+	integrity   = flag.Bool("integrity", false, "run sqlite3 quick_check on databases during verify; reads whole files")
+	showVersion = flag.Bool("version", false, "show version and exit")
+
+	indices xflag.Array // name=url
+	force   xflag.Array // task names
+)
+
+// minimumSize are sanity checks for output sizes, in bytes, as of 01/2022.
+var minimumSize = map[string]int64{
+	"oci-download":            25000000000,
+	"oci-db":                  150000000000,
+	"id-table":                400000000,
+	"id-db":                   12000000000,
+	"solr-db-ai":              40000000000,
+	"solr-db-main":            4000000000,
+	"solr-db-slub-production": 2000000000,
+}
+
+func main() {
+	flag.Var(&indices, "index", "Solr index to include, as name=url (repeatable)")
+	flag.Var(&force, "force", "run task, even if done; tasks depending on it run as well (repeatable)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: labepipe [OPTION] [TASK ...]\n\n")
+		fmt.Fprintf(os.Stderr, "Runs the given tasks and their dependencies, default: rotate.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("labepipe %s %s\n", Version, Buildtime)
+		os.Exit(0)
+	}
+	tasks, err := buildTasks()
+	if err != nil {
+		log.Fatal(err)
+	}
+	runner, err := pipeline.NewRunner(tasks)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *listTasks {
+		for _, name := range runner.Names() {
+			t := runner.Tasks[name]
+			fmt.Printf("%s\t%s\t%s\n", name, strings.Join(t.Requires, ","), t.Output)
+		}
+		os.Exit(0)
+	}
+	runner.LogDir = filepath.Join(*baseDir, "log")
+	runner.DryRun = *dryRun
+	for _, name := range force {
+		runner.Force[name] = true
+	}
+	targets := flag.Args()
+	if len(targets) == 0 {
+		targets = []string{"rotate"}
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	started := time.Now()
+	if err := runner.Run(ctx, targets...); err != nil {
+		log.Fatalf("[xx] %v", err)
+	}
+	log.Printf("[ok] pipeline done in %s", time.Since(started).Round(time.Second))
+}
+
+// defaultDir returns the XDG data directory for labe.
+func defaultDir() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "labe")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "labe"
+	}
+	return filepath.Join(home, ".local", "share", "labe")
+}
+
+// buildTasks returns all tasks for the configured OCI version and indices.
+func buildTasks() ([]*pipeline.Task, error) {
+	if *ociURL == "" {
+		return nil, fmt.Errorf("OCI download URL required (-oci-url)")
+	}
+	if len(indices) == 0 {
+		return nil, fmt.Errorf("at least one index required (-index name=url)")
+	}
+	var (
+		// A new OCI download lives under a new URL, so we use a hash of the
+		// URL to tell versions apart.
+		fingerprint = fmt.Sprintf("%x", sha1.Sum([]byte(*ociURL)))
+		path        = func(task, filename string) string {
+			return filepath.Join(*baseDir, task, filename)
+		}
+		current = pipeline.Symlink("current")
+		ociDB   = &pipeline.Task{
+			Name:      "oci-db",
+			Requires:  []string{"oci-single"},
+			Output:    path("OpenCitationsDatabase", fingerprint+".db"),
+			Run:       pipeline.Shell(`zstdcat -T0 {oci-single} | makta -sep , -quoted -key 2 -value 3 -init -I 3 -source {oci-single} -o {output}`),
+			OnSuccess: current,
+		}
+		tasks = []*pipeline.Task{
+			{
+				Name:   "oci-download",
+				Output: path("OpenCitationsDownload", fingerprint+".zip"),
+				Run: pipeline.Shell(fmt.Sprintf(`curl --fail -sL %s > {output} && unzip -tq {output} > /dev/null`,
+					pipeline.Quote(*ociURL))),
+				OnSuccess: current,
+			},
+			{
+				// The download is a zip of zip files; we want a single,
+				// undecorated, compressed CSV file.
+				Name:     "oci-single",
+				Requires: []string{"oci-download"},
+				Output:   path("OpenCitationsSingleFile", fingerprint+".zst"),
+				Run: pipeline.Shell(`T=$(mktemp -d) && unzip -q -d "$T" {oci-download} &&
+					for f in $(find "$T" -name "*zip"); do unzip -p "$f"; done |
+					grep -vF 'oci,citing' | zstd -q -c -T0 > {output} && rm -rf "$T"`),
+				OnSuccess: current,
+			},
+			ociDB,
+		}
+		short    = make(map[string]bool)
+		fetched  []string
+		dbs      []string
+		indexDBs []string
+	)
+	for _, name := range strings.Split(*shortNames, ",") {
+		short[name] = true
+	}
+	for _, v := range indices {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("index: want name=url, got %s", v)
+		}
+		var (
+			name, url = parts[0], parts[1]
+			fields    string
+			suffix    = name
+		)
+		if short[name] {
+			fields = "-fl 'id,title,author,format,url,doi_str_mv,institution'"
+			suffix = name + "-short"
+		}
+		fetch := &pipeline.Task{
+			Name:   "solr-fetch-" + name,
+			Output: path("SolrFetchDocs", fmt.Sprintf("%s-%s.zst", *date, suffix)),
+			Run: pipeline.Shell(fmt.Sprintf(`solrdump -verbose -server %s -rows 50000 %s | zstd -q -c -T0 > {output}`,
+				pipeline.Quote(url), fields)),
+			OnSuccess: pipeline.Symlink("current-" + suffix),
+		}
+		db := &pipeline.Task{
+			Name:      "solr-db-" + name,
+			Requires:  []string{fetch.Name},
+			Output:    path("SolrDatabase", fmt.Sprintf("%s-%s.db", *date, suffix)),
+			Run:       pipeline.Shell(fmt.Sprintf(`zstdcat -T0 {%s} | tabjson | makta -init -I 1 -o {output}`, fetch.Name)),
+			OnSuccess: pipeline.Symlink("current-" + suffix),
+		}
+		tasks = append(tasks, fetch, db)
+		fetched = append(fetched, fetch.Name)
+		dbs = append(dbs, db.Name)
+		indexDBs = append(indexDBs, db.Output)
+	}
+	var sniff []string
+	for _, name := range fetched {
+		sniff = append(sniff, fmt.Sprintf("zstdcat -T0 {%s} | doisniffer -T", name))
+	}
+	idTable := &pipeline.Task{
+		Name:      "id-table",
+		Requires:  fetched,
+		Output:    path("IdMappingTable", *date+".tsv.zst"),
+		Run:       pipeline.Shell(fmt.Sprintf("( %s ) | zstd -q -c -T0 > {output}", strings.Join(sniff, " && "))),
+		OnSuccess: current,
+	}
+	idDB := &pipeline.Task{
+		Name:      "id-db",
+		Requires:  []string{"id-table"},
+		Output:    path("IdMappingDatabase", *date+".db"),
+		Run:       pipeline.Shell(`zstdcat -T0 {id-table} | makta -init -I 3 -source {id-table} -o {output}`),
+		OnSuccess: current,
+	}
+	verify := &pipeline.Task{
+		Name:     "verify",
+		Requires: append([]string{"id-db", "oci-db"}, dbs...),
+		Output:   path("Verify", *date+".txt"),
+		Run: func(ctx context.Context, env *pipeline.Env) error {
+			var report strings.Builder
+			for _, name := range append([]string{"id-db", "oci-db"}, dbs...) {
+				// The identifier and OCI databases are looked up both ways.
+				required := []string{"idx_k", "idx_v"}
+				if strings.HasPrefix(name, "solr-db-") {
+					required = required[:1]
+				}
+				if err := verifyDatabase(ctx, env.Inputs[name], required); err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+				fmt.Fprintf(&report, "ok\t%s\t%s\n", name, env.Inputs[name])
+			}
+			return ioutil.WriteFile(env.Output, []byte(report.String()), 0644)
+		},
+	}
+	rotate := &pipeline.Task{
+		Name:     "rotate",
+		Requires: []string{"verify"},
+		Output:   path("Manifest", fmt.Sprintf("%s-%s.json", *date, fingerprint[:8])),
+		Run: func(ctx context.Context, env *pipeline.Env) error {
+			b, err := json.MarshalIndent(ckit.Datasets{
+				Identifier: idDB.Output,
+				Oci:        ociDB.Output,
+				Index:      indexDBs,
+			}, "", "  ")
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(env.Output, append(b, '\n'), 0644)
+		},
+		// Point labed -manifest at Manifest/current.json.
+		OnSuccess: func(output string) error {
+			if err := pipeline.Symlink("current.json")(output); err != nil {
+				return err
+			}
+			return notifyLabed(*labedURL, *labedToken)
+		},
+	}
+	tasks = append(tasks, idTable, idDB, verify, rotate)
+	if !*noSizeCheck {
+		for _, t := range tasks {
+			t.MinSize = minimumSize[t.Name]
+		}
+	}
+	return tasks, nil
+}
+
+// verifyDatabase checks a database created by makta.
+func verifyDatabase(ctx context.Context, filename string, indices []string) error {
+	db, err := ckit.OpenDatabase(filename)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return ckit.VerifyDatabase(ctx, db, *integrity, indices...)
+}
+
+// notifyLabed asks a running labed to rotate to the datasets in its
+// manifest; nothing to rotate is not an error. Rotation is an admin route,
+// token is sent as bearer token, if set.
+func notifyLabed(base, token string) error {
+	if base == "" {
+		return nil
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(base, "/")+"/rotate", nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusConflict:
+		log.Printf("[ok] labed rotate: %s", resp.Status)
+		return nil
+	default:
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("labed rotate: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+}
@@ -1,15 +1,19 @@
 // makta takes a two column TSV file and turns it into an indexed sqlite3 database.
+// Other tabular formats, e.g. CSV with a header row, are converted on the fly.
 package main
 
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/andrew-d/go-termutil"
@@ -23,13 +27,25 @@ var (
 	validTypes = []string{"INTEGER", "READ", "TEXT", "BLOB"} // sqlite3
 
 	showVersion  = flag.Bool("version", false, "show version and exit")
-	outputFile   = flag.String("o", "data.db", "output filename")
-	bufferSize   = flag.Int("B", 64*1<<20, "buffer size")
+	outputFile   = flag.String("o", "data.db", "output filename or postgres://... URL, imported with psql")
+	tableName    = flag.String("table", "map", "table name, for postgres output")
+	bufferSize   = flag.Int("B", 64*1<<20, "batch size in bytes; memory use is bounded by about twice this size")
 	indexMode    = flag.Int("I", 3, "index mode: 0=none, 1=k, 2=v, 3=kv")
+	nocaseMode   = flag.Int("N", 0, "additional case insensitive index mode, e.g. for DOI, cf. labed -nocase: 0=none, 1=k, 2=v, 3=kv")
 	cacheSize    = flag.Int("C", 1000000, "sqlite3 cache size, needs memory = C x page size")
 	initDatabase = flag.Bool("init", false, "on start, initialize database, even when the file already exists")
 	valueType    = flag.String("T", "TEXT", "sqlite3 type for value column")
 	verbose      = flag.Bool("verbose", false, "be verbose")
+	sourceName   = flag.String("source", "", "name of the source dump, e.g. a filename, stored in the meta table")
+	separator    = flag.String("sep", "tab", "input field separator, a single character or tab")
+	quoted       = flag.Bool("quoted", false, "input fields may be quoted, as in CSV")
+	header       = flag.Bool("header", false, "first input row contains column names")
+	keyColumn    = flag.String("key", "", "key column, by name (with -header) or 1-based number (default: first column)")
+	valueColumn  = flag.String("value", "", "value column, by name (with -header) or 1-based number; several comma separated columns are stored as JSON object (default: second column)")
+	numShards    = flag.Int("shards", 1, "split output into this many sqlite3 databases by key hash, with a manifest")
+	shardIndex   = flag.Int("shard", -1, "with -shards, only build this shard (0-based), e.g. to distribute builds across machines")
+	manifest     = flag.Bool("manifest", false, "write a manifest with files, key ranges, row counts and checksums next to the output, e.g. data.shards.json (always with -shards)")
+	reverse      = flag.Bool("R", false, "also write a reverse table (rmap), clustered by value, for fast lookups by value, e.g. cited DOI in labed; with -N 2 or 3 case insensitive")
 )
 
 func main() {
@@ -40,19 +56,9 @@ func main() {
 	var (
 		err      error
 		initFile string
-		pragma   = fmt.Sprintf(`
-PRAGMA journal_mode = OFF;
-PRAGMA synchronous = 0;
-PRAGMA cache_size = %d;
-PRAGMA locking_mode = EXCLUSIVE;`, *cacheSize)
-		initSQL = `
+		pragma   = tabutils.Pragma(*cacheSize)
+		initSQL  = `
 CREATE TABLE IF NOT EXISTS map (k TEXT, v %s);`
-		keyIndexSQL = fmt.Sprintf(`
-%s
-CREATE INDEX IF NOT EXISTS idx_k ON map(k);`, pragma)
-		valueIndexSQL = fmt.Sprintf(`
-%s
-CREATE INDEX IF NOT EXISTS idx_v ON map(v);`, pragma)
 		importSQL = fmt.Sprintf(`
 %s
 PRAGMA temp_store = MEMORY;
@@ -68,31 +74,101 @@ PRAGMA temp_store = MEMORY;
 		log.Println("stdin: no data")
 		os.Exit(1)
 	}
-	_, err = os.Stat(*outputFile)
-	if err != nil || *initDatabase {
-		if os.IsNotExist(err) || *initDatabase {
-			if err := tabutils.RunScript(*outputFile, fmt.Sprintf(initSQL, *valueType), "initialized database"); err != nil {
-				log.Fatal(err)
+	comma, err := tabutils.ParseComma(*separator)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dialect := tabutils.Dialect{
+		Comma:  comma,
+		Quoted: *quoted,
+		Header: *header,
+		Key:    *keyColumn,
+		Value:  *valueColumn,
+	}
+	// Two column TSV is imported as is, anything else is converted first.
+	var input io.Reader = os.Stdin
+	if !dialect.IsDefault() {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(dialect.ToTSV(pw, os.Stdin))
+		}()
+		input = pr
+	}
+	if *numShards < 1 || *shardIndex >= *numShards {
+		log.Fatalf("invalid shards: -shards %d -shard %d", *numShards, *shardIndex)
+	}
+	postgres := tabutils.IsPostgres(*outputFile)
+	if postgres && *numShards > 1 {
+		log.Fatal("shards are only supported for sqlite3 output")
+	}
+	if postgres && *manifest {
+		log.Fatal("manifests are only supported for sqlite3 output")
+	}
+	if postgres && *reverse {
+		log.Fatal("reverse tables are only supported for sqlite3 output")
+	}
+	// With shards, we write one database per shard, or only the one
+	// requested with -shard.
+	var (
+		outputs = []string{*outputFile}
+		shards  = []int{0}
+	)
+	if *numShards > 1 {
+		outputs, shards = outputs[:0], shards[:0]
+		for i := 0; i < *numShards; i++ {
+			if *shardIndex < 0 || *shardIndex == i {
+				outputs = append(outputs, tabutils.ShardName(*outputFile, i, *numShards))
+				shards = append(shards, i)
 			}
-		} else {
+		}
+	}
+	if postgres {
+		if err := tabutils.ValidTableName(*tableName); err != nil {
 			log.Fatal(err)
 		}
+		if err := tabutils.RunPsql(*outputFile, tabutils.PostgresTableScript(*tableName, *valueType), "initialized table"); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		for _, output := range outputs {
+			if _, err = os.Stat(output); err != nil || *initDatabase {
+				if os.IsNotExist(err) || *initDatabase {
+					if err := tabutils.RunScript(output, fmt.Sprintf(initSQL, *valueType), "initialized database"); err != nil {
+						log.Fatal(err)
+					}
+				} else {
+					log.Fatal(err)
+				}
+			}
+		}
 	}
 	if initFile, err = tabutils.TempFileReader(strings.NewReader(importSQL)); err != nil {
 		log.Fatal(err)
 	}
 	var (
-		br          = bufio.NewReader(os.Stdin)
-		buf         bytes.Buffer
+		br          = bufio.NewReader(input)
+		batchers    = make([]*tabutils.Batcher, len(outputs))
+		batchSize   = *bufferSize / len(outputs)
+		mu          sync.Mutex // batches are imported in the background
 		written     int64
 		started     = time.Now()
 		elapsed     float64
 		numBatches  int
-		importBatch = func() error {
-			n, err := tabutils.RunImport(&buf, initFile, *outputFile)
+		importBatch = func(i int, buf *bytes.Buffer) error {
+			var (
+				n   int64
+				err error
+			)
+			if postgres {
+				n, err = tabutils.RunCopy(buf, *outputFile, *tableName)
+			} else {
+				n, err = tabutils.RunImport(buf, initFile, outputs[i])
+			}
 			if err != nil {
 				return fmt.Errorf("import: %w", err)
 			}
+			mu.Lock()
+			defer mu.Unlock()
 			written += n
 			numBatches++
 			elapsed = time.Since(started).Seconds()
@@ -110,49 +186,148 @@ PRAGMA temp_store = MEMORY;
 		}
 		indexScripts []string
 	)
+	for i := range outputs {
+		i := i
+		batchers[i] = tabutils.NewBatcher(batchSize, func(buf *bytes.Buffer) error {
+			return importBatch(i, buf)
+		})
+	}
 	for {
 		b, err := br.ReadBytes('\n')
-		if err == io.EOF {
+		if err == io.EOF && len(b) == 0 {
 			break
 		}
-		if err != nil {
+		if err != nil && err != io.EOF {
 			log.Fatalf("read: %v", err)
 		}
-		if _, err := buf.Write(b); err != nil {
-			log.Fatalf("write: %v", err)
+		if b[len(b)-1] != '\n' {
+			b = append(b, '\n')
 		}
-		if buf.Len() >= *bufferSize {
-			if err := importBatch(); err != nil {
-				log.Fatalf("batch: %v", err)
+		var i int
+		if *numShards > 1 {
+			i = tabutils.ShardFor(tabutils.KeyOf(b), *numShards)
+			if *shardIndex >= 0 {
+				if i != *shardIndex {
+					continue
+				}
+				i = 0
 			}
 		}
+		if _, err := batchers[i].Write(b); err != nil {
+			log.Fatalf("batch: %v", err)
+		}
 	}
-	if err := importBatch(); err != nil {
-		log.Fatalf("batch: %v", err)
+	for _, b := range batchers {
+		if err := b.Close(); err != nil {
+			log.Fatalf("batch: %v", err)
+		}
 	}
 	fmt.Println()
-	switch *indexMode {
-	case 1:
-		indexScripts = []string{
-			keyIndexSQL,
-		}
-	case 2:
-		indexScripts = []string{
-			valueIndexSQL,
+	if *indexMode == 0 {
+		log.Printf("no index requested")
+	}
+	indexScripts = tabutils.IndexScripts(pragma, *indexMode, *nocaseMode)
+	if *reverse {
+		indexScripts = append(indexScripts,
+			tabutils.ReverseTableScript(pragma, *valueType, *nocaseMode == 2 || *nocaseMode == 3))
+	}
+	if postgres {
+		indexScripts = postgresIndexScripts(*tableName, *indexMode, *nocaseMode)
+	}
+	runScript := tabutils.RunScript
+	if postgres {
+		runScript = tabutils.RunPsql
+	}
+	if postgres {
+		outputs = []string{*outputFile}
+	}
+	log.Printf("[io] building %d indices ...", len(indexScripts)*len(outputs))
+	for _, output := range outputs {
+		for i, script := range indexScripts {
+			msg := fmt.Sprintf("%d/%d created index", i+1, len(indexScripts))
+			if err := runScript(output, script, msg); err != nil {
+				log.Fatalf("run script: %v", err)
+			}
 		}
-	case 3:
-		indexScripts = []string{
-			keyIndexSQL,
-			valueIndexSQL,
+	}
+	// Record where the data came from, so a running service can report it.
+	hostname, _ := os.Hostname()
+	kv := map[string]string{
+		"source": *sourceName,
+		"date":   time.Now().UTC().Format(time.RFC3339),
+		"host":   hostname,
+		"makta":  Version,
+	}
+	if postgres {
+		if err := runScript(*outputFile, tabutils.PostgresMetaScript(*tableName, kv), "wrote meta table"); err != nil {
+			log.Fatalf("run script: %v", err)
 		}
-	default:
-		log.Printf("no index requested")
+		return
 	}
-	log.Printf("[io] building %d indices ...", len(indexScripts))
-	for i, script := range indexScripts {
-		msg := fmt.Sprintf("%d/%d created index", i+1, len(indexScripts))
-		if err := tabutils.RunScript(*outputFile, script, msg); err != nil {
+	for i, output := range outputs {
+		if *numShards > 1 {
+			kv["shard"] = fmt.Sprintf("%d", shards[i])
+			kv["shards"] = fmt.Sprintf("%d", *numShards)
+		}
+		if err := runScript(output, tabutils.MetaScript(kv), "wrote meta table"); err != nil {
 			log.Fatalf("run script: %v", err)
 		}
 	}
+	if *numShards > 1 || *manifest {
+		// Only the shards built here are described, cf. -shard.
+		m := tabutils.NewShardManifest(*outputFile, *numShards)
+		for _, output := range outputs {
+			d, err := describeFile(output)
+			if err != nil {
+				log.Fatalf("manifest: %v", err)
+			}
+			m.Details = append(m.Details, d)
+		}
+		filename, err := tabutils.WriteShardManifest(*outputFile, m)
+		if err != nil {
+			log.Fatalf("manifest: %v", err)
+		}
+		log.Printf("[ok] wrote manifest · %s", filename)
+	}
+}
+
+// describeFile returns the key range, number of rows, size and checksum of
+// a database for the manifest.
+func describeFile(filename string) (d tabutils.ShardFile, err error) {
+	db, err := ckit.OpenDatabase(filename)
+	if err != nil {
+		return d, err
+	}
+	defer db.Close()
+	row := db.QueryRowContext(context.Background(),
+		"SELECT coalesce(max(rowid), 0), coalesce(min(k), ''), coalesce(max(k), '') FROM map")
+	if err := row.Scan(&d.Rows, &d.MinKey, &d.MaxKey); err != nil {
+		return d, fmt.Errorf("%s: %w", filename, err)
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return d, err
+	}
+	d.File, d.Size = filepath.Base(filename), fi.Size()
+	if d.SHA256, err = tabutils.FileSHA256(filename); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// postgresIndexScripts returns the index scripts for a Postgres table, for
+// the same index modes as for sqlite3: 0=none, 1=k, 2=v, 3=kv.
+func postgresIndexScripts(table string, mode, nocaseMode int) (scripts []string) {
+	for _, v := range []struct {
+		mode   int
+		nocase bool
+	}{{mode, false}, {nocaseMode, true}} {
+		if v.mode == 1 || v.mode == 3 {
+			scripts = append(scripts, tabutils.PostgresIndexScript(table, "k", v.nocase))
+		}
+		if v.mode == 2 || v.mode == 3 {
+			scripts = append(scripts, tabutils.PostgresIndexScript(table, "v", v.nocase))
+		}
+	}
+	return scripts
 }
@@ -0,0 +1,140 @@
+// maktamerge merges several databases created by makta, e.g. one per slice of
+// a COCI archive converted in parallel, into a single database, optionally
+// without duplicate rows.
+//
+//	$ maktamerge -o oci.db oci-*.db
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/slub/labe/go/ckit"
+	"github.com/slub/labe/go/ckit/tabutils"
+)
+
+var (
+	Version    string
+	Buildtime  string
+	validTypes = []string{"INTEGER", "READ", "TEXT", "BLOB"} // sqlite3
+
+	showVersion = flag.Bool("version", false, "show version and exit")
+	outputFile  = flag.String("o", "data.db", "output filename, must not exist")
+	indexMode   = flag.Int("I", 3, "index mode: 0=none, 1=k, 2=v, 3=kv")
+	nocaseMode  = flag.Int("N", 0, "additional case insensitive index mode, cf. makta -N: 0=none, 1=k, 2=v, 3=kv")
+	cacheSize   = flag.Int("C", 1000000, "sqlite3 cache size, needs memory = C x page size")
+	valueType   = flag.String("T", "TEXT", "sqlite3 type for value column")
+	dedup       = flag.Bool("dedup", true, "remove duplicate rows; needs about the size of all inputs as temporary space next to the output")
+	sourceName  = flag.String("source", "", "name of the source dump, stored in the meta table (default: input filenames)")
+	reverse     = flag.Bool("R", false, "also write a reverse table (rmap), cf. makta -R")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: maktamerge [OPTION] FILE [FILE ...]\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("maktamerge %s %s\n", Version, Buildtime)
+		os.Exit(0)
+	}
+	if !ckit.SliceContains(validTypes, *valueType) {
+		log.Fatalf("invalid type for value column: %v %v", *valueType, validTypes)
+	}
+	inputs := flag.Args()
+	if len(inputs) == 0 {
+		log.Fatal("no input databases given")
+	}
+	var (
+		total int64
+		names []string
+	)
+	for _, f := range inputs {
+		fi, err := os.Stat(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		total += fi.Size()
+		names = append(names, filepath.Base(f))
+	}
+	if _, err := os.Stat(*outputFile); err == nil {
+		log.Fatalf("output already exists: %s", *outputFile)
+	}
+	var (
+		pragma  = tabutils.Pragma(*cacheSize)
+		initSQL = fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS map (k TEXT, v %s);`, *valueType)
+		// With dedup, we collect all rows in a staging database first, then
+		// copy distinct rows only.
+		target  = *outputFile
+		staging = *outputFile + ".staging"
+		done    int64
+		started = time.Now()
+	)
+	if *dedup {
+		target = staging
+		if err := os.RemoveAll(staging); err != nil {
+			log.Fatal(err)
+		}
+		defer os.Remove(staging)
+	}
+	if err := tabutils.RunScript(target, initSQL, "initialized database"); err != nil {
+		log.Fatal(err)
+	}
+	for i, f := range inputs {
+		msg := fmt.Sprintf("%d/%d copied %s", i+1, len(inputs), f)
+		if err := tabutils.RunScript(target, tabutils.CopyMapScript(pragma, f, false), msg); err != nil {
+			log.Fatalf("copy: %v", err)
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		done += fi.Size()
+		log.Printf("[io] %s of %s · %s",
+			tabutils.ByteSize(int(done)),
+			tabutils.ByteSize(int(total)),
+			tabutils.HumanSpeed(done, time.Since(started).Seconds()))
+	}
+	if *dedup {
+		if err := tabutils.RunScript(*outputFile, initSQL, "initialized database"); err != nil {
+			log.Fatal(err)
+		}
+		if err := tabutils.RunScript(*outputFile, tabutils.CopyMapScript(pragma, staging, true), "copied distinct rows"); err != nil {
+			log.Fatalf("dedup: %v", err)
+		}
+	}
+	indexScripts := tabutils.IndexScripts(pragma, *indexMode, *nocaseMode)
+	if *reverse {
+		indexScripts = append(indexScripts,
+			tabutils.ReverseTableScript(pragma, *valueType, *nocaseMode == 2 || *nocaseMode == 3))
+	}
+	log.Printf("[io] building %d indices ...", len(indexScripts))
+	for i, script := range indexScripts {
+		msg := fmt.Sprintf("%d/%d created index", i+1, len(indexScripts))
+		if err := tabutils.RunScript(*outputFile, script, msg); err != nil {
+			log.Fatalf("run script: %v", err)
+		}
+	}
+	source := *sourceName
+	if source == "" {
+		source = strings.Join(names, ",")
+	}
+	hostname, _ := os.Hostname()
+	kv := map[string]string{
+		"source":     source,
+		"date":       time.Now().UTC().Format(time.RFC3339),
+		"host":       hostname,
+		"maktamerge": Version,
+	}
+	if err := tabutils.RunScript(*outputFile, tabutils.MetaScript(kv), "wrote meta table"); err != nil {
+		log.Fatalf("run script: %v", err)
+	}
+	log.Printf("[ok] merged %d databases in %s", len(inputs), time.Since(started).Round(time.Second))
+}
@@ -0,0 +1,157 @@
+// ocicounts counts the distinct citing and cited DOI of every DOI in an OCI
+// sqlite3 database and writes them into a small counts database, which labed
+// uses with -counts for counts, most cited lists and size estimates.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/slub/labe/go/ckit"
+	"github.com/slub/labe/go/ckit/tabutils"
+)
+
+var (
+	Version   string
+	Buildtime string
+
+	ociDatabasePath = flag.String("o", "", "oci as a database path (citations)")
+	outputFile      = flag.String("f", "counts.db", "output filename")
+	noCase          = flag.Bool("N", false, "store lowercase DOI and count case insensitively, cf. labed -nocase")
+	batchSize       = flag.Int("B", 100000, "number of rows per transaction")
+	cacheSize       = flag.Int("C", 1000000, "sqlite3 cache size, needs memory = C x page size")
+	sourceName      = flag.String("source", "", "name of the source dump, e.g. a filename, stored in the meta table")
+	verbose         = flag.Bool("verbose", false, "be verbose")
+	showVersion     = flag.Bool("version", false, "show version and exit")
+)
+
+// writer adds counts in batches of rows per transaction.
+type writer struct {
+	db    *sql.DB
+	tx    *sql.Tx
+	stmt  *sql.Stmt
+	query string
+	n     int
+}
+
+func (w *writer) add(doi string, count int) error {
+	if w.tx == nil {
+		var err error
+		if w.tx, err = w.db.Begin(); err != nil {
+			return err
+		}
+		if w.stmt, err = w.tx.Prepare(w.query); err != nil {
+			return err
+		}
+	}
+	if *noCase {
+		doi = strings.ToLower(doi)
+	}
+	if _, err := w.stmt.Exec(doi, count); err != nil {
+		return err
+	}
+	if w.n++; w.n%*batchSize == 0 {
+		return w.commit()
+	}
+	return nil
+}
+
+func (w *writer) commit() error {
+	if w.tx == nil {
+		return nil
+	}
+	w.stmt.Close()
+	err := w.tx.Commit()
+	w.tx = nil
+	return err
+}
+
+func main() {
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("ocicounts %s %s\n", Version, Buildtime)
+		os.Exit(0)
+	}
+	if _, err := os.Stat(*outputFile); err == nil {
+		log.Fatalf("output file exists: %s", *outputFile)
+	}
+	oci, err := ckit.OpenDatabase(*ociDatabasePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer oci.Close()
+	db, err := sql.Open("sqlite3", *outputFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	// Pragmas apply per connection.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(tabutils.Pragma(*cacheSize) + ckit.CountsSchema); err != nil {
+		log.Fatal(err)
+	}
+	started := time.Now()
+	// Edges of a DOI are contiguous in key order; this uses the indices on k
+	// and v and is slower than a plain table scan, but counts distinct DOI,
+	// like labed, without keeping all edges in memory. With -N, case variants
+	// of a DOI are counted separately and then added up.
+	for _, pass := range []struct {
+		direction string
+		query     string
+		upsert    string
+	}{
+		{
+			"outbound",
+			"SELECT k, v FROM map ORDER BY k",
+			"INSERT INTO counts (doi, citing) VALUES (?, ?) ON CONFLICT (doi) DO UPDATE SET citing = citing + excluded.citing",
+		},
+		{
+			"inbound",
+			"SELECT v, k FROM map ORDER BY v",
+			"INSERT INTO counts (doi, cited) VALUES (?, ?) ON CONFLICT (doi) DO UPDATE SET cited = cited + excluded.cited",
+		},
+	} {
+		var (
+			w        = &writer{db: db, query: pass.upsert}
+			t        = time.Now()
+			progress = func(n int64) {
+				elapsed := time.Since(t)
+				if *verbose {
+					log.Printf("%s: read %d edges in %s", pass.direction, n, elapsed)
+				} else {
+					tabutils.Flushf("%s: read %d edges · %0.0f/s", pass.direction, n, float64(n)/elapsed.Seconds())
+				}
+			}
+		)
+		n, err := ckit.CountDistinct(oci.DB, pass.query, w.add, progress)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := w.commit(); err != nil {
+			log.Fatal(err)
+		}
+		if !*verbose && n >= 1000000 {
+			fmt.Println()
+		}
+		log.Printf("%s: read %d edges, wrote %d counts in %s", pass.direction, n, w.n, time.Since(t))
+	}
+	if _, err := db.Exec(ckit.CountsIndexScript); err != nil {
+		log.Fatal(err)
+	}
+	// Record where the data came from, so a running service can report it.
+	hostname, _ := os.Hostname()
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS meta (k TEXT PRIMARY KEY, v TEXT)"); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT OR REPLACE INTO meta (k, v) VALUES ('source', ?), ('date', ?), ('host', ?), ('ocicounts', ?)",
+		*sourceName, time.Now().UTC().Format(time.RFC3339), hostname, Version); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %s in %s", *outputFile, time.Since(started))
+}
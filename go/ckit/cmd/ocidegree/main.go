@@ -0,0 +1,105 @@
+// ocidegree counts the distinct citing and cited DOI of every DOI in an OCI
+// sqlite3 database and writes them into a compact file, which labed loads
+// with -degrees to answer count queries without the citation database.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/slub/labe/go/ckit"
+	"github.com/slub/labe/go/ckit/degree"
+	"github.com/slub/labe/go/ckit/tabutils"
+)
+
+var (
+	Version   string
+	Buildtime string
+
+	ociDatabasePath = flag.String("o", "", "oci as a database path (citations)")
+	outputFile      = flag.String("f", "degrees.bin", "output filename")
+	noCase          = flag.Bool("N", false, "compare DOI case insensitively, cf. labed -nocase")
+	verbose         = flag.Bool("verbose", false, "be verbose")
+	showVersion     = flag.Bool("version", false, "show version and exit")
+)
+
+// countGroups calls f with each DOI of an ordered query and the number of
+// distinct DOI paired with it, cf. ckit.CountDistinct.
+func countGroups(db *sql.DB, query, direction string, f func(doi string, n int)) error {
+	started := time.Now()
+	progress := func(n int64) {
+		elapsed := time.Since(started)
+		if *verbose {
+			log.Printf("%s: read %d edges in %s", direction, n, elapsed)
+		} else {
+			tabutils.Flushf("%s: read %d edges · %0.0f/s", direction, n, float64(n)/elapsed.Seconds())
+		}
+	}
+	n, err := ckit.CountDistinct(db, query, func(doi string, n int) error {
+		f(doi, n)
+		return nil
+	}, progress)
+	if err != nil {
+		return err
+	}
+	if !*verbose && n >= 1000000 {
+		fmt.Println()
+	}
+	log.Printf("%s: read %d edges in %s", direction, n, time.Since(started))
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("ocidegree %s %s\n", Version, Buildtime)
+		os.Exit(0)
+	}
+	db, err := ckit.OpenDatabase(*ociDatabasePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	var (
+		started = time.Now()
+		b       = degree.NewBuilder(*noCase)
+	)
+	// Edges of a DOI are contiguous in key order; this uses the indices on k
+	// and v and is slower than a plain table scan, but counts distinct DOI,
+	// like labed, without keeping all edges in memory.
+	if err := countGroups(db.DB, "SELECT k, v FROM map ORDER BY k", "outbound", b.AddOutbound); err != nil {
+		log.Fatal(err)
+	}
+	if err := countGroups(db.DB, "SELECT v, k FROM map ORDER BY v", "inbound", b.AddInbound); err != nil {
+		log.Fatal(err)
+	}
+	// Write to a temporary file first, so a running labed never sees a
+	// partial file.
+	f, err := ioutil.TempFile(filepath.Dir(*outputFile), ".ocidegree-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0644); err != nil {
+		log.Fatal(err)
+	}
+	size, err := b.Table().WriteTo(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Rename(f.Name(), *outputFile); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote counts for %d DOI (%d bytes) to %s in %s",
+		b.Len(), size, *outputFile, time.Since(started))
+}
@@ -0,0 +1,100 @@
+// ocigraph exports the whole citation graph from an OCI sqlite3 database as a
+// compressed edge list or GraphML, optionally partitioned by DOI prefix.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/slub/labe/go/ckit"
+	"github.com/slub/labe/go/ckit/graph"
+	"github.com/slub/labe/go/ckit/tabutils"
+)
+
+var (
+	Version   string
+	Buildtime string
+
+	ociDatabasePath = flag.String("o", "", "oci as a database path (citations)")
+	outputDir       = flag.String("d", ".", "output directory")
+	format          = flag.String("f", "tsv", "output format: tsv, graphml")
+	partition       = flag.Bool("p", false, "partition output by DOI prefix of the citing DOI, one file per prefix")
+	noCompress      = flag.Bool("Z", false, "do not compress output with zstd")
+	verbose         = flag.Bool("verbose", false, "be verbose")
+	showVersion     = flag.Bool("version", false, "show version and exit")
+)
+
+func main() {
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("ocigraph %s %s\n", Version, Buildtime)
+		os.Exit(0)
+	}
+	switch graph.Format(*format) {
+	case graph.FormatEdgeList, graph.FormatGraphML:
+	default:
+		log.Fatalf("invalid format: %s", *format)
+	}
+	db, err := ckit.OpenDatabase(*ociDatabasePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	var (
+		w = &graph.Partitioned{
+			Dir:      *outputDir,
+			Format:   graph.Format(*format),
+			Compress: !*noCompress,
+		}
+		query   = "SELECT k, v FROM map"
+		started = time.Now()
+		n       int64
+	)
+	if *partition {
+		// Edges with the same prefix are contiguous in sort order; this uses
+		// the index on k.
+		w.Partition = graph.Prefix
+		query += " ORDER BY k"
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var citing, cited string
+		if err := rows.Scan(&citing, &cited); err != nil {
+			log.Fatal(err)
+		}
+		if err := w.WriteEdge(citing, cited); err != nil {
+			log.Fatal(err)
+		}
+		n++
+		if n%1000000 == 0 {
+			elapsed := time.Since(started)
+			if *verbose {
+				log.Printf("exported %d edges in %d partition(s) in %s", n, w.Partitions(), elapsed)
+			} else {
+				tabutils.Flushf("exported %d edges · %0.0f/s", n, float64(n)/elapsed.Seconds())
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if !*verbose && n >= 1000000 {
+		fmt.Println()
+	}
+	log.Printf("exported %d edges in %d partition(s) to %s in %s",
+		n, w.Partitions(), *outputDir, time.Since(started))
+}
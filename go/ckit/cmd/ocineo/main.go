@@ -0,0 +1,242 @@
+// ocineo converts the identifier and OCI databases into CSV files for the
+// Neo4j bulk importer (neo4j-admin import): DOI become nodes with local
+// identifiers and title, citations become CITES relationships.
+package main
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit"
+	"github.com/slub/labe/go/ckit/graph"
+	"github.com/slub/labe/go/ckit/xflag"
+)
+
+var (
+	Version   string
+	Buildtime string
+
+	identifierDatabasePath = flag.String("i", "", "identifier database path (id-doi mapping)")
+	ociDatabasePath        = flag.String("o", "", "oci as a database path (citations)")
+	outputDir              = flag.String("d", ".", "output directory")
+	compress               = flag.Bool("z", false, "gzip compress output")
+	verbose                = flag.Bool("verbose", false, "be verbose")
+	showVersion            = flag.Bool("version", false, "show version and exit")
+
+	sqliteFetcherPaths xflag.Array // index metadata, for titles
+)
+
+// Doc is the part of the index document we are interested in.
+type Doc struct {
+	Title string `json:"title"`
+}
+
+// outputFile wraps a file and an optional gzip writer.
+type outputFile struct {
+	f  *os.File
+	zw *gzip.Writer
+	io.Writer
+}
+
+func createOutput(name string) (*outputFile, error) {
+	if *compress {
+		name += ".gz"
+	}
+	f, err := os.Create(filepath.Join(*outputDir, name))
+	if err != nil {
+		return nil, err
+	}
+	out := &outputFile{f: f, Writer: f}
+	if *compress {
+		out.zw = gzip.NewWriter(f)
+		out.Writer = out.zw
+	}
+	return out, nil
+}
+
+func (o *outputFile) Close() error {
+	if o.zw != nil {
+		if err := o.zw.Close(); err != nil {
+			o.f.Close()
+			return err
+		}
+	}
+	return o.f.Close()
+}
+
+// title returns the title of the first local identifier with index data.
+func title(fetcher ckit.Fetcher, ids []string) (string, error) {
+	if fetcher == nil {
+		return "", nil
+	}
+	for _, id := range ids {
+		b, err := fetcher.Fetch(id)
+		if errors.Is(err, ckit.ErrBlobNotFound) || errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		var doc Doc
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return "", fmt.Errorf("index data broken (%s): %w", id, err)
+		}
+		if doc.Title != "" {
+			return doc.Title, nil
+		}
+	}
+	return "", nil
+}
+
+// writeRelationships writes all citations.
+func writeRelationships(oci *sql.DB) (n int64, err error) {
+	out, err := createOutput("relationships.csv")
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+	w := graph.NewNeo4jRelationships(out)
+	rows, err := oci.Query("SELECT k, v FROM map")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var citing, cited string
+		if err := rows.Scan(&citing, &cited); err != nil {
+			return n, err
+		}
+		if err := w.WriteEdge(citing, cited); err != nil {
+			return n, err
+		}
+		if n++; *verbose && n%10000000 == 0 {
+			log.Printf("wrote %d relationships", n)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	if err := w.Close(); err != nil {
+		return n, err
+	}
+	return n, out.Close()
+}
+
+// writeNodes writes all DOI from the citation database. Local identifiers
+// are attached by walking both databases in DOI order.
+func writeNodes(identifier, oci *sql.DB, fetcher ckit.Fetcher) (n int64, err error) {
+	out, err := createOutput("nodes.csv")
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+	w := graph.NewNeo4jNodes(out)
+	dois, err := oci.Query("SELECT k FROM map UNION SELECT v FROM map ORDER BY 1")
+	if err != nil {
+		return 0, err
+	}
+	defer dois.Close()
+	ids, err := identifier.Query("SELECT k, v FROM map ORDER BY v")
+	if err != nil {
+		return 0, err
+	}
+	defer ids.Close()
+	var (
+		id, idDOI string
+		hasID     = ids.Next()
+	)
+	if hasID {
+		if err := ids.Scan(&id, &idDOI); err != nil {
+			return 0, err
+		}
+	}
+	for dois.Next() {
+		var (
+			doi   string
+			local []string
+		)
+		if err := dois.Scan(&doi); err != nil {
+			return n, err
+		}
+		for hasID && idDOI <= doi {
+			if idDOI == doi {
+				local = append(local, id)
+			}
+			if hasID = ids.Next(); hasID {
+				if err := ids.Scan(&id, &idDOI); err != nil {
+					return n, err
+				}
+			}
+		}
+		t, err := title(fetcher, local)
+		if err != nil {
+			return n, err
+		}
+		if err := w.WriteNode(doi, local, t); err != nil {
+			return n, err
+		}
+		if n++; *verbose && n%1000000 == 0 {
+			log.Printf("wrote %d nodes", n)
+		}
+	}
+	if err := dois.Err(); err != nil {
+		return n, err
+	}
+	if err := ids.Err(); err != nil {
+		return n, err
+	}
+	if err := w.Close(); err != nil {
+		return n, err
+	}
+	return n, out.Close()
+}
+
+func main() {
+	flag.Var(&sqliteFetcherPaths, "m", "index metadata cache sqlite3 path, for titles (repeatable)")
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("ocineo %s %s\n", Version, Buildtime)
+		os.Exit(0)
+	}
+	identifierDatabase, err := ckit.OpenDatabase(*identifierDatabasePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer identifierDatabase.Close()
+	ociDatabase, err := ckit.OpenDatabase(*ociDatabasePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer ociDatabase.Close()
+	var fetcher ckit.Fetcher
+	if len(sqliteFetcherPaths) > 0 {
+		g := &ckit.FetchGroup{}
+		if err := g.FromFiles(sqliteFetcherPaths...); err != nil {
+			log.Fatal(err)
+		}
+		fetcher = g
+	}
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	started := time.Now()
+	n, err := writeNodes(identifierDatabase.DB, ociDatabase.DB, fetcher)
+	if err != nil {
+		log.Fatalf("nodes: %v", err)
+	}
+	log.Printf("wrote %d nodes in %s", n, time.Since(started))
+	if n, err = writeRelationships(ociDatabase.DB); err != nil {
+		log.Fatalf("relationships: %v", err)
+	}
+	log.Printf("wrote %d relationships in %s", n, time.Since(started))
+}
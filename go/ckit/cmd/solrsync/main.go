@@ -0,0 +1,112 @@
+// solrsync updates an index database as created by makta (and served by
+// labed -m) with documents changed in a live SOLR since the last sync, so
+// the index data can be kept up to date daily, without a full export.
+//
+//	$ solrsync -server http://localhost:8983/solr/biblio/select -db index.db -since 2022-02-01T00:00:00Z
+//	$ solrsync -server http://localhost:8983/solr/biblio/select -db index.db
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit"
+)
+
+var (
+	Version   string
+	Buildtime string
+
+	server      = flag.String("server", "", "SOLR select handler URL, e.g. http://localhost:8983/solr/biblio/select")
+	dbFile      = flag.String("db", "index.db", "index database to update")
+	since       = flag.String("since", "", "sync documents changed since, as timestamp (2006-01-02T15:04:05Z) or duration (e.g. 36h); default: time of the last sync, recorded in the meta table")
+	dateField   = flag.String("field", "last_indexed", "date field recording the last change of a document")
+	keyField    = flag.String("key", "id", "key field, the SOLR unique key")
+	fields      = flag.String("fl", "", "fields to fetch, comma separated, e.g. for a short version of the index data (default: all)")
+	rows        = flag.Int("rows", ckit.DefaultSyncRows, "documents per request")
+	timeout     = flag.Duration("timeout", time.Minute, "timeout for a single SOLR request")
+	showVersion = flag.Bool("version", false, "show version and exit")
+)
+
+func main() {
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("solrsync %s %s\n", Version, Buildtime)
+		os.Exit(0)
+	}
+	if *server == "" {
+		log.Fatal("SOLR URL required (-server)")
+	}
+	if _, err := os.Stat(*dbFile); err != nil {
+		log.Fatal(err)
+	}
+	db, err := sqlx.Open("sqlite3", *dbFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS meta (k TEXT PRIMARY KEY, v TEXT)"); err != nil {
+		log.Fatal(err)
+	}
+	t, err := syncStart(db, *since)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var (
+		// Documents changed while we sync are picked up by the next sync.
+		started = time.Now()
+		ctx     = context.Background()
+		idx     = &ckit.SolrIndex{
+			URL:    *server,
+			Client: ckit.NewHTTPClient(ckit.DefaultMaxIdleConnsPerHost, *timeout),
+		}
+		inserted, updated int
+	)
+	log.Printf("[..] syncing documents changed since %s", t.Format(time.RFC3339))
+	err = idx.Changed(ctx, *dateField, *keyField, *fields, t, *rows, func(docs []json.RawMessage) error {
+		i, u, err := ckit.UpsertDocuments(ctx, db, *keyField, docs)
+		if err != nil {
+			return err
+		}
+		inserted += i
+		updated += u
+		log.Printf("[io] %d inserted, %d updated", inserted, updated)
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("sync: %v", err)
+	}
+	if _, err := db.Exec("INSERT OR REPLACE INTO meta (k, v) VALUES ('synced', ?)",
+		started.UTC().Format(time.RFC3339)); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT OR REPLACE INTO meta (k, v) SELECT 'rows', coalesce(max(rowid), 0) FROM map"); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("[ok] %d inserted, %d updated in %s", inserted, updated, time.Since(started).Round(time.Second))
+}
+
+// syncStart returns the time to sync from, as given by a flag value or the
+// time of the last sync.
+func syncStart(db *sqlx.DB, v string) (time.Time, error) {
+	if v == "" {
+		switch err := db.Get(&v, "SELECT v FROM meta WHERE k = 'synced'"); {
+		case err == sql.ErrNoRows:
+			return time.Time{}, fmt.Errorf("no previous sync recorded, use -since")
+		case err != nil:
+			return time.Time{}, err
+		}
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
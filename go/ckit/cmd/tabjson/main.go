@@ -40,6 +40,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"encoding/base64"
@@ -48,9 +49,10 @@ import (
 	"io"
 	"log"
 	"os"
+	"runtime"
 
-	"github.com/miku/parallel"
 	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/tabutils"
 )
 
 var (
@@ -60,6 +62,9 @@ var (
 	compressValue = flag.Bool("C", false, "compress value; gz+b64")
 	compressTable = flag.Bool("T", false, "emit table showing possible savings through compression")
 	showVersion   = flag.Bool("version", false, "show version and exit")
+	numWorkers    = flag.Int("w", runtime.NumCPU(), "number of workers")
+	batchSize     = flag.Int("b", 10000, "lines per batch")
+	ordered       = flag.Bool("ordered", false, "keep input order in output")
 )
 
 // Doc is the part of the document we are interested in. If this tool should be
@@ -74,7 +79,15 @@ func main() {
 		fmt.Printf("makta %s %s\n", Version, Buildtime)
 		os.Exit(0)
 	}
-	pp := parallel.NewProcessor(os.Stdin, os.Stdout, func(p []byte) ([]byte, error) {
+	var (
+		bw = bufio.NewWriter(os.Stdout)
+		pp = tabutils.Pipeline{
+			Workers:   *numWorkers,
+			BatchSize: *batchSize,
+			Ordered:   *ordered,
+		}
+	)
+	err := pp.Run(os.Stdin, bw, func(p []byte) ([]byte, error) {
 		var doc Doc
 		if err := json.Unmarshal(p, &doc); err != nil {
 			return nil, err
@@ -105,7 +118,10 @@ func main() {
 			return append([]byte(doc.ID+"\t"), dst.Bytes()...), nil
 		}
 	})
-	if err := pp.Run(); err != nil {
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := bw.Flush(); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,116 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// corpusNamePattern restricts corpus names, as they are part of the URL.
+var corpusNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// NewCorpus returns a server for an additional corpus, e.g. a monthly
+// updated "ai" corpus besides the daily "main" one, with its own databases
+// as listed in a manifest, cf. ReadManifest. If the manifest contains no
+// index databases, the index data of s is used. The corpus shares router,
+// stats and limits with s, but not the cache, since the same id may have
+// different responses in different corpora. Add it to Corpora before
+// calling Routes; it is then served under /c/{name}.
+func (s *Server) NewCorpus(name, manifest string) (*Server, error) {
+	if !corpusNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid corpus name: %q", name)
+	}
+	ds, err := ReadManifest(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("corpus %s: %w", name, err)
+	}
+	degrees, err := openDegrees(ds.Degrees)
+	if err != nil {
+		return nil, fmt.Errorf("corpus %s: %w", name, err)
+	}
+	counts, err := openCounts(ds.Counts)
+	if err != nil {
+		return nil, fmt.Errorf("corpus %s: %w", name, err)
+	}
+	identifier, oci, index, err := openDatasets(ds, s.IdentifierInMemory, s.BusyRetries)
+	if err != nil {
+		if counts != nil {
+			counts.Close()
+		}
+		return nil, fmt.Errorf("corpus %s: %w", name, err)
+	}
+	if index == nil {
+		index = s.indexData()
+	}
+	c := &Server{
+		IdentifierDatabase:   identifier,
+		OciDatabase:          oci,
+		IndexData:            index,
+		Degrees:              degrees,
+		CountsDatabase:       counts,
+		BuildInfo:            s.BuildInfo,
+		StripRules:           s.StripRules,
+		Transformers:         s.Transformers,
+		LiveIndex:            s.LiveIndex,
+		OpenAlex:             s.OpenAlex,
+		OADatabase:           s.OADatabase,
+		Retractions:          s.Retractions,
+		SelfCitation:         s.SelfCitation,
+		YearFields:           s.YearFields,
+		NoCase:               s.NoCase,
+		MaxDataAge:           s.MaxDataAge,
+		EdgeSources:          s.EdgeSources,
+		BlobSchema:           s.BlobSchema,
+		DropInvalid:          s.DropInvalid,
+		Manifest:             manifest,
+		AdminToken:           s.AdminToken,
+		TrustedProxies:       s.TrustedProxies,
+		APIKeys:              s.APIKeys,
+		Datasets:             ds,
+		Router:               s.Router,
+		StopWatchEnabled:     s.StopWatchEnabled,
+		StopWatchThreshold:   s.StopWatchThreshold,
+		StopWatchJSON:        s.StopWatchJSON,
+		Debug:                s.Debug,
+		Bench:                s.Bench,
+		MaxPathDepth:         s.MaxPathDepth,
+		Notifier:             s.Notifier,
+		MaxEdges:             s.MaxEdges,
+		MaxResponseSize:      s.MaxResponseSize,
+		EstimatedBlobSize:    s.EstimatedBlobSize,
+		SingleFlight:         s.SingleFlight,
+		Stats:                s.Stats,
+		IdentifierTimeout:    s.IdentifierTimeout,
+		OciTimeout:           s.OciTimeout,
+		BusyRetries:          s.BusyRetries,
+		IdentifierInMemory:   s.IdentifierInMemory,
+		IdentifierFilterRate: s.IdentifierFilterRate,
+		IndexDataTimeout:     s.IndexDataTimeout,
+		Degraded:             s.Degraded,
+		OmitUnmatched:        s.OmitUnmatched,
+		SortedResponses:      s.SortedResponses,
+	}
+	if c.IdentifierFilterRate > 0 {
+		if err := c.BuildIdentifierFilter(context.Background()); err != nil {
+			identifier.Close()
+			oci.Close()
+			if counts != nil {
+				counts.Close()
+			}
+			if len(ds.Index) > 0 {
+				closeFetcher(index)
+			}
+			return nil, fmt.Errorf("corpus %s: %w", name, err)
+		}
+	}
+	return c, nil
+}
+
+// corporaRoutes registers the routes of all corpora, under /c/{name}.
+func (s *Server) corporaRoutes() {
+	for name, c := range s.Corpora {
+		c.Router = s.Router
+		c.PathPrefix = s.PathPrefix + "/c/" + name
+		c.Routes()
+	}
+}
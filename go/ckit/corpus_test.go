@@ -0,0 +1,69 @@
+package ckit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestNewCorpusName(t *testing.T) {
+	srv := &Server{}
+	for _, name := range []string{"", "a/b", "a b", "../x"} {
+		if _, err := srv.NewCorpus(name, "manifest.json"); err == nil {
+			t.Fatalf("%q: want error", name)
+		}
+	}
+}
+
+func TestCorpusRoutes(t *testing.T) {
+	abs, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := writeManifest(t, t.TempDir(), `{
+		"identifier": "`+filepath.Join(abs, "id_doi.db")+`",
+		"oci": "`+filepath.Join(abs, "doi_doi.db")+`",
+		"index": ["`+filepath.Join(abs, "id_metadata.db")+`"]}`)
+	ds, err := ReadManifest(manifest)
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	identifier, oci, index, err := openDatasets(ds, false, 0)
+	if err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	srv := &Server{
+		IdentifierDatabase: identifier,
+		OciDatabase:        oci,
+		IndexData:          index,
+		Router:             mux.NewRouter(),
+	}
+	c, err := srv.NewCorpus("ai", manifest)
+	if err != nil {
+		t.Fatalf("new corpus: %v", err)
+	}
+	srv.Corpora = map[string]*Server{"ai": c}
+	srv.Routes()
+	if err := srv.Ping(); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	var cases = []struct {
+		path       string
+		statusCode int
+	}{
+		{"/c/ai/", 200},
+		{"/c/ai/version", 200},
+		{"/c/ai/id/123", 404},
+		{"/c/xx/id/123", 404},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if w.Code != c.statusCode {
+			t.Fatalf("%s: got %v, want %v", c.path, w.Code, c.statusCode)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package ckit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/set"
+)
+
+const (
+	// CountsSchema is the schema of a counts database, cf. ocicounts: the
+	// number of distinct citing and cited DOI per DOI.
+	CountsSchema = `
+CREATE TABLE IF NOT EXISTS counts (
+	doi TEXT PRIMARY KEY,
+	citing INTEGER NOT NULL DEFAULT 0,
+	cited INTEGER NOT NULL DEFAULT 0
+) WITHOUT ROWID;`
+	// CountsIndexScript creates the index for most cited lists, after the
+	// counts have been loaded.
+	CountsIndexScript = `CREATE INDEX IF NOT EXISTS idx_counts_cited ON counts(cited DESC);`
+)
+
+// CountDistinct reads pairs of values ordered by the first, e.g. "SELECT k, v
+// FROM map ORDER BY k", and calls f with each first value and the number of
+// distinct second values; progress, if not nil, is called every million
+// rows. It returns the number of rows read.
+func CountDistinct(db *sql.DB, query string, f func(k string, n int) error, progress func(rows int64)) (int64, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var (
+		current string
+		group   = set.New()
+		n       int64
+	)
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return n, err
+		}
+		if k != current && group.Len() > 0 {
+			if err := f(current, group.Len()); err != nil {
+				return n, err
+			}
+			group.Clear()
+		}
+		current = k
+		group.Add(v)
+		n++
+		if progress != nil && n%1000000 == 0 {
+			progress(n)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	if group.Len() > 0 {
+		if err := f(current, group.Len()); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// openCounts opens a counts database; nil for an empty path.
+func openCounts(filename string) (*sqlx.DB, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	return OpenDatabase(filename)
+}
+
+// countsDB returns the current counts database, if any.
+func (s *Server) countsDB() *sqlx.DB {
+	s.dataMu.RLock()
+	defer s.dataMu.RUnlock()
+	return s.CountsDatabase
+}
+
+// storedCounts returns the number of citing and cited DOI of a DOI from the
+// degree table or the counts database; ok is false, if there is neither.
+func (s *Server) storedCounts(ctx context.Context, doi string) (citing, cited int, ok bool, err error) {
+	if t := s.degrees(); t != nil {
+		citing, cited = t.Counts(doi)
+		return citing, cited, true, nil
+	}
+	db := s.countsDB()
+	if db == nil {
+		return 0, 0, false, nil
+	}
+	ctx, cancel := withTimeout(ctx, s.OciTimeout)
+	defer cancel()
+	var c struct {
+		Citing int `db:"citing"`
+		Cited  int `db:"cited"`
+	}
+	err = s.getContext(ctx, db, &c, "SELECT citing, cited FROM counts WHERE doi = ?", s.foldDOI(doi))
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, 0, true, nil
+	case err != nil:
+		return 0, 0, true, err
+	}
+	return c.Citing, c.Cited, true, nil
+}
+
+// CountsResponse is the response for /counts/{doi}.
+type CountsResponse struct {
+	DOI    string `json:"doi"`
+	Citing int    `json:"citing"`
+	Cited  int    `json:"cited"`
+}
+
+// handleCounts returns the number of distinct citing and cited DOI of a DOI,
+// from the degree table or counts database, if configured, or the oci
+// database.
+func (s *Server) handleCounts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			started = time.Now()
+			resp    = CountsResponse{DOI: mux.Vars(r)["doi"]}
+			err     error
+		)
+		resp.Citing, resp.Cited, err = s.edgeCounts(r.Context(), resp.DOI)
+		switch {
+		case errors.Is(err, context.Canceled):
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			httpErrLogf(w, http.StatusGatewayTimeout, "counts: %w", err)
+			return
+		case err != nil:
+			httpErrLogf(w, http.StatusInternalServerError, "counts: %w", err)
+			return
+		}
+		s.Stats.MeasureSinceWithLabels("counts", started, nil)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("counts: %v", err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package ckit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/degree"
+	"github.com/thoas/stats"
+)
+
+func TestCountDistinct(t *testing.T) {
+	db, err := sqlx.Open(sqliteDriver, ":memory:")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`CREATE TABLE map (k TEXT, v TEXT);
+		INSERT INTO map VALUES ('a', 'x'), ('a', 'y'), ('a', 'x'), ('b', 'x'), ('c', 'z');`); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	got := make(map[string]int)
+	n, err := CountDistinct(db.DB, "SELECT k, v FROM map ORDER BY k", func(k string, n int) error {
+		got[k] = n
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if n != 5 {
+		t.Fatalf("got %d rows, want 5", n)
+	}
+	if diff := cmp.Diff(map[string]int{"a": 2, "b": 1, "c": 1}, got); diff != "" {
+		t.Fatalf("counts mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStoredCounts(t *testing.T) {
+	s := &Server{}
+	if _, _, ok, err := s.storedCounts(context.Background(), "10.1/a"); ok || err != nil {
+		t.Fatalf("no counts: got %v, %v", ok, err)
+	}
+}
+
+func TestHandleCounts(t *testing.T) {
+	b := degree.NewBuilder(false)
+	b.AddOutbound("10.1/a", 3)
+	b.AddInbound("10.1/a", 5)
+	srv := &Server{
+		Degrees: b.Table(),
+		Router:  mux.NewRouter(),
+		Stats:   stats.New(),
+	}
+	srv.Routes()
+	for _, c := range []struct {
+		path string
+		want CountsResponse
+	}{
+		{"/counts/10.1/a", CountsResponse{DOI: "10.1/a", Citing: 3, Cited: 5}},
+		{"/counts/10.1/b", CountsResponse{DOI: "10.1/b"}},
+	} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d, want 200", c.path, w.Code)
+		}
+		var got CountsResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %v", c.path, err)
+		}
+		if got != c.want {
+			t.Fatalf("%s: got %+v, want %+v", c.path, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package ckit
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// parseCursor returns the value of the cursor parameter, used for keyset
+// paging, and whether it is set at all; an empty cursor requests the first
+// page.
+func parseCursor(r *http.Request) (cursor string, ok bool) {
+	vs, ok := r.URL.Query()["cursor"]
+	if !ok {
+		return "", false
+	}
+	return vs[0], true
+}
+
+// cursorPage sorts ids by DOI, then by local id, and returns at most limit
+// ids following the cursor, which is the last DOI of the previous page, and
+// the last DOI of this page, if there are more. The ids of a DOI are never
+// split across pages, so a page may contain a few more than limit ids, but
+// there are no duplicates or gaps between pages. A limit of zero means no
+// limit.
+func cursorPage(ids []Map, cursor string, limit int) (page []Map, next string) {
+	sortByDOI(ids)
+	if cursor != "" {
+		ids = ids[sort.Search(len(ids), func(i int) bool { return ids[i].Value > cursor }):]
+	}
+	if limit <= 0 || len(ids) <= limit {
+		return ids, ""
+	}
+	n := limit
+	for n < len(ids) && ids[n].Value == ids[n-1].Value {
+		n++
+	}
+	if n == len(ids) {
+		return ids, ""
+	}
+	return ids[:n], ids[n-1].Value
+}
+
+// sortByDOI sorts local id to DOI mappings by DOI, then by local id.
+func sortByDOI(ids []Map) {
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].Value != ids[j].Value {
+			return ids[i].Value < ids[j].Value
+		}
+		return ids[i].Key < ids[j].Key
+	})
+}
+
+// nextCursorPage returns the URL path and query of the page following a
+// cursor, keeping all other parameters.
+func (s *Server) nextCursorPage(query url.Values, id string, cursor string) string {
+	q := url.Values{}
+	for k, vs := range query {
+		q[k] = vs
+	}
+	q.Set("cursor", cursor)
+	return s.PathPrefix + "/id/" + url.PathEscape(id) + "?" + q.Encode()
+}
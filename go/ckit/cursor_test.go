@@ -0,0 +1,94 @@
+package ckit
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCursor(t *testing.T) {
+	var cases = []struct {
+		target string
+		cursor string
+		ok     bool
+	}{
+		{"/id/a", "", false},
+		{"/id/a?cursor=", "", true},
+		{"/id/a?cursor=10.1%2Fa", "10.1/a", true},
+	}
+	for _, c := range cases {
+		cursor, ok := parseCursor(httptest.NewRequest("GET", c.target, nil))
+		if cursor != c.cursor || ok != c.ok {
+			t.Fatalf("[%s] got %q %v, want %q %v", c.target, cursor, ok, c.cursor, c.ok)
+		}
+	}
+}
+
+func TestCursorPage(t *testing.T) {
+	// Two local ids share 10.1/c, which must not be split across pages.
+	ids := []Map{
+		{Key: "5", Value: "10.1/e"},
+		{Key: "3b", Value: "10.1/c"},
+		{Key: "1", Value: "10.1/a"},
+		{Key: "4", Value: "10.1/d"},
+		{Key: "3a", Value: "10.1/c"},
+		{Key: "2", Value: "10.1/b"},
+	}
+	var cases = []struct {
+		limit int
+		pages string
+	}{
+		{0, "[[1 2 3a 3b 4 5]]"},
+		{2, "[[1 2] [3a 3b] [4 5]]"},
+		{3, "[[1 2 3a 3b] [4 5]]"},
+		{4, "[[1 2 3a 3b] [4 5]]"},
+		{6, "[[1 2 3a 3b 4 5]]"},
+	}
+	for _, c := range cases {
+		var (
+			pages  [][]string
+			cursor string
+		)
+		for {
+			page, next := cursorPage(append([]Map(nil), ids...), cursor, c.limit)
+			var keys []string
+			for _, v := range page {
+				keys = append(keys, v.Key)
+			}
+			pages = append(pages, keys)
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		if got := fmt.Sprintf("%v", pages); got != c.pages {
+			t.Fatalf("[%d] got %v, want %v", c.limit, got, c.pages)
+		}
+	}
+	if page, next := cursorPage(ids, "10.1/z", 2); len(page) != 0 || next != "" {
+		t.Fatalf("got %v %q, want empty page", page, next)
+	}
+}
+
+func TestNextCursorPage(t *testing.T) {
+	srv := &Server{PathPrefix: "/labe"}
+	r := httptest.NewRequest("GET", "/labe/id/a?cursor=10.1%2Fa&i=DE-14", nil)
+	want := "/labe/id/a?cursor=10.1%2Fc&i=DE-14"
+	if got := srv.nextCursorPage(r.URL.Query(), "a", "10.1/c"); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestSortByDOI(t *testing.T) {
+	ids := []Map{
+		{Key: "3", Value: "10.1/c"},
+		{Key: "2b", Value: "10.1/b"},
+		{Key: "1", Value: "10.1/a"},
+		{Key: "2a", Value: "10.1/b"},
+	}
+	sortByDOI(ids)
+	want := "[{1 10.1/a} {2a 10.1/b} {2b 10.1/b} {3 10.1/c}]"
+	if got := fmt.Sprintf("%v", ids); got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,53 @@
+package ckit
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/set"
+)
+
+// degrade reports whether a failed index data fetch should result in a
+// degraded response, cf. Server.Degraded; that is, unless the request itself
+// went away.
+func (s *Server) degrade(ctx context.Context, id string, err error) bool {
+	if !s.Degraded || err == nil || ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	log.Printf("index data unavailable, degraded response (%s): %v", id, err)
+	return true
+}
+
+// fillDegraded sets the citing and cited documents to stubs, for when the
+// index data is not available, cf. fillIdentifiers.
+func (r *Response) fillDegraded(ids []Map, outbound, inbound set.Set) {
+	r.fillIdentifiers(ids, outbound, inbound)
+	r.Extra.Sources = nil
+	r.Extra.Degraded = true
+}
+
+// fillIdentifiers sets the citing and cited documents to stubs, which contain
+// only the local id and the DOI; annotations, e.g. open access status, are
+// kept.
+func (r *Response) fillIdentifiers(ids []Map, outbound, inbound set.Set) {
+	r.Citing = make([]json.RawMessage, 0, len(ids))
+	r.Cited = make([]json.RawMessage, 0, len(ids))
+	for _, v := range ids {
+		b := make([]byte, 0, len(v.Key)+len(v.Value)+32)
+		b = append(b, `{"id": `...)
+		b = strconv.AppendQuote(b, v.Key)
+		b = append(b, `, "doi_str_mv": `...)
+		b = strconv.AppendQuote(b, v.Value)
+		b = append(b, '}')
+		b = r.annotated(v.Key, b)
+		switch {
+		case outbound.Contains(v.Value):
+			r.Citing = append(r.Citing, b)
+		case inbound.Contains(v.Value):
+			r.Cited = append(r.Cited, b)
+		}
+	}
+}
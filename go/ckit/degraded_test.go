@@ -0,0 +1,63 @@
+package ckit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/slub/labe/go/ckit/set"
+)
+
+func TestFillDegraded(t *testing.T) {
+	var (
+		ids = []Map{
+			{Key: "i1", Value: "10.1/a"},
+			{Key: "i2", Value: "10.1/b"},
+			{Key: "i3", Value: "10.1/c"},
+		}
+		outbound = set.FromSlice([]string{"10.1/a", "10.1/b"})
+		inbound  = set.FromSlice([]string{"10.1/c"})
+		r        = &Response{}
+	)
+	r.annotate("i3", "oa", "gold")
+	r.Extra.Sources = map[string]int{"main": 1}
+	r.fillDegraded(ids, outbound, inbound)
+	r.updateCounts()
+	if !r.Extra.Degraded || r.Extra.Sources != nil {
+		t.Fatalf("got degraded %v, sources %v", r.Extra.Degraded, r.Extra.Sources)
+	}
+	if r.Extra.CitingCount != 2 || r.Extra.CitedCount != 1 {
+		t.Fatalf("got %d citing, %d cited, want 2, 1", r.Extra.CitingCount, r.Extra.CitedCount)
+	}
+	if got := string(r.Citing[0]); got != `{"id": "i1", "doi_str_mv": "10.1/a"}` {
+		t.Fatalf("got %s", got)
+	}
+	if got := string(r.Cited[0]); got != `{"oa":"gold","id": "i3", "doi_str_mv": "10.1/c"}` {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestDegrade(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	var cases = []struct {
+		enabled bool
+		ctx     context.Context
+		err     error
+		result  bool
+	}{
+		{false, context.Background(), errors.New("connection refused"), false},
+		{true, context.Background(), nil, false},
+		{true, context.Background(), errors.New("connection refused"), true},
+		{true, context.Background(), fmt.Errorf("fetch: %w", context.DeadlineExceeded), true},
+		{true, context.Background(), fmt.Errorf("fetch: %w", context.Canceled), false},
+		{true, cancelled, errors.New("connection refused"), false},
+	}
+	for i, c := range cases {
+		srv := &Server{Degraded: c.enabled}
+		if got := srv.degrade(c.ctx, "i1", c.err); got != c.result {
+			t.Fatalf("[%d] got %v, want %v", i, got, c.result)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Package degree stores the number of distinct citing (outbound) and cited
+// (inbound) DOI per DOI in a compact table, which can be loaded into memory,
+// instead of counting edges in the citation database. DOI are stored as 64
+// bit hashes in a sorted array, counts as 16 bit values, with larger counts
+// in a separate overflow table; this needs about 12 bytes per DOI.
+package degree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// magic identifies a degree file and its format version.
+const magic = "LABEDEG1"
+
+// flagNoCase marks a table with case insensitive DOI.
+const flagNoCase = 1
+
+// saturated marks a count, which is kept in the overflow table.
+const saturated = 1<<16 - 1
+
+// ErrInvalidFile is returned for files, which are not degree tables.
+var ErrInvalidFile = errors.New("not a degree file")
+
+// key returns the hash of a DOI.
+func key(doi string, nocase bool) uint64 {
+	if nocase {
+		doi = strings.ToLower(doi)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(doi))
+	return h.Sum64()
+}
+
+// Builder collects counts, not thread-safe.
+type Builder struct {
+	nocase bool
+	counts map[uint64][2]uint32 // outbound, inbound
+}
+
+// NewBuilder returns a builder; with nocase, DOI are compared case
+// insensitively, cf. labed -nocase.
+func NewBuilder(nocase bool) *Builder {
+	return &Builder{nocase: nocase, counts: make(map[uint64][2]uint32)}
+}
+
+// AddOutbound adds to the number of citing DOI of a DOI.
+func (b *Builder) AddOutbound(doi string, n int) {
+	k := key(doi, b.nocase)
+	v := b.counts[k]
+	v[0] += uint32(n)
+	b.counts[k] = v
+}
+
+// AddInbound adds to the number of cited DOI of a DOI.
+func (b *Builder) AddInbound(doi string, n int) {
+	k := key(doi, b.nocase)
+	v := b.counts[k]
+	v[1] += uint32(n)
+	b.counts[k] = v
+}
+
+// Len returns the number of DOI.
+func (b *Builder) Len() int {
+	return len(b.counts)
+}
+
+// Table returns the collected counts as a table.
+func (b *Builder) Table() *Table {
+	t := &Table{
+		nocase:   b.nocase,
+		keys:     make([]uint64, 0, len(b.counts)),
+		overflow: make(map[uint64][2]uint32),
+	}
+	for k := range b.counts {
+		t.keys = append(t.keys, k)
+	}
+	sort.Slice(t.keys, func(i, j int) bool { return t.keys[i] < t.keys[j] })
+	t.out = make([]uint16, len(t.keys))
+	t.in = make([]uint16, len(t.keys))
+	for i, k := range t.keys {
+		v := b.counts[k]
+		if v[0] >= saturated || v[1] >= saturated {
+			t.out[i], t.in[i] = saturated, saturated
+			t.overflow[k] = v
+			continue
+		}
+		t.out[i], t.in[i] = uint16(v[0]), uint16(v[1])
+	}
+	return t
+}
+
+// Table answers count lookups; it is safe for concurrent use.
+type Table struct {
+	nocase   bool
+	keys     []uint64
+	out, in  []uint16
+	overflow map[uint64][2]uint32
+}
+
+// Counts returns the number of citing and cited DOI of a DOI; zero for
+// unknown DOI. Different DOI with the same hash share counts, which is
+// unlikely for the number of DOI in OCI.
+func (t *Table) Counts(doi string) (citing, cited int) {
+	k := key(doi, t.nocase)
+	i := sort.Search(len(t.keys), func(i int) bool { return t.keys[i] >= k })
+	if i == len(t.keys) || t.keys[i] != k {
+		return 0, 0
+	}
+	if t.out[i] == saturated && t.in[i] == saturated {
+		if v, ok := t.overflow[k]; ok {
+			return int(v[0]), int(v[1])
+		}
+	}
+	return int(t.out[i]), int(t.in[i])
+}
+
+// Len returns the number of DOI.
+func (t *Table) Len() int {
+	return len(t.keys)
+}
+
+// NoCase returns true, if DOI are compared case insensitively.
+func (t *Table) NoCase() bool {
+	return t.nocase
+}
+
+// header precedes the arrays in a degree file.
+type header struct {
+	Magic    [8]byte
+	Flags    uint64
+	Len      uint64
+	Overflow uint64
+}
+
+// overflowEntry is a row of the overflow table in a degree file.
+type overflowEntry struct {
+	Key     uint64
+	Out, In uint32
+}
+
+// WriteTo writes the table in a binary, little endian format: a header,
+// keys, outbound and inbound counts and the overflow table.
+func (t *Table) WriteTo(w io.Writer) (int64, error) {
+	var (
+		bw = bufio.NewWriter(w)
+		h  = header{Len: uint64(len(t.keys)), Overflow: uint64(len(t.overflow))}
+	)
+	copy(h.Magic[:], magic)
+	if t.nocase {
+		h.Flags |= flagNoCase
+	}
+	entries := make([]overflowEntry, 0, len(t.overflow))
+	for k, v := range t.overflow {
+		entries = append(entries, overflowEntry{k, v[0], v[1]})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	for _, v := range []interface{}{h, t.keys, t.out, t.in, entries} {
+		if err := binary.Write(bw, binary.LittleEndian, v); err != nil {
+			return 0, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return int64(binary.Size(h) + 12*len(t.keys) + 16*len(entries)), nil
+}
+
+// Read reads a table written by WriteTo.
+func Read(r io.Reader) (*Table, error) {
+	var (
+		br = bufio.NewReader(r)
+		h  header
+	)
+	if err := binary.Read(br, binary.LittleEndian, &h); err != nil {
+		return nil, ErrInvalidFile
+	}
+	if string(h.Magic[:]) != magic {
+		return nil, ErrInvalidFile
+	}
+	t := &Table{
+		nocase:   h.Flags&flagNoCase != 0,
+		keys:     make([]uint64, h.Len),
+		out:      make([]uint16, h.Len),
+		in:       make([]uint16, h.Len),
+		overflow: make(map[uint64][2]uint32, h.Overflow),
+	}
+	entries := make([]overflowEntry, h.Overflow)
+	for _, v := range []interface{}{t.keys, t.out, t.in, entries} {
+		if err := binary.Read(br, binary.LittleEndian, v); err != nil {
+			return nil, fmt.Errorf("degree: %w", err)
+		}
+	}
+	for _, e := range entries {
+		t.overflow[e.Key] = [2]uint32{e.Out, e.In}
+	}
+	return t, nil
+}
+
+// Open reads a table from a file.
+func Open(filename string) (*Table, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return t, nil
+}
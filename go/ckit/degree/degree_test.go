@@ -0,0 +1,76 @@
+package degree
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestTable(t *testing.T) {
+	is := is.New(t)
+
+	b := NewBuilder(false)
+	b.AddOutbound("10.1/a", 2)
+	b.AddInbound("10.1/a", 3)
+	b.AddInbound("10.1/b", 1)
+	b.AddInbound("10.1/b", 1)
+	b.AddOutbound("10.1/big", 70000)
+	b.AddInbound("10.1/big", 5)
+	for i := 0; i < 100; i++ {
+		b.AddOutbound(fmt.Sprintf("10.2/%d", i), i)
+	}
+	is.Equal(b.Len(), 103)
+
+	var buf bytes.Buffer
+	n, err := b.Table().WriteTo(&buf)
+	is.NoErr(err)
+	is.Equal(n, int64(buf.Len()))
+	tbl, err := Read(&buf)
+	is.NoErr(err)
+	is.Equal(tbl.Len(), 103)
+	is.True(!tbl.NoCase())
+
+	for _, c := range []struct {
+		doi           string
+		citing, cited int
+	}{
+		{"10.1/a", 2, 3},
+		{"10.1/b", 0, 2},
+		{"10.1/big", 70000, 5},
+		{"10.2/42", 42, 0},
+		{"10.1/A", 0, 0},
+		{"10.9/unknown", 0, 0},
+	} {
+		citing, cited := tbl.Counts(c.doi)
+		is.Equal(citing, c.citing) // citing
+		is.Equal(cited, c.cited)   // cited
+	}
+}
+
+func TestTableNoCase(t *testing.T) {
+	is := is.New(t)
+
+	b := NewBuilder(true)
+	b.AddOutbound("10.1/ABC", 1)
+	b.AddOutbound("10.1/abc", 1)
+	var buf bytes.Buffer
+	_, err := b.Table().WriteTo(&buf)
+	is.NoErr(err)
+	tbl, err := Read(&buf)
+	is.NoErr(err)
+	is.True(tbl.NoCase())
+	citing, _ := tbl.Counts("10.1/Abc")
+	is.Equal(citing, 2)
+}
+
+func TestReadInvalid(t *testing.T) {
+	is := is.New(t)
+
+	_, err := Read(strings.NewReader("not a degree file at all"))
+	is.Equal(err, ErrInvalidFile)
+	_, err = Read(strings.NewReader(""))
+	is.Equal(err, ErrInvalidFile)
+}
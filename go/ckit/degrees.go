@@ -0,0 +1,62 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/slub/labe/go/ckit/degree"
+)
+
+// minEdgeSize is the smallest size in bytes an edge adds to a response, an
+// unmatched entry with a short DOI, like {"doi_str_mv": "10.1/x"}.
+const minEdgeSize = 24
+
+// openDegrees reads a degree table, cf. ocidegree; nil for an empty path.
+func openDegrees(filename string) (*degree.Table, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	return degree.Open(filename)
+}
+
+// degrees returns the current degree table, if any.
+func (s *Server) degrees() *degree.Table {
+	s.dataMu.RLock()
+	defer s.dataMu.RUnlock()
+	return s.Degrees
+}
+
+// CheckDegree rejects huge neighborhoods before any edge is queried, if a
+// degree table or counts database is loaded: the response would exceed
+// MaxResponseSize, even if every edge only added an unmatched entry. Jobs are
+// not limited.
+func (rv *Resolver) CheckDegree(ctx context.Context) error {
+	s := rv.s
+	if s.MaxResponseSize <= 0 || !rv.opts.Blobs || rv.response.omitUnmatched || isJob(ctx) {
+		return nil
+	}
+	// On errors, go on; the edge queries will tell, what is wrong.
+	citing, cited, ok, err := s.storedCounts(ctx, rv.response.DOI)
+	if err != nil || !ok {
+		return nil
+	}
+	size := int64(citing+cited) * minEdgeSize
+	if size <= s.MaxResponseSize {
+		return nil
+	}
+	msg := &TooLargeMessage{
+		Status:          http.StatusRequestEntityTooLarge,
+		CitingCount:     citing,
+		CitedCount:      cited,
+		EstimatedSize:   size,
+		MaxResponseSize: s.MaxResponseSize,
+	}
+	msg.Msg = fmt.Sprintf("estimated response size of at least %d bytes exceeds limit of %d bytes",
+		size, s.MaxResponseSize)
+	if s.Jobs != nil {
+		msg.Msg += fmt.Sprintf(`, use the job API instead: POST %s/jobs {"id": %q}`,
+			s.PathPrefix, rv.response.ID)
+	}
+	return msg
+}
@@ -0,0 +1,61 @@
+package ckit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/slub/labe/go/ckit/degree"
+)
+
+func TestCheckDegree(t *testing.T) {
+	b := degree.NewBuilder(false)
+	b.AddOutbound("10.1/hub", 1000)
+	b.AddInbound("10.1/hub", 1000)
+	b.AddOutbound("10.1/small", 2)
+	s := &Server{Degrees: b.Table(), MaxResponseSize: 10000}
+	check := func(ctx context.Context, doi string, opts ResolveOptions) error {
+		rv := s.NewResolver("ai-1", opts, nil)
+		rv.response.DOI = doi
+		return rv.CheckDegree(ctx)
+	}
+	ctx := context.Background()
+	var tooLarge *TooLargeMessage
+	if err := check(ctx, "10.1/hub", ResolveOptions{Blobs: true}); !errors.As(err, &tooLarge) {
+		t.Fatalf("hub: got %v, want too large", err)
+	}
+	if tooLarge.CitingCount != 1000 || tooLarge.CitedCount != 1000 || tooLarge.EstimatedSize != 2000*minEdgeSize {
+		t.Fatalf("hub: got %+v", tooLarge)
+	}
+	for _, c := range []struct {
+		doi  string
+		opts ResolveOptions
+	}{
+		{"10.1/small", ResolveOptions{Blobs: true}},
+		{"10.1/unknown", ResolveOptions{Blobs: true}},
+		{"10.1/hub", ResolveOptions{}},
+	} {
+		if err := check(ctx, c.doi, c.opts); err != nil {
+			t.Fatalf("%s: got %v, want nil", c.doi, err)
+		}
+	}
+	// Jobs are not limited.
+	if err := check(context.WithValue(ctx, jobKey, true), "10.1/hub", ResolveOptions{Blobs: true}); err != nil {
+		t.Fatalf("job: got %v, want nil", err)
+	}
+}
+
+func TestEdgeCountsDegrees(t *testing.T) {
+	b := degree.NewBuilder(false)
+	b.AddOutbound("10.1/a", 3)
+	b.AddInbound("10.1/a", 5)
+	// No oci database is needed with a degree table.
+	s := &Server{Degrees: b.Table()}
+	citing, cited, err := s.edgeCounts(context.Background(), "10.1/a")
+	if err != nil {
+		t.Fatalf("edgeCounts: %v", err)
+	}
+	if citing != 3 || cited != 5 {
+		t.Fatalf("got %d, %d, want 3, 5", citing, cited)
+	}
+}
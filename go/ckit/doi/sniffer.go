@@ -2,9 +2,11 @@
 package doi
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/miku/parallel"
@@ -29,6 +31,13 @@ type Sniffer struct {
 	PostProcess    func(s string) string
 	BatchSize      int
 	NumWorkers     int
+	// Pairs emits (id, doi) TSV with normalized DOI instead of documents,
+	// e.g. to be imported with makta. Existing values in "UpdateKey" take
+	// precedence over sniffed ones, unless "ForceOverwrite" is set.
+	Pairs bool
+	// AllPairs emits a pair for each distinct DOI of a document, not only
+	// for the first one.
+	AllPairs bool
 }
 
 // NewSniffer sets up a new sniffer with defaults keys matching the current
@@ -76,6 +85,20 @@ func (s *Sniffer) Run() error {
 			return nil, nil
 		}
 		switch {
+		case s.Pairs:
+			id, ok := data[s.IdentifierKey]
+			if !ok {
+				return nil, fmt.Errorf("missing identifier key: %s", s.IdentifierKey)
+			}
+			var dois []string
+			if !s.ForceOverwrite && s.UpdateKey != "" {
+				dois = stringValues(data[s.UpdateKey])
+			}
+			if len(dois) == 0 {
+				sort.Strings(result)
+				dois = result
+			}
+			return pairs(fmt.Sprintf("%v", id), dois, s.AllPairs), nil
 		case s.UpdateKey != "":
 			if len(result) > 0 {
 				v, ok := data[s.UpdateKey]
@@ -117,6 +140,65 @@ func (s *Sniffer) Run() error {
 	return pp.Run()
 }
 
+// NormalizeDOI trims whitespace and resolver or scheme prefixes and
+// lowercases a DOI; returns the empty string, if the result does not look
+// like a DOI.
+func NormalizeDOI(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, prefix := range []string{
+		"https://doi.org/",
+		"http://doi.org/",
+		"https://dx.doi.org/",
+		"http://dx.doi.org/",
+		"doi:",
+	} {
+		s = strings.TrimPrefix(s, prefix)
+	}
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "10.") || !strings.Contains(s, "/") || strings.ContainsAny(s, "\t\n") {
+		return ""
+	}
+	return s
+}
+
+// pairs returns (id, doi) TSV lines for the distinct, normalized DOI, in
+// the given order; only the first, unless all is set.
+func pairs(id string, dois []string, all bool) []byte {
+	var (
+		buf  bytes.Buffer
+		seen = set.New()
+	)
+	for _, v := range dois {
+		v = NormalizeDOI(v)
+		if v == "" || seen.Contains(v) {
+			continue
+		}
+		seen.Add(v)
+		fmt.Fprintf(&buf, "%s\t%s\n", id, v)
+		if !all {
+			break
+		}
+	}
+	return buf.Bytes()
+}
+
+// stringValues returns the strings found in a string or list value.
+func stringValues(v interface{}) (result []string) {
+	switch w := v.(type) {
+	case string:
+		result = append(result, w)
+	case []string:
+		result = append(result, w...)
+	case []interface{}:
+		for _, e := range w {
+			if s, ok := e.(string); ok {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
 // MapSniffer tries to find values in a map.
 type MapSniffer struct {
 	Pattern    *regexp.Regexp
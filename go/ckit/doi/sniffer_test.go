@@ -88,3 +88,40 @@ func TestSniffer(t *testing.T) {
 		}
 	}
 }
+
+func TestNormalizeDOI(t *testing.T) {
+	var cases = []struct {
+		s    string
+		want string
+	}{
+		{"10.5617/OSLA.8504", "10.5617/osla.8504"},
+		{" https://doi.org/10.5617/osla.8504 ", "10.5617/osla.8504"},
+		{"doi:10.5617/osla.8504", "10.5617/osla.8504"},
+		{"http://dx.doi.org/10.1073/pnas.85.8.2444", "10.1073/pnas.85.8.2444"},
+		{"11.5617/osla.8504", ""},
+		{"10.5617", ""},
+	}
+	for _, c := range cases {
+		if got := NormalizeDOI(c.s); got != c.want {
+			t.Fatalf("got %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestPairs(t *testing.T) {
+	var cases = []struct {
+		dois []string
+		all  bool
+		want string
+	}{
+		{nil, false, ""},
+		{[]string{"10.1/A", "10.1/b"}, false, "x\t10.1/a\n"},
+		{[]string{"10.1/A", "10.1/a", "doi:10.1/b"}, true, "x\t10.1/a\nx\t10.1/b\n"},
+		{[]string{"invalid", "10.1/A"}, false, "x\t10.1/a\n"},
+	}
+	for _, c := range cases {
+		if got := string(pairs("x", c.dois, c.all)); got != c.want {
+			t.Fatalf("got %q, want %q", got, c.want)
+		}
+	}
+}
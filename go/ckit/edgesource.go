@@ -0,0 +1,69 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// OciSourceName labels edges from the OCI database.
+const OciSourceName = "oci"
+
+// EdgeSource is an additional citation database with the same schema as the
+// OCI database (citing and cited DOI), e.g. derived from Crossref Event Data
+// with citeconv. Citing or cited works may be identified by other URLs than
+// DOI, e.g. Wikipedia pages.
+type EdgeSource struct {
+	Name string
+	DB   *sqlx.DB
+}
+
+// sourceEdges adds the edges of all edge sources to the OCI edges of a
+// response and returns the sources of each citing and cited DOI. Edges are
+// counted by source in the response.
+func (s *Server) sourceEdges(ctx context.Context, response *Response, citing, cited []Map) ([]Map, []Map, map[string][]string, error) {
+	ctx, cancel := withTimeout(ctx, s.OciTimeout)
+	defer cancel()
+	var (
+		sources = make(map[string][]string)
+		label   = func(doi, name string) {
+			names := sources[doi]
+			if len(names) > 0 && names[len(names)-1] == name {
+				return
+			}
+			sources[doi] = append(names, name)
+		}
+	)
+	response.Extra.EdgeSources = map[string]int{OciSourceName: len(citing) + len(cited)}
+	for _, v := range citing {
+		label(v.Value, OciSourceName)
+	}
+	for _, v := range cited {
+		label(v.Key, OciSourceName)
+	}
+	for _, src := range s.EdgeSources {
+		t := time.Now()
+		var outbound, inbound []Map
+		if err := s.selectContext(ctx, src.DB, &outbound, "SELECT * FROM map WHERE "+s.doiColumn("k")+" = ?", response.DOI); err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %w", src.Name, err)
+		}
+		if err := s.selectContext(ctx, src.DB, &inbound, s.inboundQuery(ctx, src.DB, "= ?"), response.DOI); err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %w", src.Name, err)
+		}
+		s.Stats.MeasureSinceWithLabels("sql_query", t, nil)
+		s.foldMaps(outbound, true, true)
+		s.foldMaps(inbound, true, true)
+		for _, v := range outbound {
+			label(v.Value, src.Name)
+		}
+		for _, v := range inbound {
+			label(v.Key, src.Name)
+		}
+		citing = append(citing, outbound...)
+		cited = append(cited, inbound...)
+		response.Extra.EdgeSources[src.Name] += len(outbound) + len(inbound)
+	}
+	return citing, cited, sources, nil
+}
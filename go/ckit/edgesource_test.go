@@ -0,0 +1,30 @@
+package ckit
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/slub/labe/go/ckit/set"
+)
+
+func TestAnnotateDOI(t *testing.T) {
+	var (
+		r       Response
+		ids     = []Map{{Key: "id-1", Value: "10.1/a"}, {Key: "id-2", Value: "10.1/a"}}
+		matched = set.FromSlice([]string{"10.1/a"})
+		dois    = set.FromSlice([]string{"10.1/a", "10.1/b", "10.1/c"})
+		sources = map[string][]string{"10.1/a": {"oci", "eventdata"}, "10.1/b": {"eventdata"}}
+	)
+	r.annotateDOI(ids, matched, dois, "edge_sources", func(doi string) (interface{}, bool) {
+		names, ok := sources[doi]
+		return names, ok
+	})
+	want := map[string][]byte{
+		"id-1":   []byte(`"edge_sources":["oci","eventdata"]`),
+		"id-2":   []byte(`"edge_sources":["oci","eventdata"]`),
+		"10.1/b": []byte(`"edge_sources":["eventdata"]`),
+	}
+	if !reflect.DeepEqual(r.annotations, want) {
+		t.Fatalf("got %s, want %s", r.annotations, want)
+	}
+}
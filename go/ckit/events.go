@@ -0,0 +1,143 @@
+package ckit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/segmentio/encoding/json"
+)
+
+// progressInterval is the number of fetched blobs between two progress
+// events.
+const progressInterval = 100
+
+// progress reports the progress of a single request as server-sent events,
+// cf. https://html.spec.whatwg.org/multipage/server-sent-events.html. All
+// methods can be called on a nil progress, which does nothing.
+type progress struct {
+	w       io.Writer
+	flusher http.Flusher // may be nil
+	fetched int
+	total   int
+	err     error
+}
+
+// progressFrom returns the progress attached to a context, if any.
+func progressFrom(ctx context.Context) *progress {
+	p, _ := ctx.Value(progressKey).(*progress)
+	return p
+}
+
+// event writes a single event with a JSON encoded payload.
+func (p *progress) event(name string, v interface{}) {
+	if p == nil || p.err != nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		p.err = err
+		return
+	}
+	ew := &errWriter{w: p.w}
+	fmt.Fprintf(ew, "event: %s\n", name)
+	// A data line must not contain a newline, JSON encoded values do not.
+	ew.WriteString("data: ")
+	_, _ = ew.Write(b)
+	ew.WriteString("\n\n")
+	if p.err = ew.err; p.err == nil && p.flusher != nil {
+		p.flusher.Flush()
+	}
+}
+
+// matched records the number of documents to fetch.
+func (p *progress) matched(matched, unmatched int) {
+	if p == nil {
+		return
+	}
+	p.total = matched
+	p.event("matched", map[string]int{"matched": matched, "unmatched": unmatched})
+}
+
+// blobFetched records a fetched blob and emits an event every few blobs.
+func (p *progress) blobFetched() {
+	if p == nil {
+		return
+	}
+	p.fetched++
+	if p.fetched%progressInterval == 0 || p.fetched == p.total {
+		p.event("fetched", map[string]int{"fetched": p.fetched, "total": p.total})
+	}
+}
+
+// handleEvents serves a lookup as a stream of server-sent events: "edges"
+// with the number of citing and cited DOI, "matched" with the number of
+// documents found in the index, "fetched" with the number of documents
+// fetched so far and finally "result" with the JSON response (or "error").
+// Cached responses are sent right away.
+func (s *Server) handleEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			id         = mux.Vars(r)["id"]
+			flusher, _ = w.(http.Flusher)
+			p          = &progress{w: w, flusher: flusher}
+			ctx        = context.WithValue(r.Context(), progressKey, p)
+			q          = r.URL.Query()
+			buf        bytes.Buffer
+		)
+		q.Del("format") // only JSON
+		target := s.PathPrefix + "/id/" + url.PathEscape(id)
+		if len(q) > 0 {
+			target += "?" + q.Encode()
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+		if err != nil {
+			httpErrLog(w, http.StatusInternalServerError, err)
+			return
+		}
+		req.Header = r.Header.Clone()
+		req.Header.Del("Accept-Encoding") // we need the plain JSON
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no") // nginx
+		w.WriteHeader(http.StatusOK)
+		rw := &internalResponseWriter{header: make(http.Header), w: &buf, code: http.StatusOK}
+		if err := s.serveRecover(rw, req); err != nil {
+			log.Printf("events (%s): %v", id, err)
+			p.event("error", map[string]interface{}{"status": http.StatusInternalServerError})
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		body := bytes.TrimSpace(buf.Bytes())
+		if len(body) == 0 || !json.Valid(body) {
+			body = []byte(fmt.Sprintf(`{"status": %d, "msg": %q}`, rw.code, strings.TrimSpace(string(body))))
+		}
+		if rw.code >= 400 {
+			p.event("error", json.RawMessage(body))
+		} else {
+			p.event("result", json.RawMessage(body))
+		}
+	}
+}
+
+// serveRecover serves an internal request, turning a handler abort into an
+// error. The request is marked as internal, so it passes the access list and
+// API keys; the client has been checked with the request, that caused it.
+func (s *Server) serveRecover(w http.ResponseWriter, r *http.Request) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("aborted: %v", v)
+		}
+	}()
+	r = r.WithContext(context.WithValue(r.Context(), internalKey, true))
+	s.ServeHTTP(w, r)
+	return nil
+}
@@ -0,0 +1,50 @@
+package ckit
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/slub/labe/go/ckit/cache"
+)
+
+func TestProgress(t *testing.T) {
+	var p *progress
+	p.event("edges", nil) // nil progress does nothing
+	p.matched(1, 2)
+	p.blobFetched()
+	var buf bytes.Buffer
+	p = &progress{w: &buf}
+	p.matched(2, 1)
+	p.blobFetched()
+	p.blobFetched()
+	want := "event: matched\ndata: {\"matched\":2,\"unmatched\":1}\n\n" +
+		"event: fetched\ndata: {\"fetched\":2,\"total\":2}\n\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	srv := &Server{
+		Router:   mux.NewRouter(),
+		NotFound: cache.NewTTLSet(time.Minute),
+	}
+	srv.Routes()
+	srv.NotFound.Add("id:a")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/id/a/events", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v, want %v", rr.Code, http.StatusOK)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("got %v, want text/event-stream", got)
+	}
+	want := "event: error\ndata: {\"msg\":\"not found\",\"status\":404}\n\n"
+	if got := rr.Body.String(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
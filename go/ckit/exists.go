@@ -0,0 +1,205 @@
+package ckit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/slub/labe/go/ckit/set"
+)
+
+// edgeCounts returns the number of distinct citing (outbound) and cited
+// (inbound) DOI for a DOI in the OCI database or edge store, without fetching
+// them; from the degree table or counts database, if one is loaded.
+func (s *Server) edgeCounts(ctx context.Context, doi string) (citing, cited int, err error) {
+	if citing, cited, ok, err := s.storedCounts(ctx, doi); ok {
+		return citing, cited, err
+	}
+	ctx, cancel := withTimeout(ctx, s.OciTimeout)
+	defer cancel()
+	if s.Edges != nil {
+		return s.Edges.Counts(ctx, doi)
+	}
+	var (
+		db = s.ociDB()
+		t  = time.Now()
+	)
+	if err := s.getContext(ctx, db, &citing, "SELECT COUNT(DISTINCT v) FROM map WHERE "+s.doiColumn("k")+" = ?", doi); err != nil {
+		return 0, 0, err
+	}
+	if err := s.getContext(ctx, db, &cited, "SELECT COUNT(DISTINCT k) FROM ("+s.inboundQuery(ctx, db, "= ?")+")", doi); err != nil {
+		return 0, 0, err
+	}
+	s.Stats.MeasureSinceWithLabels("sql_query", t, nil)
+	return citing, cited, nil
+}
+
+// writeCountHeaders looks up edge counts for a DOI and sets them as headers;
+// it returns the total number of edges or writes an error.
+func (s *Server) writeCountHeaders(w http.ResponseWriter, r *http.Request, doi string) (int, bool) {
+	citing, cited, err := s.edgeCounts(r.Context(), doi)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return 0, false
+	case errors.Is(err, context.DeadlineExceeded):
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return 0, false
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+		return 0, false
+	}
+	w.Header().Set("X-Citing-Count", strconv.Itoa(citing))
+	w.Header().Set("X-Cited-Count", strconv.Itoa(cited))
+	return citing + cited, true
+}
+
+// writeNeighborhoodHeaders sets the number of distinct citing and cited DOI
+// and the number of those without a local id as headers, without fetching
+// any index data; it returns false, if it wrote an error status.
+func (s *Server) writeNeighborhoodHeaders(w http.ResponseWriter, r *http.Request, doi string) bool {
+	var (
+		ctx      = r.Context()
+		outbound = set.New()
+		inbound  = set.New()
+	)
+	citing, cited, err := s.edges(ctx, doi)
+	if err == nil {
+		for _, v := range citing {
+			outbound.Add(v.Value)
+		}
+		for _, v := range cited {
+			inbound.Add(v.Key)
+		}
+	}
+	var (
+		ds  = outbound.Union(inbound)
+		ids []Map
+	)
+	if err == nil && !ds.IsEmpty() {
+		ids, err = s.mapToLocal(ctx, ds.Slice())
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, context.DeadlineExceeded):
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return false
+	case err != nil:
+		log.Printf("head (%s): %v", doi, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	matched := set.New()
+	for _, v := range ids {
+		matched.Add(v.Value)
+	}
+	w.Header().Set("X-Citing-Count", strconv.Itoa(outbound.Len()))
+	w.Header().Set("X-Cited-Count", strconv.Itoa(inbound.Len()))
+	w.Header().Set("X-Unmatched-Count", strconv.Itoa(ds.Difference(matched).Len()))
+	return true
+}
+
+// handleDOIExists answers, whether there are any citations for a DOI, with
+// 200 or 404 and the number of edges in X-Citing-Count and X-Cited-Count
+// headers; there is no body, so link resolvers can check cheaply. The local
+// id, if any, is sent in X-Local-Id.
+func (s *Server) handleDOIExists() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			started = time.Now()
+			doi     = mux.Vars(r)["doi"]
+			id      string
+		)
+		n, ok := s.writeCountHeaders(w, r, doi)
+		if !ok {
+			return
+		}
+		ctx, cancel := withTimeout(r.Context(), s.IdentifierTimeout)
+		defer cancel()
+		err := s.getContext(ctx, s.identifierDB(), &id, "SELECT k FROM map WHERE "+s.doiColumn("v")+" = ?", doi)
+		switch {
+		case err == nil:
+			w.Header().Set("X-Local-Id", id)
+		case err != sql.ErrNoRows:
+			log.Printf("exists (%s): %v", doi, err)
+		}
+		s.Stats.MeasureSinceWithLabels("exists", started, nil)
+		if n == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleLocalIdentifierHead answers HEAD requests for a local id with 200,
+// if the id is known, and the number of citing, cited and unmatched DOI in
+// headers, without assembling a response.
+func (s *Server) handleLocalIdentifierHead() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			id  = mux.Vars(r)["id"]
+			doi string
+		)
+		if err := s.identifierToDOI(r.Context(), id, &doi); err != nil {
+			switch {
+			case err == sql.ErrNoRows:
+				w.WriteHeader(http.StatusNotFound)
+			case errors.Is(err, context.DeadlineExceeded):
+				w.WriteHeader(http.StatusGatewayTimeout)
+			case errors.Is(err, context.Canceled):
+				// Client is gone.
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		if s.writeNeighborhoodHeaders(w, r, doi) {
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+}
+
+// handleDOIHead answers HEAD requests for a DOI like
+// handleLocalIdentifierHead, with the local id in X-Local-Id; a DOI without
+// a local id is not found, as with GET.
+func (s *Server) handleDOIHead() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			doi = mux.Vars(r)["doi"]
+			id  string
+		)
+		if s.notFound("doi:" + doi) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		ctx, cancel := withTimeout(r.Context(), s.IdentifierTimeout)
+		defer cancel()
+		err := s.getContext(ctx, s.identifierDB(), &id, "SELECT k FROM map WHERE "+s.doiColumn("v")+" = ?", doi)
+		if err != nil {
+			switch {
+			case err == sql.ErrNoRows:
+				if s.NotFound != nil {
+					s.NotFound.Add("doi:" + doi)
+				}
+				w.WriteHeader(http.StatusNotFound)
+			case errors.Is(err, context.DeadlineExceeded):
+				w.WriteHeader(http.StatusGatewayTimeout)
+			case errors.Is(err, context.Canceled):
+				// Client is gone.
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		w.Header().Set("X-Local-Id", id)
+		if s.writeNeighborhoodHeaders(w, r, doi) {
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package ckit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/thoas/stats"
+)
+
+func TestExists(t *testing.T) {
+	a, err := OpenDatabase("testdata/id_doi.db")
+	if err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	b, err := OpenDatabase("testdata/doi_doi.db")
+	if err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	srv := &Server{
+		IdentifierDatabase: a,
+		OciDatabase:        b,
+		Router:             mux.NewRouter(),
+		Stats:              stats.New(),
+	}
+	srv.Routes()
+	var cases = []struct {
+		method    string
+		path      string
+		status    int
+		citing    string
+		cited     string
+		unmatched string
+		localID   string
+	}{
+		{"GET", "/doi/d0000/exists", http.StatusOK, "4", "1", "", "i0000"},
+		{"HEAD", "/doi/d0003/exists", http.StatusOK, "2", "3", "", "i0003"},
+		{"GET", "/doi/d0001/exists", http.StatusNotFound, "0", "0", "", "i0001"},
+		{"GET", "/doi/d0150/exists", http.StatusOK, "2", "2", "", ""},
+		{"GET", "/doi/unknown/exists", http.StatusNotFound, "0", "0", "", ""},
+		{"HEAD", "/id/i0005", http.StatusOK, "0", "3", "2", ""},
+		{"HEAD", "/id/i0001", http.StatusOK, "0", "0", "0", ""},
+		{"HEAD", "/id/unknown", http.StatusNotFound, "", "", "", ""},
+		{"HEAD", "/doi/d0003", http.StatusOK, "2", "3", "4", "i0003"},
+		{"HEAD", "/doi/unknown", http.StatusNotFound, "", "", "", ""},
+	}
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(c.method, c.path, nil))
+		if rr.Code != c.status {
+			t.Fatalf("%s %s: got %v, want %v", c.method, c.path, rr.Code, c.status)
+		}
+		if rr.Body.Len() != 0 {
+			t.Fatalf("%s %s: got body %q, want none", c.method, c.path, rr.Body.String())
+		}
+		for k, want := range map[string]string{
+			"X-Citing-Count":    c.citing,
+			"X-Cited-Count":     c.cited,
+			"X-Unmatched-Count": c.unmatched,
+			"X-Local-Id":        c.localID,
+		} {
+			if got := rr.Header().Get(k); got != want {
+				t.Fatalf("%s %s: got %s %q, want %q", c.method, c.path, k, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// QueryPlan is the sqlite query plan of one of the main queries of a
+// request, cf. Explain.
+type QueryPlan struct {
+	Name  string   `json:"name"`
+	Query string   `json:"query"`
+	Plan  []string `json:"plan"`
+	// Indexed is false, if the query scans a whole table, which usually
+	// means an index is missing.
+	Indexed bool `json:"indexed"`
+}
+
+// String formats a plan for the log.
+func (p QueryPlan) String() string {
+	return fmt.Sprintf("%s: %s -- %s", p.Name, p.Query, strings.Join(p.Plan, "; "))
+}
+
+// Explain returns the query plans of the main queries on the databases in
+// use: identifier to DOI, citing and cited DOI, DOI to identifiers and index
+// data blobs, per sqlite backend. A misbuilt database, e.g. without an index
+// on values, turns a lookup into a full table scan, which is easy to spot
+// here and hard to spot under load.
+func (s *Server) Explain(ctx context.Context) ([]QueryPlan, error) {
+	defer s.acquire()()
+	type namedQuery struct {
+		name  string
+		db    *sqlx.DB
+		query string
+	}
+	var (
+		identifier = s.identifierDB()
+		oci        = s.ociDB()
+		queries    = []namedQuery{
+			{"identifier to doi", identifier, "SELECT v FROM map WHERE k = ?"},
+			{"citing", oci, "SELECT * FROM map WHERE " + s.doiColumn("k") + " = ?"},
+			{"cited", oci, s.inboundQuery(ctx, oci, "= ?")},
+			{"doi to identifier", identifier, "SELECT * FROM map WHERE " + s.doiColumn("v") + " IN (?)"},
+		}
+		result []QueryPlan
+	)
+	for i, sf := range sqliteBackends(s.indexData()) {
+		queries = append(queries, namedQuery{fmt.Sprintf("index data #%d", i), sf.DB, "SELECT v FROM map WHERE k = ?"})
+	}
+	for _, q := range queries {
+		p, err := explainQuery(ctx, q.db, q.query)
+		if err != nil {
+			return nil, fmt.Errorf("explain %s: %w", q.name, err)
+		}
+		p.Name = q.name
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// explainQuery returns the plan for a query with a single parameter.
+func explainQuery(ctx context.Context, db *sqlx.DB, query string) (QueryPlan, error) {
+	p := QueryPlan{Query: query, Indexed: true}
+	rows, err := db.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, "")
+	if err != nil {
+		return p, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id, parent, notused int
+			detail              string
+		)
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			return p, err
+		}
+		p.Plan = append(p.Plan, detail)
+		// E.g. "SCAN map" or "SCAN TABLE map" in older versions, as opposed
+		// to "SEARCH map USING INDEX idx_k (k=?)".
+		if strings.HasPrefix(detail, "SCAN") {
+			p.Indexed = false
+		}
+	}
+	return p, rows.Err()
+}
@@ -0,0 +1,51 @@
+package ckit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestExplain(t *testing.T) {
+	open := func(script string) *sqlx.DB {
+		db, err := sqlx.Open(sqliteDriver, filepath.Join(t.TempDir(), "data.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if _, err := db.Exec("CREATE TABLE map (k TEXT, v TEXT);" + script); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	var (
+		indexed = "CREATE INDEX idx_k ON map(k); CREATE INDEX idx_v ON map(v);"
+		srv     = &Server{
+			IdentifierDatabase: open(indexed),
+			// Without an index on values, cited DOI need a table scan.
+			OciDatabase: open("CREATE INDEX idx_k ON map(k);"),
+			IndexData:   &SqliteFetcher{DB: open(indexed)},
+		}
+	)
+	plans, err := srv.Explain(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		"identifier to doi": true,
+		"citing":            true,
+		"cited":             false,
+		"doi to identifier": true,
+		"index data #0":     true,
+	}
+	if len(plans) != len(want) {
+		t.Fatalf("got %d plans, want %d", len(plans), len(want))
+	}
+	for _, p := range plans {
+		if p.Indexed != want[p.Name] {
+			t.Fatalf("%s: got indexed %v, want %v: %v", p.Name, p.Indexed, want[p.Name], p.Plan)
+		}
+	}
+}
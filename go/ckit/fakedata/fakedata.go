@@ -0,0 +1,301 @@
+// Package fakedata generates small, consistent identifier, citation and index
+// data fixtures, so tests and demos do not depend on the real data, which is
+// hundreds of GB. All data is derived from a seed, the same configuration
+// yields the same data.
+//
+// A number of DOI is generated, of which a fraction (the match rate) belongs
+// to local documents, which have an id and index data; all other DOI are only
+// known from citations, as most DOI in OCI. Each DOI cites a random number of
+// other DOI, either uniformly distributed or skewed, so that a few DOI are
+// cited a lot, as in real citation data.
+package fakedata
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/tabutils"
+)
+
+const (
+	// Uniform distribution of cited DOI.
+	Uniform = "uniform"
+	// Zipf distribution of cited DOI, a few DOI are cited a lot.
+	Zipf = "zipf"
+)
+
+// Database file names, as in testdata.
+const (
+	IdentifierFile = "id_doi.db"
+	CitationFile   = "doi_doi.db"
+	IndexFile      = "id_metadata.db"
+)
+
+// Config for generated data.
+type Config struct {
+	// Documents is the number of local documents.
+	Documents int
+	// MatchRate is the fraction of all DOI, which belong to a local
+	// document, between 0 (exclusive) and 1.
+	MatchRate float64
+	// Citations is the mean number of outgoing citations per DOI.
+	Citations int
+	// Distribution of cited DOI, Uniform or Zipf.
+	Distribution string
+	// Skew of the Zipf distribution, must be greater than 1; larger values
+	// concentrate more citations on fewer DOI.
+	Skew float64
+	// Institutions, one is assigned to each document in turn.
+	Institutions []string
+	Seed         int64
+}
+
+// DefaultConfig returns a configuration for data about the size of testdata.
+func DefaultConfig() Config {
+	return Config{
+		Documents:    100,
+		MatchRate:    0.5,
+		Citations:    4,
+		Distribution: Zipf,
+		Skew:         1.5,
+		Institutions: []string{"DE-1", "DE-2", "DE-3"},
+		Seed:         0,
+	}
+}
+
+// Validate checks a configuration.
+func (c Config) Validate() error {
+	switch {
+	case c.Documents < 1:
+		return fmt.Errorf("documents must be positive, got %d", c.Documents)
+	case c.MatchRate <= 0 || c.MatchRate > 1:
+		return fmt.Errorf("match rate must be in (0, 1], got %v", c.MatchRate)
+	case c.Citations < 0:
+		return fmt.Errorf("citations must not be negative, got %d", c.Citations)
+	case c.Distribution != Uniform && c.Distribution != Zipf:
+		return fmt.Errorf("invalid distribution: %s", c.Distribution)
+	case c.Distribution == Zipf && c.Skew <= 1:
+		return fmt.Errorf("skew must be greater than 1, got %v", c.Skew)
+	}
+	return nil
+}
+
+// Row is a key value pair, a row of a makta database.
+type Row struct {
+	Key   string
+	Value string
+}
+
+// Data is a generated set of fixtures.
+type Data struct {
+	// Identifiers maps local ids to DOI.
+	Identifiers []Row
+	// Citations maps citing to cited DOI.
+	Citations []Row
+	// Documents maps local ids to index data.
+	Documents []Row
+	// DOI are all DOI, local or not.
+	DOI []string
+}
+
+// document is the index data of a generated document, with a few fields a
+// VuFind Solr document would have.
+type document struct {
+	ID              string   `json:"id"`
+	DOI             []string `json:"doi_str_mv"`
+	Title           string   `json:"title"`
+	Format          []string `json:"format"`
+	PublishDateSort string   `json:"publishDateSort"`
+	Institution     []string `json:"institution,omitempty"`
+}
+
+// DOI returns the n-th generated DOI; 10.5555 is a test prefix.
+func DOI(n int) string {
+	return fmt.Sprintf("10.5555/fake.%06d", n)
+}
+
+// ID returns the n-th generated local id.
+func ID(n int) string {
+	return fmt.Sprintf("fake-%06d", n)
+}
+
+// Generate generates data.
+func Generate(c Config) (*Data, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	var (
+		r       = rand.New(rand.NewSource(c.Seed))
+		numDOI  = int(math.Ceil(float64(c.Documents) / c.MatchRate))
+		data    = &Data{DOI: make([]string, numDOI)}
+		cited   func() int
+		yearMin = 1950
+	)
+	for i := range data.DOI {
+		data.DOI[i] = DOI(i)
+	}
+	// Local documents get random DOI, so local and non-local DOI are mixed
+	// in citations.
+	for i, j := range r.Perm(numDOI)[:c.Documents] {
+		var (
+			id  = ID(i)
+			doi = data.DOI[j]
+			doc = document{
+				ID:              id,
+				DOI:             []string{doi},
+				Title:           "Synthetic document " + strconv.Itoa(i),
+				Format:          []string{"Article"},
+				PublishDateSort: strconv.Itoa(yearMin + r.Intn(73)),
+			}
+		)
+		if len(c.Institutions) > 0 {
+			doc.Institution = []string{c.Institutions[i%len(c.Institutions)]}
+		}
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		data.Identifiers = append(data.Identifiers, Row{Key: id, Value: doi})
+		data.Documents = append(data.Documents, Row{Key: id, Value: string(b)})
+	}
+	switch c.Distribution {
+	case Zipf:
+		// The most cited DOI are random as well, not the first ones.
+		var (
+			z    = rand.NewZipf(r, c.Skew, 1, uint64(numDOI-1))
+			rank = r.Perm(numDOI)
+		)
+		cited = func() int { return rank[z.Uint64()] }
+	default:
+		cited = func() int { return r.Intn(numDOI) }
+	}
+	if numDOI > 1 {
+		for i := 0; i < numDOI; i++ {
+			// Outgoing citations are uniformly distributed around the mean;
+			// a DOI cannot cite itself, and cites another DOI only once.
+			var (
+				n    = r.Intn(2*c.Citations + 1)
+				seen = make(map[int]bool)
+			)
+			if n > numDOI-1 {
+				n = numDOI - 1
+			}
+			for attempts := 0; len(seen) < n && attempts < 10*n; attempts++ {
+				j := cited()
+				if j == i || seen[j] {
+					continue
+				}
+				seen[j] = true
+				data.Citations = append(data.Citations, Row{Key: data.DOI[i], Value: data.DOI[j]})
+			}
+		}
+	}
+	return data, nil
+}
+
+// WriteTSV writes tab separated key value pairs, as accepted by makta.
+func WriteTSV(filename string, rows []Row) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if _, err := fmt.Fprintf(f, "%s\t%s\n", row.Key, row.Value); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// WriteDatabase writes rows into a new sqlite3 database, cf. Load.
+func WriteDatabase(ctx context.Context, filename string, rows []Row) error {
+	if _, err := os.Stat(filename); err == nil {
+		return fmt.Errorf("file exists: %s", filename)
+	}
+	db, err := sqlx.Open("sqlite3", filename)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return Load(ctx, db, rows)
+}
+
+// Load writes rows into a database, as makta would, with indices on keys and
+// values and a meta table; it works with in-memory databases as well.
+func Load(ctx context.Context, db *sqlx.DB, rows []Row) error {
+	if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS map (k TEXT, v TEXT)"); err != nil {
+		return err
+	}
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO map (k, v) VALUES (?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row.Key, row.Value); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := stmt.Close(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	scripts := tabutils.IndexScripts("", 3, 0)
+	scripts = append(scripts, tabutils.MetaScript(map[string]string{"generator": "fakedata"}))
+	for _, s := range scripts {
+		if _, err := db.ExecContext(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteDatabases writes identifier, citation and index databases into a
+// directory, cf. IdentifierFile, CitationFile and IndexFile.
+func (d *Data) WriteDatabases(ctx context.Context, dir string) error {
+	for _, v := range []struct {
+		name string
+		rows []Row
+	}{
+		{IdentifierFile, d.Identifiers},
+		{CitationFile, d.Citations},
+		{IndexFile, d.Documents},
+	} {
+		if err := WriteDatabase(ctx, filepath.Join(dir, v.name), v.rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns the number of DOI, of citations and of citations between
+// local documents, e.g. to check a match rate.
+func (d *Data) Stats() (dois, citations, matched int) {
+	local := make(map[string]bool, len(d.Identifiers))
+	for _, row := range d.Identifiers {
+		local[row.Value] = true
+	}
+	for _, row := range d.Citations {
+		if local[row.Key] && local[row.Value] {
+			matched++
+		}
+	}
+	return len(d.DOI), len(d.Citations), matched
+}
@@ -0,0 +1,170 @@
+package fakedata
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/segmentio/encoding/json"
+)
+
+func TestConfigValidate(t *testing.T) {
+	var cases = []struct {
+		update func(c *Config)
+		err    bool
+	}{
+		{func(c *Config) {}, false},
+		{func(c *Config) { c.Documents = 0 }, true},
+		{func(c *Config) { c.MatchRate = 0 }, true},
+		{func(c *Config) { c.MatchRate = 1.5 }, true},
+		{func(c *Config) { c.MatchRate = 1 }, false},
+		{func(c *Config) { c.Citations = -1 }, true},
+		{func(c *Config) { c.Distribution = "normal" }, true},
+		{func(c *Config) { c.Skew = 1 }, true},
+		{func(c *Config) { c.Distribution, c.Skew = Uniform, 0 }, false},
+	}
+	for i, c := range cases {
+		config := DefaultConfig()
+		c.update(&config)
+		if err := config.Validate(); (err != nil) != c.err {
+			t.Fatalf("[%d] got %v, want error %v", i, err, c.err)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	for _, dist := range []string{Uniform, Zipf} {
+		config := DefaultConfig()
+		config.Distribution = dist
+		data, err := Generate(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Identifiers) != 100 || len(data.Documents) != 100 || len(data.DOI) != 200 {
+			t.Fatalf("[%s] got %d ids, %d docs, %d doi", dist,
+				len(data.Identifiers), len(data.Documents), len(data.DOI))
+		}
+		// Every local id has a distinct DOI and matching index data.
+		seen := make(map[string]bool)
+		for i, row := range data.Identifiers {
+			if seen[row.Value] {
+				t.Fatalf("[%s] duplicate DOI %s", dist, row.Value)
+			}
+			seen[row.Value] = true
+			var doc document
+			if err := json.Unmarshal([]byte(data.Documents[i].Value), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if doc.ID != row.Key || !reflect.DeepEqual(doc.DOI, []string{row.Value}) {
+				t.Fatalf("[%s] got doc %v for %v", dist, doc, row)
+			}
+		}
+		edges := make(map[Row]bool)
+		for _, row := range data.Citations {
+			if row.Key == row.Value || edges[row] {
+				t.Fatalf("[%s] self or duplicate citation %v", dist, row)
+			}
+			edges[row] = true
+		}
+		dois, citations, matched := data.Stats()
+		if dois != 200 || citations == 0 || matched == 0 || matched >= citations {
+			t.Fatalf("[%s] got stats %d, %d, %d", dist, dois, citations, matched)
+		}
+		// Mean out-degree is 4, so about 800 citations.
+		if citations < 600 || citations > 1000 {
+			t.Fatalf("[%s] got %d citations, want about 800", dist, citations)
+		}
+	}
+}
+
+func TestGenerateDeterministic(t *testing.T) {
+	config := DefaultConfig()
+	a, err := Generate(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Generate(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("same config yields different data")
+	}
+	config.Seed = 1
+	c, err := Generate(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(a.Citations, c.Citations) {
+		t.Fatalf("different seeds yield same citations")
+	}
+}
+
+func TestGenerateZipfSkew(t *testing.T) {
+	config := DefaultConfig()
+	config.Documents = 1000
+	data, err := Generate(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	max := 0
+	for _, row := range data.Citations {
+		counts[row.Value]++
+		if counts[row.Value] > max {
+			max = counts[row.Value]
+		}
+	}
+	// With a uniform distribution, a DOI would be cited about 4 times.
+	if max < 100 {
+		t.Fatalf("got at most %d citations per DOI, want a skewed distribution", max)
+	}
+}
+
+func TestGenerateSingleDOI(t *testing.T) {
+	config := DefaultConfig()
+	config.Documents, config.MatchRate = 1, 1
+	data, err := Generate(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Citations) != 0 {
+		t.Fatalf("got %v", data.Citations)
+	}
+}
+
+func TestWriteDatabases(t *testing.T) {
+	data, err := Generate(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := data.WriteDatabases(context.Background(), dir); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sqlx.Open("sqlite3", filepath.Join(dir, CitationFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.Get(&n, "SELECT count(*) FROM map"); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data.Citations) {
+		t.Fatalf("got %d rows, want %d", n, len(data.Citations))
+	}
+	if err := db.Get(&n, "SELECT v FROM meta WHERE k = 'rows'"); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data.Citations) {
+		t.Fatalf("got %d rows in meta, want %d", n, len(data.Citations))
+	}
+	err = data.WriteDatabases(context.Background(), dir)
+	if err == nil || !strings.Contains(err.Error(), "file exists") {
+		t.Fatalf("got %v, want file exists error", err)
+	}
+}
@@ -1,28 +1,67 @@
 package ckit
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	"github.com/slub/labe/go/ckit/tabutils"
 )
 
 var (
 	// ErrBlobNotFound can be used for unfetchable blobs.
 	ErrBlobNotFound   = errors.New("blob not found")
 	ErrBackendsFailed = errors.New("all backends failed")
-	client            = http.Client{
-		// We use the client to fetch data from backends. Often, we request one
-		// item after another and there will be a 5 second timeout per request,
-		// not for the whole operation.
-		Timeout: 5 * time.Second,
-	}
+	// ErrNotSupported is returned, if a fetcher lacks a capability.
+	ErrNotSupported = errors.New("not supported by fetcher")
 )
 
+// fetchBatchSize is the number of ids per query of a batch fetch from
+// sqlite, which allows at most 999 variables.
+const fetchBatchSize = 500
+
+// DefaultMaxIdleConnsPerHost is the number of keep-alive connections we hold
+// per backend host. The standard library default of 2 starves under the per
+// request fan-out, where a single request may fetch thousands of blobs.
+const DefaultMaxIdleConnsPerHost = 64
+
+// NewHTTPClient returns a client tuned for many small requests against a few
+// backend hosts, with connection pooling and HTTP/2, if available. Often, we
+// request one item after another and the timeout applies per request, not for
+// the whole operation.
+func NewHTTPClient(maxIdleConnsPerHost int, timeout time.Duration) *http.Client {
+	if maxIdleConnsPerHost <= 0 {
+		maxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   5 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          4 * maxIdleConnsPerHost,
+			MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+}
+
 // Pinger allows to perform a simple health check.
 type Pinger interface {
 	Ping() error
@@ -31,21 +70,147 @@ type Pinger interface {
 // Fetcher fetches one or more blobs given their identifiers.
 type Fetcher interface {
 	Fetch(id string) ([]byte, error)
+	// Capabilities reports optional features; wrappers report the
+	// features of the fetchers they wrap.
+	Capabilities() Capabilities
+}
+
+// Capabilities of a fetcher, besides fetching a single blob. Each flag
+// tells, whether the respective interface is implemented and usable; cf.
+// PingFetcher, FetchBatch and ApproximateCount.
+type Capabilities struct {
+	// Batch fetches many blobs at once, cf. BatchFetcher.
+	Batch bool
+	// Ping checks availability, cf. Pinger.
+	Ping bool
+	// Count reports the approximate number of blobs, cf. Counter.
+	Count bool
+}
+
+// BatchFetcher fetches many blobs with few round trips; missing blobs are
+// left out of the result.
+type BatchFetcher interface {
+	FetchBatch(ctx context.Context, ids []string) (map[string][]byte, error)
+}
+
+// Counter reports the approximate number of blobs available.
+type Counter interface {
+	ApproximateCount(ctx context.Context) (int64, error)
+}
+
+// PingFetcher pings a fetcher, if it supports it; nil otherwise.
+func PingFetcher(f Fetcher) error {
+	if p, ok := f.(Pinger); ok && f.Capabilities().Ping {
+		return p.Ping()
+	}
+	return nil
+}
+
+// FetchBatch fetches blobs for a list of ids, in a batch, if the fetcher
+// supports it, otherwise one by one. Missing blobs are left out.
+func FetchBatch(ctx context.Context, f Fetcher, ids []string) (map[string][]byte, error) {
+	if bf, ok := f.(BatchFetcher); ok && f.Capabilities().Batch {
+		return bf.FetchBatch(ctx, ids)
+	}
+	result := make(map[string][]byte, len(ids))
+	for _, id := range ids {
+		b, err := FetchContext(ctx, f, id)
+		switch {
+		case err == nil:
+			result[id] = b
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case !errors.Is(err, ErrBlobNotFound):
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// ApproximateCount returns the approximate number of blobs of a fetcher;
+// ErrNotSupported, if it cannot tell.
+func ApproximateCount(ctx context.Context, f Fetcher) (int64, error) {
+	if c, ok := f.(Counter); ok && f.Capabilities().Count {
+		return c.ApproximateCount(ctx)
+	}
+	return 0, ErrNotSupported
+}
+
+// ContextFetcher is a fetcher that respects cancellation and deadlines.
+type ContextFetcher interface {
+	FetchContext(ctx context.Context, id string) ([]byte, error)
+}
+
+// FetchContext fetches a blob with a context, if the fetcher supports it;
+// otherwise the context is only checked before the fetch.
+func FetchContext(ctx context.Context, f Fetcher, id string) ([]byte, error) {
+	if cf, ok := f.(ContextFetcher); ok {
+		return cf.FetchContext(ctx, id)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.Fetch(id)
 }
 
 // SqliteFetcher serves index documents from sqlite database with a fixed schema,
 // as generated by the makta tool.
 type SqliteFetcher struct {
 	DB *sqlx.DB
+	// BusyRetries is the number of retries of fetches from a busy or locked
+	// database; zero means DefaultBusyRetries, negative values disable
+	// retries, as in Server.BusyRetries.
+	BusyRetries int
 }
 
 // Fetch document.
 func (b *SqliteFetcher) Fetch(id string) (p []byte, err error) {
-	var s string // TODO: could we just get into a []byte?
-	if err := b.DB.Get(&s, "SELECT v FROM map WHERE k = ?", id); err != nil {
+	return b.FetchContext(context.Background(), id)
+}
+
+// FetchContext fetches a document, respecting context cancellation.
+func (b *SqliteFetcher) FetchContext(ctx context.Context, id string) (p []byte, err error) {
+	// Scanning into a byte slice saves a copy, compared to a string.
+	err = retryBusy(ctx, busyRetryCount(b.BusyRetries), func() error {
+		return b.DB.GetContext(ctx, &p, "SELECT v FROM map WHERE k = ?", id)
+	})
+	if err != nil {
 		return nil, err
 	}
-	return []byte(s), nil
+	return p, nil
+}
+
+// FetchBatch fetches documents in batches of fetchBatchSize ids.
+func (b *SqliteFetcher) FetchBatch(ctx context.Context, ids []string) (map[string][]byte, error) {
+	result := make(map[string][]byte, len(ids))
+	for _, batch := range batchedStrings(ids, fetchBatchSize) {
+		query, args, err := sqlx.In("SELECT k, v FROM map WHERE k IN (?)", batch)
+		if err != nil {
+			return nil, err
+		}
+		var rows []struct {
+			Key   string `db:"k"`
+			Value []byte `db:"v"`
+		}
+		err = retryBusy(ctx, busyRetryCount(b.BusyRetries), func() error {
+			rows = nil
+			return b.DB.SelectContext(ctx, &rows, b.DB.Rebind(query), args...)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			result[row.Key] = row.Value
+		}
+	}
+	return result, nil
+}
+
+// ApproximateCount returns the largest rowid, which is the number of
+// documents, unless rows have been deleted; this avoids a full table scan.
+func (b *SqliteFetcher) ApproximateCount(ctx context.Context) (n int64, err error) {
+	err = b.DB.GetContext(ctx, &n, "SELECT coalesce(max(rowid), 0) FROM map")
+	return n, err
 }
 
 // Ping pings the database.
@@ -53,27 +218,228 @@ func (b *SqliteFetcher) Ping() error {
 	return b.DB.Ping()
 }
 
+// Capabilities of a sqlite database.
+func (b *SqliteFetcher) Capabilities() Capabilities {
+	return Capabilities{Batch: true, Ping: true, Count: true}
+}
+
+// HTTPFetcher fetches index documents from an HTTP service, e.g. microblob.
+// The URL is a template containing a single %s verb, which will be replaced
+// by the (path escaped) identifier, e.g. http://localhost:8820/%s.
+type HTTPFetcher struct {
+	URL string
+	// Client to use, each fetcher should get its own, as returned by
+	// NewHTTPClient. If nil, a client with default settings is used.
+	Client *http.Client
+	once   sync.Once
+}
+
+// client returns the configured or a default client; the default is set up
+// once, as fetches run concurrently.
+func (f *HTTPFetcher) client() *http.Client {
+	f.once.Do(func() {
+		if f.Client == nil {
+			f.Client = NewHTTPClient(DefaultMaxIdleConnsPerHost, 5*time.Second)
+		}
+	})
+	return f.Client
+}
+
+// Fetch document.
+func (f *HTTPFetcher) Fetch(id string) ([]byte, error) {
+	return f.FetchContext(context.Background(), id)
+}
+
+// FetchContext fetches a document, respecting context cancellation.
+func (f *HTTPFetcher) FetchContext(ctx context.Context, id string) ([]byte, error) {
+	link := fmt.Sprintf(f.URL, url.PathEscape(id))
+	req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+	if err != nil {
+		return nil, err
+	}
+	setTraceHeaders(req)
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		// Drain body, so the connection can be reused.
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil, ErrBlobNotFound
+	case resp.StatusCode >= 400:
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil, fmt.Errorf("fetch %s: %s", link, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Ping checks, whether the service host is reachable at all.
+func (f *HTTPFetcher) Ping() error {
+	u, err := url.Parse(fmt.Sprintf(f.URL, ""))
+	if err != nil {
+		return err
+	}
+	resp, err := f.client().Get(fmt.Sprintf("%s://%s/", u.Scheme, u.Host))
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// Capabilities of an HTTP service; we only know how to fetch single blobs.
+func (f *HTTPFetcher) Capabilities() Capabilities {
+	return Capabilities{Ping: true}
+}
+
+// SourceFetcher fetches a blob and reports, which backend served it.
+type SourceFetcher interface {
+	FetchSource(ctx context.Context, id string) ([]byte, string, error)
+}
+
+// fetchSource fetches a blob and names the backend it came from, if the
+// fetcher can tell; the source is empty otherwise.
+func fetchSource(ctx context.Context, f Fetcher, id string) ([]byte, string, error) {
+	if sf, ok := f.(SourceFetcher); ok {
+		return sf.FetchSource(ctx, id)
+	}
+	b, err := FetchContext(ctx, f, id)
+	return b, "", err
+}
+
+// LimitFetcher caps the number of concurrent fetches on a wrapped fetcher,
+// across all requests. This protects backing stores from thundering herds,
+// e.g. when bulk clients request many expensive documents at once.
+type LimitFetcher struct {
+	Fetcher Fetcher
+	mu      sync.RWMutex
+	sem     chan struct{} // replaced by SetLimit, guarded by mu
+}
+
+// NewLimitFetcher wraps a fetcher, allowing at most n concurrent fetches.
+func NewLimitFetcher(f Fetcher, n int) *LimitFetcher {
+	if n < 1 {
+		n = 1
+	}
+	return &LimitFetcher{Fetcher: f, sem: make(chan struct{}, n)}
+}
+
+// Limit returns the maximum number of concurrent fetches.
+func (f *LimitFetcher) Limit() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return cap(f.sem)
+}
+
+// SetLimit changes the maximum number of concurrent fetches at runtime.
+// Fetches running under the previous limit are not counted against the new
+// one, so the limit applies fully, once these are done.
+func (f *LimitFetcher) SetLimit(n int) {
+	if n < 1 {
+		n = 1
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sem = make(chan struct{}, n)
+}
+
+// acquire waits for a free slot; release frees it again. Waiting is
+// aborted, if the context is done.
+func (f *LimitFetcher) acquire(ctx context.Context) (release func(), err error) {
+	f.mu.RLock()
+	sem := f.sem
+	f.mu.RUnlock()
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Fetch document.
+func (f *LimitFetcher) Fetch(id string) ([]byte, error) {
+	return f.FetchContext(context.Background(), id)
+}
+
+// FetchContext waits for a free slot, then fetches the document. Waiting is
+// aborted, if the context is done.
+func (f *LimitFetcher) FetchContext(ctx context.Context, id string) ([]byte, error) {
+	release, err := f.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return FetchContext(ctx, f.Fetcher, id)
+}
+
+// FetchSource is like FetchContext, but also reports the source, if the
+// wrapped fetcher supports it.
+func (f *LimitFetcher) FetchSource(ctx context.Context, id string) ([]byte, string, error) {
+	release, err := f.acquire(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	defer release()
+	return fetchSource(ctx, f.Fetcher, id)
+}
+
+// FetchBatch fetches a batch from the wrapped fetcher, taking up a single
+// slot.
+func (f *LimitFetcher) FetchBatch(ctx context.Context, ids []string) (map[string][]byte, error) {
+	release, err := f.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return FetchBatch(ctx, f.Fetcher, ids)
+}
+
+// ApproximateCount returns the count of the wrapped fetcher.
+func (f *LimitFetcher) ApproximateCount(ctx context.Context) (int64, error) {
+	return ApproximateCount(ctx, f.Fetcher)
+}
+
+// Ping pings the wrapped fetcher, if it supports it.
+func (f *LimitFetcher) Ping() error {
+	return PingFetcher(f.Fetcher)
+}
+
+// Capabilities are those of the wrapped fetcher.
+func (f *LimitFetcher) Capabilities() Capabilities {
+	return f.Fetcher.Capabilities()
+}
+
 // FetchGroup allows to run a index data fetch operation in a cascade over a
 // couple of backends. The result from the first database that contains a value
 // for a given id is returned. Currently sequential, but could be made
 // parallel, maybe.
 type FetchGroup struct {
 	Backends []Fetcher
+	// Names of the backends, e.g. database filenames, reported as sources
+	// of blobs, cf. FetchSource; defaults to the position of the backend.
+	Names []string
+	// BusyRetries is passed on to sqlite fetchers set up by FromFiles, cf.
+	// SqliteFetcher.
+	BusyRetries int
 }
 
-// FromFiles sets up a fetch group from a list of sqlite3 database filenames.
+// FromFiles sets up a fetch group from a list of sqlite3 database filenames;
+// a shard manifest, cf. makta -shards, counts as a single backend.
 func (g *FetchGroup) FromFiles(files ...string) error {
 	for _, f := range files {
 		// TODO: In theory, we can allow empty files as well.
 		if _, err := os.Stat(f); os.IsNotExist(err) {
 			return fmt.Errorf("file not found: %s", f)
 		}
-		db, err := sqlx.Open("sqlite3", tabutils.WithReadOnly(f))
+		fetcher, err := openShardsOrDatabase(f, g.BusyRetries)
 		if err != nil {
-			return fmt.Errorf("database: %w", err)
+			return err
 		}
-		fetcher := &SqliteFetcher{DB: db}
 		g.Backends = append(g.Backends, fetcher)
+		g.Names = append(g.Names, filepath.Base(f))
 	}
 	return nil
 }
@@ -81,26 +447,95 @@ func (g *FetchGroup) FromFiles(files ...string) error {
 // Ping is a healthcheck.
 func (g *FetchGroup) Ping() error {
 	for _, v := range g.Backends {
-		w, ok := v.(Pinger)
-		if !ok {
-			continue
-		}
-		if err := w.Ping(); err != nil {
+		if err := PingFetcher(v); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// Capabilities of a group: it can be pinged, if any backend can, and
+// counted, if all backends can. Batches are only supported with a single
+// backend, since with a cascade we report the source of each blob, cf.
+// FetchSource.
+func (g *FetchGroup) Capabilities() Capabilities {
+	var c = Capabilities{Count: len(g.Backends) > 0}
+	for _, v := range g.Backends {
+		bc := v.Capabilities()
+		c.Ping = c.Ping || bc.Ping
+		c.Count = c.Count && bc.Count
+	}
+	c.Batch = len(g.Backends) == 1 && g.Backends[0].Capabilities().Batch
+	return c
+}
+
+// FetchBatch fetches a batch from a single backend.
+func (g *FetchGroup) FetchBatch(ctx context.Context, ids []string) (map[string][]byte, error) {
+	if len(g.Backends) != 1 {
+		return nil, ErrNotSupported
+	}
+	return FetchBatch(ctx, g.Backends[0], ids)
+}
+
+// ApproximateCount sums the counts of all backends; documents contained in
+// more than one backend are counted more than once.
+func (g *FetchGroup) ApproximateCount(ctx context.Context) (int64, error) {
+	var total int64
+	for _, v := range g.Backends {
+		n, err := ApproximateCount(ctx, v)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
 // Fetch constructs a URL from a template and retrieves the blob.
 func (g *FetchGroup) Fetch(id string) ([]byte, error) {
-	for _, v := range g.Backends {
-		if p, err := v.Fetch(id); err != nil {
+	return g.FetchContext(context.Background(), id)
+}
+
+// FetchContext queries backends in order and returns the first blob found. A
+// cancelled or expired context stops the cascade.
+func (g *FetchGroup) FetchContext(ctx context.Context, id string) ([]byte, error) {
+	p, _, err := g.fetch(ctx, id)
+	return p, err
+}
+
+// FetchSource is like FetchContext, but also returns the name of the backend
+// that served the blob; the source is empty, if there is only one backend.
+func (g *FetchGroup) FetchSource(ctx context.Context, id string) ([]byte, string, error) {
+	p, i, err := g.fetch(ctx, id)
+	if err != nil || len(g.Backends) < 2 {
+		return p, "", err
+	}
+	if i < len(g.Names) && g.Names[i] != "" {
+		return p, g.Names[i], nil
+	}
+	return p, strconv.Itoa(i), nil
+}
+
+// fetch returns the first blob found and the index of the backend. If all
+// backends miss the blob, the error is ErrBlobNotFound; if any backend failed
+// otherwise, it is ErrBackendsFailed, wrapping the last failure.
+func (g *FetchGroup) fetch(ctx context.Context, id string) ([]byte, int, error) {
+	var failed error
+	for i, v := range g.Backends {
+		p, err := FetchContext(ctx, v, id)
+		switch {
+		case err == nil:
+			return p, i, nil
+		case ctx.Err() != nil:
+			return nil, -1, ctx.Err()
+		case errors.Is(err, ErrBlobNotFound) || errors.Is(err, sql.ErrNoRows):
 			// OK to miss.
-			continue
-		} else {
-			return p, nil
+		default:
+			failed = err
 		}
 	}
-	return nil, ErrBackendsFailed
+	if failed != nil {
+		return nil, -1, fmt.Errorf("%w: %v", ErrBackendsFailed, failed)
+	}
+	return nil, -1, ErrBlobNotFound
 }
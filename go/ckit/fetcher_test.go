@@ -0,0 +1,276 @@
+package ckit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPFetcher(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintln(w, "ok")
+		case "/1":
+			fmt.Fprint(w, `{"id": "1"}`)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	f := &HTTPFetcher{URL: ts.URL + "/%s", Client: NewHTTPClient(4, 0)}
+	if err := f.Ping(); err != nil {
+		t.Fatalf("ping: got %v, want nil", err)
+	}
+	b, err := f.Fetch("1")
+	if err != nil {
+		t.Fatalf("fetch: got %v, want nil", err)
+	}
+	if string(b) != `{"id": "1"}` {
+		t.Fatalf("fetch: got %s", string(b))
+	}
+	if _, err := f.Fetch("2"); err != ErrBlobNotFound {
+		t.Fatalf("fetch: got %v, want %v", err, ErrBlobNotFound)
+	}
+	if _, err := f.Fetch("broken"); err == nil {
+		t.Fatalf("fetch: got nil, want error")
+	}
+}
+
+func TestHTTPFetcherDefaultClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "{}")
+	}))
+	defer ts.Close()
+	// Without a client, concurrent fetches share a single default client.
+	var (
+		f  = &HTTPFetcher{URL: ts.URL + "/%s"}
+		wg sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := f.Fetch("1"); err != nil {
+				t.Errorf("fetch: got %v, want nil", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if f.Client == nil {
+		t.Fatalf("expected default client")
+	}
+}
+
+// slowFetcher records the maximum number of concurrent fetches.
+type slowFetcher struct {
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (f *slowFetcher) Fetch(id string) ([]byte, error) {
+	f.mu.Lock()
+	f.current++
+	if f.current > f.max {
+		f.max = f.current
+	}
+	f.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	f.mu.Lock()
+	f.current--
+	f.mu.Unlock()
+	return []byte(id), nil
+}
+
+func (f *slowFetcher) Capabilities() Capabilities { return Capabilities{} }
+
+func TestLimitFetcher(t *testing.T) {
+	var (
+		sf = &slowFetcher{}
+		lf = NewLimitFetcher(sf, 3)
+		wg sync.WaitGroup
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := lf.Fetch("1"); err != nil {
+				t.Errorf("fetch: got %v, want nil", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if sf.max > 3 {
+		t.Fatalf("got %d concurrent fetches, want at most 3", sf.max)
+	}
+	// A waiting fetch gives up, when the context is done.
+	lf = NewLimitFetcher(sf, 1)
+	lf.sem <- struct{}{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := lf.FetchContext(ctx, "1"); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestFetchGroupSource(t *testing.T) {
+	g := &FetchGroup{
+		Backends: []Fetcher{mapFetcher{"1": "a"}, mapFetcher{"1": "b", "2": "c"}, mapFetcher{"3": "d"}},
+		Names:    []string{"main.db", "ai.db"},
+	}
+	var cases = []struct {
+		id     string
+		blob   string
+		source string
+	}{
+		{"1", "a", "main.db"},
+		{"2", "c", "ai.db"},
+		{"3", "d", "2"},
+	}
+	for _, c := range cases {
+		b, source, err := fetchSource(context.Background(), NewLimitFetcher(g, 1), c.id)
+		if err != nil {
+			t.Fatalf("fetch %s: got %v, want nil", c.id, err)
+		}
+		if string(b) != c.blob || source != c.source {
+			t.Fatalf("fetch %s: got %s from %q, want %s from %q", c.id, b, source, c.blob, c.source)
+		}
+	}
+	if _, _, err := g.FetchSource(context.Background(), "4"); err != ErrBlobNotFound {
+		t.Fatalf("got %v, want %v", err, ErrBlobNotFound)
+	}
+	// A single backend is not worth mentioning.
+	g = &FetchGroup{Backends: []Fetcher{mapFetcher{"1": "a"}}, Names: []string{"main.db"}}
+	if _, source, _ := g.FetchSource(context.Background(), "1"); source != "" {
+		t.Fatalf("got %q, want empty source", source)
+	}
+}
+
+func TestFetchGroupMiss(t *testing.T) {
+	var cases = []struct {
+		about    string
+		backends []Fetcher
+		err      error
+	}{
+		{
+			about:    "all backends miss",
+			backends: []Fetcher{mapFetcher{"1": "a"}, mapFetcher{"2": "b"}},
+			err:      ErrBlobNotFound,
+		},
+		{
+			about:    "one backend fails",
+			backends: []Fetcher{&stubFetcher{err: errors.New("boom")}, mapFetcher{"2": "b"}},
+			err:      ErrBackendsFailed,
+		},
+	}
+	for _, c := range cases {
+		g := &FetchGroup{Backends: c.backends}
+		if _, err := g.FetchContext(context.Background(), "3"); !errors.Is(err, c.err) {
+			t.Fatalf("%s: got %v, want %v", c.about, err, c.err)
+		}
+		// Blobs found in any backend are served regardless.
+		if b, err := g.FetchContext(context.Background(), "2"); err != nil || string(b) != "b" {
+			t.Fatalf("%s: got %s, %v, want b", c.about, b, err)
+		}
+	}
+}
+
+// batchFetcher serves blobs from a map in batches and counts the batches.
+type batchFetcher struct {
+	mapFetcher
+	mu      sync.Mutex
+	batches int
+}
+
+func (f *batchFetcher) FetchBatch(ctx context.Context, ids []string) (map[string][]byte, error) {
+	f.mu.Lock()
+	f.batches++
+	f.mu.Unlock()
+	result := make(map[string][]byte)
+	for _, id := range ids {
+		if v, ok := f.mapFetcher[id]; ok {
+			result[id] = []byte(v)
+		}
+	}
+	return result, nil
+}
+
+func (f *batchFetcher) ApproximateCount(ctx context.Context) (int64, error) {
+	return int64(len(f.mapFetcher)), nil
+}
+
+func (f *batchFetcher) Capabilities() Capabilities {
+	return Capabilities{Batch: true, Count: true}
+}
+
+func TestFetcherCapabilities(t *testing.T) {
+	var (
+		bf    = &batchFetcher{mapFetcher: mapFetcher{"1": "a", "2": "b"}}
+		hf    = &HTTPFetcher{URL: "http://localhost:1/%s"}
+		cases = []struct {
+			f    Fetcher
+			want Capabilities
+		}{
+			{mapFetcher{}, Capabilities{}},
+			{&SqliteFetcher{}, Capabilities{Batch: true, Ping: true, Count: true}},
+			{hf, Capabilities{Ping: true}},
+			{NewLimitFetcher(bf, 1), Capabilities{Batch: true, Count: true}},
+			{NewLimitFetcher(mapFetcher{}, 1), Capabilities{}},
+			{&FetchGroup{}, Capabilities{}},
+			{&FetchGroup{Backends: []Fetcher{bf}}, Capabilities{Batch: true, Count: true}},
+			{&FetchGroup{Backends: []Fetcher{bf, bf}}, Capabilities{Count: true}},
+			{&FetchGroup{Backends: []Fetcher{bf, hf}}, Capabilities{Ping: true}},
+		}
+	)
+	for i, c := range cases {
+		if got := c.f.Capabilities(); got != c.want {
+			t.Fatalf("[%d] got %+v, want %+v", i, got, c.want)
+		}
+	}
+	// A limit fetcher around a fetcher, that cannot be pinged, is fine.
+	if err := PingFetcher(NewLimitFetcher(mapFetcher{}, 1)); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if err := PingFetcher(&FetchGroup{Backends: []Fetcher{hf}}); err == nil {
+		t.Fatalf("got nil, want error")
+	}
+	n, err := ApproximateCount(context.Background(), &FetchGroup{Backends: []Fetcher{bf, bf}})
+	if err != nil || n != 4 {
+		t.Fatalf("got %v, %v, want 4", n, err)
+	}
+	if _, err := ApproximateCount(context.Background(), mapFetcher{}); err != ErrNotSupported {
+		t.Fatalf("got %v, want %v", err, ErrNotSupported)
+	}
+}
+
+func TestFetchBatch(t *testing.T) {
+	var (
+		m  = mapFetcher{"1": "a", "2": "b"}
+		bf = &batchFetcher{mapFetcher: m}
+	)
+	for _, f := range []Fetcher{m, NewLimitFetcher(bf, 1), &FetchGroup{Backends: []Fetcher{bf}}} {
+		result, err := FetchBatch(context.Background(), f, []string{"1", "2", "3"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result) != 2 || string(result["1"]) != "a" || string(result["2"]) != "b" {
+			t.Fatalf("got %v", result)
+		}
+	}
+	if bf.batches != 2 {
+		t.Fatalf("got %d batches, want 2", bf.batches)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := FetchBatch(ctx, m, []string{"1"}); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
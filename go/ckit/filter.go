@@ -0,0 +1,462 @@
+package ckit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// MaxFilterLength limits the length of a filter expression.
+const MaxFilterLength = 1024
+
+// Filter is a small, jq-like expression evaluated against a document, e.g.
+//
+//	.subjects[] == "Physics"
+//	.year >= 2000 and not (.format == "Book")
+//	.authors[0].name != null or .title
+//
+// Paths start with a dot, followed by field names, "[]" to iterate over an
+// array or "[n]" for an element. Literals are strings (in double quotes),
+// numbers, true, false and null. Comparisons (==, !=, <, <=, >, >=) hold, if
+// they hold for any of the values a path yields; a path alone holds, if any
+// value is neither null nor false. Comparisons can be combined with and, or,
+// not and parentheses.
+type Filter struct {
+	src  string
+	root filterExpr
+}
+
+// ParseFilter parses a filter expression.
+func ParseFilter(s string) (*Filter, error) {
+	if len(s) > MaxFilterLength {
+		return nil, fmt.Errorf("filter too long: %d > %d", len(s), MaxFilterLength)
+	}
+	tokens, err := lexFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("filter: unexpected %q", p.tokens[p.pos].text)
+	}
+	return &Filter{src: s, root: root}, nil
+}
+
+// String returns the expression.
+func (f *Filter) String() string {
+	return f.src
+}
+
+// Match evaluates the filter against a JSON document.
+func (f *Filter) Match(b []byte) (bool, error) {
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return false, err
+	}
+	return truthy(f.root.eval(doc)), nil
+}
+
+// filterExpr yields zero or more values for a document.
+type filterExpr interface {
+	eval(doc interface{}) []interface{}
+}
+
+// truthy returns true, if any value is neither null nor false.
+func truthy(vs []interface{}) bool {
+	for _, v := range vs {
+		if v != nil && v != false {
+			return true
+		}
+	}
+	return false
+}
+
+// pathStep is a field name, an array index or an iteration.
+type pathStep struct {
+	key   string
+	index int
+	kind  byte // 'k' for key, 'i' for index, '*' for iteration
+}
+
+type pathExpr []pathStep
+
+func (p pathExpr) eval(doc interface{}) []interface{} {
+	values := []interface{}{doc}
+	for _, step := range p {
+		var next []interface{}
+		for _, v := range values {
+			switch step.kind {
+			case 'k':
+				if m, ok := v.(map[string]interface{}); ok {
+					next = append(next, m[step.key])
+				} else {
+					next = append(next, nil)
+				}
+			case 'i':
+				if a, ok := v.([]interface{}); ok && step.index < len(a) {
+					next = append(next, a[step.index])
+				} else {
+					next = append(next, nil)
+				}
+			case '*':
+				switch w := v.(type) {
+				case []interface{}:
+					next = append(next, w...)
+				case map[string]interface{}:
+					for _, u := range w {
+						next = append(next, u)
+					}
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+type literalExpr struct{ v interface{} }
+
+func (e literalExpr) eval(doc interface{}) []interface{} { return []interface{}{e.v} }
+
+type compareExpr struct {
+	op   string
+	l, r filterExpr
+}
+
+func (e compareExpr) eval(doc interface{}) []interface{} {
+	for _, l := range e.l.eval(doc) {
+		for _, r := range e.r.eval(doc) {
+			if compareValues(e.op, l, r) {
+				return []interface{}{true}
+			}
+		}
+	}
+	return []interface{}{false}
+}
+
+// compareValues compares two decoded JSON values; ordering is defined for
+// numbers and strings only.
+func compareValues(op string, l, r interface{}) bool {
+	switch op {
+	case "==":
+		return equalValues(l, r)
+	case "!=":
+		return !equalValues(l, r)
+	}
+	var c int
+	switch a := l.(type) {
+	case float64:
+		b, ok := r.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case a < b:
+			c = -1
+		case a > b:
+			c = 1
+		}
+	case string:
+		b, ok := r.(string)
+		if !ok {
+			return false
+		}
+		c = strings.Compare(a, b)
+	default:
+		return false
+	}
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// equalValues compares scalars; arrays and objects are never equal.
+func equalValues(l, r interface{}) bool {
+	switch l.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	switch r.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return l == r
+}
+
+type andExpr struct{ l, r filterExpr }
+
+func (e andExpr) eval(doc interface{}) []interface{} {
+	return []interface{}{truthy(e.l.eval(doc)) && truthy(e.r.eval(doc))}
+}
+
+type orExpr struct{ l, r filterExpr }
+
+func (e orExpr) eval(doc interface{}) []interface{} {
+	return []interface{}{truthy(e.l.eval(doc)) || truthy(e.r.eval(doc))}
+}
+
+type notExpr struct{ e filterExpr }
+
+func (e notExpr) eval(doc interface{}) []interface{} {
+	return []interface{}{!truthy(e.e.eval(doc))}
+}
+
+// filterToken is a lexical token; kind is one of ident, string, number, op
+// or punct.
+type filterToken struct {
+	kind string
+	text string
+}
+
+// lexFilter splits an expression into tokens.
+func lexFilter(s string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("filter: unterminated string")
+			}
+			tokens = append(tokens, filterToken{"string", s[i : j+1]})
+			i = j + 1
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for ; j < len(s) && strings.IndexByte("0123456789.eE+-", s[j]) != -1; j++ {
+			}
+			tokens = append(tokens, filterToken{"number", s[i:j]})
+			i = j
+		case strings.IndexByte("=!<>", c) != -1:
+			if i+1 < len(s) && s[i+1] == '=' {
+				tokens = append(tokens, filterToken{"op", s[i : i+2]})
+				i += 2
+			} else if c == '<' || c == '>' {
+				tokens = append(tokens, filterToken{"op", s[i : i+1]})
+				i++
+			} else {
+				return nil, fmt.Errorf("filter: unexpected %q", c)
+			}
+		case strings.IndexByte(".[]()", c) != -1:
+			tokens = append(tokens, filterToken{"punct", s[i : i+1]})
+			i++
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for ; j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))); j++ {
+			}
+			tokens = append(tokens, filterToken{"ident", s[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("filter: unexpected %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+// filterParser is a recursive descent parser over tokens.
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// peek returns the current token, or an empty token at the end.
+func (p *filterParser) peek() filterToken {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return filterToken{}
+}
+
+// accept consumes the current token, if its text matches.
+func (p *filterParser) accept(text string) bool {
+	if t := p.peek(); t.kind != "string" && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = orExpr{l, r}
+	}
+	return l, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and") {
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = andExpr{l, r}
+	}
+	return l, nil
+}
+
+func (p *filterParser) parseNot() (filterExpr, error) {
+	if p.accept("not") {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{e}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *filterParser) parseCompare() (filterExpr, error) {
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == "op" {
+		p.pos++
+		r, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return compareExpr{op: t.text, l: l, r: r}, nil
+	}
+	return l, nil
+}
+
+func (p *filterParser) parseOperand() (filterExpr, error) {
+	t := p.peek()
+	switch {
+	case t.kind == "":
+		return nil, fmt.Errorf("filter: unexpected end")
+	case t.text == "(" && t.kind == "punct":
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("filter: missing )")
+		}
+		return e, nil
+	case t.text == "." && t.kind == "punct":
+		return p.parsePath()
+	case t.kind == "string":
+		p.pos++
+		var s string
+		if err := json.Unmarshal([]byte(t.text), &s); err != nil {
+			return nil, fmt.Errorf("filter: invalid string %s", t.text)
+		}
+		return literalExpr{s}, nil
+	case t.kind == "number":
+		p.pos++
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("filter: invalid number %s", t.text)
+		}
+		return literalExpr{f}, nil
+	case t.kind == "ident":
+		p.pos++
+		switch t.text {
+		case "true":
+			return literalExpr{true}, nil
+		case "false":
+			return literalExpr{false}, nil
+		case "null":
+			return literalExpr{nil}, nil
+		}
+	}
+	return nil, fmt.Errorf("filter: unexpected %q", t.text)
+}
+
+// parsePath parses a path like .a.b[].c[0]; a single dot is the document.
+func (p *filterParser) parsePath() (filterExpr, error) {
+	var path pathExpr
+	for {
+		switch {
+		case p.accept("."):
+			if t := p.peek(); t.kind == "ident" {
+				p.pos++
+				path = append(path, pathStep{key: t.text, kind: 'k'})
+			}
+		case p.accept("["):
+			if p.accept("]") {
+				path = append(path, pathStep{kind: '*'})
+				continue
+			}
+			t := p.peek()
+			n, err := strconv.Atoi(t.text)
+			if t.kind != "number" || err != nil || n < 0 {
+				return nil, fmt.Errorf("filter: invalid index %q", t.text)
+			}
+			p.pos++
+			if !p.accept("]") {
+				return nil, fmt.Errorf("filter: missing ]")
+			}
+			path = append(path, pathStep{index: n, kind: 'i'})
+		default:
+			return path, nil
+		}
+	}
+}
+
+// applyFilter removes citing and cited documents, that do not match the
+// filter; unmatched documents are kept, since they carry no index data.
+func (r *Response) applyFilter(f *Filter) error {
+	var err error
+	if r.Citing, err = filterBlobs(r.Citing, f); err != nil {
+		return err
+	}
+	if r.Cited, err = filterBlobs(r.Cited, f); err != nil {
+		return err
+	}
+	r.updateCounts()
+	r.Extra.Filter = f.String()
+	return nil
+}
+
+// filterBlobs returns the blobs matching a filter, reusing the slice.
+func filterBlobs(blobs []json.RawMessage, f *Filter) ([]json.RawMessage, error) {
+	result := blobs[:0]
+	for _, b := range blobs {
+		ok, err := f.Match(b)
+		if err != nil {
+			return nil, fmt.Errorf("filter: internal data broken: %w", err)
+		}
+		if ok {
+			result = append(result, b)
+		}
+	}
+	return result, nil
+}
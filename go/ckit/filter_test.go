@@ -0,0 +1,84 @@
+package ckit
+
+import (
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+)
+
+func TestFilter(t *testing.T) {
+	doc := []byte(`{
+		"id": "1",
+		"year": 2001,
+		"format": "Book",
+		"subjects": ["Physics", "Math"],
+		"authors": [{"name": "A"}, {"name": null}],
+		"draft": false
+	}`)
+	var cases = []struct {
+		expr  string
+		match bool
+	}{
+		{`.`, true},
+		{`.id`, true},
+		{`.missing`, false},
+		{`.draft`, false},
+		{`.id == "1"`, true},
+		{`.id == 1`, false},
+		{`.year == 2001`, true},
+		{`.year >= 2000`, true},
+		{`.year < 2000`, false},
+		{`.format != "Book"`, false},
+		{`.subjects[] == "Physics"`, true},
+		{`.subjects[] == "Biology"`, false},
+		{`.subjects[1] == "Math"`, true},
+		{`.subjects[5]`, false},
+		{`.authors[].name == "A"`, true},
+		{`.authors[1].name == null`, true},
+		{`.year >= 2000 and not (.format == "Book")`, false},
+		{`.year >= 2000 and not .format == "Article"`, true},
+		{`.format == "Article" or .subjects[] == "Math"`, true},
+		{`"a\"b" == "a\"b"`, true},
+		{`.id > "0" and .id <= "1"`, true},
+		{`-1 < 0`, true},
+	}
+	for _, c := range cases {
+		f, err := ParseFilter(c.expr)
+		if err != nil {
+			t.Fatalf("%s: got %v, want nil", c.expr, err)
+		}
+		match, err := f.Match(doc)
+		if err != nil {
+			t.Fatalf("%s: got %v, want nil", c.expr, err)
+		}
+		if match != c.match {
+			t.Fatalf("%s: got %v, want %v", c.expr, match, c.match)
+		}
+	}
+	for _, expr := range []string{``, `.a ==`, `.a = 1`, `(.a`, `.a[x]`, `.a[1`, `"abc`, `.a and`, `foo`, `1 == 1 == 1`} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Fatalf("%s: want error", expr)
+		}
+	}
+}
+
+func TestApplyFilter(t *testing.T) {
+	f, err := ParseFilter(`.year > 2000`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Response{
+		Citing: []json.RawMessage{[]byte(`{"year": 1999}`), []byte(`{"year": 2001}`)},
+		Cited:  []json.RawMessage{[]byte(`{"year": 2020}`)},
+	}
+	if err := r.applyFilter(f); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if r.Extra.CitingCount != 1 || r.Extra.CitedCount != 1 || r.Extra.Filter != `.year > 2000` {
+		t.Fatalf("unexpected response: %+v", r.Extra)
+	}
+	r.Citing = append(r.Citing, []byte(`{"year"`))
+	if err := r.applyFilter(f); err == nil {
+		t.Fatalf("want error on broken blob")
+	}
+}
@@ -0,0 +1,78 @@
+package ckit
+
+import (
+	"context"
+	"sync"
+)
+
+// flight is an in-flight computation of a response for a key.
+type flight struct {
+	done    chan struct{}
+	waiters int
+	// val is the result, zstd compressed JSON, as found in the cache; nil, if
+	// the leader failed or had nothing to share.
+	val []byte
+}
+
+// flightGroup collapses concurrent requests for the same key, similar to
+// golang.org/x/sync/singleflight. Unlike singleflight, the first request (the
+// leader) still streams its own response and only shares a compressed copy
+// of the result with the requests that waited for it. The zero value is
+// ready to use.
+type flightGroup struct {
+	mu sync.Mutex
+	m  map[string]*flight
+}
+
+// join returns the flight for a key. If there is no computation in flight,
+// the caller becomes the leader (second return value is true) and must call
+// finish eventually; otherwise the caller should wait for the result.
+func (g *flightGroup) join(key string) (*flight, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.m == nil {
+		g.m = make(map[string]*flight)
+	}
+	if f, ok := g.m[key]; ok {
+		f.waiters++
+		return f, false
+	}
+	f := &flight{done: make(chan struct{})}
+	g.m[key] = f
+	return f, true
+}
+
+// wait waits for the result of a flight joined as a follower; the result may
+// be nil. If the context is done first, the caller stops waiting and no
+// longer counts as a waiter, so the leader does not prepare a result for a
+// request, that is gone.
+func (g *flightGroup) wait(ctx context.Context, f *flight) ([]byte, error) {
+	select {
+	case <-f.done:
+		return f.val, nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		f.waiters--
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// hasWaiters returns true, if any request is waiting for the flight.
+func (g *flightGroup) hasWaiters(f *flight) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return f.waiters > 0
+}
+
+// finish publishes the result of a flight, which may be nil, and wakes up
+// all waiting requests.
+func (g *flightGroup) finish(key string, f *flight, val []byte) {
+	g.mu.Lock()
+	if g.m[key] == f {
+		delete(g.m, key)
+	}
+	f.val = val
+	g.mu.Unlock()
+	close(f.done)
+}
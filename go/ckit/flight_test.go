@@ -0,0 +1,60 @@
+package ckit
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestFlightGroup(t *testing.T) {
+	var g flightGroup
+	f, leader := g.join("a")
+	if !leader {
+		t.Fatalf("got false, want leader")
+	}
+	if g.hasWaiters(f) {
+		t.Fatalf("got waiters, want none")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		ff, leader := g.join("a")
+		if leader {
+			t.Fatalf("got leader, want follower")
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := g.wait(context.Background(), ff)
+			if err != nil || string(val) != "v" {
+				t.Errorf("got %s, %v, want v", val, err)
+			}
+		}()
+	}
+	if !g.hasWaiters(f) {
+		t.Fatalf("got no waiters, want some")
+	}
+	g.finish("a", f, []byte("v"))
+	wg.Wait()
+	if _, leader := g.join("a"); !leader {
+		t.Fatalf("got follower, want new leader after finish")
+	}
+}
+
+func TestFlightGroupCancel(t *testing.T) {
+	var g flightGroup
+	f, _ := g.join("a")
+	ff, leader := g.join("a")
+	if leader {
+		t.Fatalf("got leader, want follower")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.wait(ctx, ff); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	// A follower, that gave up, does not count as a waiter any more.
+	if g.hasWaiters(f) {
+		t.Fatalf("got waiters, want none after cancel")
+	}
+	g.finish("a", f, nil)
+}
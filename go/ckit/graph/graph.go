@@ -0,0 +1,248 @@
+// Package graph writes citation graphs in formats suitable for graph analytics
+// tools, either as a plain edge list or as GraphML.
+package graph
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrPartitionOrder is returned, if edges of a partition are not contiguous.
+var ErrPartitionOrder = errors.New("edges not ordered by partition")
+
+// Writer writes edges of a citation graph. Close must be called to finish
+// the output.
+type Writer interface {
+	WriteEdge(citing, cited string) error
+	Close() error
+}
+
+// Prefix returns the prefix of a DOI, e.g. 10.1007 for 10.1007/123; an empty
+// string, if the DOI has no prefix.
+func Prefix(doi string) string {
+	if i := strings.Index(doi, "/"); i > 0 {
+		return doi[:i]
+	}
+	return ""
+}
+
+// EdgeList writes one tab separated edge per line, citing DOI first.
+type EdgeList struct {
+	w *bufio.Writer
+}
+
+// NewEdgeList returns a new edge list writer.
+func NewEdgeList(w io.Writer) *EdgeList {
+	return &EdgeList{w: bufio.NewWriter(w)}
+}
+
+// WriteEdge writes a single edge.
+func (e *EdgeList) WriteEdge(citing, cited string) error {
+	e.w.WriteString(citing)
+	e.w.WriteByte('\t')
+	e.w.WriteString(cited)
+	return e.w.WriteByte('\n')
+}
+
+// Close flushes the output.
+func (e *EdgeList) Close() error {
+	return e.w.Flush()
+}
+
+// GraphML writes a directed graph in GraphML format, cf.
+// http://graphml.graphdrawing.org/. DOI are used as node identifiers, each
+// node is declared before its first edge. All DOI are kept in memory.
+type GraphML struct {
+	w      *bufio.Writer
+	seen   map[string]struct{}
+	nedges int
+	err    error
+}
+
+// NewGraphML returns a new GraphML writer.
+func NewGraphML(w io.Writer) *GraphML {
+	g := &GraphML{w: bufio.NewWriter(w), seen: make(map[string]struct{})}
+	g.w.WriteString(xml.Header)
+	g.w.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	g.w.WriteString(`<graph id="G" edgedefault="directed">` + "\n")
+	return g
+}
+
+// escape writes a string with XML special characters escaped.
+func (g *GraphML) escape(s string) {
+	if g.err == nil {
+		g.err = xml.EscapeText(g.w, []byte(s))
+	}
+}
+
+// node declares a node, if it has not been seen before.
+func (g *GraphML) node(doi string) {
+	if _, ok := g.seen[doi]; ok {
+		return
+	}
+	g.seen[doi] = struct{}{}
+	g.w.WriteString(`<node id="`)
+	g.escape(doi)
+	g.w.WriteString(`"/>` + "\n")
+}
+
+// WriteEdge writes a single edge, declaring nodes as needed.
+func (g *GraphML) WriteEdge(citing, cited string) error {
+	g.node(citing)
+	g.node(cited)
+	fmt.Fprintf(g.w, `<edge id="e%d" source="`, g.nedges)
+	g.escape(citing)
+	g.w.WriteString(`" target="`)
+	g.escape(cited)
+	g.w.WriteString(`"/>` + "\n")
+	g.nedges++
+	return g.err
+}
+
+// Close finishes the document and flushes the output.
+func (g *GraphML) Close() error {
+	g.w.WriteString("</graph>\n</graphml>\n")
+	if g.err != nil {
+		return g.err
+	}
+	return g.w.Flush()
+}
+
+// Format names a supported output format.
+type Format string
+
+const (
+	FormatEdgeList Format = "tsv"
+	FormatGraphML  Format = "graphml"
+)
+
+// NewWriter returns a writer for a given format.
+func NewWriter(w io.Writer, format Format) (Writer, error) {
+	switch format {
+	case FormatEdgeList:
+		return NewEdgeList(w), nil
+	case FormatGraphML:
+		return NewGraphML(w), nil
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+// Partitioned writes edges into one file per partition in a directory; the
+// partition of an edge is determined by the citing DOI. Edges must be
+// ordered by partition, e.g. sorted by citing DOI, which allows to keep a
+// single file open at a time.
+type Partitioned struct {
+	Dir    string
+	Format Format
+	// Compress output with zstd.
+	Compress bool
+	// Partition returns the partition for a citing DOI, e.g. Prefix; if nil,
+	// all edges are written into a single file.
+	Partition func(doi string) string
+
+	current string
+	f       *os.File
+	zw      *zstd.Encoder
+	w       Writer
+	done    map[string]bool
+}
+
+// filename returns the output filename for a partition.
+func (p *Partitioned) filename(partition string) string {
+	if partition == "" {
+		partition = "unknown"
+	}
+	// DOI prefixes are safe in filenames, but we do not want to depend on
+	// the Partition function.
+	partition = strings.NewReplacer("/", "_", string(os.PathSeparator), "_").Replace(partition)
+	name := partition + "." + string(p.Format)
+	if p.Compress {
+		name += ".zst"
+	}
+	return filepath.Join(p.Dir, name)
+}
+
+// open starts a new partition file.
+func (p *Partitioned) open(partition string) error {
+	if p.done == nil {
+		p.done = make(map[string]bool)
+	}
+	if p.done[partition] {
+		return fmt.Errorf("%w: %s", ErrPartitionOrder, partition)
+	}
+	f, err := os.Create(p.filename(partition))
+	if err != nil {
+		return err
+	}
+	var w io.Writer = f
+	if p.Compress {
+		if p.zw, err = zstd.NewWriter(f); err != nil {
+			f.Close()
+			return err
+		}
+		w = p.zw
+	}
+	if p.w, err = NewWriter(w, p.Format); err != nil {
+		f.Close()
+		return err
+	}
+	p.f, p.current, p.done[partition] = f, partition, true
+	return nil
+}
+
+// closeCurrent finishes the current partition file, if any.
+func (p *Partitioned) closeCurrent() error {
+	if p.f == nil {
+		return nil
+	}
+	defer func() { p.f, p.zw, p.w = nil, nil, nil }()
+	if err := p.w.Close(); err != nil {
+		p.f.Close()
+		return err
+	}
+	if p.zw != nil {
+		if err := p.zw.Close(); err != nil {
+			p.f.Close()
+			return err
+		}
+	}
+	return p.f.Close()
+}
+
+// WriteEdge writes an edge into the file for its partition.
+func (p *Partitioned) WriteEdge(citing, cited string) error {
+	var partition string
+	if p.Partition != nil {
+		partition = p.Partition(citing)
+	} else {
+		partition = "all"
+	}
+	if p.f == nil || partition != p.current {
+		if err := p.closeCurrent(); err != nil {
+			return err
+		}
+		if err := p.open(partition); err != nil {
+			return err
+		}
+	}
+	return p.w.WriteEdge(citing, cited)
+}
+
+// Close finishes the last partition file.
+func (p *Partitioned) Close() error {
+	return p.closeCurrent()
+}
+
+// Partitions returns the number of partitions written so far.
+func (p *Partitioned) Partitions() int {
+	return len(p.done)
+}
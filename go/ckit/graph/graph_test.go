@@ -0,0 +1,156 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var testEdges = [][2]string{
+	{"10.1/a", "10.2/b"},
+	{"10.1/a", "10.1/c"},
+	{"10.1/c", "10.2/<b>"},
+	{"10.2/b", "10.1/a"},
+	{"invalid", "10.1/a"},
+}
+
+func TestPrefix(t *testing.T) {
+	var cases = []struct {
+		doi    string
+		result string
+	}{
+		{"", ""},
+		{"10.1007/123", "10.1007"},
+		{"10.1007/123/456", "10.1007"},
+		{"/123", ""},
+		{"invalid", ""},
+	}
+	for _, c := range cases {
+		if got := Prefix(c.doi); got != c.result {
+			t.Fatalf("Prefix(%q): got %q, want %q", c.doi, got, c.result)
+		}
+	}
+}
+
+func TestEdgeList(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewEdgeList(&buf)
+	for _, e := range testEdges[:2] {
+		if err := w.WriteEdge(e[0], e[1]); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	want := "10.1/a\t10.2/b\n10.1/a\t10.1/c\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestGraphML(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewGraphML(&buf)
+	for _, e := range testEdges {
+		if err := w.WriteEdge(e[0], e[1]); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	var doc struct {
+		Graph struct {
+			Nodes []struct {
+				ID string `xml:"id,attr"`
+			} `xml:"node"`
+			Edges []struct {
+				Source string `xml:"source,attr"`
+				Target string `xml:"target,attr"`
+			} `xml:"edge"`
+		} `xml:"graph"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid xml: %v", err)
+	}
+	if len(doc.Graph.Nodes) != 5 {
+		t.Fatalf("got %d nodes, want 5", len(doc.Graph.Nodes))
+	}
+	if len(doc.Graph.Edges) != len(testEdges) {
+		t.Fatalf("got %d edges, want %d", len(doc.Graph.Edges), len(testEdges))
+	}
+	if e := doc.Graph.Edges[2]; e.Target != "10.2/<b>" {
+		t.Fatalf("got %q, want escaped target", e.Target)
+	}
+}
+
+func TestPartitioned(t *testing.T) {
+	dir := t.TempDir()
+	p := &Partitioned{Dir: dir, Format: FormatEdgeList, Compress: true, Partition: Prefix}
+	for _, e := range testEdges {
+		if err := p.WriteEdge(e[0], e[1]); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if p.Partitions() != 3 {
+		t.Fatalf("got %d partitions, want 3", p.Partitions())
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range matches {
+		matches[i] = filepath.Base(matches[i])
+	}
+	sort.Strings(matches)
+	want := []string{"10.1.tsv.zst", "10.2.tsv.zst", "unknown.tsv.zst"}
+	if len(matches) != len(want) {
+		t.Fatalf("got %v, want %v", matches, want)
+	}
+	for i := range want {
+		if matches[i] != want[i] {
+			t.Fatalf("got %v, want %v", matches, want)
+		}
+	}
+	f, err := os.Open(filepath.Join(dir, "10.1.tsv.zst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := "10.1/a\t10.2/b\n10.1/a\t10.1/c\n10.1/c\t10.2/<b>\n"; string(b) != s {
+		t.Fatalf("got %q, want %q", b, s)
+	}
+}
+
+func TestPartitionedOrder(t *testing.T) {
+	p := &Partitioned{Dir: t.TempDir(), Format: FormatEdgeList, Partition: Prefix}
+	defer p.Close()
+	for _, e := range [][2]string{{"10.1/a", "x"}, {"10.2/a", "x"}} {
+		if err := p.WriteEdge(e[0], e[1]); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := p.WriteEdge("10.1/b", "x"); !errors.Is(err, ErrPartitionOrder) {
+		t.Fatalf("got %v, want %v", err, ErrPartitionOrder)
+	}
+}
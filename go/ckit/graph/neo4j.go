@@ -0,0 +1,82 @@
+package graph
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+// Neo4j bulk import CSV headers, cf. https://neo4j.com/docs/operations-manual/current/tools/neo4j-admin/neo4j-admin-import/.
+// DOI are the node identifiers in the "Work" id space; ids holds the local
+// identifiers, separated by the default array delimiter.
+var (
+	Neo4jNodeHeader         = []string{"doi:ID(Work)", "ids:string[]", "title", ":LABEL"}
+	Neo4jRelationshipHeader = []string{":START_ID(Work)", ":END_ID(Work)", ":TYPE"}
+)
+
+const (
+	// Neo4jLabel is the label for all nodes.
+	Neo4jLabel = "Work"
+	// Neo4jRelationship is the type of all relationships.
+	Neo4jRelationship = "CITES"
+)
+
+// Neo4jNodes writes nodes in neo4j-admin import CSV format.
+type Neo4jNodes struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewNeo4jNodes returns a new node writer.
+func NewNeo4jNodes(w io.Writer) *Neo4jNodes {
+	return &Neo4jNodes{w: csv.NewWriter(w)}
+}
+
+// WriteNode writes a single DOI with its local identifiers and title, which
+// may be empty.
+func (n *Neo4jNodes) WriteNode(doi string, ids []string, title string) error {
+	if !n.header {
+		if err := n.w.Write(Neo4jNodeHeader); err != nil {
+			return err
+		}
+		n.header = true
+	}
+	// Multiline fields need an extra import flag, we do not need them.
+	title = strings.Join(strings.Fields(title), " ")
+	return n.w.Write([]string{doi, strings.Join(ids, ";"), title, Neo4jLabel})
+}
+
+// Close flushes the output.
+func (n *Neo4jNodes) Close() error {
+	n.w.Flush()
+	return n.w.Error()
+}
+
+// Neo4jRelationships writes CITES relationships in neo4j-admin import CSV
+// format.
+type Neo4jRelationships struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewNeo4jRelationships returns a new relationship writer.
+func NewNeo4jRelationships(w io.Writer) *Neo4jRelationships {
+	return &Neo4jRelationships{w: csv.NewWriter(w)}
+}
+
+// WriteEdge writes a single relationship.
+func (r *Neo4jRelationships) WriteEdge(citing, cited string) error {
+	if !r.header {
+		if err := r.w.Write(Neo4jRelationshipHeader); err != nil {
+			return err
+		}
+		r.header = true
+	}
+	return r.w.Write([]string{citing, cited, Neo4jRelationship})
+}
+
+// Close flushes the output.
+func (r *Neo4jRelationships) Close() error {
+	r.w.Flush()
+	return r.w.Error()
+}
@@ -0,0 +1,41 @@
+package graph
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNeo4jNodes(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewNeo4jNodes(&buf)
+	if err := w.WriteNode("10.1/a", []string{"0-1", "0-2"}, "A \"title\",\nwith newline"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := w.WriteNode("10.1/b", nil, ""); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	want := "doi:ID(Work),ids:string[],title,:LABEL\n" +
+		"10.1/a,0-1;0-2,\"A \"\"title\"\", with newline\",Work\n" +
+		"10.1/b,,,Work\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestNeo4jRelationships(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewNeo4jRelationships(&buf)
+	if err := w.WriteEdge("10.1/a", "10.1/b"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	want := ":START_ID(Work),:END_ID(Work),:TYPE\n10.1/a,10.1/b,CITES\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+}
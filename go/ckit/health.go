@@ -0,0 +1,225 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/segmentio/encoding/json"
+)
+
+// Health states.
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded" // serving, but data is older than expected
+	HealthDown     = "down"     // a datastore is not available
+)
+
+// DatasetHealth describes the freshness of a database in use.
+type DatasetHealth struct {
+	Name string `json:"name"`
+	Path string `json:"path,omitempty"`
+	// Modified is the modification time of the database file.
+	Modified time.Time `json:"modified"`
+	// Date is the date recorded in the database by makta, or the time of
+	// the last solrsync, whichever is later; empty, if unknown.
+	Date string `json:"date,omitempty"`
+	// Age is the time since Date, if known, otherwise since Modified.
+	Age   string  `json:"age"`
+	Days  float64 `json:"days"`
+	Stale bool    `json:"stale"`
+	age   time.Duration
+}
+
+// HealthResponse is the response for /health.
+type HealthResponse struct {
+	Status   string          `json:"status"`
+	Error    string          `json:"error,omitempty"`
+	MaxAge   string          `json:"max_age,omitempty"`
+	Datasets []DatasetHealth `json:"datasets"`
+	// IndexDocuments is the approximate number of index data documents,
+	// if the index data backend can tell, cf. Capabilities.
+	IndexDocuments int64 `json:"index_documents,omitempty"`
+}
+
+// databaseFile returns the filename of the main database of a connection.
+func databaseFile(ctx context.Context, db *sqlx.DB) (string, error) {
+	var rows []struct {
+		Seq  int    `db:"seq"`
+		Name string `db:"name"`
+		File string `db:"file"`
+	}
+	if err := db.SelectContext(ctx, &rows, "PRAGMA database_list"); err != nil {
+		return "", err
+	}
+	for _, row := range rows {
+		if row.Name == "main" {
+			return row.File, nil
+		}
+	}
+	return "", fmt.Errorf("main database not found")
+}
+
+// datasetDate returns the later of the date recorded by makta and the time
+// of the last sync, as recorded by solrsync.
+func datasetDate(meta map[string]string) (s string, t time.Time) {
+	for _, k := range []string{"date", "synced"} {
+		v, err := time.Parse(time.RFC3339, meta[k])
+		if err != nil {
+			continue
+		}
+		if v.After(t) {
+			s, t = meta[k], v
+		}
+	}
+	return s, t
+}
+
+// datasetHealth inspects a single database.
+func (s *Server) datasetHealth(ctx context.Context, name string, db *sqlx.DB, now time.Time) (DatasetHealth, error) {
+	dh := DatasetHealth{Name: name}
+	path, err := databaseFile(ctx, db)
+	if err != nil {
+		return dh, err
+	}
+	dh.Path = path
+	if fi, err := os.Stat(path); err == nil {
+		dh.Modified = fi.ModTime()
+	}
+	meta, err := readMeta(ctx, db)
+	if err != nil {
+		return dh, err
+	}
+	date, t := datasetDate(meta)
+	if date == "" {
+		t = dh.Modified
+	}
+	dh.Date = date
+	dh.age = now.Sub(t)
+	dh.Age = dh.age.Round(time.Second).String()
+	dh.Days = math.Round(dh.age.Hours()/24*10) / 10
+	dh.Stale = s.MaxDataAge > 0 && dh.age > s.MaxDataAge
+	return dh, nil
+}
+
+// Health reports whether datastores are available and how old the data
+// is; the server is degraded, if any data is older than MaxDataAge.
+func (s *Server) Health(ctx context.Context) *HealthResponse {
+	resp := &HealthResponse{Status: HealthOK, Datasets: []DatasetHealth{}}
+	if s.MaxDataAge > 0 {
+		resp.MaxAge = s.MaxDataAge.String()
+	}
+	if err := s.Ping(); err != nil {
+		resp.Status, resp.Error = HealthDown, err.Error()
+		return resp
+	}
+	now := time.Now()
+	for _, v := range s.databases() {
+		dh, err := s.datasetHealth(ctx, v.name, v.db, now)
+		if err != nil {
+			resp.Status, resp.Error = HealthDown, fmt.Sprintf("%s: %v", v.name, err)
+			return resp
+		}
+		if dh.Stale {
+			resp.Status = HealthDegraded
+		}
+		resp.Datasets = append(resp.Datasets, dh)
+	}
+	if index := s.indexData(); index != nil {
+		if n, err := ApproximateCount(ctx, index); err == nil {
+			resp.IndexDocuments = n
+		}
+	}
+	return resp
+}
+
+// handleHealth reports status and data freshness; a degraded server still
+// answers with 200, since it can serve requests, a server with unavailable
+// datastores with 503.
+func (s *Server) handleHealth() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := s.Health(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if resp.Status == HealthDown {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			httpErrLog(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// HealthPartial is the readiness of a server, that can answer some, but not
+// all requests, e.g. without index data, cf. Readiness.
+const HealthPartial = "partial"
+
+// ReadinessResponse is the response for /healthz.
+type ReadinessResponse struct {
+	Status string `json:"status"`
+	// Checks are ok or the error, by component: identifier, oci, edges,
+	// index, analytics and additional corpora as corpus/{name}.
+	Checks map[string]string `json:"checks"`
+}
+
+// Readiness checks each datastore separately: without the identifier or
+// oci database or the edge store, the server is down; without the index data, the analytical
+// database or a corpus, it is partially ready.
+func (s *Server) Readiness(ctx context.Context) *ReadinessResponse {
+	var (
+		resp  = &ReadinessResponse{Status: HealthOK, Checks: make(map[string]string)}
+		check = func(name string, err error, required bool) {
+			switch {
+			case err == nil:
+				resp.Checks[name] = HealthOK
+			case required:
+				resp.Checks[name], resp.Status = err.Error(), HealthDown
+			default:
+				resp.Checks[name] = err.Error()
+				if resp.Status == HealthOK {
+					resp.Status = HealthPartial
+				}
+			}
+		}
+	)
+	check("identifier", s.identifierDB().PingContext(ctx), true)
+	check("oci", s.ociDB().PingContext(ctx), true)
+	if s.Edges != nil {
+		check("edges", s.Edges.Ping(), true)
+	}
+	if f := s.indexData(); f != nil && f.Capabilities().Ping {
+		check("index", PingFetcher(f), false)
+	}
+	if s.Analytics != nil {
+		check("analytics", s.Analytics.PingContext(ctx), false)
+	}
+	for name, c := range s.Corpora {
+		if r := c.Readiness(ctx); r.Status == HealthOK {
+			check("corpus/"+name, nil, false)
+		} else {
+			check("corpus/"+name, fmt.Errorf("%s", r.Status), false)
+		}
+	}
+	return resp
+}
+
+// handleReadiness reports, which datastores are available, for container
+// orchestration. A partially ready server answers with 200 in degraded mode,
+// cf. Server.Degraded, since it can still serve requests, otherwise with
+// 503, as does a server, that is down.
+func (s *Server) handleReadiness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := s.Readiness(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if resp.Status == HealthDown || (resp.Status == HealthPartial && !s.Degraded) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("healthz: %v", err)
+		}
+	}
+}
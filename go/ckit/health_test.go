@@ -0,0 +1,130 @@
+package ckit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/segmentio/encoding/json"
+)
+
+func TestDatasetDate(t *testing.T) {
+	var cases = []struct {
+		meta map[string]string
+		date string
+	}{
+		{nil, ""},
+		{map[string]string{"date": "2022-02-01T00:00:00Z"}, "2022-02-01T00:00:00Z"},
+		{map[string]string{"date": "2022-02-01T00:00:00Z", "synced": "2022-02-03T06:00:00Z"}, "2022-02-03T06:00:00Z"},
+		{map[string]string{"date": "2022-02-05T00:00:00Z", "synced": "2022-02-03T06:00:00Z"}, "2022-02-05T00:00:00Z"},
+		{map[string]string{"date": "yesterday"}, ""},
+	}
+	for _, c := range cases {
+		date, _ := datasetDate(c.meta)
+		if date != c.date {
+			t.Fatalf("got %v, want %v", date, c.date)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	a, err := OpenDatabase("testdata/id_doi.db")
+	if err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	b, err := OpenDatabase("testdata/doi_doi.db")
+	if err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	g := &FetchGroup{}
+	if err := g.FromFiles("testdata/id_metadata.db"); err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	var cases = []struct {
+		maxAge time.Duration
+		status string
+	}{
+		{0, HealthOK},
+		{time.Nanosecond, HealthDegraded},
+	}
+	for _, c := range cases {
+		srv := &Server{
+			IdentifierDatabase: a,
+			OciDatabase:        b,
+			IndexData:          g,
+			MaxDataAge:         c.maxAge,
+			Router:             mux.NewRouter(),
+		}
+		srv.Routes()
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got %v, want %v", rr.Code, http.StatusOK)
+		}
+		var resp HealthResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("could not unmarshal health response: %v", err)
+		}
+		if resp.Status != c.status {
+			t.Fatalf("got %v, want %v", resp.Status, c.status)
+		}
+		if len(resp.Datasets) != 3 {
+			t.Fatalf("got %d datasets, want 3", len(resp.Datasets))
+		}
+	}
+}
+
+// downFetcher is an index data service, that cannot be reached.
+type downFetcher struct{ err error }
+
+func (f *downFetcher) Fetch(id string) ([]byte, error) { return nil, f.err }
+func (f *downFetcher) Capabilities() Capabilities      { return Capabilities{Ping: true} }
+func (f *downFetcher) Ping() error                     { return f.err }
+
+func TestReadiness(t *testing.T) {
+	a, err := OpenDatabase("testdata/id_doi.db")
+	if err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	b, err := OpenDatabase("testdata/doi_doi.db")
+	if err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	down := &downFetcher{err: errors.New("connection refused")}
+	var cases = []struct {
+		about    string
+		index    Fetcher
+		degraded bool
+		code     int
+		status   string
+	}{
+		{"no ping capability", &FetchGroup{}, false, 200, HealthOK},
+		{"index down", down, false, 503, HealthPartial},
+		{"index down, degraded mode", down, true, 200, HealthPartial},
+	}
+	for _, c := range cases {
+		srv := &Server{
+			IdentifierDatabase: a,
+			OciDatabase:        b,
+			IndexData:          c.index,
+			Degraded:           c.degraded,
+			Router:             mux.NewRouter(),
+		}
+		srv.Routes()
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+		if rr.Code != c.code {
+			t.Fatalf("[%s] got %v, want %v", c.about, rr.Code, c.code)
+		}
+		var resp ReadinessResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("[%s] could not unmarshal readiness response: %v", c.about, err)
+		}
+		if resp.Status != c.status {
+			t.Fatalf("[%s] got %v, want %v", c.about, resp.Status, c.status)
+		}
+	}
+}
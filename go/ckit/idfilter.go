@@ -0,0 +1,77 @@
+package ckit
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/slub/labe/go/ckit/bloom"
+)
+
+// buildIdentifierFilter builds a bloom filter over all local identifiers and
+// DOI of an identifier database, with keys "id:..." and "doi:...", like the
+// keys of Server.NotFound; DOI are lowercased, if nocase is set.
+func buildIdentifierFilter(ctx context.Context, db *sqlx.DB, p float64, nocase bool) (*bloom.Filter, error) {
+	var n int
+	if err := db.GetContext(ctx, &n, "SELECT coalesce(max(rowid), 0) FROM map"); err != nil {
+		return nil, err
+	}
+	f := bloom.New(2*n, p)
+	rows, err := db.QueryxContext(ctx, "SELECT k, v FROM map")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m Map
+		if err := rows.StructScan(&m); err != nil {
+			return nil, err
+		}
+		if nocase {
+			m.Value = strings.ToLower(m.Value)
+		}
+		f.Add("id:" + m.Key)
+		f.Add("doi:" + m.Value)
+	}
+	return f, rows.Err()
+}
+
+// BuildIdentifierFilter builds a bloom filter over the current identifier
+// database with false positive rate Server.IdentifierFilterRate; identifiers
+// and DOI not in the filter are answered with 404 without any query. The
+// filter is rebuilt on rotation.
+func (s *Server) BuildIdentifierFilter(ctx context.Context) error {
+	started := time.Now()
+	f, err := buildIdentifierFilter(ctx, s.identifierDB(), s.IdentifierFilterRate, s.NoCase)
+	if err != nil {
+		return err
+	}
+	s.dataMu.Lock()
+	s.identifierFilter = f
+	s.dataMu.Unlock()
+	log.Printf("built identifier filter (%d bytes) in %s", f.Size(), time.Since(started))
+	return nil
+}
+
+// filterMiss returns true, if the identifier filter rules out a key, like
+// "id:..." or "doi:...".
+func (s *Server) filterMiss(key string) bool {
+	s.dataMu.RLock()
+	f := s.identifierFilter
+	s.dataMu.RUnlock()
+	if f == nil {
+		return false
+	}
+	if s.NoCase && strings.HasPrefix(key, "doi:") {
+		key = strings.ToLower(key)
+	}
+	if f.Test(key) {
+		return false
+	}
+	if s.Stats != nil {
+		s.Stats.MeasureSinceWithLabels("identifier_filter_miss", time.Now(), nil)
+	}
+	return true
+}
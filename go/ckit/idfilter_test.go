@@ -0,0 +1,66 @@
+package ckit
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/slub/labe/go/ckit/bloom"
+	"github.com/thoas/stats"
+)
+
+func TestFilterMiss(t *testing.T) {
+	f := bloom.New(10, 0.001)
+	f.Add("id:ai-1")
+	f.Add("doi:10.123/abc")
+	s := &Server{Stats: stats.New()}
+	if s.filterMiss("id:ai-2") {
+		t.Fatalf("no filter: want no miss")
+	}
+	s.identifierFilter = f
+	for _, c := range []struct {
+		key    string
+		nocase bool
+		want   bool
+	}{
+		{"id:ai-1", false, false},
+		{"id:ai-2", false, true},
+		{"doi:10.123/abc", false, false},
+		{"doi:10.123/ABC", false, true},
+		{"doi:10.123/ABC", true, false},
+		{"id:AI-1", true, true},
+	} {
+		s.NoCase = c.nocase
+		if got := s.filterMiss(c.key); got != c.want {
+			t.Errorf("filterMiss(%q, nocase=%v): got %v, want %v", c.key, c.nocase, got, c.want)
+		}
+	}
+	s.NoCase = false
+	if !s.notFound("id:ai-2") {
+		t.Fatalf("notFound: want true for filtered id")
+	}
+	// No database is needed for a definite miss.
+	var doi string
+	if err := s.identifierToDOI(context.Background(), "ai-2", &doi); err != sql.ErrNoRows {
+		t.Fatalf("identifierToDOI: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestBuildIdentifierFilter(t *testing.T) {
+	db, err := OpenDatabase("testdata/id_doi.db")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	var sample Map
+	if err := db.Get(&sample, "SELECT * FROM map LIMIT 1"); err != nil {
+		t.Fatalf("sample: %v", err)
+	}
+	s := &Server{IdentifierDatabase: db, IdentifierFilterRate: 0.01, Stats: stats.New()}
+	if err := s.BuildIdentifierFilter(context.Background()); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if s.filterMiss("id:"+sample.Key) || s.filterMiss("doi:"+sample.Value) {
+		t.Fatalf("filter misses %v", sample)
+	}
+}
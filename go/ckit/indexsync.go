@@ -0,0 +1,119 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/segmentio/encoding/json"
+)
+
+// DefaultSyncRows is the number of documents fetched per request, when
+// syncing changed documents.
+const DefaultSyncRows = 1000
+
+// Changed pages through all documents modified at or after a given time,
+// as recorded in a date field, e.g. last_indexed in VuFind, and calls f for
+// each page. Documents are sorted by key, which SOLR requires for deep
+// paging with a cursor; fields restricts the returned fields, e.g.
+// "id,title,doi_str_mv", empty for all.
+func (idx *SolrIndex) Changed(ctx context.Context, field, key, fields string, since time.Time,
+	rows int, f func(docs []json.RawMessage) error) error {
+	if rows <= 0 {
+		rows = DefaultSyncRows
+	}
+	cursor := "*"
+	for {
+		vs := url.Values{}
+		vs.Set("q", fmt.Sprintf("%s:[%s TO *]", field, since.UTC().Format("2006-01-02T15:04:05Z")))
+		vs.Set("sort", key+" asc")
+		vs.Set("rows", strconv.Itoa(rows))
+		vs.Set("cursorMark", cursor)
+		vs.Set("wt", "json")
+		if fields != "" {
+			vs.Set("fl", fields)
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", idx.URL,
+			strings.NewReader(vs.Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		var resp struct {
+			NextCursorMark string `json:"nextCursorMark"`
+			Response       struct {
+				Docs []json.RawMessage `json:"docs"`
+			} `json:"response"`
+		}
+		if err := doJSON(liveClient(idx.Client), req, &resp); err != nil {
+			return fmt.Errorf("solr: %w", err)
+		}
+		if len(resp.Response.Docs) > 0 {
+			if err := f(resp.Response.Docs); err != nil {
+				return err
+			}
+		}
+		// The cursor does not change, when all documents have been seen.
+		if resp.NextCursorMark == "" || resp.NextCursorMark == cursor {
+			return nil
+		}
+		cursor = resp.NextCursorMark
+	}
+}
+
+// UpsertDocuments replaces documents in an index database as created by
+// makta, keyed by the value of a field, e.g. id, and adds documents not yet
+// in the database. Rows are updated in place, never deleted, so the row
+// count in the meta table stays valid. Runs in a single transaction.
+func UpsertDocuments(ctx context.Context, db *sqlx.DB, key string, docs []json.RawMessage) (inserted, updated int, err error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	for _, doc := range docs {
+		id, err := documentKey(doc, key)
+		if err != nil {
+			return inserted, updated, err
+		}
+		res, err := tx.ExecContext(ctx, "UPDATE map SET v = ? WHERE k = ?", string(doc), id)
+		if err != nil {
+			return inserted, updated, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return inserted, updated, err
+		}
+		if n > 0 {
+			updated++
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO map (k, v) VALUES (?, ?)", id, string(doc)); err != nil {
+			return inserted, updated, err
+		}
+		inserted++
+	}
+	return inserted, updated, tx.Commit()
+}
+
+// documentKey returns the string value of a field of a JSON document.
+func documentKey(doc json.RawMessage, key string) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return "", err
+	}
+	var id string
+	if err := json.Unmarshal(fields[key], &id); err != nil || id == "" {
+		return "", fmt.Errorf("document without string field %s", key)
+	}
+	return id, nil
+}
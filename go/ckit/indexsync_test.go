@@ -0,0 +1,97 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/segmentio/encoding/json"
+)
+
+func TestSolrIndexChanged(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if q := r.Form.Get("q"); q != "last_indexed:[2022-02-01T00:00:00Z TO *]" {
+			t.Errorf("unexpected query: %s", q)
+		}
+		if s := r.Form.Get("sort"); s != "id asc" {
+			t.Errorf("unexpected sort: %s", s)
+		}
+		switch r.Form.Get("cursorMark") {
+		case "*":
+			fmt.Fprint(w, `{"nextCursorMark": "c1", "response": {"docs": [{"id": "1"}, {"id": "2"}]}}`)
+		case "c1":
+			fmt.Fprint(w, `{"nextCursorMark": "c2", "response": {"docs": [{"id": "3"}]}}`)
+		default:
+			fmt.Fprint(w, `{"nextCursorMark": "c2", "response": {"docs": []}}`)
+		}
+	}))
+	defer ts.Close()
+	var (
+		idx   = &SolrIndex{URL: ts.URL}
+		since = time.Date(2022, 2, 1, 1, 0, 0, 0, time.FixedZone("CET", 3600))
+		pages []int
+	)
+	err := idx.Changed(context.Background(), "last_indexed", "id", "", since, 2, func(docs []json.RawMessage) error {
+		pages = append(pages, len(docs))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if fmt.Sprintf("%v", pages) != "[2 1]" {
+		t.Fatalf("got pages %v, want [2 1]", pages)
+	}
+}
+
+func TestUpsertDocuments(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "index.db")
+	db, err := sqlx.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE map (k TEXT, v TEXT);
+		INSERT INTO map (k, v) VALUES ('1', '{"id": "1", "title": "old"}')`); err != nil {
+		t.Fatal(err)
+	}
+	docs := []json.RawMessage{
+		json.RawMessage(`{"id": "1", "title": "new"}`),
+		json.RawMessage(`{"id": "2", "title": "added"}`),
+	}
+	inserted, updated, err := UpsertDocuments(context.Background(), db, "id", docs)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if inserted != 1 || updated != 1 {
+		t.Fatalf("got %d inserted, %d updated, want 1, 1", inserted, updated)
+	}
+	var v string
+	if err := db.Get(&v, "SELECT v FROM map WHERE k = '1'"); err != nil {
+		t.Fatal(err)
+	}
+	if v != `{"id": "1", "title": "new"}` {
+		t.Fatalf("got %v", v)
+	}
+	_, _, err = UpsertDocuments(context.Background(), db, "id", []json.RawMessage{
+		json.RawMessage(`{"id": "3"}`),
+		json.RawMessage(`{"title": "no id"}`),
+	})
+	if err == nil {
+		t.Fatalf("expected error for document without id")
+	}
+	var n int
+	if err := db.Get(&n, "SELECT count(*) FROM map"); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("got %d rows, want 2, failed batch should be rolled back", n)
+	}
+}
@@ -0,0 +1,579 @@
+package ckit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/cache"
+	"github.com/slub/labe/go/ckit/fakedata"
+	"github.com/thoas/stats"
+)
+
+// The integration tests run a Server with generated data in in-memory
+// databases, a stub fetcher for the index data and an in-memory cache, and
+// check responses against the generated data.
+
+// lonelyID is a local document without any citations.
+const lonelyID = "lonely"
+
+var memoryDatabases int64
+
+// memoryDatabase returns an in-memory sqlite3 database containing rows, which
+// lives as long as the test.
+func memoryDatabase(t *testing.T, rows []fakedata.Row) *sqlx.DB {
+	t.Helper()
+	name := fmt.Sprintf("file:memdb%d?mode=memory&cache=shared", atomic.AddInt64(&memoryDatabases, 1))
+	db, err := sqlx.Open("sqlite3", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An in-memory database is gone with its last connection.
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		db.Close()
+	})
+	if err := fakedata.Load(context.Background(), db, rows); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// stubFetcher serves blobs from a map and counts fetches.
+type stubFetcher struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+	err   error // returned for every fetch, if set
+	calls int
+}
+
+func (f *stubFetcher) Fetch(id string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	b, ok := f.blobs[id]
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+	return b, nil
+}
+
+func (f *stubFetcher) Capabilities() Capabilities { return Capabilities{} }
+
+func (f *stubFetcher) set(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *stubFetcher) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// memoryStore is a cache.Store keeping values in a map.
+type memoryStore struct {
+	mu       sync.Mutex
+	values   map[string][]byte
+	modified map[string]time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{values: make(map[string][]byte), modified: make(map[string]time.Time)}
+}
+
+func (m *memoryStore) Get(key string) ([]byte, error) {
+	b, _, err := m.GetWithTime(key)
+	return b, err
+}
+
+func (m *memoryStore) GetWithTime(key string) ([]byte, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.values[key]
+	if !ok {
+		return nil, time.Time{}, cache.ErrCacheMiss
+	}
+	return b, m.modified[key], nil
+}
+
+func (m *memoryStore) Set(key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = value
+	m.modified[key] = time.Now()
+	return nil
+}
+
+func (m *memoryStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values, key)
+	delete(m.modified, key)
+	return nil
+}
+
+func (m *memoryStore) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values = make(map[string][]byte)
+	m.modified = make(map[string]time.Time)
+	return nil
+}
+
+func (m *memoryStore) ItemCount() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.values), nil
+}
+
+func (m *memoryStore) Close() error { return nil }
+
+// harness is a running server with generated data.
+type harness struct {
+	srv     *Server
+	ts      *httptest.Server
+	data    *fakedata.Data
+	fetcher *stubFetcher
+	// client does not follow redirects.
+	client *http.Client
+}
+
+// newHarness starts a server with generated data; configure may change the
+// server before it starts.
+func newHarness(t *testing.T, configure func(s *Server)) *harness {
+	t.Helper()
+	data, err := fakedata.Generate(fakedata.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.Identifiers = append(data.Identifiers, fakedata.Row{Key: lonelyID, Value: "10.5555/lonely"})
+	data.Documents = append(data.Documents, fakedata.Row{Key: lonelyID, Value: `{"id":"lonely"}`})
+	fetcher := &stubFetcher{blobs: make(map[string][]byte)}
+	for _, row := range data.Documents {
+		fetcher.blobs[row.Key] = []byte(row.Value)
+	}
+	srv := &Server{
+		IdentifierDatabase: memoryDatabase(t, data.Identifiers),
+		OciDatabase:        memoryDatabase(t, data.Citations),
+		IndexData:          fetcher,
+		Router:             mux.NewRouter(),
+		Stats:              stats.New(),
+	}
+	if configure != nil {
+		configure(srv)
+	}
+	srv.Routes()
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return &harness{
+		srv:     srv,
+		ts:      ts,
+		data:    data,
+		fetcher: fetcher,
+		client: &http.Client{
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// do sends a request, with the admin token of the server, if any, and
+// returns the response with its body read.
+func (h *harness) do(t *testing.T, method, path string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, h.ts.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.srv.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.srv.AdminToken)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, b
+}
+
+// getResponse requests a path, expects status 200 and decodes the response.
+func (h *harness) getResponse(t *testing.T, path string) *Response {
+	t.Helper()
+	resp, b := h.do(t, "GET", path)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: got %v, want 200: %s", path, resp.StatusCode, b)
+	}
+	var r Response
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return &r
+}
+
+// expected is a response derived from the generated data.
+type expected struct {
+	citing, cited                   []string // sorted local ids
+	unmatchedCiting, unmatchedCited int
+	// outbound and inbound count all citing and cited DOI.
+	outbound, inbound int
+}
+
+// expect returns the expected response for a DOI. A DOI, which is cited by
+// and cites another, counts as citing, as in the server.
+func (h *harness) expect(doi string) expected {
+	var (
+		local    = make(map[string]string)
+		outbound = make(map[string]bool)
+		inbound  = make(map[string]bool)
+		e        expected
+	)
+	for _, row := range h.data.Identifiers {
+		local[row.Value] = row.Key
+	}
+	for _, row := range h.data.Citations {
+		if row.Key == doi {
+			outbound[row.Value] = true
+		}
+		if row.Value == doi {
+			inbound[row.Key] = true
+		}
+	}
+	e.outbound, e.inbound = len(outbound), len(inbound)
+	for v := range outbound {
+		if id, ok := local[v]; ok {
+			e.citing = append(e.citing, id)
+		} else {
+			e.unmatchedCiting++
+		}
+	}
+	for v := range inbound {
+		if outbound[v] {
+			continue
+		}
+		if id, ok := local[v]; ok {
+			e.cited = append(e.cited, id)
+		} else {
+			e.unmatchedCited++
+		}
+	}
+	sort.Strings(e.citing)
+	sort.Strings(e.cited)
+	return e
+}
+
+func (e expected) empty() bool {
+	return len(e.citing)+len(e.cited)+e.unmatchedCiting+e.unmatchedCited == 0
+}
+
+// responseIDs returns the sorted ids of documents.
+func responseIDs(t *testing.T, docs []json.RawMessage) []string {
+	t.Helper()
+	var ids []string
+	for _, b := range docs {
+		var doc struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			t.Fatalf("%s: %v", b, err)
+		}
+		ids = append(ids, doc.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// checkResponse compares a response with the expected documents and counts.
+func checkResponse(t *testing.T, id string, r *Response, e expected) {
+	t.Helper()
+	if got := responseIDs(t, r.Citing); fmt.Sprint(got) != fmt.Sprint(e.citing) {
+		t.Fatalf("%s: got citing %v, want %v", id, got, e.citing)
+	}
+	if got := responseIDs(t, r.Cited); fmt.Sprint(got) != fmt.Sprint(e.cited) {
+		t.Fatalf("%s: got cited %v, want %v", id, got, e.cited)
+	}
+	if r.Extra.CitingCount != len(e.citing) || r.Extra.CitedCount != len(e.cited) {
+		t.Fatalf("%s: got counts %d, %d, want %d, %d", id,
+			r.Extra.CitingCount, r.Extra.CitedCount, len(e.citing), len(e.cited))
+	}
+	if r.Extra.UnmatchedCitingCount != e.unmatchedCiting || r.Extra.UnmatchedCitedCount != e.unmatchedCited {
+		t.Fatalf("%s: got unmatched counts %d, %d, want %d, %d", id,
+			r.Extra.UnmatchedCitingCount, r.Extra.UnmatchedCitedCount, e.unmatchedCiting, e.unmatchedCited)
+	}
+	if len(r.Unmatched.Citing) != e.unmatchedCiting || len(r.Unmatched.Cited) != e.unmatchedCited {
+		t.Fatalf("%s: got %d, %d unmatched documents", id, len(r.Unmatched.Citing), len(r.Unmatched.Cited))
+	}
+}
+
+// someID returns a local id with matched citing and cited documents.
+func (h *harness) someID(t *testing.T) (id, doi string) {
+	t.Helper()
+	for _, row := range h.data.Identifiers {
+		if e := h.expect(row.Value); len(e.citing) > 0 && len(e.cited) > 0 {
+			return row.Key, row.Value
+		}
+	}
+	t.Fatal("no id with citing and cited documents in generated data")
+	return "", ""
+}
+
+func TestIntegrationLocalIdentifier(t *testing.T) {
+	h := newHarness(t, nil)
+	var found, notFound int
+	for _, row := range h.data.Identifiers {
+		e := h.expect(row.Value)
+		if e.empty() {
+			resp, _ := h.do(t, "GET", "/id/"+row.Key)
+			if resp.StatusCode != http.StatusNotFound {
+				t.Fatalf("%s: got %v, want 404", row.Key, resp.StatusCode)
+			}
+			notFound++
+			continue
+		}
+		r := h.getResponse(t, "/id/"+row.Key)
+		if r.ID != row.Key || r.DOI != row.Value {
+			t.Fatalf("got id %s, doi %s, want %s, %s", r.ID, r.DOI, row.Key, row.Value)
+		}
+		checkResponse(t, row.Key, r, e)
+		found++
+	}
+	if found == 0 || notFound == 0 {
+		t.Fatalf("got %d found, %d not found, want both", found, notFound)
+	}
+	resp, _ := h.do(t, "GET", "/id/unknown")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown: got %v, want 404", resp.StatusCode)
+	}
+	// HEAD counts all citing and cited DOI.
+	id, doi := h.someID(t)
+	e := h.expect(doi)
+	resp, _ = h.do(t, "HEAD", "/id/"+id)
+	for k, want := range map[string]int{
+		"X-Citing-Count":    e.outbound,
+		"X-Cited-Count":     e.inbound,
+		"X-Unmatched-Count": e.unmatchedCiting + e.unmatchedCited,
+	} {
+		if got := resp.Header.Get(k); got != fmt.Sprint(want) {
+			t.Fatalf("%s: got %s %s, want %d", id, k, got, want)
+		}
+	}
+}
+
+func TestIntegrationLocalIdentifierOptions(t *testing.T) {
+	h := newHarness(t, nil)
+	id, doi := h.someID(t)
+	e := h.expect(doi)
+	// Without index data, documents are stubs with id and DOI.
+	calls := h.fetcher.count()
+	r := h.getResponse(t, "/id/"+id+"?blobs=false")
+	checkResponse(t, id, r, e)
+	if h.fetcher.count() != calls {
+		t.Fatalf("blobs=false: got %d fetches, want none", h.fetcher.count()-calls)
+	}
+	// Unmatched documents are left out, their counts are kept.
+	r = h.getResponse(t, "/id/"+id+"?unmatched=false")
+	if len(r.Unmatched.Citing)+len(r.Unmatched.Cited) != 0 {
+		t.Fatalf("unmatched=false: got unmatched documents")
+	}
+	if r.Extra.UnmatchedCitingCount != e.unmatchedCiting || r.Extra.UnmatchedCitedCount != e.unmatchedCited {
+		t.Fatalf("unmatched=false: got counts %d, %d", r.Extra.UnmatchedCitingCount, r.Extra.UnmatchedCitedCount)
+	}
+	// A missing blob is skipped.
+	h.fetcher.mu.Lock()
+	delete(h.fetcher.blobs, e.citing[0])
+	h.fetcher.mu.Unlock()
+	r = h.getResponse(t, "/id/"+id)
+	if r.Extra.CitingCount != len(e.citing)-1 {
+		t.Fatalf("missing blob: got %d citing, want %d", r.Extra.CitingCount, len(e.citing)-1)
+	}
+	for _, path := range []string{
+		"/id/" + id + "?offset=x",
+		"/id/" + id + "?offset=1&cursor=",
+		"/id/" + id + "?blobs=maybe",
+	} {
+		resp, _ := h.do(t, "GET", path)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s: got %v, want 400", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestIntegrationDOI(t *testing.T) {
+	h := newHarness(t, nil)
+	id, doi := h.someID(t)
+	resp, _ := h.do(t, "GET", "/doi/"+doi+"?unmatched=false")
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("got %v, want 307", resp.StatusCode)
+	}
+	if got, want := resp.Header.Get("Location"), "/id/"+id+"?unmatched=false"; got != want {
+		t.Fatalf("got location %s, want %s", got, want)
+	}
+	// Following the redirect gives the response for the local id.
+	follow := &http.Client{}
+	resp, err := follow.Get(h.ts.URL + "/doi/" + doi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r Response
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResponse(t, id, &r, h.expect(doi))
+	resp, _ = h.do(t, "HEAD", "/doi/"+doi)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Local-Id") != id {
+		t.Fatalf("HEAD: got %v, local id %q", resp.StatusCode, resp.Header.Get("X-Local-Id"))
+	}
+	// DOI without a local document.
+	local := make(map[string]bool)
+	for _, row := range h.data.Identifiers {
+		local[row.Value] = true
+	}
+	for _, v := range h.data.DOI {
+		if local[v] {
+			continue
+		}
+		if resp, _ := h.do(t, "GET", "/doi/"+v); resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s: got %v, want 404", v, resp.StatusCode)
+		}
+		break
+	}
+	if resp, _ := h.do(t, "GET", "/doi/10.5555/unknown"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown: got %v, want 404", resp.StatusCode)
+	}
+}
+
+func TestIntegrationCache(t *testing.T) {
+	store := newMemoryStore()
+	h := newHarness(t, func(s *Server) {
+		s.Cache = store
+		s.NotFound = cache.NewTTLSet(time.Minute)
+		s.AdminToken = "secret"
+	})
+	id, doi := h.someID(t)
+	e := h.expect(doi)
+	checkResponse(t, id, h.getResponse(t, "/id/"+id), e)
+	if n, _ := store.ItemCount(); n != 1 {
+		t.Fatalf("got %d cached items, want 1", n)
+	}
+	// The second request is served from the cache, without fetches.
+	calls := h.fetcher.count()
+	r := h.getResponse(t, "/id/"+id)
+	checkResponse(t, id, r, e)
+	if !r.Extra.Cached {
+		t.Fatalf("got cached false, want true")
+	}
+	if h.fetcher.count() != calls {
+		t.Fatalf("got %d fetches for cached response, want none", h.fetcher.count()-calls)
+	}
+	// Evicted values are computed again.
+	if resp, _ := h.do(t, "DELETE", "/cache/"+url.PathEscape(id)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("evict: got %v, want 200", resp.StatusCode)
+	}
+	checkResponse(t, id, h.getResponse(t, "/id/"+id), e)
+	if h.fetcher.count() == calls {
+		t.Fatalf("got no fetches after eviction")
+	}
+	// Ids without citations are remembered.
+	if resp, _ := h.do(t, "GET", "/id/"+lonelyID); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("%s: got %v, want 404", lonelyID, resp.StatusCode)
+	}
+	if !h.srv.NotFound.Contains("id:" + lonelyID) {
+		t.Fatalf("%s: not recorded as not found", lonelyID)
+	}
+	if resp, _ := h.do(t, "GET", "/id/"+lonelyID); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("%s: got %v, want 404", lonelyID, resp.StatusCode)
+	}
+}
+
+func TestIntegrationIndexDataErrors(t *testing.T) {
+	h := newHarness(t, nil)
+	id, doi := h.someID(t)
+	e := h.expect(doi)
+	h.fetcher.set(errors.New("index data backend down"))
+	resp, _ := h.do(t, "GET", "/id/"+id)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("got %v, want 500", resp.StatusCode)
+	}
+	// With degraded mode, we still get ids and DOI.
+	h.srv.Degraded = true
+	r := h.getResponse(t, "/id/"+id)
+	if !r.Extra.Degraded {
+		t.Fatalf("got degraded false, want true")
+	}
+	checkResponse(t, id, r, e)
+	// Once the index data is back, so are full responses.
+	h.fetcher.set(nil)
+	r = h.getResponse(t, "/id/"+id)
+	if r.Extra.Degraded {
+		t.Fatalf("got degraded true, want false")
+	}
+	checkResponse(t, id, r, e)
+}
+
+// blockingFetcher blocks every fetch until the context is done, like a
+// backend, that hangs instead of failing.
+type blockingFetcher struct{}
+
+func (f blockingFetcher) Fetch(id string) ([]byte, error) {
+	return f.FetchContext(context.Background(), id)
+}
+
+func (f blockingFetcher) FetchContext(ctx context.Context, id string) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (f blockingFetcher) Capabilities() Capabilities { return Capabilities{} }
+
+func TestIntegrationTimeouts(t *testing.T) {
+	var cases = []struct {
+		name      string
+		configure func(s *Server)
+	}{
+		{"identifier", func(s *Server) { s.IdentifierTimeout = time.Nanosecond }},
+		{"oci", func(s *Server) { s.OciTimeout = time.Nanosecond }},
+		{"index", func(s *Server) {
+			s.IndexData = blockingFetcher{}
+			s.IndexDataTimeout = 50 * time.Millisecond
+		}},
+	}
+	for _, c := range cases {
+		h := newHarness(t, c.configure)
+		id, _ := h.someID(t)
+		started := time.Now()
+		resp, b := h.do(t, "GET", "/id/"+id)
+		if resp.StatusCode != http.StatusGatewayTimeout {
+			t.Fatalf("%s: got %v, want 504: %s", c.name, resp.StatusCode, b)
+		}
+		if elapsed := time.Since(started); elapsed > 5*time.Second {
+			t.Fatalf("%s: request took %s", c.name, elapsed)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package ckit
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/segmentio/encoding/json"
+)
+
+const (
+	// DefaultJobTTL is the default time finished jobs and their results are
+	// kept around.
+	DefaultJobTTL = time.Hour
+	// MaxJobIDs limits the number of ids in a single job.
+	MaxJobIDs = 1000
+	// DefaultMaxJobsPerClient is the default number of pending jobs a single
+	// client may have.
+	DefaultMaxJobsPerClient = 10
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrTooManyJobs = errors.New("too many pending jobs")
+	// ErrTooManyClientJobs is returned, if a single client has too many
+	// pending jobs.
+	ErrTooManyClientJobs = errors.New("too many pending jobs for client")
+)
+
+// JobStatus is the state of a job.
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// Job is a request for one or more ids, computed in the background. The
+// result is a JSON array with one response per id, in order; ids, for which
+// no response could be computed, get an error message instead.
+type Job struct {
+	ID       string     `json:"id"`
+	IDs      []string   `json:"ids"`
+	Status   JobStatus  `json:"status"`
+	Err      string     `json:"err,omitempty"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// Result is the URL path of the result, set when the job is done.
+	Result string `json:"result,omitempty"`
+	path   string
+	client string
+}
+
+// Jobs keeps track of jobs and runs them with a limited number of workers.
+// Results are written to files in a directory, as they can be large.
+type Jobs struct {
+	Dir string
+	// TTL is the time finished jobs and results are kept.
+	TTL time.Duration
+	// MaxPending limits the number of queued and running jobs.
+	MaxPending int
+	// MaxPerClient limits the number of queued and running jobs of a single
+	// client, identified by its address; no limit, if zero.
+	MaxPerClient int
+
+	mu        sync.Mutex
+	m         map[string]*Job
+	sem       chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	pending   int
+	perClient map[string]int
+}
+
+// NewJobs sets up job handling with a given number of workers; results are
+// stored in dir.
+func NewJobs(dir string, workers int) *Jobs {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Jobs{
+		Dir:          dir,
+		TTL:          DefaultJobTTL,
+		MaxPending:   100 * workers,
+		MaxPerClient: DefaultMaxJobsPerClient,
+		m:            make(map[string]*Job),
+		sem:          make(chan struct{}, workers),
+		ctx:          ctx,
+		cancel:       cancel,
+		perClient:    make(map[string]int),
+	}
+}
+
+// Get returns a copy of a job.
+func (j *Jobs) Get(id string) (Job, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.expire()
+	job, ok := j.m[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return *job, nil
+}
+
+// Close cancels all running jobs and removes all results.
+func (j *Jobs) Close() error {
+	j.cancel()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for id, job := range j.m {
+		os.Remove(job.path)
+		delete(j.m, id)
+	}
+	return nil
+}
+
+// add registers a new job for a client.
+func (j *Jobs) add(client string, ids []string) (*Job, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.expire()
+	if j.pending >= j.MaxPending {
+		return nil, ErrTooManyJobs
+	}
+	if j.MaxPerClient > 0 && j.perClient[client] >= j.MaxPerClient {
+		return nil, ErrTooManyClientJobs
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	job := &Job{
+		ID:      hex.EncodeToString(b),
+		IDs:     ids,
+		Status:  JobQueued,
+		Created: time.Now(),
+		client:  client,
+	}
+	job.path = filepath.Join(j.Dir, "labed-job-"+job.ID+".json")
+	j.m[job.ID] = job
+	j.pending++
+	j.perClient[client]++
+	return job, nil
+}
+
+// update sets the status of a job.
+func (j *Jobs) update(job *Job, status JobStatus, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job.Status = status
+	switch status {
+	case JobDone, JobFailed:
+		now := time.Now()
+		job.Finished = &now
+		j.pending--
+		if j.perClient[job.client]--; j.perClient[job.client] <= 0 {
+			delete(j.perClient, job.client)
+		}
+		if err != nil {
+			job.Err = err.Error()
+			os.Remove(job.path)
+		}
+	}
+}
+
+// expire removes finished jobs older than TTL, caller must hold the lock.
+func (j *Jobs) expire() {
+	for id, job := range j.m {
+		if job.Finished != nil && time.Since(*job.Finished) > j.TTL {
+			os.Remove(job.path)
+			delete(j.m, id)
+		}
+	}
+}
+
+// isJob returns true, if the context belongs to a job request.
+func isJob(ctx context.Context) bool {
+	v, _ := ctx.Value(jobKey).(bool)
+	return v
+}
+
+// runJob computes the result for all ids of a job, waiting for a free worker
+// first.
+func (s *Server) runJob(job *Job) {
+	select {
+	case s.Jobs.sem <- struct{}{}:
+		defer func() { <-s.Jobs.sem }()
+	case <-s.Jobs.ctx.Done():
+		s.Jobs.update(job, JobFailed, s.Jobs.ctx.Err())
+		return
+	}
+	s.Jobs.update(job, JobRunning, nil)
+	started := time.Now()
+	if err := s.writeJobResult(s.Jobs.ctx, job); err != nil {
+		log.Printf("[job] %s failed: %v", job.ID, err)
+		s.Jobs.update(job, JobFailed, err)
+		return
+	}
+	s.Stats.MeasureSinceWithLabels("job", started, nil)
+	log.Printf("[job] %s done with %d ids in %s", job.ID, len(job.IDs), time.Since(started))
+	s.Jobs.update(job, JobDone, nil)
+}
+
+// writeJobResult writes the responses for all ids of a job as a JSON array
+// into the result file.
+func (s *Server) writeJobResult(ctx context.Context, job *Job) error {
+	f, err := os.Create(job.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	out := &errWriter{w: bw}
+	out.WriteString("[")
+	for i, id := range job.IDs {
+		if i > 0 {
+			out.WriteString(",")
+		}
+		if err := s.jobRequest(ctx, out, id); err != nil {
+			return err
+		}
+		if out.err != nil {
+			return out.err
+		}
+	}
+	out.WriteString("]\n")
+	if out.err != nil {
+		return out.err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// jobRequest runs a request for a single id and writes the response body,
+// which is JSON for successful and failed requests alike, to w.
+func (s *Server) jobRequest(ctx context.Context, w io.Writer, id string) error {
+	ctx = context.WithValue(ctx, jobKey, true)
+	req, err := http.NewRequestWithContext(ctx, "GET", s.PathPrefix+"/id/"+url.PathEscape(id), nil)
+	if err != nil {
+		return err
+	}
+	rw := &internalResponseWriter{header: make(http.Header), w: w, code: http.StatusOK}
+	// A stream error aborts the handler with a panic, which is recovered by
+	// the HTTP server normally; we need to do that ourselves.
+	if err := s.serveRecover(rw, req); err != nil {
+		return fmt.Errorf("%s: %w", id, err)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if rw.n == 0 {
+		// E.g. a not found response without body.
+		_, err = fmt.Fprintf(w, `{"status": %d}`, rw.code)
+	}
+	return err
+}
+
+// jobRequestBody is the payload for job creation, either a single id or a
+// list of ids.
+type jobRequestBody struct {
+	ID  string   `json:"id"`
+	IDs []string `json:"ids"`
+}
+
+// jobClient returns the client a job is accounted to, the host part of the
+// remote address, which is the client address behind a trusted proxy, cf.
+// Server.TrustedProxies.
+func jobClient(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// handleJobCreate starts a new job and returns its status with 202 Accepted.
+func (s *Server) handleJobCreate() http.HandlerFunc {
+	if s.Jobs == nil {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "not configured", 400)
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body jobRequestBody
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil {
+			httpErrLogf(w, http.StatusBadRequest, "job: %w", err)
+			return
+		}
+		ids := body.IDs
+		if body.ID != "" {
+			ids = append([]string{body.ID}, ids...)
+		}
+		switch {
+		case len(ids) == 0:
+			httpErrLogf(w, http.StatusBadRequest, "job: no ids given")
+			return
+		case len(ids) > MaxJobIDs:
+			httpErrLogf(w, http.StatusBadRequest, "job: too many ids, limit is %d", MaxJobIDs)
+			return
+		}
+		job, err := s.Jobs.add(jobClient(r), ids)
+		switch {
+		case err == ErrTooManyJobs:
+			httpErrLog(w, http.StatusServiceUnavailable, err)
+			return
+		case err == ErrTooManyClientJobs:
+			httpErrLog(w, http.StatusTooManyRequests, err)
+			return
+		case err != nil:
+			httpErrLog(w, http.StatusInternalServerError, err)
+			return
+		}
+		status := *job // job is modified by the worker
+		go s.runJob(job)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", s.PathPrefix+"/jobs/"+job.ID)
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.Printf("job: %v", err)
+		}
+	}
+}
+
+// handleJobStatus returns the status of a job.
+func (s *Server) handleJobStatus() http.HandlerFunc {
+	if s.Jobs == nil {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "not configured", 400)
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, err := s.Jobs.Get(mux.Vars(r)["id"])
+		if err != nil {
+			httpErrLog(w, http.StatusNotFound, err)
+			return
+		}
+		if job.Status == JobDone {
+			job.Result = s.PathPrefix + "/jobs/" + job.ID + "/result"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(job); err != nil {
+			httpErrLog(w, http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// handleJobResult serves the result of a finished job; it responds with 409
+// Conflict, if the job is not done yet.
+func (s *Server) handleJobResult() http.HandlerFunc {
+	if s.Jobs == nil {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "not configured", 400)
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, err := s.Jobs.Get(mux.Vars(r)["id"])
+		if err != nil {
+			httpErrLog(w, http.StatusNotFound, err)
+			return
+		}
+		switch job.Status {
+		case JobDone:
+		case JobFailed:
+			httpErrLogf(w, http.StatusInternalServerError, "job failed: %s", job.Err)
+			return
+		default:
+			httpErrLogf(w, http.StatusConflict, "job %s", job.Status)
+			return
+		}
+		f, err := os.Open(job.path)
+		if err != nil {
+			httpErrLog(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeContent(w, r, "", *job.Finished, f)
+	}
+}
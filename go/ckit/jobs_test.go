@@ -0,0 +1,119 @@
+package ckit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/cache"
+	"github.com/thoas/stats"
+)
+
+func TestJobs(t *testing.T) {
+	srv := &Server{
+		Router:   mux.NewRouter(),
+		NotFound: cache.NewTTLSet(time.Minute),
+		Stats:    stats.New(),
+		Jobs:     NewJobs(t.TempDir(), 1),
+	}
+	defer srv.Jobs.Close()
+	srv.Routes()
+	// Known misses do not need any database.
+	srv.NotFound.Add("id:a")
+	srv.NotFound.Add("id:b")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("POST", "/jobs", strings.NewReader(`{"ids": ["a", "b"]}`)))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("got %v, want %v", rr.Code, http.StatusAccepted)
+	}
+	var job Job
+	if err := json.Unmarshal(rr.Body.Bytes(), &job); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got := rr.Header().Get("Location"); got != "/jobs/"+job.ID {
+		t.Fatalf("got %v, want /jobs/%v", got, job.ID)
+	}
+	for i := 0; job.Status != JobDone; i++ {
+		if i == 100 || job.Status == JobFailed {
+			t.Fatalf("job did not finish: %v", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/jobs/"+job.ID, nil))
+		if err := json.Unmarshal(rr.Body.Bytes(), &job); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+	}
+	if job.Result != "/jobs/"+job.ID+"/result" {
+		t.Fatalf("got %v, want result path", job.Result)
+	}
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", job.Result, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v, want %v", rr.Code, http.StatusOK)
+	}
+	var result []struct {
+		Status int `json:"status"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(result) != 2 || result[0].Status != 404 || result[1].Status != 404 {
+		t.Fatalf("got %v, want two not found responses", result)
+	}
+	var cases = []struct {
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"POST", "/jobs", `{}`, http.StatusBadRequest},
+		{"POST", "/jobs", `xxx`, http.StatusBadRequest},
+		{"GET", "/jobs/xxx", "", http.StatusNotFound},
+		{"GET", "/jobs/xxx/result", "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(c.method, c.target, strings.NewReader(c.body)))
+		if rr.Code != c.status {
+			t.Fatalf("%s %s: got %v, want %v", c.method, c.target, rr.Code, c.status)
+		}
+	}
+}
+
+func TestJobsPerClient(t *testing.T) {
+	srv := &Server{
+		Router:   mux.NewRouter(),
+		NotFound: cache.NewTTLSet(time.Minute),
+		Stats:    stats.New(),
+		Jobs:     NewJobs(t.TempDir(), 1),
+	}
+	defer srv.Jobs.Close()
+	srv.Jobs.MaxPerClient = 1
+	srv.Routes()
+	srv.NotFound.Add("id:a")
+	// Occupy the only worker, so jobs stay queued.
+	srv.Jobs.sem <- struct{}{}
+	defer func() { <-srv.Jobs.sem }()
+	var cases = []struct {
+		remoteAddr string
+		status     int
+	}{
+		{"192.0.2.1:1234", http.StatusAccepted},
+		{"192.0.2.1:5678", http.StatusTooManyRequests},
+		{"192.0.2.2:1234", http.StatusAccepted},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/jobs", strings.NewReader(`{"id": "a"}`))
+		req.RemoteAddr = c.remoteAddr
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != c.status {
+			t.Fatalf("%s: got %v, want %v", c.remoteAddr, rr.Code, c.status)
+		}
+	}
+}
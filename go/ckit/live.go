@@ -0,0 +1,195 @@
+package ckit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// DefaultLiveBatchSize is the number of DOI looked up in a live index with a
+// single query.
+const DefaultLiveBatchSize = 100
+
+// LiveIndex finds documents by DOI in a live search index. The index data is
+// a snapshot, so documents added to the live index since the snapshot was
+// taken would otherwise appear as unmatched.
+type LiveIndex interface {
+	// LookupDOI returns documents for DOI, keyed by DOI, as given. DOI not
+	// found are missing from the result.
+	LookupDOI(ctx context.Context, dois []string) (map[string][]byte, error)
+}
+
+// SolrIndex looks up documents in SOLR, e.g. a VuFind index.
+type SolrIndex struct {
+	// URL of the select handler, e.g. http://localhost:8983/solr/biblio/select.
+	URL string
+	// Field containing the DOI, e.g. doi_str_mv.
+	Field  string
+	Client *http.Client
+}
+
+// LookupDOI queries the DOI field for all DOI at once.
+func (idx *SolrIndex) LookupDOI(ctx context.Context, dois []string) (map[string][]byte, error) {
+	quoted := make([]string, len(dois))
+	for i, doi := range dois {
+		quoted[i] = `"` + solrPhraseEscaper.Replace(doi) + `"`
+	}
+	vs := url.Values{}
+	vs.Set("q", fmt.Sprintf("%s:(%s)", idx.Field, strings.Join(quoted, " OR ")))
+	vs.Set("rows", strconv.Itoa(len(dois)))
+	vs.Set("wt", "json")
+	req, err := http.NewRequestWithContext(ctx, "POST", idx.URL,
+		strings.NewReader(vs.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setTraceHeaders(req)
+	var resp struct {
+		Response struct {
+			Docs []json.RawMessage `json:"docs"`
+		} `json:"response"`
+	}
+	if err := doJSON(liveClient(idx.Client), req, &resp); err != nil {
+		return nil, fmt.Errorf("solr: %w", err)
+	}
+	return matchDOI(resp.Response.Docs, idx.Field, dois), nil
+}
+
+// solrPhraseEscaper escapes a value for use in a quoted SOLR phrase.
+var solrPhraseEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// ElasticIndex looks up documents in elasticsearch.
+type ElasticIndex struct {
+	// URL of the search endpoint, e.g. http://localhost:9200/biblio/_search.
+	URL string
+	// Field containing the DOI, should be a keyword field.
+	Field  string
+	Client *http.Client
+}
+
+// LookupDOI runs a terms query on the DOI field.
+func (idx *ElasticIndex) LookupDOI(ctx context.Context, dois []string) (map[string][]byte, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"size": len(dois),
+		"query": map[string]interface{}{
+			"terms": map[string]interface{}{idx.Field: dois},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", idx.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setTraceHeaders(req)
+	var resp struct {
+		Hits struct {
+			Hits []struct {
+				Source json.RawMessage `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := doJSON(liveClient(idx.Client), req, &resp); err != nil {
+		return nil, fmt.Errorf("elasticsearch: %w", err)
+	}
+	docs := make([]json.RawMessage, len(resp.Hits.Hits))
+	for i, hit := range resp.Hits.Hits {
+		docs[i] = hit.Source
+	}
+	return matchDOI(docs, idx.Field, dois), nil
+}
+
+// defaultLiveClient is used for live index lookups without a client.
+var defaultLiveClient = NewHTTPClient(DefaultMaxIdleConnsPerHost, 10*time.Second)
+
+// liveClient returns the given client or a default one.
+func liveClient(client *http.Client) *http.Client {
+	if client == nil {
+		return defaultLiveClient
+	}
+	return client
+}
+
+// doJSON runs a request and decodes a JSON response.
+func doJSON(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return fmt.Errorf("%s: %s", req.URL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// matchDOI assigns documents to the requested DOI, by the value of a field,
+// which may be a string or a list of strings. DOI are case insensitive.
+func matchDOI(docs []json.RawMessage, field string, dois []string) map[string][]byte {
+	var (
+		lower  = make(map[string]string, len(dois))
+		result = make(map[string][]byte)
+	)
+	for _, doi := range dois {
+		lower[strings.ToLower(doi)] = doi
+	}
+	for _, doc := range docs {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(doc, &fields); err != nil {
+			continue
+		}
+		var values []string
+		if err := json.Unmarshal(fields[field], &values); err != nil {
+			var v string
+			if err := json.Unmarshal(fields[field], &v); err != nil {
+				continue
+			}
+			values = []string{v}
+		}
+		for _, v := range values {
+			if doi, ok := lower[strings.ToLower(v)]; ok {
+				if _, seen := result[doi]; !seen {
+					result[doi] = doc
+				}
+			}
+		}
+	}
+	return result
+}
+
+// lookupLive looks up DOI in the live index, in batches. Documents are
+// prepared like index data, cf. prepareBlob. Lookups are best effort; on
+// errors, the documents found so far are returned.
+func (s *Server) lookupLive(ctx context.Context, response *Response, dois []string) map[string][]byte {
+	result := make(map[string][]byte)
+	for i := 0; i < len(dois); i += DefaultLiveBatchSize {
+		t := time.Now()
+		batch := dois[i:minInt(i+DefaultLiveBatchSize, len(dois))]
+		docs, err := s.LiveIndex.LookupDOI(ctx, batch)
+		if err != nil {
+			log.Printf("live index: %v", err)
+			break
+		}
+		s.Stats.MeasureSinceWithLabels("live_index_lookup", t, nil)
+		for doi, b := range docs {
+			if b, err = s.prepareBlob(response, doi, b); err == nil {
+				result[doi] = b
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,73 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+)
+
+func TestSolrIndex(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if q := r.Form.Get("q"); q != `doi_str_mv:("10.1/a" OR "10.1/\"b")` {
+			t.Errorf("unexpected query: %s", q)
+		}
+		fmt.Fprint(w, `{"response": {"docs": [
+			{"id": "1", "doi_str_mv": ["10.1/A"]},
+			{"id": "2", "doi_str_mv": "10.1/x"}
+		]}}`)
+	}))
+	defer ts.Close()
+	idx := &SolrIndex{URL: ts.URL, Field: "doi_str_mv"}
+	docs, err := idx.LookupDOI(context.Background(), []string{"10.1/a", `10.1/"b`})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if len(docs) != 1 || !strings.Contains(string(docs["10.1/a"]), `"id": "1"`) {
+		t.Fatalf("unexpected docs: %v", docs)
+	}
+}
+
+func TestElasticIndex(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Query struct {
+				Terms map[string][]string `json:"terms"`
+			} `json:"query"`
+		}
+		if err := json.Unmarshal(b, &body); err != nil {
+			t.Fatal(err)
+		}
+		if v := body.Query.Terms["doi"]; len(v) != 2 {
+			t.Errorf("unexpected terms: %v", body.Query.Terms)
+		}
+		fmt.Fprint(w, `{"hits": {"hits": [{"_source": {"id": "2", "doi": "10.1/b"}}]}}`)
+	}))
+	defer ts.Close()
+	idx := &ElasticIndex{URL: ts.URL, Field: "doi"}
+	docs, err := idx.LookupDOI(context.Background(), []string{"10.1/a", "10.1/b"})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if len(docs) != 1 || docs["10.1/b"] == nil {
+		t.Fatalf("unexpected docs: %v", docs)
+	}
+	// Failed lookups leave DOI unmatched.
+	ts.Close()
+	srv := &Server{LiveIndex: idx}
+	if found := srv.lookupLive(context.Background(), &Response{}, []string{"10.1/b"}); len(found) != 0 {
+		t.Fatalf("got %v, want no documents", found)
+	}
+}
@@ -0,0 +1,295 @@
+// Package loadtest replays requests for a list of identifiers against a
+// server with increasing concurrency and reports latency percentiles, error
+// rates and throughput over time, like the parallel and curl one-liners we
+// used to benchmark labed with, but reproducible.
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result of a single request.
+type Result struct {
+	Latency time.Duration
+	Status  int
+	Err     error
+}
+
+// failed returns true, if the request failed; a 404 is an answer, since
+// many identifiers have no citations.
+func (r Result) failed() bool {
+	return r.Err != nil || r.Status >= 500
+}
+
+// Report summarizes the results of a time window or of a whole run.
+type Report struct {
+	Elapsed     time.Duration `json:"elapsed"`
+	Concurrency int           `json:"concurrency"`
+	Requests    int           `json:"requests"`
+	Errors      int           `json:"errors"`
+	NotFound    int           `json:"not_found"`
+	ErrorRate   float64       `json:"error_rate"`
+	Throughput  float64       `json:"throughput"` // requests per second
+	P50         time.Duration `json:"p50"`
+	P90         time.Duration `json:"p90"`
+	P99         time.Duration `json:"p99"`
+	Max         time.Duration `json:"max"`
+}
+
+// String formats a report as a single line.
+func (r Report) String() string {
+	return fmt.Sprintf("%8s  c=%-4d  n=%-7d  err=%5.2f%%  404=%-6d  %8.1f req/s  p50=%-10s  p90=%-10s  p99=%-10s  max=%s",
+		r.Elapsed.Round(time.Second), r.Concurrency, r.Requests, r.ErrorRate*100, r.NotFound,
+		r.Throughput, round(r.P50), round(r.P90), round(r.P99), round(r.Max))
+}
+
+// round rounds a latency for display.
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
+
+// Window collects results.
+type Window struct {
+	latencies []time.Duration
+	errors    int
+	notFound  int
+}
+
+// Add adds a result.
+func (w *Window) Add(r Result) {
+	w.latencies = append(w.latencies, r.Latency)
+	switch {
+	case r.failed():
+		w.errors++
+	case r.Status == http.StatusNotFound:
+		w.notFound++
+	}
+}
+
+// Count returns the number of results collected.
+func (w *Window) Count() int {
+	return len(w.latencies)
+}
+
+// Report summarizes the results collected over a duration.
+func (w *Window) Report(d time.Duration) Report {
+	sorted := make([]time.Duration, len(w.latencies))
+	copy(sorted, w.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	r := Report{
+		Requests: len(sorted),
+		Errors:   w.errors,
+		NotFound: w.notFound,
+		P50:      Percentile(sorted, 50),
+		P90:      Percentile(sorted, 90),
+		P99:      Percentile(sorted, 99),
+		Max:      Percentile(sorted, 100),
+	}
+	if r.Requests > 0 {
+		r.ErrorRate = float64(r.Errors) / float64(r.Requests)
+	}
+	if d > 0 {
+		r.Throughput = float64(r.Requests) / d.Seconds()
+	}
+	return r
+}
+
+// Percentile returns the p-th percentile of sorted latencies, using the
+// nearest rank method; zero for no latencies.
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted)) + 0.5)
+	switch {
+	case rank < 1:
+		rank = 1
+	case rank > len(sorted):
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// Runner replays identifiers against a server. Concurrency starts at Start
+// and grows by Step every RampInterval, up to Max.
+type Runner struct {
+	// URL is a template, with %s replaced by the (escaped) identifier, e.g.
+	// http://localhost:8000/id/%s.
+	URL    string
+	Client *http.Client
+	// Start, Step and Max concurrency; Step zero means constant concurrency.
+	Start        int
+	Step         int
+	Max          int
+	RampInterval time.Duration
+	// ReportInterval is the time between two reports; Report is called
+	// with the results of each interval.
+	ReportInterval time.Duration
+	Report         func(Report)
+}
+
+// Run replays all identifiers once and returns a report over the whole run.
+func (r *Runner) Run(ctx context.Context, ids []string) (Report, error) {
+	if !strings.Contains(r.URL, "%s") {
+		return Report{}, fmt.Errorf("URL template needs a %%s placeholder: %s", r.URL)
+	}
+	var (
+		client  = r.Client
+		queue   = make(chan string)
+		results = make(chan Result)
+		wg      sync.WaitGroup
+		started = time.Now()
+		workers int
+		start   = r.Start
+		max     = r.Max
+	)
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if start < 1 {
+		start = 1
+	}
+	if max < start {
+		max = start
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	spawn := func(n int) {
+		for i := 0; i < n && workers < max; i++ {
+			workers++
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for id := range queue {
+					select {
+					case results <- r.fetch(ctx, client, id):
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+		}
+	}
+	go func() {
+		defer close(queue)
+		for _, id := range ids {
+			select {
+			case queue <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	spawn(start)
+	var (
+		done       = make(chan struct{})
+		rampTicker = newTicker(r.RampInterval, r.Step > 0)
+		repTicker  = newTicker(r.ReportInterval, r.Report != nil)
+		window     = &Window{}
+		total      = &Window{}
+		lastReport = started
+	)
+	defer rampTicker.Stop()
+	defer repTicker.Stop()
+	// Workers are only added from this goroutine and only while there are
+	// results outstanding, so the wait group is never incremented while we
+	// wait.
+	var once sync.Once
+	waitDone := func() {
+		once.Do(func() {
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+		})
+	}
+	if len(ids) == 0 {
+		waitDone()
+	}
+	for {
+		select {
+		case res := <-results:
+			window.Add(res)
+			total.Add(res)
+			if total.Count() == len(ids) {
+				waitDone()
+			}
+		case <-rampTicker.C():
+			if total.Count() < len(ids) {
+				spawn(r.Step)
+			}
+		case <-repTicker.C():
+			now := time.Now()
+			rep := window.Report(now.Sub(lastReport))
+			rep.Elapsed, rep.Concurrency = now.Sub(started), workers
+			r.Report(rep)
+			window, lastReport = &Window{}, now
+		case <-done:
+			if r.Report != nil && window.Count() > 0 {
+				now := time.Now()
+				rep := window.Report(now.Sub(lastReport))
+				rep.Elapsed, rep.Concurrency = now.Sub(started), workers
+				r.Report(rep)
+			}
+			rep := total.Report(time.Since(started))
+			rep.Elapsed, rep.Concurrency = time.Since(started), workers
+			return rep, nil
+		case <-ctx.Done():
+			rep := total.Report(time.Since(started))
+			rep.Elapsed, rep.Concurrency = time.Since(started), workers
+			return rep, ctx.Err()
+		}
+	}
+}
+
+// fetch requests a single identifier and reads the whole response.
+func (r *Runner) fetch(ctx context.Context, client *http.Client, id string) Result {
+	started := time.Now()
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf(r.URL, url.PathEscape(id)), nil)
+	if err != nil {
+		return Result{Err: err}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Result{Latency: time.Since(started), Err: err}
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	return Result{Latency: time.Since(started), Status: resp.StatusCode, Err: err}
+}
+
+// ticker is a time.Ticker, which may be disabled.
+type ticker struct {
+	t *time.Ticker
+}
+
+func newTicker(d time.Duration, enabled bool) *ticker {
+	if !enabled || d <= 0 {
+		return &ticker{}
+	}
+	return &ticker{t: time.NewTicker(d)}
+}
+
+// C returns the ticker channel, nil for a disabled ticker, which blocks
+// forever in a select.
+func (t *ticker) C() <-chan time.Time {
+	if t.t == nil {
+		return nil
+	}
+	return t.t.C
+}
+
+func (t *ticker) Stop() {
+	if t.t != nil {
+		t.t.Stop()
+	}
+}
@@ -0,0 +1,124 @@
+package loadtest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	var cases = []struct {
+		sorted []time.Duration
+		p      float64
+		result time.Duration
+	}{
+		{nil, 50, 0},
+		{[]time.Duration{time.Second}, 99, time.Second},
+		{sorted, 0, 1 * time.Millisecond},
+		{sorted, 50, 50 * time.Millisecond},
+		{sorted, 90, 90 * time.Millisecond},
+		{sorted, 99, 99 * time.Millisecond},
+		{sorted, 100, 100 * time.Millisecond},
+		{sorted[:3], 50, 2 * time.Millisecond},
+	}
+	for i, c := range cases {
+		if got := Percentile(c.sorted, c.p); got != c.result {
+			t.Fatalf("[%d] got %v, want %v", i, got, c.result)
+		}
+	}
+}
+
+func TestWindowReport(t *testing.T) {
+	w := &Window{}
+	w.Add(Result{Latency: 3 * time.Millisecond, Status: 200})
+	w.Add(Result{Latency: 1 * time.Millisecond, Status: 404})
+	w.Add(Result{Latency: 2 * time.Millisecond, Status: 503})
+	w.Add(Result{Latency: 4 * time.Millisecond, Err: context.DeadlineExceeded})
+	r := w.Report(2 * time.Second)
+	if r.Requests != 4 || r.Errors != 2 || r.NotFound != 1 {
+		t.Fatalf("got %d requests, %d errors, %d not found", r.Requests, r.Errors, r.NotFound)
+	}
+	if r.ErrorRate != 0.5 || r.Throughput != 2 {
+		t.Fatalf("got error rate %v, throughput %v", r.ErrorRate, r.Throughput)
+	}
+	if r.P50 != 2*time.Millisecond || r.Max != 4*time.Millisecond {
+		t.Fatalf("got p50 %v, max %v", r.P50, r.Max)
+	}
+}
+
+func TestRunnerRun(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen = make(map[string]int)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/id/")
+		mu.Lock()
+		seen[id]++
+		mu.Unlock()
+		switch id {
+		case "missing":
+			http.NotFound(w, r)
+		case "broken":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	var (
+		ids     = []string{"a", "b", "missing", "c", "broken", "d", "e", "f"}
+		reports []Report
+		runner  = &Runner{
+			URL:            srv.URL + "/id/%s",
+			Start:          1,
+			Step:           2,
+			Max:            4,
+			RampInterval:   time.Millisecond,
+			ReportInterval: time.Hour,
+			Report:         func(r Report) { reports = append(reports, r) },
+		}
+	)
+	total, err := runner.Run(context.Background(), ids)
+	if err != nil {
+		t.Fatalf("got %v", err)
+	}
+	if total.Requests != len(ids) || total.Errors != 1 || total.NotFound != 1 {
+		t.Fatalf("got %d requests, %d errors, %d not found", total.Requests, total.Errors, total.NotFound)
+	}
+	if len(seen) != len(ids) {
+		t.Fatalf("got %d distinct requests, want %d", len(seen), len(ids))
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Fatalf("%s requested %d times", id, n)
+		}
+	}
+	// The last, partial interval is reported as well.
+	if len(reports) != 1 || reports[0].Requests != len(ids) {
+		t.Fatalf("got reports %v", reports)
+	}
+	if total.Concurrency < 1 || total.Concurrency > 4 {
+		t.Fatalf("got concurrency %d", total.Concurrency)
+	}
+}
+
+func TestRunnerRunEmpty(t *testing.T) {
+	runner := &Runner{URL: "http://localhost:0/id/%s", Step: 1, RampInterval: time.Millisecond}
+	total, err := runner.Run(context.Background(), nil)
+	if err != nil || total.Requests != 0 {
+		t.Fatalf("got %v, %v", total, err)
+	}
+	runner.URL = "http://localhost:0/id/"
+	if _, err := runner.Run(context.Background(), []string{"a"}); err == nil {
+		t.Fatalf("expected error for URL template without placeholder")
+	}
+}
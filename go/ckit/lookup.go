@@ -0,0 +1,85 @@
+package ckit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/thoas/stats"
+)
+
+// Lookup resolves local ids to responses in-process, without the HTTP
+// server, e.g. to enrich records while building an index. It uses the same
+// steps as the server, cf. Resolver, but no caches. A lookup is safe for
+// concurrent use.
+//
+//	l, err := ckit.OpenLookup(&ckit.Datasets{
+//		Identifier: "id_doi.db",
+//		Oci:        "doi_doi.db",
+//		Index:      []string{"id_metadata.db"},
+//	})
+//	...
+//	defer l.Close()
+//	resp, err := l.Resolve(ctx, "ai-49-aHR0c...", ckit.ResolveOptions{Blobs: true})
+//	if ckit.IsNotFound(err) { ... }
+type Lookup struct {
+	// Server holds databases and settings, like MaxEdges, NoCase,
+	// timeouts or Degraded; set them before the first call to Resolve. Only
+	// settings related to resolving an id apply.
+	Server *Server
+	owned  bool // databases were opened by OpenLookup
+}
+
+// NewLookup returns a lookup for existing databases; index may be nil, if
+// only local id and DOI are needed. The caller is responsible for closing
+// the databases.
+func NewLookup(identifier, oci *sqlx.DB, index Fetcher) *Lookup {
+	return &Lookup{
+		Server: &Server{
+			IdentifierDatabase: identifier,
+			OciDatabase:        oci,
+			IndexData:          index,
+			Stats:              stats.New(),
+		},
+	}
+}
+
+// OpenLookup opens the databases of a dataset, cf. ReadManifest; Close
+// closes them.
+func OpenLookup(ds *Datasets) (*Lookup, error) {
+	identifier, oci, index, err := openDatasets(ds, false, 0)
+	if err != nil {
+		return nil, err
+	}
+	l := NewLookup(identifier, oci, index)
+	l.Server.Datasets = ds
+	l.owned = true
+	return l, nil
+}
+
+// Resolve returns the response for a local id. If the id is unknown or
+// there are no citations, the error satisfies IsNotFound. Index data is
+// only fetched with opts.Blobs set.
+func (l *Lookup) Resolve(ctx context.Context, id string, opts ResolveOptions) (*Response, error) {
+	return l.Server.NewResolver(id, opts, nil).Run(ctx)
+}
+
+// Close closes the databases, if they were opened by OpenLookup.
+func (l *Lookup) Close() error {
+	if !l.owned {
+		return nil
+	}
+	closeFetcher(l.Server.IndexData)
+	if err := l.Server.IdentifierDatabase.Close(); err != nil {
+		l.Server.OciDatabase.Close()
+		return err
+	}
+	return l.Server.OciDatabase.Close()
+}
+
+// IsNotFound returns true, if a lookup failed, because an id has no DOI or
+// the DOI has no citations.
+func IsNotFound(err error) bool {
+	return errors.Is(err, sql.ErrNoRows) || errors.Is(err, errNoCitations)
+}
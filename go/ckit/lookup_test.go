@@ -0,0 +1,72 @@
+package ckit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestLookupResolve(t *testing.T) {
+	h := newHarness(t, nil)
+	l := NewLookup(h.srv.IdentifierDatabase, h.srv.OciDatabase, h.fetcher)
+	id, doi := h.someID(t)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := l.Resolve(context.Background(), id, ResolveOptions{Blobs: true})
+			if err != nil {
+				errs <- err
+				return
+			}
+			if r.DOI != doi {
+				errs <- fmt.Errorf("got %s, want %s", r.DOI, doi)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	r, err := l.Resolve(context.Background(), id, ResolveOptions{Blobs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResponse(t, id, r, h.expect(doi))
+	for _, id := range []string{"unknown", lonelyID} {
+		if _, err := l.Resolve(context.Background(), id, ResolveOptions{}); !IsNotFound(err) {
+			t.Fatalf("%s: got %v, want not found", id, err)
+		}
+	}
+	// Databases are not owned by the lookup.
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.srv.IdentifierDatabase.Ping(); err != nil {
+		t.Fatalf("got %v, want open database", err)
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	var cases = []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("disk I/O error"), false},
+		{fmt.Errorf("doi lookup (a): %w", sql.ErrNoRows), true},
+		{fmt.Errorf("%w: 10.1/2", errNoCitations), true},
+		{context.Canceled, false},
+	}
+	for i, c := range cases {
+		if got := IsNotFound(c.err); got != c.want {
+			t.Fatalf("[%d] got %v, want %v", i, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package ckit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"github.com/segmentio/encoding/json"
+)
+
+// namedDB is a database in use, with a name for reports.
+type namedDB struct {
+	name string
+	db   *sqlx.DB
+}
+
+// databases returns the identifier, citation and sqlite index databases in
+// use, named identifier, oci, index, index-1 and so on, and the counts
+// database, if any.
+func (s *Server) databases() []namedDB {
+	s.dataMu.RLock()
+	identifier, oci, index, counts := s.IdentifierDatabase, s.OciDatabase, s.IndexData, s.CountsDatabase
+	s.dataMu.RUnlock()
+	dbs := []namedDB{{"identifier", identifier}, {"oci", oci}}
+	for i, sf := range sqliteBackends(index) {
+		name := "index"
+		if i > 0 {
+			name = fmt.Sprintf("index-%d", i)
+		}
+		dbs = append(dbs, namedDB{name, sf.DB})
+	}
+	if counts != nil {
+		dbs = append(dbs, namedDB{"counts", counts})
+	}
+	return dbs
+}
+
+// MaintenanceResult reports the maintenance of a single database.
+type MaintenanceResult struct {
+	Name string `json:"name"`
+	// Busy, Log and Checkpointed are the results of wal_checkpoint: whether
+	// the checkpoint was blocked, the number of frames in the write-ahead
+	// log and the number of frames moved into the database; -1 for
+	// databases not in WAL mode.
+	Busy         int `json:"busy"`
+	Log          int `json:"log"`
+	Checkpointed int `json:"checkpointed"`
+	// Optimized is false for read-only databases, if sqlite wanted to
+	// update statistics.
+	Optimized bool    `json:"optimized"`
+	Took      float64 `json:"took"`
+	Err       string  `json:"err,omitempty"`
+}
+
+// MaintenanceResponse is the response for /maintenance.
+type MaintenanceResponse struct {
+	Databases []MaintenanceResult `json:"databases"`
+	Took      float64             `json:"took"`
+}
+
+// failed returns true, if maintenance failed for any database.
+func (r *MaintenanceResponse) failed() bool {
+	for _, v := range r.Databases {
+		if v.Err != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// maintainDatabase checkpoints the write-ahead log of a database, if any,
+// and lets sqlite update its query planner statistics, cf.
+// https://www.sqlite.org/pragma.html#pragma_optimize.
+func maintainDatabase(ctx context.Context, db *sqlx.DB) (result MaintenanceResult, err error) {
+	row := db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)")
+	if err := row.Scan(&result.Busy, &result.Log, &result.Checkpointed); err != nil {
+		return result, fmt.Errorf("wal_checkpoint: %w", err)
+	}
+	_, err = db.ExecContext(ctx, "PRAGMA optimize")
+	var e sqlite3.Error
+	switch {
+	case errors.As(err, &e) && e.Code == sqlite3.ErrReadonly:
+		return result, nil
+	case err != nil:
+		return result, fmt.Errorf("optimize: %w", err)
+	}
+	result.Optimized = true
+	return result, nil
+}
+
+// Maintain checkpoints and optimizes all databases in use, including the
+// open access and additional edge source databases. It is meant to be run
+// during quiet hours on long running instances; only one maintenance runs
+// at a time.
+func (s *Server) Maintain(ctx context.Context) *MaintenanceResponse {
+	s.maintenanceMu.Lock()
+	defer s.maintenanceMu.Unlock()
+	var (
+		started = time.Now()
+		dbs     = s.databases()
+		resp    = &MaintenanceResponse{Databases: []MaintenanceResult{}}
+	)
+	if s.OADatabase != nil {
+		dbs = append(dbs, namedDB{"oa", s.OADatabase})
+	}
+	for _, src := range s.EdgeSources {
+		dbs = append(dbs, namedDB{"edges-" + src.Name, src.DB})
+	}
+	for _, v := range dbs {
+		t := time.Now()
+		result, err := maintainDatabase(ctx, v.db)
+		result.Name = v.name
+		result.Took = time.Since(t).Seconds()
+		if err != nil {
+			result.Err = err.Error()
+			log.Printf("maintenance %s: %v", v.name, err)
+		}
+		resp.Databases = append(resp.Databases, result)
+	}
+	resp.Took = time.Since(started).Seconds()
+	log.Printf("maintenance of %d databases took %0.3fs", len(dbs), resp.Took)
+	return resp
+}
+
+// handleMaintenance runs maintenance on all databases; it responds with 500,
+// if maintenance failed for any of them.
+func (s *Server) handleMaintenance() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := s.Maintain(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if resp.failed() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("maintenance: %v", err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package ckit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/segmentio/encoding/json"
+)
+
+func TestMaintenance(t *testing.T) {
+	a, err := OpenDatabase("testdata/id_doi.db")
+	if err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	b, err := OpenDatabase("testdata/doi_doi.db")
+	if err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	g := &FetchGroup{}
+	if err := g.FromFiles("testdata/id_metadata.db"); err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	srv := &Server{
+		IdentifierDatabase: a,
+		OciDatabase:        b,
+		IndexData:          g,
+		EdgeSources:        []EdgeSource{{Name: "ced", DB: b}},
+		Router:             mux.NewRouter(),
+		AdminToken:         "secret",
+	}
+	srv.Routes()
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/maintenance", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200: %s", rr.Code, rr.Body.String())
+	}
+	var resp MaintenanceResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, v := range resp.Databases {
+		names = append(names, v.Name)
+		// Test data is not in WAL mode.
+		if v.Log != -1 || v.Checkpointed != -1 {
+			t.Fatalf("%s: got %d, %d frames, want -1", v.Name, v.Log, v.Checkpointed)
+		}
+	}
+	if want := []string{"identifier", "oci", "index", "edges-ced"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+}
+
+func TestMaintenanceResponseFailed(t *testing.T) {
+	resp := &MaintenanceResponse{Databases: []MaintenanceResult{{Name: "identifier"}, {Name: "oci"}}}
+	if resp.failed() {
+		t.Fatalf("got failed, want ok")
+	}
+	resp.Databases[1].Err = "optimize: disk I/O error"
+	if !resp.failed() {
+		t.Fatalf("got ok, want failed")
+	}
+}
@@ -0,0 +1,90 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+// memoryDatabaseCount makes the names of in-memory databases unique.
+var memoryDatabaseCount int64
+
+// LoadDatabase copies a database, tables and indices, into memory and
+// returns a connection to the copy, like OpenDatabase; this is useful for the
+// identifier database, which is small compared to the others and queried
+// twice in every request. The copy is shared by all connections of the pool
+// through the memdb VFS; the pool keeps its connections open, since the copy
+// is gone, once the last connection is closed. Close releases the memory.
+func LoadDatabase(ctx context.Context, filename string) (*sqlx.DB, error) {
+	if len(filename) == 0 {
+		return nil, fmt.Errorf("empty file")
+	}
+	filename, err := resolveShardManifest(filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil, fmt.Errorf("file not found: %s", filename)
+	}
+	name := fmt.Sprintf("file:/labe-memory-%d?vfs=memdb", atomic.AddInt64(&memoryDatabaseCount, 1))
+	db, err := sqlx.Open(sqliteDriver, name)
+	if err != nil {
+		return nil, err
+	}
+	// Connections are never closed for being idle or old.
+	n := 4 * runtime.NumCPU()
+	db.SetMaxOpenConns(n)
+	db.SetMaxIdleConns(n)
+	if err := copyDatabase(ctx, db, filename); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("load %s: %w", filename, err)
+	}
+	return db, nil
+}
+
+// copyDatabase copies a database file, tables and indices, into db with the
+// sqlite backup API. The file is opened separately, since a database attached
+// to a memdb connection would use the memdb VFS as well and appear empty.
+func copyDatabase(ctx context.Context, db *sqlx.DB, filename string) error {
+	src, err := OpenDatabase(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	dstConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+	return dstConn.Raw(func(dc interface{}) error {
+		return srcConn.Raw(func(sc interface{}) error {
+			dst, ok := dc.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("not a sqlite connection: %T", dc)
+			}
+			src, ok := sc.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("not a sqlite connection: %T", sc)
+			}
+			b, err := dst.Backup("main", src, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := b.Step(-1); err != nil {
+				b.Close()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
@@ -0,0 +1,56 @@
+package ckit
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLoadDatabase(t *testing.T) {
+	ctx := context.Background()
+	if _, err := LoadDatabase(ctx, "testdata/missing.db"); err == nil {
+		t.Fatalf("missing file: want error")
+	}
+	disk, err := OpenDatabase("testdata/id_doi.db")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer disk.Close()
+	mem, err := LoadDatabase(ctx, "testdata/id_doi.db")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	defer mem.Close()
+	var want, got int
+	if err := disk.GetContext(ctx, &want, "SELECT count(*) FROM map"); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if err := mem.GetContext(ctx, &got, "SELECT count(*) FROM map"); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if got != want || got == 0 {
+		t.Fatalf("got %d rows, want %d", got, want)
+	}
+	// Indices are copied as well.
+	var indices int
+	if err := mem.GetContext(ctx, &indices, "SELECT count(*) FROM sqlite_master WHERE type = 'index'"); err != nil {
+		t.Fatalf("indices: %v", err)
+	}
+	if indices == 0 {
+		t.Fatalf("no indices copied")
+	}
+	// Two loads do not share data.
+	other, err := LoadDatabase(ctx, "testdata/id_doi.db")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	defer other.Close()
+	if _, err := other.ExecContext(ctx, "DELETE FROM map"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := mem.GetContext(ctx, &got, "SELECT count(*) FROM map"); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if got != want {
+		t.Fatalf("got %d rows after delete in other copy, want %d", got, want)
+	}
+}
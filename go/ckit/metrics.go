@@ -0,0 +1,146 @@
+package ckit
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// countBuckets are the upper bounds for blob and edge counts.
+	countBuckets = []float64{0, 1, 10, 50, 100, 500, 1000, 5000, 10000, 50000, 100000}
+	// sizeBuckets are the upper bounds for response sizes, 1K to 64M.
+	sizeBuckets = []float64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20}
+	// latencyBuckets are the upper bounds for request durations in seconds.
+	latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+)
+
+// histogram counts observations in cumulative buckets, like a prometheus
+// histogram; thread-safe.
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+// Observe records a single value.
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// writeTo writes the histogram in prometheus text format.
+func (h *histogram) writeTo(w io.Writer, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var c uint64
+	for i, b := range h.bounds {
+		c += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(b, 'g', -1, 64), c)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// workload tracks the shape of /id/{id} requests, for capacity planning of
+// the index data backend.
+type workload struct {
+	once  sync.Once
+	blobs *histogram // index data blobs fetched
+	bytes *histogram // response bytes sent
+	edges *histogram // citing and cited DOI
+	// Latencies of cacheable requests, served from the cache or not, cf.
+	// Server.CacheTriggerDuration.
+	hits, misses *histogram
+	mu           sync.Mutex
+	hitBytes     int64 // response bytes served from the cache, guarded by mu
+}
+
+func (wl *workload) init() {
+	wl.once.Do(func() {
+		wl.blobs = newHistogram(countBuckets)
+		wl.bytes = newHistogram(sizeBuckets)
+		wl.edges = newHistogram(countBuckets)
+		wl.hits = newHistogram(latencyBuckets)
+		wl.misses = newHistogram(latencyBuckets)
+	})
+}
+
+// observe records the blobs and edges of a resolved request; degraded
+// requests and requests without blobs do not fetch index data.
+func (wl *workload) observe(rv *Resolver) {
+	wl.init()
+	wl.edges.Observe(float64(rv.outbound.Len() + rv.inbound.Len()))
+	if rv.opts.Blobs && !rv.degraded {
+		wl.blobs.Observe(float64(len(rv.ids)))
+	}
+}
+
+// observeBytes records the size of a response.
+func (wl *workload) observeBytes(n int64) {
+	wl.init()
+	wl.bytes.Observe(float64(n))
+}
+
+// observeCache records the latency of a cacheable request and the bytes
+// sent for cache hits.
+func (wl *workload) observeCache(hit bool, took time.Duration, n int64) {
+	wl.init()
+	if !hit {
+		wl.misses.Observe(took.Seconds())
+		return
+	}
+	wl.hits.Observe(took.Seconds())
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	wl.hitBytes += n
+}
+
+// sizeWriter counts the bytes of the response body.
+type sizeWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *sizeWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// handleMetrics exposes the workload histograms in prometheus text format.
+func (s *Server) handleMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.workload.init()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		s.workload.blobs.writeTo(w, "labed_blob_count", "Index data blobs fetched per request.")
+		s.workload.bytes.writeTo(w, "labed_response_bytes", "Response body size in bytes.")
+		s.workload.edges.writeTo(w, "labed_edges", "Citing and cited DOI per request.")
+		s.workload.hits.writeTo(w, "labed_cache_hit_seconds", "Latency of requests served from the cache.")
+		s.workload.misses.writeTo(w, "labed_cache_miss_seconds", "Latency of cacheable requests not found in the cache.")
+		s.workload.mu.Lock()
+		hitBytes := s.workload.hitBytes
+		s.workload.mu.Unlock()
+		fmt.Fprintf(w, "# HELP labed_cache_hit_bytes_total Response bytes served from the cache.\n"+
+			"# TYPE labed_cache_hit_bytes_total counter\nlabed_cache_hit_bytes_total %d\n", hitBytes)
+	}
+}
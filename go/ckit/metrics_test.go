@@ -0,0 +1,66 @@
+package ckit
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{1, 10})
+	for _, v := range []float64{0, 1, 5, 100} {
+		h.Observe(v)
+	}
+	var buf bytes.Buffer
+	h.writeTo(&buf, "x", "Test.")
+	for _, want := range []string{
+		`x_bucket{le="1"} 2`,
+		`x_bucket{le="10"} 3`,
+		`x_bucket{le="+Inf"} 4`,
+		`x_sum 106`,
+		`x_count 4`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Fatalf("missing %q in %s", want, buf.String())
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	h := newHarness(t, nil)
+	id, _ := h.someID(t)
+	h.getResponse(t, "/id/"+id)
+	resp, b := h.do(t, "GET", "/metrics")
+	if resp.StatusCode != 200 {
+		t.Fatalf("got %v, want 200", resp.StatusCode)
+	}
+	for _, want := range []string{
+		"labed_blob_count_count 1",
+		"labed_response_bytes_count 1",
+		"labed_edges_count 1",
+	} {
+		if !bytes.Contains(b, []byte(want+"\n")) {
+			t.Fatalf("missing %q in %s", want, b)
+		}
+	}
+}
+
+func TestMetricsCache(t *testing.T) {
+	h := newHarness(t, func(s *Server) {
+		s.Cache = newMemoryStore()
+	})
+	id, _ := h.someID(t)
+	h.getResponse(t, "/id/"+id)
+	_, b := h.do(t, "GET", "/id/"+id)
+	_, m := h.do(t, "GET", "/metrics")
+	for _, want := range []string{
+		"labed_cache_hit_seconds_count 1",
+		"labed_cache_miss_seconds_count 1",
+		"labed_cache_hit_bytes_total " + strconv.Itoa(len(b)),
+	} {
+		if !bytes.Contains(m, []byte(want+"\n")) {
+			t.Fatalf("missing %q in %s", want, m)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package ckit
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Middleware wraps a handler, e.g. to authenticate, log, measure or
+// compress requests.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps a handler with middleware; the first middleware is the
+// outermost, it sees a request first and the response last.
+func Chain(h http.Handler, mw ...Middleware) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// MiddlewareRegistry names the available middleware, so a chain can be
+// configured as a list of names, e.g. "stats,log,cors,gzip". A nil
+// middleware is known, but disabled, e.g. if compression is not
+// requested.
+type MiddlewareRegistry map[string]Middleware
+
+// Uses returns true, if a comma separated list names a middleware.
+func (r MiddlewareRegistry) Uses(s, name string) bool {
+	for _, v := range strings.Split(s, ",") {
+		if strings.TrimSpace(v) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse returns the middleware named in a comma separated list, in order,
+// leaving out disabled ones. Unknown and repeated names are an error.
+func (r MiddlewareRegistry) Parse(s string) ([]Middleware, error) {
+	var (
+		result []Middleware
+		seen   = make(map[string]bool)
+	)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		m, ok := r[name]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("unknown middleware: %s", name)
+		case seen[name]:
+			return nil, fmt.Errorf("middleware used more than once: %s", name)
+		}
+		seen[name] = true
+		if m != nil {
+			result = append(result, m)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,83 @@
+package ckit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// tagMiddleware appends a name to the X-Chain header of the response, before
+// and after calling the wrapped handler.
+func tagMiddleware(name string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Chain", name)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	h := Chain(http.NotFoundHandler(), tagMiddleware("a"), tagMiddleware("b"), tagMiddleware("c"))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if got := strings.Join(rr.Header().Values("X-Chain"), ","); got != "a,b,c" {
+		t.Fatalf("got %s, want a,b,c", got)
+	}
+}
+
+func TestMiddlewareRegistryParse(t *testing.T) {
+	registry := MiddlewareRegistry{
+		"a":   tagMiddleware("a"),
+		"b":   tagMiddleware("b"),
+		"off": nil,
+	}
+	var cases = []struct {
+		s    string
+		want string
+		err  bool
+	}{
+		{"", "", false},
+		{"a,b", "a,b", false},
+		{"b, a", "b,a", false},
+		{"off,a", "a", false},
+		{"a,c", "", true},
+		{"a,b,a", "", true},
+	}
+	for _, c := range cases {
+		mw, err := registry.Parse(c.s)
+		if (err != nil) != c.err {
+			t.Fatalf("%q: got %v, want error %v", c.s, err, c.err)
+		}
+		if err != nil {
+			continue
+		}
+		rr := httptest.NewRecorder()
+		Chain(http.NotFoundHandler(), mw...).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		if got := strings.Join(rr.Header().Values("X-Chain"), ","); got != c.want {
+			t.Fatalf("%q: got %s, want %s", c.s, got, c.want)
+		}
+	}
+	if !registry.Uses("b, off", "off") || registry.Uses("a,b", "off") {
+		t.Fatalf("uses: got wrong result")
+	}
+}
+
+func TestServerMiddleware(t *testing.T) {
+	srv := &Server{
+		Router:     mux.NewRouter(),
+		Middleware: []Middleware{tagMiddleware("a"), tagMiddleware("b")},
+	}
+	srv.Routes()
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("got %d, want 404", rr.Code)
+	}
+	if got := strings.Join(rr.Header().Values("X-Chain"), ","); got != "a,b" {
+		t.Fatalf("got %s, want a,b", got)
+	}
+}
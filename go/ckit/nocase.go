@@ -0,0 +1,38 @@
+package ckit
+
+import "strings"
+
+// doiColumn returns a column name for DOI comparisons, with a case
+// insensitive collation, if Server.NoCase is set. Such queries only use an
+// index created with the same collation, cf. makta -N.
+func (s *Server) doiColumn(name string) string {
+	if s.NoCase {
+		return name + " COLLATE NOCASE"
+	}
+	return name
+}
+
+// foldDOI lowercases a DOI, if Server.NoCase is set, so DOI from different
+// databases compare equal.
+func (s *Server) foldDOI(doi string) string {
+	if s.NoCase {
+		return strings.ToLower(doi)
+	}
+	return doi
+}
+
+// foldMaps lowercases DOI in the key or value column of query results, if
+// Server.NoCase is set.
+func (s *Server) foldMaps(ms []Map, key, value bool) {
+	if !s.NoCase {
+		return
+	}
+	for i := range ms {
+		if key {
+			ms[i].Key = strings.ToLower(ms[i].Key)
+		}
+		if value {
+			ms[i].Value = strings.ToLower(ms[i].Value)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package ckit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNoCase(t *testing.T) {
+	s := &Server{}
+	if got := s.doiColumn("v"); got != "v" {
+		t.Fatalf("got %q, want v", got)
+	}
+	ms := []Map{{Key: "10.1/A", Value: "10.1/B"}}
+	s.foldMaps(ms, true, true)
+	if ms[0].Key != "10.1/A" || s.foldDOI("10.1/A") != "10.1/A" {
+		t.Fatalf("got %v, want unchanged", ms)
+	}
+	s.NoCase = true
+	if got := s.doiColumn("v"); got != "v COLLATE NOCASE" {
+		t.Fatalf("got %q, want v COLLATE NOCASE", got)
+	}
+	ms = []Map{{Key: "ID-A", Value: "10.1/B"}}
+	s.foldMaps(ms, false, true)
+	if want := []Map{{Key: "ID-A", Value: "10.1/b"}}; !reflect.DeepEqual(ms, want) {
+		t.Fatalf("got %v, want %v", ms, want)
+	}
+	if got := s.foldDOI("10.1/A"); got != "10.1/a" {
+		t.Fatalf("got %q, want 10.1/a", got)
+	}
+}
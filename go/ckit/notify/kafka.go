@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// KafkaREST publishes events to a Kafka topic via a Kafka REST Proxy (API
+// v2), cf. https://docs.confluent.io/platform/current/kafka-rest/api.html.
+type KafkaREST struct {
+	// URL of the REST Proxy, e.g. http://localhost:8082.
+	URL   string
+	Topic string
+	// Client to use, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// kafkaRecords is the payload for producing messages with the REST Proxy.
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaRecord struct {
+	Value Event `json:"value"`
+}
+
+// Publish publishes a single event.
+func (k *KafkaREST) Publish(ctx context.Context, e Event) error {
+	b, err := json.Marshal(kafkaRecords{Records: []kafkaRecord{{Value: e}}})
+	if err != nil {
+		return err
+	}
+	link := k.URL + "/topics/" + url.PathEscape(k.Topic)
+	req, err := http.NewRequestWithContext(ctx, "POST", link, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	client := k.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("kafka rest proxy: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// NATS publishes events to a NATS subject, using the text protocol, cf.
+// https://docs.nats.io/reference/reference-protocols/nats-protocol. Events
+// are rare, so we connect for each event and wait for the server to
+// acknowledge it, instead of keeping a connection alive.
+type NATS struct {
+	Addr    string
+	Subject string
+}
+
+// Publish publishes a single event.
+func (n *NATS) Publish(ctx context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", n.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(DefaultTimeout))
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	// The server greets with an INFO line.
+	line, err := rw.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("nats: %w", err)
+	}
+	if !strings.HasPrefix(line, "INFO") {
+		return fmt.Errorf("nats: unexpected greeting: %q", strings.TrimSpace(line))
+	}
+	// A PING after the PUB is answered with PONG, once the server processed
+	// everything before it; errors are reported as -ERR.
+	fmt.Fprintf(rw, "CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"labed\"}\r\n")
+	fmt.Fprintf(rw, "PUB %s %d\r\n", n.Subject, len(b))
+	rw.Write(b)
+	rw.WriteString("\r\nPING\r\n")
+	if err := rw.Flush(); err != nil {
+		return fmt.Errorf("nats: %w", err)
+	}
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("nats: %w", err)
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: %s", line)
+		}
+	}
+}
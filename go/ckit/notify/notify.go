@@ -0,0 +1,147 @@
+// Package notify publishes events about data updates, e.g. when a new OCI or
+// index snapshot went live or when the cache has been flushed, so downstream
+// systems can react.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	// EventStarted is published, when a server is up with a set of
+	// datasets; a restart with new data marks a new snapshot going live.
+	EventStarted = "started"
+	// EventRotated is published, after a server switched to a new set of
+	// datasets while running.
+	EventRotated = "rotated"
+	// EventCacheFlushed is published, when the whole cache has been flushed.
+	EventCacheFlushed = "cache_flushed"
+	// EventCacheEvicted is published, when a single cache entry was removed.
+	EventCacheEvicted = "cache_evicted"
+	// EventStale is published, when a dataset has not been updated within
+	// its expected refresh interval, e.g. after a failed pipeline run.
+	EventStale = "stale"
+)
+
+// DefaultTimeout is the default timeout for publishing a single event.
+var DefaultTimeout = 5 * time.Second
+
+// Dataset describes a database file a server uses.
+type Dataset struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	// Age and MaxAge are set for stale datasets.
+	Age    string `json:"age,omitempty"`
+	MaxAge string `json:"max_age,omitempty"`
+}
+
+// Stat returns a dataset description for a file; size and modification time
+// are left empty, if the file cannot be read.
+func Stat(name, path string) Dataset {
+	ds := Dataset{Name: name, Path: path}
+	if fi, err := os.Stat(path); err == nil {
+		ds.Size, ds.Modified = fi.Size(), fi.ModTime()
+	}
+	return ds
+}
+
+// Event is a single notification.
+type Event struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname,omitempty"`
+	PID      int       `json:"pid"`
+	// Datasets in use, set for start and rotation events; stale datasets
+	// for stale events.
+	Datasets []Dataset `json:"datasets,omitempty"`
+	// Previous datasets, set for rotation events.
+	Previous []Dataset `json:"previous,omitempty"`
+	// ID is the affected identifier, for single entry cache events.
+	ID string `json:"id,omitempty"`
+}
+
+// NewEvent returns an event of a given type, with time and host set.
+func NewEvent(typ string) Event {
+	hostname, _ := os.Hostname()
+	return Event{Type: typ, Time: time.Now(), Hostname: hostname, PID: os.Getpid()}
+}
+
+// Publisher publishes events to some external system.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// Open returns a publisher for a URL: nats://host:4222/subject publishes to
+// a NATS subject, kafka+http://host:8082/topic (or kafka+https) publishes to
+// a Kafka topic via a Kafka REST Proxy.
+func Open(s string) (Publisher, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	target := strings.Trim(u.Path, "/")
+	if u.Host == "" || target == "" {
+		return nil, fmt.Errorf("notify: host and subject or topic required: %s", s)
+	}
+	switch u.Scheme {
+	case "nats":
+		return &NATS{Addr: u.Host, Subject: target}, nil
+	case "kafka+http", "kafka+https":
+		return &KafkaREST{
+			URL:   strings.TrimPrefix(u.Scheme, "kafka+") + "://" + u.Host,
+			Topic: target,
+		}, nil
+	default:
+		return nil, fmt.Errorf("notify: unsupported scheme: %s", u.Scheme)
+	}
+}
+
+// Notifier publishes events to a number of publishers in the background. It
+// is safe to use a nil notifier, which does nothing.
+type Notifier struct {
+	Publishers []Publisher
+	Timeout    time.Duration
+
+	wg sync.WaitGroup
+}
+
+// Notify publishes an event to all publishers, without waiting; failures are
+// logged.
+func (n *Notifier) Notify(e Event) {
+	if n == nil {
+		return
+	}
+	timeout := n.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	for _, p := range n.Publishers {
+		n.wg.Add(1)
+		go func(p Publisher) {
+			defer n.wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := p.Publish(ctx, e); err != nil {
+				log.Printf("notify: %s event: %v", e.Type, err)
+			}
+		}(p)
+	}
+}
+
+// Wait waits for all pending events to be published.
+func (n *Notifier) Wait() {
+	if n == nil {
+		return
+	}
+	n.wg.Wait()
+}
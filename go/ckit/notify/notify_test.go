@@ -0,0 +1,198 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+)
+
+func TestOpen(t *testing.T) {
+	var cases = []struct {
+		url    string
+		result Publisher
+		err    bool
+	}{
+		{"nats://localhost:4222/labe.updates", &NATS{Addr: "localhost:4222", Subject: "labe.updates"}, false},
+		{"kafka+http://localhost:8082/labe", &KafkaREST{URL: "http://localhost:8082", Topic: "labe"}, false},
+		{"kafka+https://proxy/labe", &KafkaREST{URL: "https://proxy", Topic: "labe"}, false},
+		{"nats://localhost:4222", nil, true},
+		{"http://localhost/x", nil, true},
+	}
+	for _, c := range cases {
+		p, err := Open(c.url)
+		if (err != nil) != c.err {
+			t.Fatalf("Open(%s): got %v", c.url, err)
+		}
+		if c.err {
+			continue
+		}
+		if fmt.Sprintf("%#v", p) != fmt.Sprintf("%#v", c.result) {
+			t.Fatalf("Open(%s): got %#v, want %#v", c.url, p, c.result)
+		}
+	}
+}
+
+// fakeNATS accepts connections and records published messages.
+type fakeNATS struct {
+	mu       sync.Mutex
+	messages map[string][]string
+}
+
+func (f *fakeNATS) serve(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.handle(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (f *fakeNATS) handle(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	rw.WriteString("INFO {\"server_id\":\"fake\"}\r\n")
+	rw.Flush()
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "PUB":
+			size, _ := strconv.Atoi(fields[2])
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, buf); err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.messages[fields[1]] = append(f.messages[fields[1]], string(buf[:size]))
+			f.mu.Unlock()
+		case "PING":
+			rw.WriteString("PONG\r\n")
+		}
+		rw.Flush()
+	}
+}
+
+func TestNATS(t *testing.T) {
+	f := &fakeNATS{messages: make(map[string][]string)}
+	p := &NATS{Addr: f.serve(t), Subject: "labe.updates"}
+	if err := p.Publish(context.Background(), NewEvent(EventCacheFlushed)); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.messages["labe.updates"]) != 1 {
+		t.Fatalf("got %v, want a single message", f.messages)
+	}
+	var e Event
+	if err := json.Unmarshal([]byte(f.messages["labe.updates"][0]), &e); err != nil {
+		t.Fatalf("invalid message: %v", err)
+	}
+	if e.Type != EventCacheFlushed {
+		t.Fatalf("got %v, want %v", e.Type, EventCacheFlushed)
+	}
+}
+
+func TestKafkaREST(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/labe" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		mu.Unlock()
+	}))
+	defer ts.Close()
+	p := &KafkaREST{URL: ts.URL, Topic: "labe"}
+	n := &Notifier{Publishers: []Publisher{p}}
+	e := NewEvent(EventStarted)
+	e.Datasets = []Dataset{Stat("oci", "notify_test.go")}
+	n.Notify(e)
+	n.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 1 {
+		t.Fatalf("got %d requests, want 1", len(bodies))
+	}
+	var payload kafkaRecords
+	if err := json.Unmarshal([]byte(bodies[0]), &payload); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if len(payload.Records) != 1 || payload.Records[0].Value.Datasets[0].Size == 0 {
+		t.Fatalf("unexpected payload: %s", bodies[0])
+	}
+	p.Topic = "missing"
+	if err := p.Publish(context.Background(), e); err == nil {
+		t.Fatalf("want error for missing topic")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []Event
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+	n := &Notifier{Publishers: []Publisher{Only(&Webhook{URL: ts.URL}, EventStarted, EventRotated)}}
+	rotated := NewEvent(EventRotated)
+	rotated.Datasets = []Dataset{{Name: "oci", Path: "new.db"}}
+	rotated.Previous = []Dataset{{Name: "oci", Path: "old.db"}}
+	for _, e := range []Event{NewEvent(EventCacheFlushed), rotated} {
+		n.Notify(e)
+	}
+	n.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	if e := events[0]; e.Type != EventRotated || e.Previous[0].Path != "old.db" || e.Datasets[0].Path != "new.db" {
+		t.Fatalf("unexpected event: %#v", e)
+	}
+	h := &Webhook{URL: ts.URL + "/x"}
+	ts.Close()
+	if err := h.Publish(context.Background(), rotated); err == nil {
+		t.Fatalf("want error for unreachable webhook")
+	}
+}
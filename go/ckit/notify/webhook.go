@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// Webhook posts events as JSON to a URL.
+type Webhook struct {
+	URL string
+	// Client to use, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// Publish posts a single event.
+func (h *Webhook) Publish(ctx context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", h.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "labed")
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// filtered passes only events of certain types to a publisher.
+type filtered struct {
+	Publisher
+	types map[string]bool
+}
+
+// Publish publishes an event, if its type is wanted.
+func (f *filtered) Publish(ctx context.Context, e Event) error {
+	if !f.types[e.Type] {
+		return nil
+	}
+	return f.Publisher.Publish(ctx, e)
+}
+
+// Only returns a publisher, which only publishes events of the given types.
+func Only(p Publisher, types ...string) Publisher {
+	f := &filtered{Publisher: p, types: make(map[string]bool)}
+	for _, t := range types {
+		f.types[t] = true
+	}
+	return f
+}
@@ -0,0 +1,35 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// oaStatus looks up the open access status of DOI, as derived from Unpaywall
+// (https://unpaywall.org/), e.g. gold, green, hybrid, bronze or closed; DOI
+// without a status are missing from the result.
+func (s *Server) oaStatus(ctx context.Context, dois []string) (map[string]string, error) {
+	ctx, cancel := withTimeout(ctx, s.IdentifierTimeout)
+	defer cancel()
+	result := make(map[string]string)
+	for _, batch := range batchedStrings(dois, 500) {
+		t := time.Now()
+		query, args, err := sqlx.In("SELECT * FROM map WHERE "+s.doiColumn("k")+" IN (?)", batch)
+		if err != nil {
+			return nil, fmt.Errorf("query (%d): %v", len(dois), err)
+		}
+		var rows []Map
+		if err := s.selectContext(ctx, s.OADatabase, &rows, s.OADatabase.Rebind(query), args...); err != nil {
+			return nil, fmt.Errorf("select (%d): %w", len(dois), err)
+		}
+		s.Stats.MeasureSinceWithLabels("oa_query", t, nil)
+		s.foldMaps(rows, true, false)
+		for _, row := range rows {
+			result[row.Key] = row.Value
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,161 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/cache"
+)
+
+const (
+	// DefaultOpenAlexURL is the OpenAlex API, cf. https://docs.openalex.org/.
+	DefaultOpenAlexURL = "https://api.openalex.org"
+	// DefaultOpenAlexTTL is the time we keep OpenAlex results in the cache.
+	DefaultOpenAlexTTL = 7 * 24 * time.Hour
+	// openAlexBatchSize is the maximum number of values in an OpenAlex OR
+	// filter.
+	openAlexBatchSize = 50
+	// openAlexFields are the fields we request for a work.
+	openAlexFields = "id,doi,title,publication_year,type,host_venue,cited_by_count"
+)
+
+// OpenAlex looks up works by DOI in OpenAlex, to enrich DOI that are not in
+// the local index with basic metadata. Results, including misses, are cached,
+// if a cache is given.
+type OpenAlex struct {
+	// URL of the API, DefaultOpenAlexURL, if empty.
+	URL string
+	// Mailto is sent along, to get into the "polite pool" of the API.
+	Mailto string
+	Client *http.Client
+	// Cache, if set, keeps results for TTL, DefaultOpenAlexTTL, if zero.
+	Cache cache.Store
+	TTL   time.Duration
+}
+
+// cacheKey returns the cache key of a DOI.
+func (o *OpenAlex) cacheKey(doi string) string {
+	return "openalex:" + strings.ToLower(doi)
+}
+
+// cached returns a cached value, which is "null" for known misses.
+func (o *OpenAlex) cached(doi string) ([]byte, bool) {
+	if o.Cache == nil {
+		return nil, false
+	}
+	b, t, err := o.Cache.GetWithTime(o.cacheKey(doi))
+	if err != nil {
+		return nil, false
+	}
+	ttl := o.TTL
+	if ttl == 0 {
+		ttl = DefaultOpenAlexTTL
+	}
+	if time.Since(t) > ttl {
+		return nil, false
+	}
+	return b, true
+}
+
+// LookupDOI returns works for DOI, keyed by DOI, as given.
+func (o *OpenAlex) LookupDOI(ctx context.Context, dois []string) (map[string][]byte, error) {
+	var (
+		result  = make(map[string][]byte)
+		missing []string
+	)
+	for _, doi := range dois {
+		b, ok := o.cached(doi)
+		switch {
+		case strings.ContainsAny(doi, "|,"):
+			// Cannot be expressed in an OpenAlex filter.
+		case !ok:
+			missing = append(missing, doi)
+		case string(b) != "null":
+			result[doi] = b
+		}
+	}
+	for i := 0; i < len(missing); i += openAlexBatchSize {
+		batch := missing[i:minInt(i+openAlexBatchSize, len(missing))]
+		found, err := o.lookup(ctx, batch)
+		if err != nil {
+			return result, err
+		}
+		for _, doi := range batch {
+			b, ok := found[doi]
+			if ok {
+				result[doi] = b
+			} else {
+				b = []byte("null")
+			}
+			if o.Cache != nil {
+				if err := o.Cache.Set(o.cacheKey(doi), b); err != nil {
+					log.Printf("openalex: cache: %v", err)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// lookup queries the API for a batch of DOI.
+func (o *OpenAlex) lookup(ctx context.Context, dois []string) (map[string][]byte, error) {
+	base := o.URL
+	if base == "" {
+		base = DefaultOpenAlexURL
+	}
+	vs := url.Values{}
+	vs.Set("filter", "doi:"+strings.Join(dois, "|"))
+	vs.Set("per-page", fmt.Sprintf("%d", len(dois)))
+	vs.Set("select", openAlexFields)
+	if o.Mailto != "" {
+		vs.Set("mailto", o.Mailto)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET",
+		strings.TrimRight(base, "/")+"/works?"+vs.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Results []json.RawMessage `json:"results"`
+	}
+	if err := doJSON(liveClient(o.Client), req, &resp); err != nil {
+		return nil, fmt.Errorf("openalex: %w", err)
+	}
+	lower := make(map[string]string, len(dois))
+	for _, doi := range dois {
+		lower[strings.ToLower(doi)] = doi
+	}
+	result := make(map[string][]byte)
+	for _, b := range resp.Results {
+		var work struct {
+			DOI string `json:"doi"`
+		}
+		if err := json.Unmarshal(b, &work); err != nil {
+			continue
+		}
+		// OpenAlex returns DOI as URL, e.g. https://doi.org/10.1/a.
+		v := strings.ToLower(strings.TrimPrefix(work.DOI, "https://doi.org/"))
+		if doi, ok := lower[v]; ok {
+			result[doi] = b
+		}
+	}
+	return result, nil
+}
+
+// enrichUnmatched looks up unmatched DOI in OpenAlex; lookups are best
+// effort, on errors, the works found so far are returned.
+func (s *Server) enrichUnmatched(ctx context.Context, dois []string) map[string][]byte {
+	t := time.Now()
+	works, err := s.OpenAlex.LookupDOI(ctx, dois)
+	if err != nil {
+		log.Printf("enrich: %v", err)
+	}
+	s.Stats.MeasureSinceWithLabels("openalex_lookup", t, nil)
+	return works
+}
@@ -0,0 +1,62 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memStore is an in-memory cache.Store.
+type memStore map[string][]byte
+
+func (m memStore) Get(key string) ([]byte, error) {
+	v, _, err := m.GetWithTime(key)
+	return v, err
+}
+
+func (m memStore) GetWithTime(key string) ([]byte, time.Time, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("cache miss")
+	}
+	return v, time.Now(), nil
+}
+
+func (m memStore) Set(key string, value []byte) error { m[key] = value; return nil }
+func (m memStore) Delete(key string) error            { delete(m, key); return nil }
+func (m memStore) Flush() error                       { return nil }
+func (m memStore) ItemCount() (int, error)            { return len(m), nil }
+func (m memStore) Close() error                       { return nil }
+
+func TestOpenAlex(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if f := r.URL.Query().Get("filter"); f != "doi:10.1/A|10.1/b" {
+			t.Errorf("unexpected filter: %s", f)
+		}
+		if m := r.URL.Query().Get("mailto"); m != "ops@example.com" {
+			t.Errorf("unexpected mailto: %s", m)
+		}
+		fmt.Fprint(w, `{"results": [{"id": "https://openalex.org/W1", "doi": "https://doi.org/10.1/a"}]}`)
+	}))
+	defer ts.Close()
+	o := &OpenAlex{URL: ts.URL, Mailto: "ops@example.com", Cache: memStore{}}
+	for i := 0; i < 2; i++ {
+		works, err := o.LookupDOI(context.Background(), []string{"10.1/A", "10.1/b", "10.1/x|y"})
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if len(works) != 1 || works["10.1/A"] == nil {
+			t.Fatalf("unexpected works: %v", works)
+		}
+	}
+	// Hits and misses are cached.
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("got %d requests, want 1", n)
+	}
+}
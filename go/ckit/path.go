@@ -0,0 +1,274 @@
+package ckit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/segmentio/encoding/json"
+)
+
+const (
+	// DefaultMaxPathDepth is the default maximum number of citation edges
+	// between two records.
+	DefaultMaxPathDepth = 4
+	// DefaultMaxPathNodes limits the number of DOI visited in a path search.
+	DefaultMaxPathNodes = 100000
+)
+
+// ErrPathSearchLimit is returned, if a path search visited too many nodes.
+var ErrPathSearchLimit = errors.New("path search limit exceeded")
+
+// PathNode is a single record on a citation path, with a DOI and the local
+// identifiers for that DOI, if any.
+type PathNode struct {
+	DOI string   `json:"doi"`
+	IDs []string `json:"ids,omitempty"`
+}
+
+// PathResponse contains the shortest citation path between two records; the
+// path is empty, if none was found within the maximum depth.
+type PathResponse struct {
+	From  string     `json:"from"`
+	To    string     `json:"to"`
+	Path  []PathNode `json:"path"`
+	Extra struct {
+		Depth      int     `json:"depth"`
+		MaxDepth   int     `json:"max_depth"`
+		Undirected bool    `json:"undirected"`
+		Visited    int     `json:"visited"`
+		Took       float64 `json:"took"`
+	} `json:"extra"`
+}
+
+// neighborsFunc returns the edges for a list of DOI. With forward set, these
+// are the citing edges (DOI as key), otherwise the cited edges (DOI as
+// value).
+type neighborsFunc func(ctx context.Context, dois []string, forward bool) ([]Map, error)
+
+// shortestPath runs a bidirectional breadth-first search from one DOI to
+// another, following citations, i.e. each DOI on the path cites the next one.
+// If undirected is true, edges are followed in both directions. The search
+// is limited to maxDepth edges and maxNodes visited DOI. Returns the DOI on
+// the path, empty if no path was found, and the number of visited DOI.
+func shortestPath(ctx context.Context, from, to string, maxDepth, maxNodes int,
+	undirected bool, neighbors neighborsFunc) ([]string, int, error) {
+	if from == to {
+		return []string{from}, 1, nil
+	}
+	var (
+		// Parents of visited nodes, towards from or to, respectively.
+		fwdParent = map[string]string{from: ""}
+		bwdParent = map[string]string{to: ""}
+		fwd       = []string{from}
+		bwd       = []string{to}
+	)
+	// expand visits the neighbors of a frontier and returns the next frontier
+	// and a node, which has been visited from both sides, if any.
+	expand := func(frontier []string, parent, other map[string]string, forward bool) ([]string, string, error) {
+		var next []string
+		visit := func(u, v string) string {
+			if _, ok := parent[v]; ok {
+				return ""
+			}
+			parent[v] = u
+			next = append(next, v)
+			if _, ok := other[v]; ok {
+				return v
+			}
+			return ""
+		}
+		directions := []bool{forward}
+		if undirected {
+			directions = append(directions, !forward)
+		}
+		for _, dir := range directions {
+			edges, err := neighbors(ctx, frontier, dir)
+			if err != nil {
+				return nil, "", err
+			}
+			for _, e := range edges {
+				u, v := e.Key, e.Value // citing edge, u cites v
+				if !dir {
+					u, v = v, u
+				}
+				if meet := visit(u, v); meet != "" {
+					return next, meet, nil
+				}
+			}
+		}
+		return next, "", nil
+	}
+	for depth := 0; depth < maxDepth && len(fwd) > 0 && len(bwd) > 0; depth++ {
+		var (
+			meet string
+			err  error
+		)
+		// Expanding the smaller frontier keeps the search small.
+		if len(fwd) <= len(bwd) {
+			fwd, meet, err = expand(fwd, fwdParent, bwdParent, true)
+		} else {
+			bwd, meet, err = expand(bwd, bwdParent, fwdParent, false)
+		}
+		visited := len(fwdParent) + len(bwdParent)
+		if err != nil {
+			return nil, visited, err
+		}
+		if meet != "" {
+			var path []string
+			for v := meet; v != ""; v = fwdParent[v] {
+				path = append([]string{v}, path...)
+			}
+			for v := bwdParent[meet]; v != ""; v = bwdParent[v] {
+				path = append(path, v)
+			}
+			return path, visited, nil
+		}
+		if visited > maxNodes {
+			return nil, visited, ErrPathSearchLimit
+		}
+	}
+	return nil, len(fwdParent) + len(bwdParent), nil
+}
+
+// ociNeighbors returns citing or cited edges for a list of DOI from the OCI
+// database or edge store.
+func (s *Server) ociNeighbors(ctx context.Context, dois []string, forward bool) ([]Map, error) {
+	if s.Edges != nil {
+		t := time.Now()
+		edges, err := s.Edges.Neighbors(ctx, dois, forward)
+		s.Stats.MeasureSinceWithLabels("sql_query", t, nil)
+		return edges, err
+	}
+	const size = 500 // Anything between 1 and 999, cf. mapToLocal.
+	var (
+		db    = s.ociDB()
+		q     = s.inboundQuery(ctx, db, "IN (?)")
+		edges []Map
+	)
+	if forward {
+		q = "SELECT * FROM map WHERE " + s.doiColumn("k") + " IN (?)"
+	}
+	for _, batch := range batchedStrings(dois, size) {
+		t := time.Now()
+		query, args, err := sqlx.In(q, batch)
+		if err != nil {
+			return nil, fmt.Errorf("query (%d): %v", len(dois), err)
+		}
+		query = db.Rebind(query)
+		var result []Map
+		if err := s.selectContext(ctx, db, &result, query, args...); err != nil {
+			return nil, fmt.Errorf("select (%d): %w", len(dois), err)
+		}
+		s.Stats.MeasureSinceWithLabels("sql_query", t, nil)
+		s.foldMaps(result, true, true)
+		edges = append(edges, result...)
+	}
+	return edges, nil
+}
+
+// handlePath finds the shortest citation path between two records, given
+// as local identifiers, e.g. /path?from=0-1&to=0-2. The maximum depth can be
+// lowered with the depth parameter, undirected=true ignores the direction of
+// citations.
+func (s *Server) handlePath() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			started  = time.Now()
+			ctx      = r.Context()
+			q        = r.URL.Query()
+			maxDepth = s.MaxPathDepth
+			resp     = &PathResponse{From: q.Get("from"), To: q.Get("to")}
+			err      error
+		)
+		if maxDepth == 0 {
+			maxDepth = DefaultMaxPathDepth
+		}
+		if resp.From == "" || resp.To == "" {
+			httpErrLogf(w, http.StatusBadRequest, "path: from and to required")
+			return
+		}
+		if v := q.Get("depth"); v != "" {
+			depth, err := strconv.Atoi(v)
+			if err != nil || depth < 1 || depth > maxDepth {
+				httpErrLogf(w, http.StatusBadRequest, "path: depth must be between 1 and %d", maxDepth)
+				return
+			}
+			maxDepth = depth
+		}
+		if v := q.Get("undirected"); v != "" {
+			if resp.Extra.Undirected, err = strconv.ParseBool(v); err != nil {
+				httpErrLogf(w, http.StatusBadRequest, "path: invalid undirected value: %q", v)
+				return
+			}
+		}
+		resp.Extra.MaxDepth = maxDepth
+		var fromDOI, toDOI string
+		for _, v := range []struct {
+			id  string
+			doi *string
+		}{{resp.From, &fromDOI}, {resp.To, &toDOI}} {
+			if err := s.identifierToDOI(ctx, v.id, v.doi); err != nil {
+				switch {
+				case err == sql.ErrNoRows:
+					httpErrLogf(w, http.StatusNotFound, "doi lookup (%s): %w", v.id, err)
+				case errors.Is(err, context.DeadlineExceeded):
+					httpErrLogf(w, http.StatusGatewayTimeout, "doi lookup (%s): %w", v.id, err)
+				default:
+					httpErrLogf(w, http.StatusInternalServerError, "doi lookup (%s): %w", v.id, err)
+				}
+				return
+			}
+		}
+		fromDOI, toDOI = s.foldDOI(fromDOI), s.foldDOI(toDOI)
+		searchCtx, cancel := withTimeout(ctx, s.OciTimeout)
+		defer cancel()
+		path, visited, err := shortestPath(searchCtx, fromDOI, toDOI, maxDepth, DefaultMaxPathNodes,
+			resp.Extra.Undirected, s.ociNeighbors)
+		resp.Extra.Visited = visited
+		switch {
+		case err == ErrPathSearchLimit:
+			httpErrLogf(w, http.StatusUnprocessableEntity, "path: %w, try a lower depth", err)
+			return
+		case errors.Is(err, context.Canceled):
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			httpErrLogf(w, http.StatusGatewayTimeout, "path: %w", err)
+			return
+		case err != nil:
+			httpErrLogf(w, http.StatusInternalServerError, "path: %w", err)
+			return
+		}
+		// Attach local identifiers to all DOI on the path.
+		ids, err := s.mapToLocal(ctx, path)
+		if err != nil {
+			httpErrLogf(w, http.StatusInternalServerError, "map: %w", err)
+			return
+		}
+		byDOI := make(map[string][]string)
+		for _, v := range ids {
+			byDOI[v.Value] = append(byDOI[v.Value], v.Key)
+		}
+		resp.Path = make([]PathNode, 0, len(path))
+		for _, doi := range path {
+			resp.Path = append(resp.Path, PathNode{DOI: doi, IDs: byDOI[doi]})
+		}
+		if len(path) > 0 {
+			resp.Extra.Depth = len(path) - 1
+		}
+		resp.Extra.Took = time.Since(started).Seconds()
+		s.Stats.MeasureSinceWithLabels("path", started, nil)
+		w.Header().Set("Content-Type", "application/json")
+		if len(path) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			httpErrLog(w, http.StatusInternalServerError, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package ckit
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// graphNeighbors returns a neighborsFunc for a list of citing edges.
+func graphNeighbors(edges []Map) neighborsFunc {
+	return func(ctx context.Context, dois []string, forward bool) ([]Map, error) {
+		var result []Map
+		for _, e := range edges {
+			for _, doi := range dois {
+				if (forward && e.Key == doi) || (!forward && e.Value == doi) {
+					result = append(result, e)
+				}
+			}
+		}
+		return result, nil
+	}
+}
+
+func TestShortestPath(t *testing.T) {
+	// a -> b -> c -> d, a -> e -> d, f -> d, g
+	var edges = []Map{
+		{Key: "a", Value: "b"},
+		{Key: "b", Value: "c"},
+		{Key: "c", Value: "d"},
+		{Key: "a", Value: "e"},
+		{Key: "e", Value: "d"},
+		{Key: "f", Value: "d"},
+	}
+	var cases = []struct {
+		about      string
+		from, to   string
+		depth      int
+		undirected bool
+		result     []string
+	}{
+		{"same node", "a", "a", 4, false, []string{"a"}},
+		{"single edge", "a", "b", 4, false, []string{"a", "b"}},
+		{"shortest path", "a", "d", 4, false, []string{"a", "e", "d"}},
+		{"depth too small", "a", "d", 1, false, nil},
+		{"against direction", "d", "a", 4, false, nil},
+		{"against direction, undirected", "d", "a", 4, true, []string{"d", "e", "a"}},
+		{"via cited", "a", "f", 4, false, nil},
+		{"via cited, undirected", "a", "f", 4, true, []string{"a", "e", "d", "f"}},
+		{"unconnected", "a", "g", 4, true, nil},
+	}
+	for _, c := range cases {
+		path, _, err := shortestPath(context.Background(), c.from, c.to, c.depth, 100,
+			c.undirected, graphNeighbors(edges))
+		if err != nil {
+			t.Fatalf("[%s] got %v", c.about, err)
+		}
+		if !reflect.DeepEqual(path, c.result) {
+			t.Fatalf("[%s] got %v, want %v", c.about, path, c.result)
+		}
+	}
+}
+
+func TestShortestPathLimit(t *testing.T) {
+	var edges []Map
+	for _, v := range []string{"b", "c", "d", "e"} {
+		edges = append(edges, Map{Key: "a", Value: v})
+	}
+	_, _, err := shortestPath(context.Background(), "a", "x", 4, 3, false, graphNeighbors(edges))
+	if err != ErrPathSearchLimit {
+		t.Fatalf("got %v, want %v", err, ErrPathSearchLimit)
+	}
+}
@@ -0,0 +1,248 @@
+// Package pipeline runs tasks with dependencies, in the spirit of luigi: each
+// task has a single output file and is done, when that file exists and is
+// newer than the outputs of the tasks it requires. Outputs are written to a
+// temporary file first and moved into place on success only, so an
+// interrupted run can be resumed by running it again.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Env is passed to a running task.
+type Env struct {
+	// Output is the temporary file to write to, moved to the task output
+	// on success.
+	Output string
+	// Inputs maps the names of required tasks to their outputs.
+	Inputs map[string]string
+	// Log receives diagnostic output, e.g. stderr of commands.
+	Log io.Writer
+}
+
+// Task is a unit of work.
+type Task struct {
+	Name     string
+	Requires []string
+	Output   string
+	// MinSize is the minimum size of the output in bytes, as a sanity
+	// check; zero disables the check.
+	MinSize int64
+	Run     func(ctx context.Context, env *Env) error
+	// OnSuccess runs after the output has been moved into place, e.g. to
+	// update a symlink.
+	OnSuccess func(output string) error
+}
+
+// Runner runs tasks and their dependencies in order.
+type Runner struct {
+	Tasks  map[string]*Task
+	LogDir string          // if set, keep a log file per task
+	Force  map[string]bool // run these tasks, even if done
+	DryRun bool            // only log, what would run
+}
+
+// NewRunner returns a runner for a list of tasks.
+func NewRunner(tasks []*Task) (*Runner, error) {
+	r := &Runner{Tasks: make(map[string]*Task), Force: make(map[string]bool)}
+	for _, t := range tasks {
+		if _, ok := r.Tasks[t.Name]; ok {
+			return nil, fmt.Errorf("duplicate task: %s", t.Name)
+		}
+		r.Tasks[t.Name] = t
+	}
+	return r, nil
+}
+
+// Names returns all task names, sorted.
+func (r *Runner) Names() (names []string) {
+	for name := range r.Tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Plan returns the tasks required for the targets, including the targets,
+// in an order in which they can run.
+func (r *Runner) Plan(targets ...string) ([]*Task, error) {
+	var (
+		plan  []*Task
+		state = make(map[string]int) // 1: visiting, 2: done
+		visit func(name string, path []string) error
+	)
+	visit = func(name string, path []string) error {
+		t, ok := r.Tasks[name]
+		if !ok {
+			return fmt.Errorf("unknown task: %s", name)
+		}
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("cycle: %s", strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, dep := range t.Requires {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		plan = append(plan, t)
+		return nil
+	}
+	for _, name := range targets {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// Done returns true, if the output of a task exists and is not older than
+// the outputs of the tasks it requires.
+func (r *Runner) Done(t *Task) bool {
+	fi, err := os.Stat(t.Output)
+	if err != nil {
+		return false
+	}
+	for _, dep := range t.Requires {
+		di, err := os.Stat(r.Tasks[dep].Output)
+		if err != nil || di.ModTime().After(fi.ModTime()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Run runs all tasks required for the targets, which are not done yet.
+// Since a task that ran has a fresh output, tasks depending on it run as
+// well.
+func (r *Runner) Run(ctx context.Context, targets ...string) error {
+	plan, err := r.Plan(targets...)
+	if err != nil {
+		return err
+	}
+	for i, t := range plan {
+		prefix := fmt.Sprintf("[%d/%d] %s", i+1, len(plan), t.Name)
+		if !r.Force[t.Name] && r.Done(t) {
+			log.Printf("%s: done · %s", prefix, t.Output)
+			continue
+		}
+		if r.DryRun {
+			log.Printf("%s: would run · %s", prefix, t.Output)
+			continue
+		}
+		log.Printf("%s: running", prefix)
+		started := time.Now()
+		if err := r.runTask(ctx, t); err != nil {
+			return fmt.Errorf("%s: %w", t.Name, err)
+		}
+		log.Printf("%s: ok in %s · %s", prefix, time.Since(started).Round(time.Second), t.Output)
+	}
+	return nil
+}
+
+// runTask runs a single task, writing to a temporary file first.
+func (r *Runner) runTask(ctx context.Context, t *Task) error {
+	if err := os.MkdirAll(filepath.Dir(t.Output), 0755); err != nil {
+		return err
+	}
+	env := &Env{
+		Output: t.Output + ".part",
+		Inputs: make(map[string]string),
+		Log:    os.Stderr,
+	}
+	for _, dep := range t.Requires {
+		env.Inputs[dep] = r.Tasks[dep].Output
+	}
+	if r.LogDir != "" {
+		if err := os.MkdirAll(r.LogDir, 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filepath.Join(r.LogDir, t.Name+".log"),
+			os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fmt.Fprintf(f, "# %s %s\n", time.Now().Format(time.RFC3339), t.Name)
+		env.Log = io.MultiWriter(os.Stderr, f)
+	}
+	if err := os.RemoveAll(env.Output); err != nil {
+		return err
+	}
+	if err := t.Run(ctx, env); err != nil {
+		return err
+	}
+	fi, err := os.Stat(env.Output)
+	if err != nil {
+		return fmt.Errorf("no output: %w", err)
+	}
+	if fi.Size() < t.MinSize {
+		return fmt.Errorf("output too small: %d < %d bytes: %s", fi.Size(), t.MinSize, env.Output)
+	}
+	if err := os.Rename(env.Output, t.Output); err != nil {
+		return err
+	}
+	if t.OnSuccess != nil {
+		return t.OnSuccess(t.Output)
+	}
+	return nil
+}
+
+// Shell returns a task function running a bash script with pipefail set.
+// In the script, {output} is replaced by the temporary output file and
+// {name} by the output of the required task name, both quoted.
+func Shell(script string) func(ctx context.Context, env *Env) error {
+	return func(ctx context.Context, env *Env) error {
+		var (
+			names = make([]string, 0, len(env.Inputs))
+			pairs = []string{"{output}", Quote(env.Output)}
+		)
+		for name := range env.Inputs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			pairs = append(pairs, "{"+name+"}", Quote(env.Inputs[name]))
+		}
+		cmd := exec.CommandContext(ctx, "bash", "-o", "pipefail", "-c",
+			strings.NewReplacer(pairs...).Replace(script))
+		cmd.Stderr = env.Log
+		cmd.Stdout = env.Log
+		return cmd.Run()
+	}
+}
+
+// Symlink returns an OnSuccess function pointing a symlink, e.g. "current",
+// in the directory of the output to the output; the link is replaced
+// atomically.
+func Symlink(name string) func(output string) error {
+	return func(output string) error {
+		var (
+			link = filepath.Join(filepath.Dir(output), name)
+			tmp  = link + ".tmp"
+		)
+		_ = os.Remove(tmp)
+		if err := os.Symlink(filepath.Base(output), tmp); err != nil {
+			return err
+		}
+		return os.Rename(tmp, link)
+	}
+}
+
+// Quote quotes a string for the shell, e.g. to put a URL into a script.
+func Quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,160 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writer returns a task function writing a string and counting runs.
+func writer(s string, runs map[string]int, name string) func(context.Context, *Env) error {
+	return func(ctx context.Context, env *Env) error {
+		runs[name]++
+		return ioutil.WriteFile(env.Output, []byte(s), 0644)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	r, err := NewRunner([]*Task{
+		{Name: "c", Requires: []string{"a", "b"}},
+		{Name: "b", Requires: []string{"a"}},
+		{Name: "a"},
+		{Name: "x", Requires: []string{"y"}},
+		{Name: "y", Requires: []string{"x"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := r.Plan("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, t := range plan {
+		names = append(names, t.Name)
+	}
+	if got := strings.Join(names, " "); got != "a b c" {
+		t.Fatalf("got %v, want a b c", got)
+	}
+	if _, err := r.Plan("x"); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("expected cycle, got %v", err)
+	}
+	if _, err := r.Plan("z"); err == nil {
+		t.Fatalf("expected unknown task error")
+	}
+	if _, err := NewRunner([]*Task{{Name: "a"}, {Name: "a"}}); err == nil {
+		t.Fatalf("expected duplicate task error")
+	}
+}
+
+func TestRunResume(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		runs = make(map[string]int)
+		a    = filepath.Join(dir, "a.txt")
+		b    = filepath.Join(dir, "sub", "b.txt")
+	)
+	r, err := NewRunner([]*Task{
+		{Name: "a", Output: a, Run: writer("a", runs, "a"), OnSuccess: Symlink("current")},
+		{Name: "b", Output: b, Requires: []string{"a"}, Run: func(ctx context.Context, env *Env) error {
+			runs["b"]++
+			p, err := ioutil.ReadFile(env.Inputs["a"])
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(env.Output, append(p, 'b'), 0644)
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.LogDir = filepath.Join(dir, "log")
+	ctx := context.Background()
+	if err := r.Run(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := ioutil.ReadFile(b); string(p) != "ab" {
+		t.Fatalf("got %q, want ab", p)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "current")); err != nil || target != "a.txt" {
+		t.Fatalf("got symlink %v, %v", target, err)
+	}
+	// Everything is done, nothing runs.
+	if err := r.Run(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if runs["a"] != 1 || runs["b"] != 1 {
+		t.Fatalf("unexpected runs: %v", runs)
+	}
+	// A forced task runs again and so do the tasks depending on it.
+	time.Sleep(10 * time.Millisecond)
+	r.Force["a"] = true
+	if err := r.Run(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if runs["a"] != 2 || runs["b"] != 2 {
+		t.Fatalf("unexpected runs: %v", runs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "log", "a.log")); err != nil {
+		t.Fatalf("expected log file: %v", err)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		failed = errors.New("failed")
+		output = filepath.Join(dir, "x")
+	)
+	r, err := NewRunner([]*Task{
+		{Name: "fail", Output: output, Run: func(ctx context.Context, env *Env) error {
+			if err := ioutil.WriteFile(env.Output, []byte("partial"), 0644); err != nil {
+				return err
+			}
+			return failed
+		}},
+		{Name: "small", Output: output, MinSize: 100, Run: writer("x", map[string]int{}, "small")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(context.Background(), "fail"); !errors.Is(err, failed) {
+		t.Fatalf("got %v, want %v", err, failed)
+	}
+	if err := r.Run(context.Background(), "small"); err == nil {
+		t.Fatalf("expected size check to fail")
+	}
+	// A failed task leaves no output behind, so it runs again next time.
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Fatalf("expected no output, got %v", err)
+	}
+}
+
+func TestShell(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		input = filepath.Join(dir, "it's.txt")
+	)
+	if err := ioutil.WriteFile(input, []byte("b\na\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env := &Env{
+		Output: filepath.Join(dir, "out"),
+		Inputs: map[string]string{"in": input},
+		Log:    ioutil.Discard,
+	}
+	if err := Shell("sort {in} > {output}")(context.Background(), env); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := ioutil.ReadFile(env.Output); string(p) != "a\nb\n" {
+		t.Fatalf("got %q", p)
+	}
+	if err := Shell("false | cat > {output}")(context.Background(), env); err == nil {
+		t.Fatalf("expected pipefail")
+	}
+}
@@ -0,0 +1,129 @@
+package ckit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/segmentio/encoding/json"
+)
+
+// prefixPattern matches a DOI prefix, e.g. 10.1073 or 10.1000.10.
+var prefixPattern = regexp.MustCompile(`^10[.][0-9]+([.][0-9]+)*$`)
+
+// PrefixStats contains counts for a DOI prefix, usually a publisher.
+type PrefixStats struct {
+	Prefix string `json:"prefix"`
+	// Identifiers is the number of local ids with a DOI under the prefix,
+	// Matched the number of distinct DOI among them.
+	Identifiers int `json:"identifiers"`
+	Matched     int `json:"matched"`
+	// DOI is the number of distinct DOI under the prefix in the citation
+	// graph; Citing and Cited are the number of edges, where the citing or
+	// cited DOI has the prefix.
+	DOI    int `json:"doi"`
+	Citing int `json:"citing"`
+	Cited  int `json:"cited"`
+	Extra  struct {
+		Cached bool    `json:"cached"`
+		Took   float64 `json:"took"`
+	} `json:"extra"`
+}
+
+// prefixRange returns the bounds of all DOI under a prefix; a range query
+// on an indexed column is a prefix lookup, while LIKE would scan the table.
+func prefixRange(prefix string) (lo, hi string) {
+	return prefix + "/", prefix + "0" // '0' follows '/'
+}
+
+// prefixStats counts identifiers and edges for a DOI prefix; edges are
+// counted in the analytical database, if there is one.
+func (s *Server) prefixStats(ctx context.Context, prefix string) (*PrefixStats, error) {
+	var (
+		ps     = &PrefixStats{Prefix: prefix}
+		lo, hi = prefixRange(prefix)
+	)
+	idCtx, cancel := withTimeout(ctx, s.IdentifierTimeout)
+	defer cancel()
+	t := time.Now()
+	if err := s.getContext(idCtx, s.identifierDB(), &ps.Identifiers,
+		"SELECT COUNT(*) FROM map WHERE v >= ? AND v < ?", lo, hi); err != nil {
+		return nil, err
+	}
+	if err := s.getContext(idCtx, s.identifierDB(), &ps.Matched,
+		"SELECT COUNT(DISTINCT v) FROM map WHERE v >= ? AND v < ?", lo, hi); err != nil {
+		return nil, err
+	}
+	s.Stats.MeasureSinceWithLabels("sql_query", t, nil)
+	ociCtx, cancel := withTimeout(ctx, s.OciTimeout)
+	defer cancel()
+	t = time.Now()
+	db := s.analyticsDB()
+	if err := s.getContext(ociCtx, db, &ps.Citing,
+		"SELECT COUNT(*) FROM map WHERE k >= ? AND k < ?", lo, hi); err != nil {
+		return nil, err
+	}
+	if err := s.getContext(ociCtx, db, &ps.Cited,
+		"SELECT COUNT(*) FROM map WHERE v >= ? AND v < ?", lo, hi); err != nil {
+		return nil, err
+	}
+	if err := s.getContext(ociCtx, db, &ps.DOI, `SELECT COUNT(*) FROM (
+		SELECT k FROM map WHERE k >= ? AND k < ?
+		UNION
+		SELECT v FROM map WHERE v >= ? AND v < ?)`, lo, hi, lo, hi); err != nil {
+		return nil, err
+	}
+	s.Stats.MeasureSinceWithLabels("sql_query", t, nil)
+	return ps, nil
+}
+
+// handlePrefixStats returns counts of identifiers and edges for a DOI
+// prefix, e.g. /prefix/10.1073/stats, for collection analysis. Results are
+// kept for an hour, as large prefixes take a while.
+func (s *Server) handlePrefixStats() http.HandlerFunc {
+	var cache topCache
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			started = time.Now()
+			prefix  = mux.Vars(r)["prefix"]
+		)
+		if !prefixPattern.MatchString(prefix) {
+			httpErrLogf(w, http.StatusBadRequest, "prefix: invalid DOI prefix: %q", prefix)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if b, ok := cache.get(prefix); ok {
+			var ps PrefixStats
+			if err := json.Unmarshal(b, &ps); err == nil {
+				ps.Extra.Cached = true
+				ps.Extra.Took = time.Since(started).Seconds()
+				json.NewEncoder(w).Encode(ps)
+				return
+			}
+		}
+		ps, err := s.prefixStats(r.Context(), prefix)
+		switch {
+		case errors.Is(err, context.Canceled):
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			httpErrLogf(w, http.StatusGatewayTimeout, "prefix: %w", err)
+			return
+		case err != nil:
+			httpErrLogf(w, http.StatusInternalServerError, "prefix: %w", err)
+			return
+		}
+		ps.Extra.Took = time.Since(started).Seconds()
+		b, err := json.Marshal(ps)
+		if err != nil {
+			httpErrLog(w, http.StatusInternalServerError, err)
+			return
+		}
+		b = append(b, '\n')
+		cache.set(prefix, b)
+		s.Stats.MeasureSinceWithLabels("prefix", started, nil)
+		w.Write(b)
+	}
+}
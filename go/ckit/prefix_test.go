@@ -0,0 +1,33 @@
+package ckit
+
+import "testing"
+
+func TestPrefixRange(t *testing.T) {
+	var cases = []struct {
+		prefix string
+		valid  bool
+	}{
+		{"10.1073", true},
+		{"10.1000.10", true},
+		{"10.1073/pnas", false},
+		{"11.1073", false},
+		{"10.", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if got := prefixPattern.MatchString(c.prefix); got != c.valid {
+			t.Fatalf("%q: got %v, want %v", c.prefix, got, c.valid)
+		}
+	}
+	lo, hi := prefixRange("10.1073")
+	for _, doi := range []string{"10.1073/", "10.1073/pnas.85.8.2444", "10.1073/~"} {
+		if doi < lo || doi >= hi {
+			t.Fatalf("%s: got out of range [%s, %s), want in range", doi, lo, hi)
+		}
+	}
+	for _, doi := range []string{"10.1072/x", "10.10730/x", "10.1073.1/x", "10.1074/x"} {
+		if doi >= lo && doi < hi {
+			t.Fatalf("%s: got in range [%s, %s), want out of range", doi, lo, hi)
+		}
+	}
+}
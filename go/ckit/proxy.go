@@ -0,0 +1,108 @@
+package ckit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseTrustedProxies parses a comma separated list of addresses or networks
+// in CIDR notation, e.g. "127.0.0.1,10.0.0.0/8", cf. Server.TrustedProxies.
+func ParseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		n, err := parseNetwork(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %w", err)
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// parseNetwork parses a network in CIDR notation or a single address.
+func parseNetwork(v string) (*net.IPNet, error) {
+	if strings.Contains(v, "/") {
+		_, n, err := net.ParseCIDR(v)
+		return n, err
+	}
+	ip := net.ParseIP(v)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address: %s", v)
+	}
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		ip, bits = ip.To4(), 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// trustedProxy returns true, if an address belongs to a trusted proxy.
+func (s *Server) trustedProxy(ip net.IP) bool {
+	for _, n := range s.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client, that sent a request. If the
+// request came from a trusted proxy, this is the rightmost address in
+// X-Forwarded-For, that is not a trusted proxy itself, or X-Real-IP;
+// otherwise the remote address. Nil, if the remote address is not an IP.
+func (s *Server) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !s.trustedProxy(ip) {
+		return ip
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	if len(hops) == 0 {
+		if v := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); v != nil {
+			return v
+		}
+		return ip
+	}
+	// Walk from the proxy next to us towards the client; addresses left of
+	// an untrusted hop may be set by anyone.
+	for i := len(hops) - 1; i >= 0; i-- {
+		v := net.ParseIP(strings.TrimSpace(hops[i]))
+		if v == nil {
+			break
+		}
+		ip = v
+		if !s.trustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// withClientIP sets the remote address of a request from a trusted proxy to
+// the address of the client, so access logs, per client limits and access
+// control see the client; the port is unknown and set to zero.
+func (s *Server) withClientIP(r *http.Request) *http.Request {
+	if len(s.TrustedProxies) == 0 {
+		return r
+	}
+	ip := s.clientIP(r)
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); ip == nil || (err == nil && ip.Equal(net.ParseIP(host))) {
+		return r
+	}
+	// Shallow copy, we only change the remote address.
+	r = r.WithContext(r.Context())
+	r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+	return r
+}
@@ -0,0 +1,66 @@
+package ckit
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := ParseTrustedProxies("127.0.0.1, 10.0.0.0/8,::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nets) != 3 {
+		t.Fatalf("got %d networks, want 3", len(nets))
+	}
+	if got := nets[0].String(); got != "127.0.0.1/32" {
+		t.Fatalf("got %s, want 127.0.0.1/32", got)
+	}
+	for _, s := range []string{"localhost", "10.0.0.0/33"} {
+		if _, err := ParseTrustedProxies(s); err == nil {
+			t.Fatalf("%s: got nil, want error", s)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	nets, err := ParseTrustedProxies("127.0.0.1,10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{TrustedProxies: nets}
+	var cases = []struct {
+		remote string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		{"1.2.3.4:1234", nil, "", "1.2.3.4"},
+		// Headers of untrusted clients are ignored.
+		{"1.2.3.4:1234", []string{"5.6.7.8"}, "5.6.7.8", "1.2.3.4"},
+		{"127.0.0.1:1234", nil, "", "127.0.0.1"},
+		{"127.0.0.1:1234", nil, "5.6.7.8", "5.6.7.8"},
+		{"127.0.0.1:1234", []string{"5.6.7.8"}, "", "5.6.7.8"},
+		// A client may send its own X-Forwarded-For.
+		{"127.0.0.1:1234", []string{"6.6.6.6, 5.6.7.8, 10.0.0.2"}, "", "5.6.7.8"},
+		{"127.0.0.1:1234", []string{"6.6.6.6", "5.6.7.8"}, "", "5.6.7.8"},
+		{"127.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"127.0.0.1:1234", []string{"garbage, 10.0.0.2"}, "", "10.0.0.2"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		for _, v := range c.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-IP", c.realIP)
+		}
+		if got := s.clientIP(r).String(); got != c.want {
+			t.Fatalf("%s %v %s: got %s, want %s", c.remote, c.xff, c.realIP, got, c.want)
+		}
+		if got := jobClient(s.withClientIP(r)); got != c.want {
+			t.Fatalf("%s %v %s: job client %s, want %s", c.remote, c.xff, c.realIP, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,771 @@
+package ckit
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRangeCachePages is the number of pages a RangeFetcher keeps in
+// memory, 16MB with the default page size of 4096 bytes.
+const DefaultRangeCachePages = 4096
+
+// maxTreeDepth guards against cycles in a corrupt b-tree; a b-tree with 4K
+// pages and a depth of 20 would hold more rows than fit in a file.
+const maxTreeDepth = 20
+
+// ErrCorrupt is returned, if a remote file is not a valid sqlite database.
+var ErrCorrupt = errors.New("database corrupt")
+
+// b-tree page types, cf. https://www.sqlite.org/fileformat.html#b_tree_pages.
+const (
+	pageInteriorIndex = 2
+	pageInteriorTable = 5
+	pageLeafIndex     = 10
+	pageLeafTable     = 13
+)
+
+// RangeFetcher serves index documents from a sqlite database, as generated
+// by makta, that is served by a remote HTTP server, e.g. nginx or an object
+// store, which supports range requests. Only the pages needed for a lookup
+// are read, one request per level of the idx_k index and the map table, so
+// a small node can serve a large index data file without a local copy.
+// Interior pages are used by every lookup and are kept in a page cache.
+//
+// Experimental. The file is read as it is, without locks or a journal, so it
+// must not change while it is served; publish new data under a new URL.
+type RangeFetcher struct {
+	URL    string
+	Client *http.Client
+
+	size     int64  // file size
+	pageSize int64  // bytes per page
+	usable   int64  // page size without reserved bytes
+	counter  uint32 // file change counter, cf. Ping
+	table    uint32 // root page of the map table
+	index    uint32 // root page of the index on map(k)
+	cache    *pageCache
+}
+
+// OpenRangeFetcher reads the header and the schema of a remote sqlite
+// database. The client may be nil for a client with default settings;
+// cachePages limits the page cache, zero means DefaultRangeCachePages.
+func OpenRangeFetcher(link string, client *http.Client, cachePages int) (*RangeFetcher, error) {
+	if client == nil {
+		client = NewHTTPClient(DefaultMaxIdleConnsPerHost, 5*time.Second)
+	}
+	if cachePages <= 0 {
+		cachePages = DefaultRangeCachePages
+	}
+	f := &RangeFetcher{URL: link, Client: client, cache: newPageCache(cachePages)}
+	ctx := context.Background()
+	h, size, err := f.readRange(ctx, 0, 100)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(h, []byte("SQLite format 3\x00")) {
+		return nil, fmt.Errorf("%s: not a sqlite database", link)
+	}
+	if size <= 0 {
+		// Content-Range may not contain a total, e.g. "bytes 0-99/*".
+		if size, err = f.headSize(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("%s: unknown file size, neither in Content-Range nor Content-Length", link)
+	}
+	f.size = size
+	f.pageSize = int64(binary.BigEndian.Uint16(h[16:18]))
+	if f.pageSize == 1 {
+		f.pageSize = 65536
+	}
+	if f.pageSize < 512 || f.pageSize&(f.pageSize-1) != 0 {
+		return nil, fmt.Errorf("%s: invalid page size: %d", link, f.pageSize)
+	}
+	f.usable = f.pageSize - int64(h[20])
+	f.counter = binary.BigEndian.Uint32(h[24:28])
+	if enc := binary.BigEndian.Uint32(h[56:60]); enc != 1 {
+		return nil, fmt.Errorf("%s: unsupported text encoding: %d", link, enc)
+	}
+	if err := f.readSchema(ctx); err != nil {
+		return nil, fmt.Errorf("%s: %w", link, err)
+	}
+	return f, nil
+}
+
+// readSchema finds the root pages of the map table and its index on k in
+// the schema table.
+func (f *RangeFetcher) readSchema(ctx context.Context) error {
+	err := f.walkTable(ctx, 1, 0, func(rec []sqlValue) error {
+		// type, name, tbl_name, rootpage, sql
+		if len(rec) < 5 || rec[3].kind != valueInt || !strings.EqualFold(string(rec[2].b), "map") {
+			return nil
+		}
+		switch {
+		case string(rec[0].b) == "table":
+			f.table = uint32(rec[3].i)
+		case string(rec[0].b) == "index" && f.index == 0 && isKeyIndex(string(rec[4].b)):
+			f.index = uint32(rec[3].i)
+		}
+		return nil
+	})
+	switch {
+	case err != nil:
+		return err
+	case f.table == 0:
+		return fmt.Errorf("no map table")
+	case f.index == 0:
+		return fmt.Errorf("no index on map(k)")
+	}
+	return nil
+}
+
+// isKeyIndex returns true, if a CREATE INDEX statement indexes exactly the k
+// column with the default collation, e.g. makta's CREATE INDEX idx_k ON map(k).
+func isKeyIndex(stmt string) bool {
+	s := strings.ToLower(strings.Join(strings.Fields(stmt), ""))
+	s = strings.NewReplacer(`"`, "", "`", "", "[", "", "]", "").Replace(s)
+	return strings.HasSuffix(s, "onmap(k)") || strings.HasSuffix(s, "onmap(kasc)")
+}
+
+// readRange reads n bytes at an offset and returns them with the size of the
+// file, as reported by the server; a read past the end of the file is
+// truncated.
+func (f *RangeFetcher) readRange(ctx context.Context, off, n int64) ([]byte, int64, error) {
+	if f.size > 0 && off+n > f.size {
+		n = f.size - off
+	}
+	if n <= 0 {
+		return nil, 0, fmt.Errorf("read beyond end of file at %d: %w", off, ErrCorrupt)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", f.URL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	setTraceHeaders(req)
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		// Drain body, so the connection can be reused; a 200 means, that the
+		// server does not support range requests and sends the whole file,
+		// so we do not read it.
+		if resp.StatusCode != http.StatusOK {
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+		}
+		return nil, 0, fmt.Errorf("range request %s: %s", f.URL, resp.Status)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(resp.Body, b); err != nil {
+		return nil, 0, fmt.Errorf("range request %s: %w", f.URL, err)
+	}
+	// Content-Range: bytes 0-99/1234
+	var size int64
+	if i := strings.LastIndex(resp.Header.Get("Content-Range"), "/"); i >= 0 {
+		size, _ = strconv.ParseInt(resp.Header.Get("Content-Range")[i+1:], 10, 64)
+	}
+	return b, size, nil
+}
+
+// headSize returns the size of the file from the Content-Length of a HEAD
+// request, zero, if unknown.
+func (f *RangeFetcher) headSize(ctx context.Context) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", f.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	setTraceHeaders(req)
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("head request %s: %s", f.URL, resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return 0, nil
+	}
+	return resp.ContentLength, nil
+}
+
+// page returns a page, from the cache, if possible.
+func (f *RangeFetcher) page(ctx context.Context, n uint32) ([]byte, error) {
+	if n == 0 || int64(n-1)*f.pageSize >= f.size {
+		return nil, fmt.Errorf("invalid page %d: %w", n, ErrCorrupt)
+	}
+	if b, ok := f.cache.get(n); ok {
+		return b, nil
+	}
+	b, _, err := f.readRange(ctx, int64(n-1)*f.pageSize, f.pageSize)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) != f.pageSize {
+		return nil, fmt.Errorf("short page %d: %w", n, ErrCorrupt)
+	}
+	f.cache.put(n, b)
+	return b, nil
+}
+
+// btreePage is a parsed b-tree page header, cf.
+// https://www.sqlite.org/fileformat.html#b_tree_pages.
+type btreePage struct {
+	data  []byte
+	kind  byte
+	cells int
+	ptrs  int    // offset of the cell pointer array
+	right uint32 // right-most child of interior pages
+}
+
+// btree reads and parses a b-tree page; the header of page 1 follows the
+// file header.
+func (f *RangeFetcher) btree(ctx context.Context, n uint32) (*btreePage, error) {
+	b, err := f.page(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	off := 0
+	if n == 1 {
+		off = 100
+	}
+	p := &btreePage{data: b, kind: b[off], cells: int(binary.BigEndian.Uint16(b[off+3:]))}
+	switch p.kind {
+	case pageInteriorIndex, pageInteriorTable:
+		p.right, p.ptrs = binary.BigEndian.Uint32(b[off+8:]), off+12
+	case pageLeafIndex, pageLeafTable:
+		p.ptrs = off + 8
+	default:
+		return nil, fmt.Errorf("page %d: invalid page type %d: %w", n, p.kind, ErrCorrupt)
+	}
+	if p.ptrs+2*p.cells > len(b) {
+		return nil, fmt.Errorf("page %d: too many cells: %w", n, ErrCorrupt)
+	}
+	return p, nil
+}
+
+// cell returns the bytes from the start of cell i to the end of the page.
+func (p *btreePage) cell(i int) ([]byte, error) {
+	off := int(binary.BigEndian.Uint16(p.data[p.ptrs+2*i:]))
+	if off < p.ptrs+2*p.cells || off >= len(p.data) {
+		return nil, fmt.Errorf("cell offset %d: %w", off, ErrCorrupt)
+	}
+	return p.data[off:], nil
+}
+
+// child returns the left child page of cell i of an interior page.
+func (p *btreePage) child(i int) (uint32, error) {
+	c, err := p.cell(i)
+	if err != nil {
+		return 0, err
+	}
+	if len(c) < 4 {
+		return 0, ErrCorrupt
+	}
+	return binary.BigEndian.Uint32(c), nil
+}
+
+// rowid returns the integer key of cell i of a table page.
+func (p *btreePage) rowid(i int) (int64, error) {
+	c, err := p.cell(i)
+	if err != nil {
+		return 0, err
+	}
+	if p.kind == pageInteriorTable {
+		if len(c) < 4 {
+			return 0, ErrCorrupt
+		}
+		c = c[4:]
+	} else {
+		_, n := sqliteVarint(c)
+		if n == 0 {
+			return 0, ErrCorrupt
+		}
+		c = c[n:]
+	}
+	v, n := sqliteVarint(c)
+	if n == 0 {
+		return 0, ErrCorrupt
+	}
+	return int64(v), nil
+}
+
+// record decodes the payload of cell i of a leaf table or an index page.
+func (f *RangeFetcher) record(ctx context.Context, p *btreePage, i int) ([]sqlValue, error) {
+	c, err := p.cell(i)
+	if err != nil {
+		return nil, err
+	}
+	if p.kind == pageInteriorIndex {
+		if len(c) < 4 {
+			return nil, ErrCorrupt
+		}
+		c = c[4:]
+	}
+	size, n := sqliteVarint(c)
+	if n == 0 {
+		return nil, ErrCorrupt
+	}
+	c = c[n:]
+	if p.kind == pageLeafTable {
+		if _, n = sqliteVarint(c); n == 0 {
+			return nil, ErrCorrupt
+		}
+		c = c[n:]
+	}
+	b, err := f.payload(ctx, c, int64(size), p.kind == pageLeafTable)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRecord(b)
+}
+
+// payload returns a payload of a given size, starting at the beginning of b,
+// reading overflow pages as needed, cf.
+// https://www.sqlite.org/fileformat.html#cell_payload_overflow_pages.
+func (f *RangeFetcher) payload(ctx context.Context, b []byte, size int64, table bool) ([]byte, error) {
+	u := f.usable
+	x := (u-12)*64/255 - 23
+	if table {
+		x = u - 35
+	}
+	if size <= x {
+		if size > int64(len(b)) {
+			return nil, fmt.Errorf("payload exceeds page: %w", ErrCorrupt)
+		}
+		return b[:size], nil
+	}
+	m := (u-12)*32/255 - 23
+	local := m + (size-m)%(u-4)
+	if local > x {
+		local = m
+	}
+	if local+4 > int64(len(b)) {
+		return nil, fmt.Errorf("payload exceeds page: %w", ErrCorrupt)
+	}
+	result := make([]byte, local, size)
+	copy(result, b[:local])
+	return f.overflow(ctx, result, binary.BigEndian.Uint32(b[local:]), size)
+}
+
+// overflow appends the content of an overflow page chain to a payload,
+// until it has a given size. Documents are written in one go, so their
+// chains are usually contiguous and read with a single request, instead of
+// one per page; overflow pages are not cached.
+func (f *RangeFetcher) overflow(ctx context.Context, result []byte, n uint32, size int64) ([]byte, error) {
+	var (
+		chunk = f.usable - 4
+		run   []byte // contiguous pages read ahead, starting at page first
+		first uint32
+	)
+	for int64(len(result)) < size {
+		if n == 0 {
+			return nil, fmt.Errorf("overflow chain too short: %w", ErrCorrupt)
+		}
+		if run == nil || n < first || int64(n-first) >= int64(len(run))/f.pageSize {
+			if int64(n-1)*f.pageSize >= f.size {
+				return nil, fmt.Errorf("invalid overflow page %d: %w", n, ErrCorrupt)
+			}
+			pages := (size - int64(len(result)) + chunk - 1) / chunk
+			b, _, err := f.readRange(ctx, int64(n-1)*f.pageSize, pages*f.pageSize)
+			if err != nil {
+				return nil, err
+			}
+			if int64(len(b)) < f.pageSize {
+				return nil, fmt.Errorf("short overflow page %d: %w", n, ErrCorrupt)
+			}
+			run, first = b, n
+		}
+		p := run[int64(n-first)*f.pageSize:][:f.pageSize]
+		k := size - int64(len(result))
+		if k > chunk {
+			k = chunk
+		}
+		result = append(result, p[4:4+k]...)
+		n = binary.BigEndian.Uint32(p)
+	}
+	return result, nil
+}
+
+// walkTable calls fn with every record of the table b-tree rooted at page n,
+// in rowid order.
+func (f *RangeFetcher) walkTable(ctx context.Context, n uint32, depth int, fn func([]sqlValue) error) error {
+	if depth > maxTreeDepth {
+		return fmt.Errorf("b-tree too deep: %w", ErrCorrupt)
+	}
+	p, err := f.btree(ctx, n)
+	if err != nil {
+		return err
+	}
+	switch p.kind {
+	case pageInteriorTable:
+		for i := 0; i < p.cells; i++ {
+			child, err := p.child(i)
+			if err != nil {
+				return err
+			}
+			if err := f.walkTable(ctx, child, depth+1, fn); err != nil {
+				return err
+			}
+		}
+		return f.walkTable(ctx, p.right, depth+1, fn)
+	case pageLeafTable:
+		for i := 0; i < p.cells; i++ {
+			rec, err := f.record(ctx, p, i)
+			if err != nil {
+				return err
+			}
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("page %d: want table page: %w", n, ErrCorrupt)
+	}
+}
+
+// searchIndex returns the rowid of the first entry with a given key in the
+// index b-tree rooted at page n. Index entries are (k, rowid); interior
+// pages hold entries, too, and their left child all entries up to and
+// including theirs, so equal entries may precede an interior entry.
+func (f *RangeFetcher) searchIndex(ctx context.Context, n uint32, key string, depth int) (int64, bool, error) {
+	if depth > maxTreeDepth {
+		return 0, false, fmt.Errorf("b-tree too deep: %w", ErrCorrupt)
+	}
+	p, err := f.btree(ctx, n)
+	if err != nil {
+		return 0, false, err
+	}
+	if p.kind != pageInteriorIndex && p.kind != pageLeafIndex {
+		return 0, false, fmt.Errorf("page %d: want index page: %w", n, ErrCorrupt)
+	}
+	entry := func(i int) ([]sqlValue, error) {
+		rec, err := f.record(ctx, p, i)
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("index entry: %w", ErrCorrupt)
+		}
+		return rec, nil
+	}
+	var ferr error
+	// First entry, that is not less than the key.
+	i := sort.Search(p.cells, func(i int) bool {
+		rec, err := entry(i)
+		if err != nil {
+			ferr = err
+			return true
+		}
+		return compareKey(rec[0], key) >= 0
+	})
+	if ferr != nil {
+		return 0, false, ferr
+	}
+	if p.kind == pageInteriorIndex {
+		child := p.right
+		if i < p.cells {
+			if child, err = p.child(i); err != nil {
+				return 0, false, err
+			}
+		}
+		rowid, ok, err := f.searchIndex(ctx, child, key, depth+1)
+		if err != nil || ok {
+			return rowid, ok, err
+		}
+	}
+	if i == p.cells {
+		return 0, false, nil
+	}
+	found, err := entry(i)
+	if err != nil {
+		return 0, false, err
+	}
+	if compareKey(found[0], key) != 0 {
+		return 0, false, nil
+	}
+	last := found[len(found)-1]
+	if last.kind != valueInt {
+		return 0, false, fmt.Errorf("index entry without rowid: %w", ErrCorrupt)
+	}
+	return last.i, true, nil
+}
+
+// searchTable returns the record with a given rowid in the table b-tree
+// rooted at page n.
+func (f *RangeFetcher) searchTable(ctx context.Context, n uint32, rowid int64, depth int) ([]sqlValue, bool, error) {
+	if depth > maxTreeDepth {
+		return nil, false, fmt.Errorf("b-tree too deep: %w", ErrCorrupt)
+	}
+	p, err := f.btree(ctx, n)
+	if err != nil {
+		return nil, false, err
+	}
+	var ferr error
+	i := sort.Search(p.cells, func(i int) bool {
+		v, err := p.rowid(i)
+		if err != nil {
+			ferr = err
+			return true
+		}
+		return v >= rowid
+	})
+	if ferr != nil {
+		return nil, false, ferr
+	}
+	switch p.kind {
+	case pageInteriorTable:
+		child := p.right
+		if i < p.cells {
+			if child, err = p.child(i); err != nil {
+				return nil, false, err
+			}
+		}
+		return f.searchTable(ctx, child, rowid, depth+1)
+	case pageLeafTable:
+		if i == p.cells {
+			return nil, false, nil
+		}
+		if v, _ := p.rowid(i); v != rowid {
+			return nil, false, nil
+		}
+		rec, err := f.record(ctx, p, i)
+		return rec, err == nil, err
+	default:
+		return nil, false, fmt.Errorf("page %d: want table page: %w", n, ErrCorrupt)
+	}
+}
+
+// Fetch document.
+func (f *RangeFetcher) Fetch(id string) ([]byte, error) {
+	return f.FetchContext(context.Background(), id)
+}
+
+// FetchContext looks up the rowid of a document in the index, then the
+// document in the table.
+func (f *RangeFetcher) FetchContext(ctx context.Context, id string) ([]byte, error) {
+	rowid, ok, err := f.searchIndex(ctx, f.index, id, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+	rec, ok, err := f.searchTable(ctx, f.table, rowid, 0)
+	switch {
+	case err != nil:
+		return nil, err
+	case !ok:
+		return nil, fmt.Errorf("rowid %d not in table: %w", rowid, ErrCorrupt)
+	case len(rec) < 2:
+		return nil, nil
+	}
+	return rec[1].b, nil
+}
+
+// ApproximateCount returns the largest rowid, like SqliteFetcher, which is
+// found on the right-most path of the table.
+func (f *RangeFetcher) ApproximateCount(ctx context.Context) (int64, error) {
+	n := f.table
+	for depth := 0; depth <= maxTreeDepth; depth++ {
+		p, err := f.btree(ctx, n)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case p.kind == pageInteriorTable:
+			n = p.right
+		case p.kind == pageLeafTable && p.cells == 0:
+			return 0, nil
+		case p.kind == pageLeafTable:
+			return p.rowid(p.cells - 1)
+		default:
+			return 0, fmt.Errorf("page %d: want table page: %w", n, ErrCorrupt)
+		}
+	}
+	return 0, fmt.Errorf("b-tree too deep: %w", ErrCorrupt)
+}
+
+// Ping reads the file header again and fails, if the file has changed since
+// it was opened, as cached pages would be stale.
+func (f *RangeFetcher) Ping() error {
+	h, _, err := f.readRange(context.Background(), 0, 100)
+	if err != nil {
+		return err
+	}
+	if c := binary.BigEndian.Uint32(h[24:28]); c != f.counter {
+		return fmt.Errorf("%s: file changed since start", f.URL)
+	}
+	return nil
+}
+
+// Capabilities of a remote database; a batch would not save requests.
+func (f *RangeFetcher) Capabilities() Capabilities {
+	return Capabilities{Ping: true, Count: true}
+}
+
+// Kinds of values, in sqlite sort order, cf.
+// https://www.sqlite.org/datatype3.html#sort_order.
+const (
+	valueNull = iota
+	valueInt
+	valueFloat
+	valueText
+	valueBlob
+)
+
+// sqlValue is a column of a record; floats are not needed and not decoded.
+type sqlValue struct {
+	kind int
+	i    int64
+	b    []byte
+}
+
+// compareKey compares a value with a text key, with the binary collation.
+func compareKey(v sqlValue, key string) int {
+	switch v.kind {
+	case valueText:
+		return strings.Compare(string(v.b), key)
+	case valueBlob:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// sqliteVarint decodes a big-endian variable length integer, cf.
+// https://www.sqlite.org/fileformat.html#varint; n is zero, if b is too short.
+func sqliteVarint(b []byte) (v uint64, n int) {
+	for i := 0; i < 8; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	if len(b) < 9 {
+		return 0, 0
+	}
+	return v<<8 | uint64(b[8]), 9
+}
+
+// decodeRecord decodes the columns of a record, cf.
+// https://www.sqlite.org/fileformat.html#record_format.
+func decodeRecord(p []byte) ([]sqlValue, error) {
+	hlen, n := sqliteVarint(p)
+	if n == 0 || hlen > uint64(len(p)) || hlen < uint64(n) {
+		return nil, fmt.Errorf("record header: %w", ErrCorrupt)
+	}
+	var (
+		result []sqlValue
+		off    = int(hlen)
+	)
+	for h := p[n:hlen]; len(h) > 0; {
+		t, k := sqliteVarint(h)
+		if k == 0 {
+			return nil, fmt.Errorf("record header: %w", ErrCorrupt)
+		}
+		h = h[k:]
+		var size uint64
+		switch {
+		case t <= 4:
+			size = t
+		case t == 5:
+			size = 6
+		case t == 6 || t == 7:
+			size = 8
+		case t == 8 || t == 9:
+		case t >= 12:
+			size = (t - 12) / 2
+		default:
+			return nil, fmt.Errorf("serial type %d: %w", t, ErrCorrupt)
+		}
+		if uint64(off)+size > uint64(len(p)) {
+			return nil, fmt.Errorf("record body: %w", ErrCorrupt)
+		}
+		b := p[off : off+int(size)]
+		off += int(size)
+		switch {
+		case t == 0:
+			result = append(result, sqlValue{kind: valueNull})
+		case t <= 6:
+			var v int64
+			if b[0]&0x80 != 0 {
+				v = -1
+			}
+			for _, c := range b {
+				v = v<<8 | int64(c)
+			}
+			result = append(result, sqlValue{kind: valueInt, i: v})
+		case t == 7:
+			result = append(result, sqlValue{kind: valueFloat})
+		case t == 8 || t == 9:
+			result = append(result, sqlValue{kind: valueInt, i: int64(t - 8)})
+		case t%2 == 0:
+			result = append(result, sqlValue{kind: valueBlob, b: b})
+		default:
+			result = append(result, sqlValue{kind: valueText, b: b})
+		}
+	}
+	return result, nil
+}
+
+// pageCache keeps the most recently used pages.
+type pageCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	pages map[uint32]*list.Element
+}
+
+type cachedPage struct {
+	n    uint32
+	data []byte
+}
+
+func newPageCache(size int) *pageCache {
+	return &pageCache{size: size, ll: list.New(), pages: make(map[uint32]*list.Element)}
+}
+
+func (c *pageCache) get(n uint32) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.pages[n]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*cachedPage).data, true
+}
+
+func (c *pageCache) put(n uint32, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pages[n]; ok {
+		return
+	}
+	c.pages[n] = c.ll.PushFront(&cachedPage{n: n, data: data})
+	if c.ll.Len() > c.size {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.pages, e.Value.(*cachedPage).n)
+	}
+}
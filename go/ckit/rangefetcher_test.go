@@ -0,0 +1,223 @@
+package ckit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// writeIndexData writes rows into a new database with a given page size, as
+// makta does, and returns the filename.
+func writeIndexData(t *testing.T, pageSize int, rows map[string]string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "index.db")
+	db, err := sqlx.Open(sqliteDriver, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA page_size = %d", pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE map (k TEXT, v TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range rows {
+		if _, err := db.Exec("INSERT INTO map VALUES (?, ?)", k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("CREATE INDEX idx_k ON map(k)"); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestRangeFetcher(t *testing.T) {
+	rows := make(map[string]string)
+	for i := 0; i < 2000; i++ {
+		k := fmt.Sprintf("ai-%d-%x", i%7, i*7919)
+		switch {
+		case i%100 == 0:
+			rows[k] = strings.Repeat(fmt.Sprintf(`{"id": %d}`, i), 1000) // overflow
+		default:
+			rows[k] = fmt.Sprintf(`{"id": "%s", "title": "%s"}`, k, strings.Repeat("x", i%300))
+		}
+	}
+	for _, pageSize := range []int{512, 4096} {
+		filename := writeIndexData(t, pageSize, rows)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, filename)
+		}))
+		defer ts.Close()
+		f, err := OpenRangeFetcher(ts.URL, nil, 16)
+		if err != nil {
+			t.Fatalf("[%d] open: %v", pageSize, err)
+		}
+		for k, v := range rows {
+			b, err := f.Fetch(k)
+			if err != nil {
+				t.Fatalf("[%d] fetch %s: %v", pageSize, k, err)
+			}
+			if string(b) != v {
+				t.Fatalf("[%d] fetch %s: got %d bytes, want %d", pageSize, k, len(b), len(v))
+			}
+		}
+		for _, k := range []string{"", "ai-0", "ai-9", "zzz"} {
+			if _, err := f.Fetch(k); !errors.Is(err, ErrBlobNotFound) {
+				t.Fatalf("[%d] fetch %q: got %v, want ErrBlobNotFound", pageSize, k, err)
+			}
+		}
+		n, err := ApproximateCount(context.Background(), f)
+		if err != nil {
+			t.Fatalf("[%d] count: %v", pageSize, err)
+		}
+		if n != int64(len(rows)) {
+			t.Fatalf("[%d] count: got %d, want %d", pageSize, n, len(rows))
+		}
+		if err := PingFetcher(f); err != nil {
+			t.Fatalf("[%d] ping: %v", pageSize, err)
+		}
+	}
+}
+
+func TestRangeFetcherTestdata(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer ts.Close()
+	f, err := OpenRangeFetcher(ts.URL+"/id_metadata.db", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &FetchGroup{}
+	if err := g.FromFiles("testdata/id_metadata.db"); err != nil {
+		t.Fatal(err)
+	}
+	defer closeFetcher(g)
+	db := sqliteBackends(g)[0].DB
+	var keys []string
+	if err := db.Select(&keys, "SELECT k FROM map"); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		want, err := g.Fetch(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := f.Fetch(k)
+		if err != nil {
+			t.Fatalf("fetch %s: %v", k, err)
+		}
+		if string(got) != string(want) {
+			t.Fatalf("fetch %s: got %s, want %s", k, got, want)
+		}
+	}
+}
+
+func TestRangeFetcherErrors(t *testing.T) {
+	// Without range support, the whole file is sent with 200.
+	noRange := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadFile("testdata/id_metadata.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write(b)
+	}))
+	defer noRange.Close()
+	if _, err := OpenRangeFetcher(noRange.URL, nil, 0); err == nil {
+		t.Fatalf("got nil, want error for server without range requests")
+	}
+	files := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer files.Close()
+	for _, name := range []string{"id_metadata.tsv", "missing.db"} {
+		if _, err := OpenRangeFetcher(files.URL+"/"+name, nil, 0); err == nil {
+			t.Fatalf("%s: got nil, want error", name)
+		}
+	}
+	// Without a total in Content-Range, the size is taken from a HEAD
+	// request; if that fails, too, the file cannot be opened.
+	filename := writeIndexData(t, 4096, map[string]string{"a": "1"})
+	for _, head := range []bool{true, false} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "HEAD" && !head {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			rw := &unknownSizeWriter{ResponseWriter: w}
+			http.ServeFile(rw, r, filename)
+		}))
+		defer ts.Close()
+		f, err := OpenRangeFetcher(ts.URL, nil, 0)
+		switch {
+		case head && err != nil:
+			t.Fatalf("unknown size with HEAD: %v", err)
+		case head:
+			if b, err := f.Fetch("a"); err != nil || string(b) != "1" {
+				t.Fatalf("unknown size with HEAD: got %s, %v, want 1", b, err)
+			}
+		case err == nil:
+			t.Fatalf("unknown size without HEAD: got nil, want error")
+		}
+	}
+	// A change of the file is noticed by Ping.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filename)
+	}))
+	defer ts.Close()
+	f, err := OpenRangeFetcher(ts.URL, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sqlx.Open(sqliteDriver, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("INSERT INTO map VALUES ('b', '2')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Ping(); err == nil {
+		t.Fatalf("got nil, want error after file change")
+	}
+}
+
+// unknownSizeWriter hides the total size in Content-Range, as some servers
+// do for files, that are still growing.
+type unknownSizeWriter struct {
+	http.ResponseWriter
+}
+
+func (w *unknownSizeWriter) WriteHeader(code int) {
+	if v := w.Header().Get("Content-Range"); v != "" {
+		w.Header().Set("Content-Range", v[:strings.LastIndex(v, "/")]+"/*")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func TestSqliteVarint(t *testing.T) {
+	var cases = []struct {
+		b []byte
+		v uint64
+		n int
+	}{
+		{[]byte{0x00}, 0, 1},
+		{[]byte{0x7f}, 127, 1},
+		{[]byte{0x81, 0x00}, 128, 2},
+		{[]byte{0x81}, 0, 0},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 1<<64 - 1, 9},
+	}
+	for _, c := range cases {
+		v, n := sqliteVarint(c.b)
+		if v != c.v || n != c.n {
+			t.Fatalf("sqliteVarint(%x): got %d, %d, want %d, %d", c.b, v, n, c.v, c.n)
+		}
+	}
+}
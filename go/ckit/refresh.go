@@ -0,0 +1,144 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/slub/labe/go/ckit/cache"
+)
+
+// ctxKey is the type for request context keys of this package.
+type ctxKey int
+
+const (
+	// refreshKey marks a background refresh request; these bypass the cache
+	// and always replace the cached value.
+	refreshKey ctxKey = iota
+	// jobKey marks requests run as part of a job; these are not limited by
+	// MaxEdges or MaxResponseSize.
+	jobKey
+	// progressKey holds a *progress for requests reporting their progress.
+	progressKey
+	// timingsKey holds *timings for requests recording the duration of
+	// every query and fetch, cf. withTimings.
+	timingsKey
+	// traceKey holds the *trace of a request, cf. withTrace.
+	traceKey
+	// internalKey marks requests, which we run ourselves, e.g. for events,
+	// jobs and refreshes; these have no client address and skip access
+	// control and API key accounting, cf. serveRecover.
+	internalKey
+)
+
+// isRefresh returns true, if the context belongs to a refresh request.
+func isRefresh(ctx context.Context) bool {
+	v, _ := ctx.Value(refreshKey).(bool)
+	return v
+}
+
+// isInternal returns true, if the context belongs to an internal request.
+func isInternal(ctx context.Context) bool {
+	v, _ := ctx.Value(internalKey).(bool)
+	return v
+}
+
+// RefreshHot periodically recomputes the cached responses of the n most
+// requested ids, as recorded in Hot. Cached values do not expire, but the
+// underlying data may change; refreshing keeps popular records up to date
+// without a cache flush, which would result in slow requests for exactly
+// these records. If RefreshMaxAge is set, only values that would exceed this
+// age before the next run are recomputed, fresh values are left alone.
+// Blocks until the context is cancelled.
+func (s *Server) RefreshHot(ctx context.Context, interval time.Duration, n int) {
+	// Refresh values within one interval of their maximum age, so they
+	// never get older than RefreshMaxAge.
+	var due time.Duration
+	if s.RefreshMaxAge > interval {
+		due = s.RefreshMaxAge - interval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var (
+			started = time.Now()
+			ids     = s.Hot.Top(n)
+			count   int
+		)
+		// Recent requests should count more than old ones.
+		s.Hot.Decay()
+		for _, id := range ids {
+			if ctx.Err() != nil {
+				return
+			}
+			ok, err := s.refresh(ctx, id, due)
+			if err != nil {
+				log.Printf("[refresh] %s: %v", id, err)
+				continue
+			}
+			if ok {
+				count++
+			}
+		}
+		s.Stats.MeasureSinceWithLabels("refresh", started, nil)
+		log.Printf("[refresh] refreshed %d/%d hot ids in %s", count, len(ids), time.Since(started))
+	}
+}
+
+// refresh recomputes the response for an id and replaces the cached value,
+// if the id is currently cached and the value is at least as old as due.
+// Returns true, if the value was refreshed.
+func (s *Server) refresh(ctx context.Context, id string, due time.Duration) (bool, error) {
+	_, modified, err := s.Cache.GetWithTime(id)
+	if err == cache.ErrCacheMiss {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if time.Since(modified) < due {
+		return false, nil
+	}
+	ctx = context.WithValue(ctx, refreshKey, true)
+	req, err := http.NewRequestWithContext(ctx, "GET", s.PathPrefix+"/id/"+url.PathEscape(id), nil)
+	if err != nil {
+		return false, err
+	}
+	w := &internalResponseWriter{header: make(http.Header), w: ioutil.Discard, code: http.StatusOK}
+	if err := s.serveRecover(w, req); err != nil {
+		return false, err
+	}
+	if w.code >= 400 {
+		return false, fmt.Errorf("got HTTP %d", w.code)
+	}
+	return true, nil
+}
+
+// internalResponseWriter is used for requests, which we run ourselves; it
+// writes the body to w and keeps the status code and the number of bytes
+// written.
+type internalResponseWriter struct {
+	header http.Header
+	w      io.Writer
+	code   int
+	n      int64
+}
+
+func (w *internalResponseWriter) Header() http.Header { return w.header }
+
+func (w *internalResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *internalResponseWriter) WriteHeader(code int) { w.code = code }
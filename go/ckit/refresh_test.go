@@ -0,0 +1,49 @@
+package ckit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRefreshDue(t *testing.T) {
+	store := newMemoryStore()
+	h := newHarness(t, func(s *Server) {
+		s.Cache = store
+	})
+	id, _ := h.someID(t)
+	due := 50 * time.Minute
+	var cases = []struct {
+		about string
+		age   time.Duration
+		want  bool
+	}{
+		{"fresh value is left alone", time.Minute, false},
+		{"value near expiry is refreshed", 55 * time.Minute, true},
+	}
+	for _, c := range cases {
+		modified := time.Now().Add(-c.age)
+		store.mu.Lock()
+		store.values[id] = []byte(`{}`)
+		store.modified[id] = modified
+		store.mu.Unlock()
+		ok, err := h.srv.refresh(context.Background(), id, due)
+		if err != nil {
+			t.Fatalf("%s: %v", c.about, err)
+		}
+		if ok != c.want {
+			t.Fatalf("%s: got %v, want %v", c.about, ok, c.want)
+		}
+		_, m, err := store.GetWithTime(id)
+		if err != nil {
+			t.Fatalf("%s: %v", c.about, err)
+		}
+		if refreshed := m.After(modified); refreshed != c.want {
+			t.Fatalf("%s: got value replaced %v, want %v", c.about, refreshed, c.want)
+		}
+	}
+	// Ids, which are not cached, are not computed.
+	if ok, err := h.srv.refresh(context.Background(), "unknown", 0); ok || err != nil {
+		t.Fatalf("unknown: got %v, %v, want false, nil", ok, err)
+	}
+}
@@ -0,0 +1,74 @@
+package ckit
+
+import (
+	"context"
+	"net/http"
+)
+
+// RequestIDHeader carries the id of a request; labed takes it from the
+// client or a proxy, or assigns one, returns it to the client and forwards it
+// to the index data service.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength limits request ids taken from clients.
+const maxRequestIDLength = 128
+
+// traceHeaders are W3C trace context headers, forwarded as they are.
+var traceHeaders = []string{"traceparent", "tracestate"}
+
+// trace is the request id and trace context of a request.
+type trace struct {
+	id     string
+	header http.Header // trace context headers, may be empty
+}
+
+// validRequestID returns true, if a client supplied request id is short and
+// printable, so it is safe to log and to forward.
+func validRequestID(s string) bool {
+	if s == "" || len(s) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// withTrace returns a context carrying the request id and trace context
+// headers of a request; a request id is assigned, if the request has none.
+func withTrace(ctx context.Context, r *http.Request) (context.Context, *trace) {
+	t := &trace{id: r.Header.Get(RequestIDHeader), header: make(http.Header)}
+	if !validRequestID(t.id) {
+		t.id = randString(16)
+	}
+	for _, k := range traceHeaders {
+		if v := r.Header.Get(k); v != "" {
+			t.header.Set(k, v)
+		}
+	}
+	return context.WithValue(ctx, traceKey, t), t
+}
+
+// RequestID returns the id of the request a context belongs to, or the
+// empty string.
+func RequestID(ctx context.Context) string {
+	if t, ok := ctx.Value(traceKey).(*trace); ok {
+		return t.id
+	}
+	return ""
+}
+
+// setTraceHeaders adds the request id and trace context of the originating
+// request, taken from the context of an outgoing request, to its headers.
+func setTraceHeaders(req *http.Request) {
+	t, ok := req.Context().Value(traceKey).(*trace)
+	if !ok {
+		return
+	}
+	req.Header.Set(RequestIDHeader, t.id)
+	for k, vs := range t.header {
+		req.Header[k] = vs
+	}
+}
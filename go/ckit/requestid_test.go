@@ -0,0 +1,76 @@
+package ckit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestValidRequestID(t *testing.T) {
+	var cases = []struct {
+		id   string
+		want bool
+	}{
+		{"", false},
+		{"abc-123", true},
+		{"with space", false},
+		{"line\nbreak", false},
+		{strings.Repeat("a", maxRequestIDLength), true},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, c := range cases {
+		if got := validRequestID(c.id); got != c.want {
+			t.Fatalf("validRequestID(%q): got %v, want %v", c.id, got, c.want)
+		}
+	}
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen []http.Header
+	)
+	blobs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Header.Clone())
+		mu.Unlock()
+		fmt.Fprint(w, `{}`)
+	}))
+	defer blobs.Close()
+	h := newHarness(t, func(s *Server) {
+		s.IndexData = &HTTPFetcher{URL: blobs.URL + "/%s"}
+	})
+	id, _ := h.someID(t)
+	req, err := http.NewRequest("GET", h.ts.URL+"/id/"+id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req.Header.Set(RequestIDHeader, "req-1")
+	req.Header.Set("traceparent", traceparent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get(RequestIDHeader); got != "req-1" {
+		t.Fatalf("got request id %q, want req-1", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) == 0 {
+		t.Fatalf("no index data fetches")
+	}
+	for _, hdr := range seen {
+		if hdr.Get(RequestIDHeader) != "req-1" || hdr.Get("traceparent") != traceparent {
+			t.Fatalf("got %v, want request id and trace context", hdr)
+		}
+	}
+	// Requests without an id get one.
+	if resp, _ := h.do(t, "GET", "/version"); resp.Header.Get(RequestIDHeader) == "" {
+		t.Fatalf("got no request id")
+	}
+}
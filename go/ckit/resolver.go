@@ -0,0 +1,569 @@
+package ckit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/set"
+)
+
+// errNoCitations is returned, if a DOI is neither citing nor cited.
+var errNoCitations = errors.New("no citations found")
+
+// ResolveOptions select the documents of a response.
+type ResolveOptions struct {
+	// Offset selects a page, cf. Server.MaxEdges.
+	Offset int
+	// Cursor selects a page, if Paged is set, cf. cursorPage; an empty
+	// cursor is the first page.
+	Cursor string
+	Paged  bool
+	// Sorted orders documents by DOI.
+	Sorted bool
+	// Blobs includes index data; otherwise documents consist of local id
+	// and DOI only.
+	Blobs bool
+	// Query is kept in links to the next page.
+	Query url.Values
+}
+
+// first returns true for the page with unmatched documents.
+func (o ResolveOptions) first() bool {
+	return o.Offset == 0 && o.Cursor == ""
+}
+
+// Resolver assembles the response for a local id, step by step: Resolve the
+// id to a DOI, find the Edges, Map the DOI back to local ids, Fetch the index
+// data and Assemble the response; Run runs all steps. A resolver is used for
+// a single request. Errors are wrapped with the step they occurred in.
+type Resolver struct {
+	s        *Server
+	opts     ResolveOptions
+	response *Response
+	sw       *StopWatch
+	started  time.Time
+
+	outbound, inbound set.Set
+	ds                set.Set // all citing and cited DOI
+	unmatchedSet      set.Set
+	unmatchedSize     int // bytes of unmatched documents
+	ids               []Map
+	edgeSources       map[string][]string
+	live              map[string][]byte
+	degraded          bool
+	// fetchDeadline applies to all index data fetches of a request, cf.
+	// Server.IndexDataTimeout.
+	fetchDeadline time.Time
+}
+
+// NewResolver returns a resolver for a local id; sw may be nil.
+func (s *Server) NewResolver(id string, opts ResolveOptions, sw *StopWatch) *Resolver {
+	if sw == nil {
+		sw = &StopWatch{}
+	}
+	return &Resolver{
+		s:            s,
+		opts:         opts,
+		response:     &Response{ID: id},
+		sw:           sw,
+		started:      time.Now(),
+		outbound:     set.New(),
+		inbound:      set.New(),
+		ds:           set.New(),
+		unmatchedSet: set.New(),
+	}
+}
+
+// Response returns the response, which is complete after Assemble.
+func (rv *Resolver) Response() *Response {
+	return rv.response
+}
+
+// Degraded returns true, if index data could not be fetched, cf.
+// Server.Degraded.
+func (rv *Resolver) Degraded() bool {
+	return rv.degraded
+}
+
+// Run runs all steps and returns the response; documents are collected in
+// memory, cf. Stream.
+func (rv *Resolver) Run(ctx context.Context) (*Response, error) {
+	for _, step := range []func(context.Context) error{
+		rv.Resolve,
+		rv.CheckDegree,
+		rv.Edges,
+		rv.Map,
+		func(context.Context) error { return rv.CheckSize() },
+		rv.Prepare,
+		rv.Fetch,
+	} {
+		if err := step(ctx); err != nil {
+			return nil, err
+		}
+	}
+	rv.Assemble()
+	return rv.response, nil
+}
+
+// Resolve looks up the DOI for the local id; sql.ErrNoRows, if there is none.
+func (rv *Resolver) Resolve(ctx context.Context) error {
+	t := time.Now()
+	if err := rv.s.identifierToDOI(ctx, rv.response.ID, &rv.response.DOI); err != nil {
+		return fmt.Errorf("doi lookup (%s): %w", rv.response.ID, err)
+	}
+	rv.s.Stats.MeasureSinceWithLabels("sql_query", t, nil)
+	rv.sw.Recordf("found doi: %s", rv.response.DOI)
+	return nil
+}
+
+// Edges finds outbound and inbound edges, including those from additional
+// sources; errNoCitations, if there are none.
+func (rv *Resolver) Edges(ctx context.Context) error {
+	citing, cited, err := rv.s.edges(ctx, rv.response.DOI)
+	if err != nil {
+		return fmt.Errorf("edges: %w", err)
+	}
+	// Optional: Add edges from additional sources, e.g. Crossref Event Data.
+	if len(rv.s.EdgeSources) > 0 {
+		if citing, cited, rv.edgeSources, err = rv.s.sourceEdges(ctx, rv.response, citing, cited); err != nil {
+			return fmt.Errorf("edge sources: %w", err)
+		}
+	}
+	rv.sw.Recordf("found %d outbound and %d inbound edges", len(citing), len(cited))
+	// We want to collect the unique set of DOI to get the complete indexed
+	// documents.
+	for _, v := range citing {
+		rv.outbound.Add(v.Value)
+	}
+	for _, v := range cited {
+		rv.inbound.Add(v.Key)
+	}
+	rv.ds = rv.outbound.Union(rv.inbound)
+	progressFrom(ctx).event("edges", map[string]int{"citing": rv.outbound.Len(), "cited": rv.inbound.Len()})
+	if rv.ds.IsEmpty() {
+		return fmt.Errorf("%w: %s", errNoCitations, rv.response.ID)
+	}
+	return nil
+}
+
+// Map maps DOI back to local ids, records unmatched DOI, adds annotations and
+// selects the documents of the requested page.
+func (rv *Resolver) Map(ctx context.Context) error {
+	var (
+		s        = rv.s
+		response = rv.response
+		first    = rv.opts.first()
+		err      error
+	)
+	if rv.ids, err = s.mapToLocal(ctx, rv.ds.Slice()); err != nil {
+		return fmt.Errorf("map: %w", err)
+	}
+	rv.sw.Recordf("mapped %d dois back to ids", rv.ds.Len())
+	// Here, we can find unmatched items, via DOI.
+	matched := make([]string, 0, len(rv.ids))
+	for _, v := range rv.ids {
+		matched = append(matched, v.Value)
+	}
+	matchedSet := set.FromSlice(matched)
+	rv.unmatchedSet = rv.ds.Difference(matchedSet)
+	// Optional: Look up unmatched DOI in a live index; documents found there
+	// are included like index data, on the first page.
+	if s.LiveIndex != nil && first && rv.opts.Blobs && !rv.unmatchedSet.IsEmpty() {
+		liveCtx, cancel := withTimeout(ctx, s.IndexDataTimeout)
+		rv.live = s.lookupLive(liveCtx, response, rv.unmatchedSet.Sorted())
+		cancel()
+		for doi := range rv.live {
+			rv.unmatchedSet.Remove(doi)
+		}
+		response.Extra.LiveCount = len(rv.live)
+		rv.sw.Recordf("found %d unmatched dois in live index", len(rv.live))
+	}
+	// Optional: Enrich unmatched DOI with metadata from OpenAlex.
+	var works map[string][]byte
+	if s.OpenAlex != nil && first && rv.opts.Blobs && !rv.unmatchedSet.IsEmpty() {
+		enrichCtx, cancel := withTimeout(ctx, s.IndexDataTimeout)
+		works = s.enrichUnmatched(enrichCtx, rv.unmatchedSet.Sorted())
+		cancel()
+		rv.sw.Recordf("found %d unmatched dois in openalex", len(works))
+	}
+	// Optional: Annotate documents with their open access status.
+	if s.OADatabase != nil {
+		oa, err := s.oaStatus(ctx, rv.ds.Slice())
+		if err != nil {
+			return fmt.Errorf("oa: %w", err)
+		}
+		response.annotateDOI(rv.ids, matchedSet, rv.ds, "oa", func(doi string) (interface{}, bool) {
+			status, ok := oa[doi]
+			return status, ok
+		})
+		response.Extra.OA = make(map[string]int)
+		for doi := range rv.ds {
+			if status, ok := oa[doi]; ok {
+				response.Extra.OA[status]++
+			} else {
+				response.Extra.OA["unknown"]++
+			}
+		}
+		rv.sw.Recordf("found oa status for %d dois", len(oa))
+	}
+	// Label documents with the sources of their edges.
+	if rv.edgeSources != nil {
+		response.annotateDOI(rv.ids, matchedSet, rv.ds, "edge_sources", func(doi string) (interface{}, bool) {
+			names, ok := rv.edgeSources[doi]
+			return names, ok
+		})
+	}
+	// Optional: Flag retracted works.
+	if s.Retractions != nil {
+		response.annotateDOI(rv.ids, matchedSet, rv.ds, "retraction", func(doi string) (interface{}, bool) {
+			return s.Retractions.Lookup(doi)
+		})
+		response.Extra.Retracted = map[string]int{"citing": 0, "cited": 0}
+		for doi := range rv.ds {
+			if _, ok := s.Retractions.Lookup(doi); !ok {
+				continue
+			}
+			if rv.outbound.Contains(doi) {
+				response.Extra.Retracted["citing"]++
+			}
+			if rv.inbound.Contains(doi) {
+				response.Extra.Retracted["cited"]++
+			}
+		}
+	}
+	rv.recordUnmatched(works)
+	// Expand at most MaxEdges documents per request; clients can page through
+	// the rest. Unmatched documents are only on the first page.
+	if !first {
+		response.Unmatched.Citing = nil
+		response.Unmatched.Cited = nil
+	}
+	switch {
+	case rv.opts.Paged:
+		limit := s.MaxEdges
+		if isJob(ctx) {
+			limit = 0
+		}
+		var next string
+		if rv.ids, next = cursorPage(rv.ids, rv.opts.Cursor, limit); next != "" {
+			response.Extra.Truncated = true
+			response.Extra.Cursor = next
+			response.Extra.Next = s.nextCursorPage(rv.opts.Query, response.ID, next)
+		}
+		rv.sw.Recordf("paged to %d ids after cursor %q", len(rv.ids), rv.opts.Cursor)
+	case rv.opts.Offset > 0 || (s.MaxEdges > 0 && len(rv.ids) > s.MaxEdges && !isJob(ctx)):
+		ids := rv.ids
+		sort.Slice(ids, func(i, j int) bool { return ids[i].Key < ids[j].Key })
+		ids = ids[minInt(rv.opts.Offset, len(ids)):]
+		if s.MaxEdges > 0 && len(ids) > s.MaxEdges && !isJob(ctx) {
+			ids = ids[:s.MaxEdges]
+			response.Extra.Truncated = true
+			response.Extra.Next = s.nextPage(rv.opts.Query, response.ID, rv.opts.Offset+s.MaxEdges)
+		}
+		rv.ids = ids
+		rv.sw.Recordf("truncated to %d ids", len(rv.ids))
+	}
+	// Optional: Sort documents by DOI; cursor pages already are.
+	if rv.opts.Sorted && !rv.opts.Paged {
+		sortByDOI(rv.ids)
+	}
+	progressFrom(ctx).matched(len(rv.ids), len(rv.unmatchedSet))
+	return nil
+}
+
+// recordUnmatched adds the unmatched documents, with OpenAlex works, if any.
+func (rv *Resolver) recordUnmatched(works map[string][]byte) {
+	response := rv.response
+	// All unmatched snippets share a single, presized backing array.
+	var size int
+	for k := range rv.unmatchedSet {
+		size += len(k) + 20 + len(works[k]) + len(response.annotations[k])
+	}
+	arena := make([]byte, 0, size)
+	unmatchedKeys := rv.unmatchedSet.Slice()
+	if rv.opts.Sorted {
+		sort.Strings(unmatchedKeys)
+	}
+	for _, k := range unmatchedKeys {
+		// We shortcut and do not use a proper JSON marshaller to save a bit
+		// of time. TODO: may switch to proper JSON encoding, if other parts
+		// are more optimized.
+		start := len(arena)
+		arena = append(arena, `{"doi_str_mv": `...)
+		arena = strconv.AppendQuote(arena, k)
+		if work, ok := works[k]; ok {
+			arena = append(arena, `, "openalex": `...)
+			arena = append(arena, work...)
+		}
+		if fragment, ok := response.annotations[k]; ok {
+			arena = append(arena, ", "...)
+			arena = append(arena, fragment...)
+		}
+		arena = append(arena, '}')
+		b := arena[start:len(arena):len(arena)]
+		switch {
+		case rv.outbound.Contains(k):
+			response.Unmatched.Citing = append(response.Unmatched.Citing, b)
+		case rv.inbound.Contains(k):
+			response.Unmatched.Cited = append(response.Unmatched.Cited, b)
+		default:
+			panic("cosmic rays detected (in-flight change of inbound or outbound values)")
+		}
+	}
+	rv.unmatchedSize = size
+	rv.sw.Record("recorded unmatched ids")
+}
+
+// Error returns the message, so a too large response can be returned as an
+// error, cf. CheckSize.
+func (m *TooLargeMessage) Error() string {
+	return m.Msg
+}
+
+// CheckSize rejects huge neighborhoods early, before fetching any blob, based
+// on an estimated response size; the error is a *TooLargeMessage.
+func (rv *Resolver) CheckSize() error {
+	s := rv.s
+	if s.MaxResponseSize <= 0 || !rv.opts.Blobs {
+		return nil
+	}
+	var (
+		blobSize = s.EstimatedBlobSize
+		msg      = &TooLargeMessage{
+			Status:               http.StatusRequestEntityTooLarge,
+			UnmatchedCitingCount: len(rv.response.Unmatched.Citing),
+			UnmatchedCitedCount:  len(rv.response.Unmatched.Cited),
+			MaxResponseSize:      s.MaxResponseSize,
+		}
+	)
+	if blobSize == 0 {
+		blobSize = DefaultEstimatedBlobSize
+	}
+	for _, v := range rv.ids {
+		if rv.outbound.Contains(v.Value) {
+			msg.CitingCount++
+		} else {
+			msg.CitedCount++
+		}
+	}
+	msg.EstimatedSize = int64(len(rv.ids))*blobSize + int64(rv.unmatchedSize)
+	if msg.EstimatedSize <= s.MaxResponseSize {
+		return nil
+	}
+	msg.Msg = fmt.Sprintf("estimated response size of %d bytes exceeds limit of %d bytes",
+		msg.EstimatedSize, s.MaxResponseSize)
+	if s.Jobs != nil {
+		msg.Msg += fmt.Sprintf(`, use the job API instead: POST %s/jobs {"id": %q}`,
+			s.PathPrefix, rv.response.ID)
+	}
+	return msg
+}
+
+// fetchContext returns a context for index data fetches. All fetches of a
+// request share a single deadline, which starts with the first fetch.
+func (rv *Resolver) fetchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if rv.s.IndexDataTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	if rv.fetchDeadline.IsZero() {
+		rv.fetchDeadline = time.Now().Add(rv.s.IndexDataTimeout)
+	}
+	return context.WithDeadline(ctx, rv.fetchDeadline)
+}
+
+// fetchFailed returns err, unless index data errors should result in a
+// degraded response, cf. Server.Degraded.
+func (rv *Resolver) fetchFailed(ctx context.Context, err error) error {
+	if rv.s.degrade(ctx, rv.response.ID, err) {
+		rv.degraded = true
+		return nil
+	}
+	return err
+}
+
+// Prepare fetches the requested document, if needed to flag self-citations
+// or to compute citation ages.
+func (rv *Resolver) Prepare(ctx context.Context) error {
+	if !rv.opts.Blobs {
+		return nil
+	}
+	fetchCtx, cancel := rv.fetchContext(ctx)
+	defer cancel()
+	if err := rv.s.prepareSource(fetchCtx, rv.response); err != nil {
+		if err = rv.fetchFailed(ctx, err); err != nil {
+			return fmt.Errorf("source: %w", err)
+		}
+	}
+	return nil
+}
+
+// Streamable returns true, if the documents can be streamed, cf. Stream.
+func (rv *Resolver) Streamable() bool {
+	return len(rv.live) == 0 && rv.opts.Blobs && !rv.degraded
+}
+
+// Stream writes the response to w, fetching blobs one by one; cf.
+// streamResponse for keep. If the index data is not available and nothing
+// has been written yet, the resolver may be degraded instead, in which case
+// the response is left to Fetch and Assemble.
+func (rv *Resolver) Stream(ctx context.Context, w io.Writer, keep bool) ([]byte, error) {
+	var citingIDs, citedIDs []string
+	for _, v := range rv.ids {
+		switch {
+		case rv.outbound.Contains(v.Value):
+			citingIDs = append(citingIDs, v.Key)
+		case rv.inbound.Contains(v.Value):
+			citedIDs = append(citedIDs, v.Key)
+		}
+	}
+	fetchCtx, cancel := rv.fetchContext(ctx)
+	defer cancel()
+	compressed, err := rv.s.streamResponse(fetchCtx, w, rv.response, citingIDs, citedIDs, rv.started, keep)
+	var serr *StreamError
+	switch {
+	case err == nil:
+		rv.sw.Recordf("streamed %d blobs from index data store", len(rv.ids))
+		return compressed, nil
+	case errors.As(err, &serr) && serr.Sent > 0:
+		return nil, err
+	}
+	if err = rv.fetchFailed(ctx, err); err != nil {
+		return nil, fmt.Errorf("stream: %w", err)
+	}
+	return nil, nil
+}
+
+// Fetch collects the index data of all documents; in a single batch, if the
+// index data backend supports it, cf. Capabilities.
+func (rv *Resolver) Fetch(ctx context.Context) error {
+	var (
+		s        = rv.s
+		response = rv.response
+		batch    map[string][]byte
+	)
+	fetchCtx, cancel := rv.fetchContext(ctx)
+	defer cancel()
+	response.Citing = make([]json.RawMessage, 0, minInt(len(rv.ids), rv.outbound.Len()))
+	response.Cited = make([]json.RawMessage, 0, minInt(len(rv.ids), rv.inbound.Len()))
+	if f := s.indexData(); rv.opts.Blobs && !rv.degraded && len(rv.ids) > 0 && f != nil && f.Capabilities().Batch {
+		var (
+			t    = time.Now()
+			keys = make([]string, len(rv.ids))
+			err  error
+		)
+		for i, v := range rv.ids {
+			keys[i] = v.Key
+		}
+		batch, err = FetchBatch(fetchCtx, f, keys)
+		recordTiming(ctx, "batch", "", len(keys), t, err)
+		if err != nil {
+			if err = rv.fetchFailed(ctx, err); err != nil {
+				return fmt.Errorf("index data fetch: %w", err)
+			}
+		} else {
+			s.Stats.MeasureSinceWithLabels("index_data_fetch", t, nil)
+			rv.sw.Recordf("fetched %d blobs in a batch", len(batch))
+		}
+	}
+	for _, v := range rv.ids {
+		if rv.degraded || !rv.opts.Blobs {
+			break
+		}
+		var (
+			t   = time.Now()
+			b   []byte
+			err error
+		)
+		if batch != nil {
+			if blob, ok := batch[v.Key]; ok {
+				b, err = s.prepareBlob(response, v.Key, blob)
+			} else {
+				err = ErrBlobNotFound
+			}
+		} else {
+			b, err = s.fetchBlob(fetchCtx, response, v.Key)
+		}
+		if errors.Is(err, ErrBlobNotFound) {
+			continue
+		}
+		if err != nil {
+			if err = rv.fetchFailed(ctx, err); err != nil {
+				return fmt.Errorf("index data fetch: %w", err)
+			}
+			continue
+		}
+		if batch == nil {
+			s.Stats.MeasureSinceWithLabels("index_data_fetch", t, nil)
+		}
+		progressFrom(ctx).blobFetched()
+		switch {
+		case rv.outbound.Contains(v.Value):
+			response.Citing = append(response.Citing, b)
+		case rv.inbound.Contains(v.Value):
+			response.Cited = append(response.Cited, b)
+		}
+	}
+	switch {
+	case rv.degraded:
+		response.fillDegraded(rv.ids, rv.outbound, rv.inbound)
+		rv.sw.Recordf("degraded response with %d ids", len(rv.ids))
+	case !rv.opts.Blobs:
+		response.fillIdentifiers(rv.ids, rv.outbound, rv.inbound)
+		rv.sw.Recordf("skipped index data for %d ids", len(rv.ids))
+	}
+	return nil
+}
+
+// Assemble adds documents from the live index and finalizes counts.
+func (rv *Resolver) Assemble() {
+	response := rv.response
+	// Live documents were prepared before annotations were known; they
+	// follow the index data documents.
+	liveKeys := make([]string, 0, len(rv.live))
+	for doi := range rv.live {
+		liveKeys = append(liveKeys, doi)
+	}
+	if rv.opts.Sorted {
+		sort.Strings(liveKeys)
+	}
+	for _, doi := range liveKeys {
+		b := response.annotated(doi, rv.live[doi])
+		switch {
+		case rv.outbound.Contains(doi):
+			response.Citing = append(response.Citing, b)
+		case rv.inbound.Contains(doi):
+			response.Cited = append(response.Cited, b)
+		}
+	}
+	rv.sw.Recordf("fetched %d blob from index data store", len(rv.ids))
+	response.updateCounts()
+	if len(rv.s.YearFields) > 0 {
+		var citingKeys, citedKeys []string
+		for _, v := range rv.ids {
+			switch {
+			case rv.outbound.Contains(v.Value):
+				citingKeys = append(citingKeys, v.Key)
+			case rv.inbound.Contains(v.Value):
+				citedKeys = append(citedKeys, v.Key)
+			}
+		}
+		for doi := range rv.live {
+			switch {
+			case rv.outbound.Contains(doi):
+				citingKeys = append(citingKeys, doi)
+			case rv.inbound.Contains(doi):
+				citedKeys = append(citedKeys, doi)
+			}
+		}
+		response.updateCitationAges(citingKeys, citedKeys)
+	}
+	response.Extra.Took = time.Since(rv.started).Seconds()
+}
@@ -0,0 +1,122 @@
+package ckit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/slub/labe/go/ckit/cache"
+)
+
+func TestResolverRun(t *testing.T) {
+	h := newHarness(t, nil)
+	id, doi := h.someID(t)
+	r, err := h.srv.NewResolver(id, ResolveOptions{Blobs: true}, nil).Run(context.Background())
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if r.DOI != doi {
+		t.Fatalf("got %s, want %s", r.DOI, doi)
+	}
+	checkResponse(t, id, r, h.expect(doi))
+	// Without blobs, nothing is fetched.
+	calls := h.fetcher.count()
+	r, err = h.srv.NewResolver(id, ResolveOptions{}, nil).Run(context.Background())
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	checkResponse(t, id, r, h.expect(doi))
+	if h.fetcher.count() != calls {
+		t.Fatalf("got %d fetches, want none", h.fetcher.count()-calls)
+	}
+}
+
+func TestResolverErrors(t *testing.T) {
+	h := newHarness(t, nil)
+	id, _ := h.someID(t)
+	var cases = []struct {
+		id              string
+		maxResponseSize int64
+		err             error
+	}{
+		{"unknown", 0, sql.ErrNoRows},
+		{lonelyID, 0, errNoCitations},
+		{id, 1, &TooLargeMessage{}},
+	}
+	for _, c := range cases {
+		h.srv.MaxResponseSize = c.maxResponseSize
+		_, err := h.srv.NewResolver(c.id, ResolveOptions{Blobs: true}, nil).Run(context.Background())
+		var tooLarge *TooLargeMessage
+		switch want := c.err.(type) {
+		case *TooLargeMessage:
+			if !errors.As(err, &tooLarge) {
+				t.Fatalf("%s: got %v, want %T", c.id, err, want)
+			}
+		default:
+			if !errors.Is(err, want) {
+				t.Fatalf("%s: got %v, want %v", c.id, err, want)
+			}
+		}
+	}
+	// Index data errors are returned, unless degraded.
+	h.srv.MaxResponseSize = 0
+	h.fetcher.set(errors.New("index data backend down"))
+	if _, err := h.srv.NewResolver(id, ResolveOptions{Blobs: true}, nil).Run(context.Background()); err == nil {
+		t.Fatalf("got nil, want error")
+	}
+	h.srv.Degraded = true
+	rv := h.srv.NewResolver(id, ResolveOptions{Blobs: true}, nil)
+	if _, err := rv.Run(context.Background()); err != nil || !rv.Degraded() {
+		t.Fatalf("got %v, degraded %v, want nil, true", err, rv.Degraded())
+	}
+}
+
+func TestWriteResolveError(t *testing.T) {
+	var cases = []struct {
+		err      error
+		status   int
+		notFound bool
+	}{
+		{fmt.Errorf("doi lookup (a): %w", sql.ErrNoRows), http.StatusNotFound, true},
+		{fmt.Errorf("%w: a", errNoCitations), http.StatusNotFound, true},
+		{fmt.Errorf("edges: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, false},
+		{fmt.Errorf("map: %w", errors.New("disk I/O error")), http.StatusInternalServerError, false},
+		{&TooLargeMessage{Msg: "too large"}, http.StatusRequestEntityTooLarge, false},
+		// Canceled requests get no response.
+		{fmt.Errorf("stream: %w", context.Canceled), http.StatusOK, false},
+	}
+	for i, c := range cases {
+		srv := &Server{NotFound: cache.NewTTLSet(time.Minute)}
+		rr := httptest.NewRecorder()
+		srv.writeResolveError(rr, "a", c.err)
+		if rr.Code != c.status {
+			t.Fatalf("[%d] got %v, want %v", i, rr.Code, c.status)
+		}
+		if got := srv.NotFound.Contains("id:a"); got != c.notFound {
+			t.Fatalf("[%d] got not found %v, want %v", i, got, c.notFound)
+		}
+	}
+}
+
+func TestResolverFetchBatch(t *testing.T) {
+	h := newHarness(t, nil)
+	bf := &batchFetcher{mapFetcher: make(mapFetcher)}
+	for _, row := range h.data.Documents {
+		bf.mapFetcher[row.Key] = row.Value
+	}
+	h.srv.IndexData = NewLimitFetcher(bf, 1)
+	id, doi := h.someID(t)
+	r, err := h.srv.NewResolver(id, ResolveOptions{Blobs: true}, nil).Run(context.Background())
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	checkResponse(t, id, r, h.expect(doi))
+	if bf.batches != 1 || h.fetcher.count() != 0 {
+		t.Fatalf("got %d batches, %d single fetches, want 1, 0", bf.batches, h.fetcher.count())
+	}
+}
@@ -0,0 +1,98 @@
+package ckit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Retractions maps lowercase DOI of retracted works to the reason, as given
+// in a retraction list, e.g. "Retraction" or "Expression of concern".
+type Retractions map[string]string
+
+// ReadRetractions reads a retraction list, either the Retraction Watch CSV
+// export as distributed by Crossref, or a plain list of DOI, one per line.
+// Only retractions and expressions of concern are kept; corrections and
+// reinstatements are ignored.
+func ReadRetractions(r io.Reader) (Retractions, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(64)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimPrefix(header, []byte("\ufeff")), []byte("Record ID")) {
+		return readRetractionWatch(br)
+	}
+	var (
+		result  = make(Retractions)
+		scanner = bufio.NewScanner(br)
+	)
+	for scanner.Scan() {
+		doi := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if doi == "" || strings.HasPrefix(doi, "#") {
+			continue
+		}
+		result[doi] = "Retraction"
+	}
+	return result, scanner.Err()
+}
+
+// readRetractionWatch reads the Retraction Watch CSV export, with
+// OriginalPaperDOI and RetractionNature columns.
+func readRetractionWatch(r io.Reader) (Retractions, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	var doiIndex, natureIndex = -1, -1
+	for i, name := range header {
+		switch strings.TrimPrefix(name, "\ufeff") {
+		case "OriginalPaperDOI":
+			doiIndex = i
+		case "RetractionNature":
+			natureIndex = i
+		}
+	}
+	if doiIndex == -1 || natureIndex == -1 {
+		return nil, fmt.Errorf("retractions: missing OriginalPaperDOI or RetractionNature column")
+	}
+	result := make(Retractions)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("retractions: %w", err)
+		}
+		if len(record) <= doiIndex || len(record) <= natureIndex {
+			continue
+		}
+		doi := strings.ToLower(strings.TrimSpace(record[doiIndex]))
+		if !strings.HasPrefix(doi, "10.") {
+			continue // e.g. "unavailable"
+		}
+		switch nature := record[natureIndex]; nature {
+		case "Retraction":
+			result[doi] = nature
+		case "Expression of concern":
+			// A retraction takes precedence.
+			if _, ok := result[doi]; !ok {
+				result[doi] = nature
+			}
+		}
+	}
+	return result, nil
+}
+
+// Lookup returns the reason a DOI is on the retraction list, if at all.
+func (r Retractions) Lookup(doi string) (string, bool) {
+	v, ok := r[strings.ToLower(doi)]
+	return v, ok
+}
@@ -0,0 +1,53 @@
+package ckit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadRetractions(t *testing.T) {
+	var cases = []struct {
+		about string
+		input string
+		want  Retractions
+		err   bool
+	}{
+		{
+			about: "plain list",
+			input: "# comment\n10.1/A\n\n10.1/b\n",
+			want:  Retractions{"10.1/a": "Retraction", "10.1/b": "Retraction"},
+		},
+		{
+			about: "retraction watch",
+			input: "\ufeffRecord ID,Title,OriginalPaperDOI,RetractionNature\n" +
+				"1,\"A, B\",10.1/A,Retraction\n" +
+				"2,C,10.1/b,Correction\n" +
+				"3,D,unavailable,Retraction\n" +
+				"4,E,10.1/c,Expression of concern\n" +
+				"5,F,10.1/a,Expression of concern\n",
+			want: Retractions{"10.1/a": "Retraction", "10.1/c": "Expression of concern"},
+		},
+		{
+			about: "retraction watch, missing column",
+			input: "Record ID,Title\n1,A\n",
+			err:   true,
+		},
+	}
+	for _, c := range cases {
+		got, err := ReadRetractions(strings.NewReader(c.input))
+		if (err != nil) != c.err {
+			t.Fatalf("[%s] got %v, want error %v", c.about, err, c.err)
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("[%s] got %v, want %v", c.about, got, c.want)
+		}
+	}
+	r := Retractions{"10.1/a": "Retraction"}
+	if v, ok := r.Lookup("10.1/A"); !ok || v != "Retraction" {
+		t.Fatalf("got %v, %v, want Retraction, true", v, ok)
+	}
+}
@@ -0,0 +1,33 @@
+package ckit
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/slub/labe/go/ckit/tabutils"
+)
+
+// hasReverseTable returns true, if a database has a reverse table, cf. makta
+// -R; the result is kept per database, errors are not.
+func (s *Server) hasReverseTable(ctx context.Context, db *sqlx.DB) bool {
+	if v, ok := s.reverseTables.Load(db); ok {
+		return v.(bool)
+	}
+	var n int
+	if err := db.GetContext(ctx, &n, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+		tabutils.ReverseTable); err != nil {
+		return false
+	}
+	s.reverseTables.Store(db, n > 0)
+	return n > 0
+}
+
+// inboundQuery returns a query for rows by value, e.g. cited DOI, with a
+// condition like "= ?" or "IN (?)". It uses the reverse table, if the
+// database has one, with columns named like those of the map table.
+func (s *Server) inboundQuery(ctx context.Context, db *sqlx.DB, cond string) string {
+	if s.hasReverseTable(ctx, db) {
+		return "SELECT v AS k, k AS v FROM " + tabutils.ReverseTable + " WHERE " + s.doiColumn("k") + " " + cond
+	}
+	return "SELECT * FROM map WHERE " + s.doiColumn("v") + " " + cond
+}
@@ -0,0 +1,57 @@
+package ckit
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jmoiron/sqlx"
+	"github.com/slub/labe/go/ckit/tabutils"
+	"github.com/thoas/stats"
+)
+
+func TestReverseTable(t *testing.T) {
+	ctx := context.Background()
+	open := func(reverse bool) *sqlx.DB {
+		db, err := sqlx.Open(sqliteDriver, filepath.Join(t.TempDir(), "o.db"))
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if _, err := db.Exec(`CREATE TABLE map (k TEXT, v TEXT);
+			INSERT INTO map VALUES ('10.1/a', '10.1/c'), ('10.1/b', '10.1/c'), ('10.1/c', '10.1/a');`); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+		if reverse {
+			if _, err := db.Exec(tabutils.ReverseTableScript("", "TEXT", false)); err != nil {
+				t.Fatalf("reverse: %v", err)
+			}
+		}
+		return db
+	}
+	for _, reverse := range []bool{false, true} {
+		db := open(reverse)
+		s := &Server{OciDatabase: db, Stats: stats.New()}
+		if got := s.hasReverseTable(ctx, db); got != reverse {
+			t.Fatalf("hasReverseTable: got %v, want %v", got, reverse)
+		}
+		_, cited, err := s.edges(ctx, "10.1/c")
+		if err != nil {
+			t.Fatalf("edges: %v", err)
+		}
+		sort.Slice(cited, func(i, j int) bool { return cited[i].Key < cited[j].Key })
+		want := []Map{{Key: "10.1/a", Value: "10.1/c"}, {Key: "10.1/b", Value: "10.1/c"}}
+		if diff := cmp.Diff(want, cited); diff != "" {
+			t.Fatalf("reverse=%v: cited mismatch (-want +got):\n%s", reverse, diff)
+		}
+		_, n, err := s.edgeCounts(ctx, "10.1/c")
+		if err != nil {
+			t.Fatalf("edgeCounts: %v", err)
+		}
+		if n != 2 {
+			t.Fatalf("reverse=%v: got %d cited, want 2", reverse, n)
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package ckit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/bloom"
+	"github.com/slub/labe/go/ckit/notify"
+)
+
+// Datasets names the database files served together. A manifest is a JSON
+// file with this structure, e.g. {"identifier": "/data/v2/i.db", "oci":
+// "/data/v2/o.db", "index": ["/data/v2/index.db"]}. Relative paths are
+// relative to the manifest. The manifest itself, or the paths in it, may be
+// symlinks, e.g. a "current" symlink pointing to a versioned directory.
+type Datasets struct {
+	Identifier string   `json:"identifier"`
+	Oci        string   `json:"oci"`
+	Index      []string `json:"index,omitempty"`
+	// Degrees is an optional degree table of the oci database, cf.
+	// ocidegree.
+	Degrees string `json:"degrees,omitempty"`
+	// Counts is an optional counts database of the oci database, cf.
+	// ocicounts.
+	Counts string `json:"counts,omitempty"`
+}
+
+// ReadManifest reads a manifest and resolves all symlinks, so the result
+// changes, when a symlink is flipped.
+func ReadManifest(filename string) (*Datasets, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var ds Datasets
+	if err := json.Unmarshal(b, &ds); err != nil {
+		return nil, fmt.Errorf("manifest: %w", err)
+	}
+	if ds.Identifier == "" || ds.Oci == "" {
+		return nil, fmt.Errorf("manifest: identifier and oci required")
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+	resolve := func(p string) (string, error) {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		return filepath.EvalSymlinks(p)
+	}
+	if ds.Identifier, err = resolve(ds.Identifier); err != nil {
+		return nil, err
+	}
+	if ds.Oci, err = resolve(ds.Oci); err != nil {
+		return nil, err
+	}
+	for i, p := range ds.Index {
+		if ds.Index[i], err = resolve(p); err != nil {
+			return nil, err
+		}
+	}
+	if ds.Degrees != "" {
+		if ds.Degrees, err = resolve(ds.Degrees); err != nil {
+			return nil, err
+		}
+	}
+	if ds.Counts != "" {
+		if ds.Counts, err = resolve(ds.Counts); err != nil {
+			return nil, err
+		}
+	}
+	return &ds, nil
+}
+
+// Notify returns the datasets as described in events.
+func (ds *Datasets) Notify() []notify.Dataset {
+	if ds == nil {
+		return nil
+	}
+	result := []notify.Dataset{
+		notify.Stat("identifier", ds.Identifier),
+		notify.Stat("oci", ds.Oci),
+	}
+	for _, p := range ds.Index {
+		result = append(result, notify.Stat("index", p))
+	}
+	if ds.Degrees != "" {
+		result = append(result, notify.Stat("degrees", ds.Degrees))
+	}
+	if ds.Counts != "" {
+		result = append(result, notify.Stat("counts", ds.Counts))
+	}
+	return result
+}
+
+// generation counts the requests using one set of databases, so the
+// databases can be closed after a rotation, once the last of them finished.
+type generation struct {
+	wg sync.WaitGroup
+}
+
+// acquire registers a user of the current databases; release must be called,
+// when the databases are no longer used. Requests acquire the databases in
+// ServeHTTP, so long running responses, jobs and refreshes are covered.
+func (s *Server) acquire() (release func()) {
+	s.dataMu.RLock()
+	g := s.gen
+	if g != nil {
+		g.wg.Add(1)
+		s.dataMu.RUnlock()
+		return g.wg.Done
+	}
+	s.dataMu.RUnlock()
+	s.dataMu.Lock()
+	defer s.dataMu.Unlock()
+	if s.gen == nil {
+		s.gen = &generation{}
+	}
+	s.gen.wg.Add(1)
+	return s.gen.wg.Done
+}
+
+// identifierDB returns the current identifier database.
+func (s *Server) identifierDB() *sqlx.DB {
+	s.dataMu.RLock()
+	defer s.dataMu.RUnlock()
+	return s.IdentifierDatabase
+}
+
+// ociDB returns the current citation database.
+func (s *Server) ociDB() *sqlx.DB {
+	s.dataMu.RLock()
+	defer s.dataMu.RUnlock()
+	return s.OciDatabase
+}
+
+// indexData returns the current index data fetcher.
+func (s *Server) indexData() Fetcher {
+	s.dataMu.RLock()
+	defer s.dataMu.RUnlock()
+	return s.IndexData
+}
+
+// openDatasets opens all databases of a dataset; index data is nil, if the
+// datasets do not contain index databases. With memory set, the identifier
+// database is loaded into memory. Index databases retry busy fetches as
+// configured by busyRetries, cf. SqliteFetcher.
+func openDatasets(ds *Datasets, memory bool, busyRetries int) (identifier, oci *sqlx.DB, index Fetcher, err error) {
+	if memory {
+		identifier, err = LoadDatabase(context.Background(), ds.Identifier)
+	} else {
+		identifier, err = OpenDatabase(ds.Identifier)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if oci, err = OpenDatabase(ds.Oci); err != nil {
+		identifier.Close()
+		return nil, nil, nil, err
+	}
+	if len(ds.Index) > 0 {
+		g := &FetchGroup{BusyRetries: busyRetries}
+		if err = g.FromFiles(ds.Index...); err != nil {
+			identifier.Close()
+			oci.Close()
+			return nil, nil, nil, err
+		}
+		index = g
+	}
+	return identifier, oci, index, nil
+}
+
+// closeFetcher closes the sqlite databases of a fetcher, if any.
+func closeFetcher(f Fetcher) {
+	switch v := f.(type) {
+	case *FetchGroup:
+		for _, b := range v.Backends {
+			closeFetcher(b)
+		}
+	case *ShardFetcher:
+		for _, sf := range v.Shards {
+			sf.DB.Close()
+		}
+	case *SqliteFetcher:
+		v.DB.Close()
+	case *LimitFetcher:
+		closeFetcher(v.Fetcher)
+	}
+}
+
+// verifyDatasets checks, if databases are reachable and look complete, cf.
+// VerifyDatabase; it then takes a sample identifier, looks up its DOI and
+// fetches a sample document from all index databases.
+func verifyDatasets(ctx context.Context, identifier, oci *sqlx.DB, index Fetcher) error {
+	for _, db := range []*sqlx.DB{identifier, oci} {
+		if err := db.PingContext(ctx); err != nil {
+			return err
+		}
+	}
+	if err := verifyAll(ctx, identifier, oci, index, false); err != nil {
+		return err
+	}
+	var sample Map
+	if err := identifier.GetContext(ctx, &sample, "SELECT * FROM map LIMIT 1"); err != nil {
+		return fmt.Errorf("identifier database: %w", err)
+	}
+	var doi string
+	if err := identifier.GetContext(ctx, &doi, "SELECT v FROM map WHERE k = ?", sample.Key); err != nil {
+		return fmt.Errorf("identifier database: lookup %s: %w", sample.Key, err)
+	}
+	var citing string
+	if err := oci.GetContext(ctx, &citing, "SELECT k FROM map LIMIT 1"); err != nil {
+		return fmt.Errorf("oci database: %w", err)
+	}
+	for _, sf := range sqliteBackends(index) {
+		var id string
+		if err := sf.DB.GetContext(ctx, &id, "SELECT k FROM map LIMIT 1"); err != nil {
+			return fmt.Errorf("index database: %w", err)
+		}
+		if _, err := sf.FetchContext(ctx, id); err != nil {
+			return fmt.Errorf("index database: fetch %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// RotateResult describes a data rotation.
+type RotateResult struct {
+	Previous *Datasets `json:"previous"`
+	Current  *Datasets `json:"current"`
+	Took     float64   `json:"took"`
+}
+
+// ErrNothingToRotate is returned, if the manifest has not changed.
+var ErrNothingToRotate = errors.New("datasets unchanged")
+
+// Rotate reads the manifest and switches to the datasets named in it: new
+// databases are opened and verified first, then swapped in at once. After
+// the swap, the server checks itself with a sample lookup and switches back
+// to the previous databases, if that fails. On success, the cache is flushed
+// and the previous databases are closed, as soon as the last request using
+// them finished.
+func (s *Server) Rotate(ctx context.Context) (*RotateResult, error) {
+	if s.Manifest == "" {
+		return nil, fmt.Errorf("no manifest configured")
+	}
+	s.rotateMu.Lock()
+	defer s.rotateMu.Unlock()
+	started := time.Now()
+	ds, err := ReadManifest(s.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	s.dataMu.RLock()
+	previous := s.Datasets
+	s.dataMu.RUnlock()
+	if reflect.DeepEqual(ds, previous) {
+		return nil, ErrNothingToRotate
+	}
+	identifier, oci, index, err := openDatasets(ds, s.IdentifierInMemory, s.BusyRetries)
+	if err != nil {
+		return nil, err
+	}
+	var counts *sqlx.DB
+	rollback := func() {
+		identifier.Close()
+		oci.Close()
+		closeFetcher(index)
+		if counts != nil {
+			counts.Close()
+		}
+	}
+	if err := verifyDatasets(ctx, identifier, oci, index); err != nil {
+		rollback()
+		return nil, fmt.Errorf("verify: %w", err)
+	}
+	// Keep the concurrency limit of the index data, which may have been
+	// changed at runtime, cf. UpdateSettings.
+	var limit *LimitFetcher
+	if prev := s.limitFetcher(); prev != nil && index != nil {
+		limit = NewLimitFetcher(index, prev.Limit())
+		index = limit
+	}
+	degrees, err := openDegrees(ds.Degrees)
+	if err != nil {
+		rollback()
+		return nil, fmt.Errorf("degrees: %w", err)
+	}
+	if counts, err = openCounts(ds.Counts); err != nil {
+		rollback()
+		return nil, fmt.Errorf("counts: %w", err)
+	}
+	var filter *bloom.Filter
+	if s.IdentifierFilterRate > 0 {
+		filter, err = buildIdentifierFilter(ctx, identifier, s.IdentifierFilterRate, s.NoCase)
+		if err != nil {
+			rollback()
+			return nil, fmt.Errorf("identifier filter: %w", err)
+		}
+	}
+	// Swap all databases at once; keep the index data, if the manifest
+	// does not mention any, e.g. with HTTP backends. Requests started from
+	// now on use the new generation.
+	gen := &generation{}
+	s.dataMu.Lock()
+	prevGen := s.gen
+	s.gen = gen
+	prevIdentifier, prevOci, prevIndex, prevLimit := s.IdentifierDatabase, s.OciDatabase, s.IndexData, s.limit
+	prevFilter, prevDegrees, prevCounts := s.identifierFilter, s.Degrees, s.CountsDatabase
+	s.IdentifierDatabase, s.OciDatabase = identifier, oci
+	s.identifierFilter, s.Degrees, s.CountsDatabase = filter, degrees, counts
+	if index != nil {
+		s.IndexData = index
+	}
+	if limit != nil {
+		s.limit = limit
+	}
+	s.Datasets = ds
+	s.dataMu.Unlock()
+	// Check the server with the new data, switch back if it fails.
+	var (
+		sample Map
+		doi    string
+	)
+	err = s.Ping()
+	if err == nil {
+		err = identifier.GetContext(ctx, &sample, "SELECT * FROM map LIMIT 1")
+	}
+	if err == nil {
+		err = s.identifierToDOI(ctx, sample.Key, &doi)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		s.dataMu.Lock()
+		s.IdentifierDatabase, s.OciDatabase, s.IndexData, s.limit = prevIdentifier, prevOci, prevIndex, prevLimit
+		s.identifierFilter, s.Degrees, s.CountsDatabase = prevFilter, prevDegrees, prevCounts
+		s.Datasets = previous
+		s.gen = prevGen
+		s.dataMu.Unlock()
+		// Requests may have picked up the new databases meanwhile.
+		go func() {
+			gen.wg.Wait()
+			rollback()
+		}()
+		return nil, fmt.Errorf("rolled back: %w", err)
+	}
+	// Cached responses and negative cache entries belong to the old data.
+	if s.NotFound != nil {
+		s.NotFound.Flush()
+	}
+	if s.Cache != nil {
+		if err := s.Cache.Flush(); err != nil {
+			log.Printf("rotate: cache flush: %v", err)
+		}
+	}
+	go func() {
+		if prevGen != nil {
+			prevGen.wg.Wait()
+		}
+		prevIdentifier.Close()
+		prevOci.Close()
+		if index != nil {
+			closeFetcher(prevIndex)
+		}
+		if prevCounts != nil {
+			prevCounts.Close()
+		}
+	}()
+	result := &RotateResult{Previous: previous, Current: ds, Took: time.Since(started).Seconds()}
+	e := notify.NewEvent(notify.EventRotated)
+	e.Datasets, e.Previous = ds.Notify(), previous.Notify()
+	s.Notifier.Notify(e)
+	log.Printf("rotated to %s, %s %v", ds.Identifier, ds.Oci, ds.Index)
+	return result, nil
+}
+
+// WatchManifest checks the manifest periodically and rotates, if it or any
+// symlink in it changed. Blocks until the context is cancelled.
+func (s *Server) WatchManifest(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := s.Rotate(ctx)
+			switch {
+			case err == ErrNothingToRotate:
+			case err != nil:
+				log.Printf("rotate: %v", err)
+			}
+		}
+	}
+}
+
+// handleRotate switches to the datasets named in the manifest. Responds with
+// 409 Conflict, if nothing changed and 422 Unprocessable Entity, if the new
+// data did not pass verification; the previous data stays in use then.
+func (s *Server) handleRotate() http.HandlerFunc {
+	if s.Manifest == "" {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "not configured", 400)
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		result, err := s.Rotate(r.Context())
+		switch {
+		case err == ErrNothingToRotate:
+			httpErrLog(w, http.StatusConflict, err)
+			return
+		case err != nil:
+			httpErrLogf(w, http.StatusUnprocessableEntity, "rotate: %w", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			httpErrLog(w, http.StatusInternalServerError, err)
+		}
+	}
+}
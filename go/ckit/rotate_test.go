@@ -0,0 +1,254 @@
+package ckit
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// writeManifest writes a manifest file into a directory.
+func writeManifest(t *testing.T, dir, content string) string {
+	filename := filepath.Join(dir, "manifest.json")
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// copyTestdata copies a file from testdata into a directory.
+func copyTestdata(t *testing.T, name, dir string) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	dir := t.TempDir()
+	for _, v := range []string{"v1", "v2"} {
+		if err := os.Mkdir(filepath.Join(dir, v), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"i.db", "o.db"} {
+			if err := ioutil.WriteFile(filepath.Join(dir, v, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := os.Symlink("v1", filepath.Join(dir, "current")); err != nil {
+		t.Fatal(err)
+	}
+	filename := writeManifest(t, dir, `{"identifier": "current/i.db", "oci": "current/o.db"}`)
+	ds, err := ReadManifest(filename)
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	if !strings.HasSuffix(ds.Oci, filepath.Join("v1", "o.db")) {
+		t.Fatalf("got %v, want resolved path", ds.Oci)
+	}
+	// Flip the symlink.
+	if err := os.Remove(filepath.Join(dir, "current")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("v2", filepath.Join(dir, "current")); err != nil {
+		t.Fatal(err)
+	}
+	if ds, err = ReadManifest(filename); err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	if !strings.HasSuffix(ds.Identifier, filepath.Join("v2", "i.db")) {
+		t.Fatalf("got %v, want resolved path", ds.Identifier)
+	}
+	for _, content := range []string{`{"oci": "current/o.db"}`, `{`, `{"identifier": "x.db", "oci": "current/o.db"}`} {
+		if _, err := ReadManifest(writeManifest(t, dir, content)); err == nil {
+			t.Fatalf("%s: want error", content)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	// Relative paths are relative to the manifest, which lives in a temporary
+	// directory; start with absolute paths to the test data.
+	dir := t.TempDir()
+	abs, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := writeManifest(t, dir, `{
+		"identifier": "`+filepath.Join(abs, "id_doi.db")+`",
+		"oci": "`+filepath.Join(abs, "doi_doi.db")+`",
+		"index": ["`+filepath.Join(abs, "id_metadata.db")+`"]}`)
+	ds, err := ReadManifest(manifest)
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	identifier, oci, index, err := openDatasets(ds, false, 0)
+	if err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	srv := &Server{
+		IdentifierDatabase: identifier,
+		OciDatabase:        oci,
+		IndexData:          index,
+		Router:             mux.NewRouter(),
+		Manifest:           manifest,
+		Datasets:           ds,
+	}
+	if _, err := srv.Rotate(context.Background()); err != ErrNothingToRotate {
+		t.Fatalf("got %v, want %v", err, ErrNothingToRotate)
+	}
+	// A copy of the data is a new dataset.
+	for _, name := range []string{"id_doi.db", "doi_doi.db", "id_metadata.db"} {
+		copyTestdata(t, name, dir)
+	}
+	writeManifest(t, dir, `{"identifier": "id_doi.db", "oci": "doi_doi.db", "index": ["id_metadata.db"]}`)
+	result, err := srv.Rotate(context.Background())
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if result.Previous.Oci != ds.Oci || filepath.Dir(result.Current.Oci) == filepath.Dir(ds.Oci) {
+		t.Fatalf("unexpected rotation: %v -> %v", result.Previous, result.Current)
+	}
+	if srv.ociDB() == oci {
+		t.Fatalf("want new oci database")
+	}
+	// The previous databases are closed, once no request uses them.
+	for i := 0; oci.Ping() == nil; i++ {
+		if i == 100 {
+			t.Fatalf("want previous oci database closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Empty databases do not pass verification, the current data stays.
+	current := srv.ociDB()
+	for _, name := range []string{"empty-i.db", "empty-o.db"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeManifest(t, dir, `{"identifier": "empty-i.db", "oci": "empty-o.db"}`)
+	if _, err := srv.Rotate(context.Background()); err == nil {
+		t.Fatalf("want verification error")
+	}
+	if srv.ociDB() != current {
+		t.Fatalf("want previous oci database after failed rotation")
+	}
+}
+
+func TestRotateInFlight(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"id_doi.db", "doi_doi.db", "id_metadata.db"} {
+		copyTestdata(t, name, dir)
+	}
+	manifest := writeManifest(t, dir, `{"identifier": "id_doi.db", "oci": "doi_doi.db"}`)
+	ds, err := ReadManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identifier, oci, _, err := openDatasets(ds, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{
+		IdentifierDatabase: identifier,
+		OciDatabase:        oci,
+		Router:             mux.NewRouter(),
+		Manifest:           manifest,
+		Datasets:           ds,
+	}
+	// A request, e.g. a long stream, uses the current databases.
+	release := srv.acquire()
+	writeManifest(t, dir, `{"identifier": "id_doi.db", "oci": "doi_doi.db", "index": ["id_metadata.db"]}`)
+	if _, err := srv.Rotate(context.Background()); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := oci.Ping(); err != nil {
+		t.Fatalf("previous oci database closed during request: %v", err)
+	}
+	release()
+	for i := 0; oci.Ping() == nil; i++ {
+		if i == 100 {
+			t.Fatalf("want previous oci database closed after request")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRotateLimit(t *testing.T) {
+	dir := t.TempDir()
+	abs, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := writeManifest(t, dir, `{
+		"identifier": "`+filepath.Join(abs, "id_doi.db")+`",
+		"oci": "`+filepath.Join(abs, "doi_doi.db")+`",
+		"index": ["`+filepath.Join(abs, "id_metadata.db")+`"]}`)
+	ds, err := ReadManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identifier, oci, index, err := openDatasets(ds, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{
+		IdentifierDatabase:   identifier,
+		OciDatabase:          oci,
+		IndexData:            index,
+		IndexDataConcurrency: 3,
+		Router:               mux.NewRouter(),
+		Manifest:             manifest,
+		Datasets:             ds,
+	}
+	srv.Routes()
+	// A limit changed at runtime is kept, too.
+	n := 5
+	if _, err := srv.UpdateSettings(SettingsUpdate{BlobConcurrency: &n}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"id_doi.db", "doi_doi.db", "id_metadata.db"} {
+		copyTestdata(t, name, dir)
+	}
+	writeManifest(t, dir, `{"identifier": "id_doi.db", "oci": "doi_doi.db", "index": ["id_metadata.db"]}`)
+	if _, err := srv.Rotate(context.Background()); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	lf, ok := srv.indexData().(*LimitFetcher)
+	if !ok {
+		t.Fatalf("got %T, want *LimitFetcher", srv.indexData())
+	}
+	if lf.Limit() != 5 {
+		t.Fatalf("got limit %d, want 5", lf.Limit())
+	}
+	if lf.Fetcher == index {
+		t.Fatalf("want new index data")
+	}
+	// Settings apply to the new index data.
+	n = 2
+	v, err := srv.UpdateSettings(SettingsUpdate{BlobConcurrency: &n})
+	if err != nil {
+		t.Fatalf("settings after rotation: %v", err)
+	}
+	if v.BlobConcurrency != 2 || lf.Limit() != 2 {
+		t.Fatalf("got %+v, limit %d, want 2", v, lf.Limit())
+	}
+	// The previous index databases are closed behind the limit, too.
+	previous := sqliteBackends(index)[0].DB
+	for i := 0; previous.Ping() == nil; i++ {
+		if i == 100 {
+			t.Fatalf("want previous index database closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,157 @@
+package ckit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// ErrInvalidJSON is returned for blobs, that are not valid JSON.
+var ErrInvalidJSON = errors.New("invalid JSON")
+
+// Schema is a small subset of JSON Schema (https://json-schema.org/), enough
+// to check the rough shape of index data: type, required, properties and
+// items. Other keywords are ignored. An empty schema only requires valid
+// JSON.
+type Schema struct {
+	// Type is a single type or a list of types, e.g. "string" or ["string",
+	// "null"]; one of object, array, string, number, integer, boolean, null.
+	Type       SchemaType         `json:"type,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Items      *Schema            `json:"items,omitempty"`
+}
+
+// SchemaType is a list of allowed types, which can be given as a string or
+// as a list of strings.
+type SchemaType []string
+
+// UnmarshalJSON accepts a string or a list of strings.
+func (t *SchemaType) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = SchemaType{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return fmt.Errorf("schema type: %w", err)
+	}
+	*t = ss
+	return nil
+}
+
+// ReadSchema reads a JSON schema from a file.
+func ReadSchema(filename string) (*Schema, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var schema Schema
+	if err := json.Unmarshal(b, &schema); err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+	return &schema, nil
+}
+
+// Validate checks, whether a blob is valid JSON and matches the schema.
+func (s *Schema) Validate(b []byte) error {
+	if !json.Valid(b) {
+		return ErrInvalidJSON
+	}
+	if s == nil || (len(s.Type) == 0 && len(s.Required) == 0 && s.Properties == nil && s.Items == nil) {
+		return nil
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return ErrInvalidJSON
+	}
+	return s.validate("$", v)
+}
+
+// validate checks a decoded value; path locates the value in error messages.
+func (s *Schema) validate(path string, v interface{}) error {
+	if s == nil {
+		return nil
+	}
+	if len(s.Type) > 0 && !SliceContains(s.Type, jsonType(v)) {
+		if !(jsonType(v) == "integer" && SliceContains(s.Type, "number")) {
+			return fmt.Errorf("%s: got %s, want %v", path, jsonType(v), []string(s.Type))
+		}
+	}
+	switch w := v.(type) {
+	case map[string]interface{}:
+		for _, k := range s.Required {
+			if _, ok := w[k]; !ok {
+				return fmt.Errorf("%s: missing required field %s", path, k)
+			}
+		}
+		// Sorted, so errors are stable.
+		keys := make([]string, 0, len(s.Properties))
+		for k := range s.Properties {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if u, ok := w[k]; ok {
+				if err := s.Properties[k].validate(path+"."+k, u); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		for i, u := range w {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), u); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonType returns the JSON schema type name of a decoded value.
+func jsonType(v interface{}) string {
+	switch w := v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := w.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case nil:
+		return "null"
+	default:
+		return "unknown"
+	}
+}
+
+// checkBlob validates a blob against the schema of the server, if any. Broken
+// JSON is always dropped, since it would break the whole response; other
+// invalid documents are dropped only with DropInvalid set. The ids of all
+// invalid documents are listed in the response.
+func (s *Server) checkBlob(response *Response, id string, b []byte) (drop bool) {
+	if s.BlobSchema == nil {
+		return false
+	}
+	err := s.BlobSchema.Validate(b)
+	if err == nil {
+		return false
+	}
+	log.Printf("invalid blob %s: %v", id, err)
+	response.Extra.Invalid = append(response.Extra.Invalid, id)
+	return s.DropInvalid || err == ErrInvalidJSON
+}
@@ -0,0 +1,73 @@
+package ckit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	var schema Schema
+	if err := json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["id"],
+		"properties": {
+			"id": {"type": "string"},
+			"year": {"type": ["integer", "null"]},
+			"score": {"type": "number"},
+			"institution": {"type": "array", "items": {"type": "string"}}
+		}}`), &schema); err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		blob string
+		err  bool
+	}{
+		{`{"id": "1"}`, false},
+		{`{"id": "1", "year": 2001, "score": 1, "institution": ["DE-14"]}`, false},
+		{`{"id": "1", "year": null, "score": 0.5}`, false},
+		{`{"id": "1"`, true},
+		{`{}`, true},
+		{`[]`, true},
+		{`{"id": 1}`, true},
+		{`{"id": "1", "year": 2001.5}`, true},
+		{`{"id": "1", "institution": ["DE-14", 15]}`, true},
+	}
+	for _, c := range cases {
+		if err := schema.Validate([]byte(c.blob)); (err != nil) != c.err {
+			t.Fatalf("%s: got %v, want error %v", c.blob, err, c.err)
+		}
+	}
+	var empty Schema
+	if err := empty.Validate([]byte(`[1, "a"]`)); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if err := empty.Validate([]byte(`[1, `)); err != ErrInvalidJSON {
+		t.Fatalf("got %v, want %v", err, ErrInvalidJSON)
+	}
+}
+
+func TestFetchBlobInvalid(t *testing.T) {
+	srv := &Server{
+		IndexData:  mapFetcher{"1": `{"id": "1"}`, "2": `{}`, "3": `{"id"`},
+		BlobSchema: &Schema{Required: []string{"id"}},
+	}
+	var (
+		response Response
+		found    []string
+	)
+	for _, id := range []string{"1", "2", "3"} {
+		if _, err := srv.fetchBlob(context.Background(), &response, id); err == nil {
+			found = append(found, id)
+		}
+	}
+	if len(found) != 2 || len(response.Extra.Invalid) != 2 {
+		t.Fatalf("got %v, invalid %v; want [1 2], invalid [2 3]", found, response.Extra.Invalid)
+	}
+	// Dropping invalid documents.
+	srv.DropInvalid, response = true, Response{}
+	if _, err := srv.fetchBlob(context.Background(), &response, "2"); err != ErrBlobNotFound {
+		t.Fatalf("got %v, want %v", err, ErrBlobNotFound)
+	}
+}
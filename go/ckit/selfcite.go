@@ -0,0 +1,86 @@
+package ckit
+
+import (
+	"strings"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/set"
+)
+
+// SelfCitation configures the detection of self-citations: a citing or cited
+// document counts as self-citation, if it shares an author or a journal with
+// the requested document. Fields may hold a string or a list of strings.
+type SelfCitation struct {
+	AuthorFields  []string
+	JournalFields []string
+}
+
+// selfKeys are the normalized authors and journals of a document.
+type selfKeys struct {
+	authors  set.Set
+	journals set.Set
+}
+
+// keys extracts authors and journals from a blob.
+func (c *SelfCitation) keys(b []byte) selfKeys {
+	var (
+		doc  map[string]interface{}
+		keys = selfKeys{authors: set.New(), journals: set.New()}
+	)
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return keys
+	}
+	collect := func(s set.Set, fields []string) {
+		for _, f := range fields {
+			switch v := doc[f].(type) {
+			case string:
+				addSelfKey(s, v)
+			case []interface{}:
+				for _, w := range v {
+					if w, ok := w.(string); ok {
+						addSelfKey(s, w)
+					}
+				}
+			}
+		}
+	}
+	collect(keys.authors, c.AuthorFields)
+	collect(keys.journals, c.JournalFields)
+	return keys
+}
+
+// addSelfKey adds a lowercase value with whitespace collapsed, if not empty.
+func addSelfKey(s set.Set, v string) {
+	if v = strings.ToLower(strings.Join(strings.Fields(v), " ")); v != "" {
+		s.Add(v)
+	}
+}
+
+// overlaps returns the kinds of overlap between two documents, "author" and
+// "journal", or nil.
+func (k selfKeys) overlaps(other selfKeys) (kinds []string) {
+	if k.authors.Intersection(other.authors).Len() > 0 {
+		kinds = append(kinds, "author")
+	}
+	if k.journals.Intersection(other.journals).Len() > 0 {
+		kinds = append(kinds, "journal")
+	}
+	return kinds
+}
+
+// flagSelfCitation annotates a blob as self-citation, if it shares an author
+// or journal with the requested document and counts it.
+func (s *Server) flagSelfCitation(response *Response, id string, b []byte) {
+	if s.SelfCitation == nil || response.self == nil {
+		return
+	}
+	kinds := response.self.overlaps(s.SelfCitation.keys(b))
+	if len(kinds) == 0 {
+		return
+	}
+	response.annotate(id, "self_citation", kinds)
+	for _, kind := range kinds {
+		response.Extra.SelfCitations[kind]++
+	}
+	response.Extra.SelfCitations["total"]++
+}
@@ -0,0 +1,42 @@
+package ckit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelfCitation(t *testing.T) {
+	var (
+		c = &SelfCitation{
+			AuthorFields:  []string{"author", "author2"},
+			JournalFields: []string{"container_title"},
+		}
+		s        = &Server{SelfCitation: c}
+		keys     = c.keys([]byte(`{"author": ["Doe, Jane"], "container_title": "Journal of X"}`))
+		response = &Response{self: &keys}
+	)
+	response.Extra.SelfCitations = map[string]int{"author": 0, "journal": 0, "total": 0}
+	var cases = []struct {
+		id   string
+		blob string
+		want string
+	}{
+		{"1", `{"author2": ["doe,  JANE", "Roe, Richard"]}`, `{"self_citation":["author"],"author2": ["doe,  JANE", "Roe, Richard"]}`},
+		{"2", `{"author": "Doe, Jane", "container_title": "journal of x"}`, `{"self_citation":["author","journal"],"author": "Doe, Jane", "container_title": "journal of x"}`},
+		{"3", `{"author": ["Roe, Richard"], "container_title": "Journal of Y"}`, `{"author": ["Roe, Richard"], "container_title": "Journal of Y"}`},
+		{"4", `{"author": 1}`, `{"author": 1}`},
+	}
+	for _, c := range cases {
+		b, err := s.prepareBlob(response, c.id, []byte(c.blob))
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.id, err)
+		}
+		if string(b) != c.want {
+			t.Fatalf("[%s] got %s, want %s", c.id, b, c.want)
+		}
+	}
+	want := map[string]int{"author": 2, "journal": 1, "total": 2}
+	if !reflect.DeepEqual(response.Extra.SelfCitations, want) {
+		t.Fatalf("got %v, want %v", response.Extra.SelfCitations, want)
+	}
+}
@@ -8,10 +8,15 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
@@ -20,19 +25,47 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/klauspost/compress/zstd"
 	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/bloom"
 	"github.com/slub/labe/go/ckit/cache"
-	"github.com/slub/labe/go/ckit/set"
+	"github.com/slub/labe/go/ckit/degree"
+	"github.com/slub/labe/go/ckit/notify"
 	"github.com/slub/labe/go/ckit/tabutils"
 	"github.com/thoas/stats"
 	"golang.org/x/text/transform"
 )
 
+// DefaultEstimatedBlobSize is the assumed average size of an index data
+// blob, used to estimate response sizes.
+const DefaultEstimatedBlobSize = 4096
+
+// errStale is returned, if a cached value is older than requested.
+var errStale = errors.New("stale cache value")
+
 var bufPool = sync.Pool{
 	New: func() interface{} {
 		return new(bytes.Buffer)
 	},
 }
 
+// maxPooledBufferSize limits the size of buffers we put back into the pool;
+// a few huge responses should not pin lots of memory.
+const maxPooledBufferSize = 16 << 20
+
+// getBuffer returns an empty buffer from the pool.
+func getBuffer() *bytes.Buffer {
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuffer returns a buffer to the pool, unless it grew too large.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	bufPool.Put(buf)
+}
+
 var snippetPool = sync.Pool{
 	New: func() interface{} {
 		return new(Snippet)
@@ -76,6 +109,10 @@ type Server struct {
 	// 10.1002/9781119393351.ch1       10.1109/cdc.2013.6760196
 	// ...
 	OciDatabase *sqlx.DB
+	// Edges, if set, serves citation edges instead of the oci database, e.g.
+	// a ClickHouseEdgeStore; the oci database is still used for statistics,
+	// query plans and verification.
+	Edges EdgeStore
 	// IndexData allows to fetch a metadata blob for an identifier. This is
 	// an interface that in the past has been implemented by types wrapping
 	// microblob, SOLR and sqlite3, as well as a FetchGroup, that allows to
@@ -88,16 +125,204 @@ type Server struct {
 	// dswarm-126-ZnR0dW11ZW5jaGVuOm...   {"id":"dswarm-126-ZnR0dW11ZW5jaGVuOm9ha...
 	// ...
 	IndexData Fetcher
+	// IndexDataConcurrency, if positive, caps the number of concurrent index
+	// data fetches across all requests, cf. LimitFetcher; the limit can be
+	// changed at runtime, cf. UpdateSettings, and is kept over rotations.
+	IndexDataConcurrency int
+	// BuildInfo describes the binary, shown on the index page.
+	BuildInfo BuildInfo
+	// StripRules are applied to index data blobs before they are included
+	// in a response; fields used for filtering, like institution, should not
+	// be stripped.
+	StripRules []StripRule
+	// Transformers rewrite index data blobs, after strip rules have been
+	// applied, cf. RegisterTransformer.
+	Transformers []Transformer
+	// LiveIndex, if set, is queried for DOI, that are not in the identifier
+	// database, e.g. because they were added after the snapshot was taken.
+	LiveIndex LiveIndex
+	// OpenAlex, if set, is used to add basic metadata to unmatched DOI.
+	OpenAlex *OpenAlex
+	// EdgeSources are additional citation databases, merged with the edges
+	// from the OCI database; documents are labeled with their sources.
+	EdgeSources []EdgeSource
+	// OADatabase, if set, maps DOI to an open access status, e.g. derived
+	// from Unpaywall; the status is added to every citing and cited document
+	// as "oa" field.
+	OADatabase *sqlx.DB
+	// Retractions, if set, are used to flag retracted citing and cited
+	// works with a "retraction" field, cf. ReadRetractions.
+	Retractions Retractions
+	// SelfCitation, if set, flags citing and cited documents, that share an
+	// author or journal with the requested document.
+	SelfCitation *SelfCitation
+	// YearFields are index data fields holding the publication year of a
+	// document, e.g. publishDateSort; if set, responses contain a histogram
+	// of citation ages.
+	YearFields []string
+	// NoCase makes DOI lookups case insensitive and lowercases DOI from
+	// all databases; it requires case insensitive indices, cf. makta -N.
+	NoCase bool
+	// OmitUnmatched drops unmatched documents from responses, unless a
+	// client asks for them with unmatched=true; counts are kept.
+	OmitUnmatched bool
+	// SortedResponses sorts citing, cited and unmatched documents by DOI in
+	// all responses, so they can be compared between data builds; clients
+	// can ask for this with sorted=true.
+	SortedResponses bool
+	// BlobSchema, if set, is used to validate index data blobs; invalid
+	// documents are listed in the response and dropped, if DropInvalid is
+	// set. Blobs, that are not valid JSON, are always dropped.
+	BlobSchema  *Schema
+	DropInvalid bool
+	// Corpora are additional corpora with their own databases, served
+	// under /c/{name}, cf. NewCorpus.
+	Corpora map[string]*Server
+	// MaxDataAge, if set, marks the server as degraded in /health, if any
+	// database is older, cf. Health.
+	MaxDataAge time.Duration
+	// RefreshIntervals are the expected update intervals of the datasets by
+	// name, e.g. index or oci, cf. WatchStale; DefaultRefreshIntervals, if
+	// nil.
+	RefreshIntervals map[string]time.Duration
+	// Manifest, if set, names a JSON file listing the databases to use; the
+	// server can switch to new databases while running, cf. Rotate.
+	Manifest string
+	// Datasets are the databases currently in use, if known.
+	Datasets *Datasets
+	dataMu   sync.RWMutex // guards databases, index data and datasets
+	rotateMu sync.Mutex
+	gen      *generation   // users of the current databases, guarded by dataMu
+	limit    *LimitFetcher // wraps the index data, if limited, guarded by dataMu
+	// maintenanceMu allows only one maintenance at a time, cf. Maintain.
+	maintenanceMu sync.Mutex
+	// reverseTables records, which databases have a reverse table, cf.
+	// hasReverseTable.
+	reverseTables sync.Map
 	// Router to register routes on.
 	Router *mux.Router
+	// APIKeys, if set, accounts requests to API keys, cf. /usage; quotas
+	// are enforced by its middleware.
+	APIKeys *APIKeys
+	// TrustedProxies are the addresses of reverse proxies, e.g. nginx; for
+	// requests from these, the client address is taken from
+	// X-Forwarded-For or X-Real-IP, cf. ParseTrustedProxies.
+	TrustedProxies []*net.IPNet
+	// Middleware wraps all routes, the first being the outermost, cf.
+	// Chain; it must be set before calling Routes.
+	Middleware []Middleware
+	handler    http.Handler // router wrapped in middleware
 	// StopWatchEnabled enabled the stopwatch, a builtin, simplistic request tracer.
 	StopWatchEnabled bool
+	// StopWatchThreshold only logs the stopwatch table of requests taking
+	// at least this long, so the stopwatch can stay on in production.
+	StopWatchThreshold time.Duration
+	// StopWatchJSON logs the stopwatch timings of a request as a single
+	// JSON line instead of a table.
+	StopWatchJSON bool
+	// Debug logs every SQL query and blob fetch of a request.
+	Debug bool
+	// Runtime settings, cf. UpdateSettings.
+	settingsMu sync.Mutex
+	settings   *Settings
+	// Bench allows clients to request the timings of all phases of a
+	// request in extra.bench with bench=1; such requests bypass the cache.
+	Bench bool
 	// Cache for expensive items.
-	Cache *cache.Cache
+	Cache cache.Store
 	// CacheTriggerDuration determines which items to cache.
 	CacheTriggerDuration time.Duration
+	cacheSkips           cacheSkips
+	// CacheControlToken, if set, allows clients sending it as bearer token to
+	// force recomputation of a response with "Cache-Control: no-cache" or to
+	// limit the age of a cached response with "Cache-Control: max-age=N".
+	CacheControlToken string
+	// AdminToken, if set, allows clients sending it as bearer token to use
+	// admin routes, which change the state of the server, e.g. DELETE
+	// /cache; without a token, admin routes are disabled.
+	AdminToken string
+	// RankedPath is a list of DOI ranked by citation count, as produced by
+	// the OpenCitationsRanked task, e.g. "  1234 10.123/456" per line; may be
+	// zstd compressed. Used to find the most cited records of an institution.
+	RankedPath    string
+	MaxRankedScan int
+	// MaxPathDepth limits the number of citation edges between two records
+	// in a shortest path search, zero means DefaultMaxPathDepth.
+	MaxPathDepth int
+	// Notifier, if not nil, publishes cache flush events.
+	Notifier *notify.Notifier
+	// Jobs, if not nil, allows to compute expensive responses in the
+	// background, without MaxEdges or MaxResponseSize limits.
+	Jobs *Jobs
+	// MaxEdges limits the number of citing and cited documents per response;
+	// responses are truncated and can be paged through with the offset
+	// parameter. Zero means no limit.
+	MaxEdges int
+	// MaxResponseSize limits the estimated size of a response in bytes; the
+	// estimate is the number of matched documents times EstimatedBlobSize.
+	// Larger responses are rejected with 413 before fetching index data. Zero
+	// means no limit.
+	MaxResponseSize   int64
+	EstimatedBlobSize int64
+	// ZstdPassThrough sends cached values, which are zstd compressed, as is
+	// to clients accepting zstd content coding. Must not be combined with a
+	// compression middleware.
+	ZstdPassThrough bool
+	// Hot records requested ids, if not nil; used to keep the cached
+	// responses of the most requested ids fresh, cf. RefreshHot.
+	Hot *cache.HitCounter
+	// RefreshMaxAge is the age, cached responses of hot ids should not
+	// exceed; RefreshHot leaves younger values alone. Zero refreshes all hot
+	// ids on every run.
+	RefreshMaxAge time.Duration
+	// SingleFlight enables collapsing of concurrent requests for the same
+	// id, e.g. a paper that trends; the response is computed only once.
+	SingleFlight bool
+	flights      flightGroup
+	// workload are histograms of the shape of requests, cf. /metrics.
+	workload workload
+	// NotFound caches identifiers and DOI, for which we found nothing, for a
+	// short time; bulk clients tend to request the same unresolvable ids
+	// over and over again.
+	NotFound *cache.TTLSet
+	// IdentifierFilterRate is the false positive rate of the identifier
+	// filter, cf. BuildIdentifierFilter; zero means no filter.
+	IdentifierFilterRate float64
+	identifierFilter     *bloom.Filter // guarded by dataMu
+	// Degrees, if set, answers edge counts without the oci database and
+	// rejects huge neighborhoods early, cf. ocidegree; replaced on rotation.
+	Degrees *degree.Table
+	// CountsDatabase, if set, is used like Degrees, if there is no degree
+	// table, and for most cited lists, cf. ocicounts; replaced on rotation.
+	CountsDatabase *sqlx.DB
+	// Analytics, if set, is an analytical database, e.g. DuckDB, with a copy
+	// of the oci edges in a map(k, v) table; prefix statistics and lists,
+	// which scan many edges, run there instead of the oci database, cf.
+	// OpenAnalytics. Not replaced on rotation.
+	Analytics *sqlx.DB
 	// Stats, like request counts and status codes.
 	Stats *stats.Stats
+	// IdentifierTimeout, OciTimeout and IndexDataTimeout limit the time
+	// spent in each backend per request; zero means no limit. The index data
+	// timeout applies to all blob fetches of a request together.
+	IdentifierTimeout time.Duration
+	OciTimeout        time.Duration
+	IndexDataTimeout  time.Duration
+	// BusyRetries is the number of retries of queries, that fail with a
+	// busy or locked database, e.g. during a rotation or a backup; zero
+	// means DefaultBusyRetries, negative values disable retries.
+	BusyRetries int
+	// IdentifierInMemory loads the identifier database into memory, when
+	// datasets are opened on rotation or for a corpus, cf. LoadDatabase.
+	IdentifierInMemory bool
+	// Degraded, if set, serves citing and cited documents with local id and
+	// DOI only, if the index data backend fails, instead of an error; useful
+	// during blob store maintenance.
+	Degraded bool
+	// PathPrefix, if set, mounts all routes under a given prefix, e.g.
+	// "/labe/v1", so the server can run behind a reverse proxy without
+	// rewriting URLs.
+	PathPrefix string
 }
 
 // Map is a generic lookup table. We use it together with sqlite3. This
@@ -114,6 +339,20 @@ type ErrorMessage struct {
 	Err    error `json:"err,omitempty"`
 }
 
+// TooLargeMessage is sent with status 413, if the estimated response size
+// exceeds the configured limit. It contains the number of matched documents,
+// so clients can decide what to do.
+type TooLargeMessage struct {
+	Status               int    `json:"status"`
+	Msg                  string `json:"msg"`
+	CitingCount          int    `json:"citing_count"`
+	CitedCount           int    `json:"cited_count"`
+	UnmatchedCitingCount int    `json:"unmatched_citing_count"`
+	UnmatchedCitedCount  int    `json:"unmatched_cited_count"`
+	EstimatedSize        int64  `json:"estimated_size"`
+	MaxResponseSize      int64  `json:"max_response_size"`
+}
+
 // Response contains a subset of index data fused with citation data. Citing
 // and cited documents are kept unparsed for flexibility and performance; we expect JSON. For
 // unmatched docs, we may only transmit the DOI, e.g. {"doi_str_mv": "10.12/34"}.
@@ -136,7 +375,78 @@ type Response struct {
 		// Institution is set optionally (e.g. to "DE-14"), if the response has
 		// been tailored towards the holdings of a given institution.
 		Institution string `json:"institution,omitempty"`
+		// Truncated is set, if not all matched documents are included, cf.
+		// Server.MaxEdges; Next is the URL of the next page. When paging
+		// with a cursor, Cursor is the last DOI of the page.
+		Truncated bool   `json:"truncated,omitempty"`
+		Next      string `json:"next,omitempty"`
+		Cursor    string `json:"cursor,omitempty"`
+		// Sources counts the blobs served by each index data backend, if
+		// there is more than one, cf. FetchGroup.Names.
+		Sources map[string]int `json:"sources,omitempty"`
+		// Invalid lists the ids of documents, that did not pass validation,
+		// cf. Server.BlobSchema.
+		Invalid []string `json:"invalid,omitempty"`
+		// Filter is the expression citing and cited documents were filtered
+		// with, if any, cf. Filter.
+		Filter string `json:"filter,omitempty"`
+		// LiveCount is the number of documents found in the live index,
+		// instead of the index data, cf. Server.LiveIndex.
+		LiveCount int `json:"live_count,omitempty"`
+		// OA counts citing and cited DOI by open access status, e.g. gold,
+		// including unmatched DOI, cf. Server.OADatabase.
+		OA map[string]int `json:"oa,omitempty"`
+		// EdgeSources counts citing and cited edges by source, if there are
+		// additional edge sources, cf. Server.EdgeSources.
+		EdgeSources map[string]int `json:"edge_sources,omitempty"`
+		// Retracted counts retracted citing and cited DOI, including
+		// unmatched DOI, cf. Server.Retractions.
+		Retracted map[string]int `json:"retracted,omitempty"`
+		// SelfCitations counts documents sharing an author or journal with
+		// the requested document, cf. Server.SelfCitation.
+		SelfCitations map[string]int `json:"self_citations,omitempty"`
+		// Bench are the timings of the phases of a request, if requested
+		// with bench=1, cf. Server.Bench.
+		Bench *BenchStat `json:"bench,omitempty"`
+		// Timings are the durations of all SQL queries and blob fetches of
+		// a request, if requested with bench=1.
+		Timings []Timing `json:"timings,omitempty"`
+		// CitationAges are histograms of citation ages in years, for the
+		// citing and cited documents with a known year, cf.
+		// Server.YearFields.
+		CitationAges map[string]AgeHistogram `json:"citation_ages,omitempty"`
+		// Degraded is set, if the index data was not available and citing
+		// and cited documents contain only local id and DOI, cf.
+		// Server.Degraded.
+		Degraded bool `json:"degraded,omitempty"`
 	} `json:"extra,omitempty"`
+	// annotations are fields to add to documents, cf. annotate.
+	annotations map[string][]byte
+	// self are the authors and journals of the requested document, cf.
+	// prepareSelfCitations.
+	self *selfKeys
+	// year is the publication year of the requested document and years
+	// those of the included documents, if known, cf. Server.YearFields.
+	year  int
+	years map[string]int
+	// stopwatch, if set, records timings into Extra.Bench, before a
+	// streamed response is finished.
+	stopwatch *StopWatch
+	// omitUnmatched drops the unmatched documents from a streamed response,
+	// but not from the value kept for the cache.
+	omitUnmatched bool
+}
+
+// addSource counts a blob served by a given backend; empty sources are
+// ignored.
+func (r *Response) addSource(source string) {
+	if source == "" {
+		return
+	}
+	if r.Extra.Sources == nil {
+		r.Extra.Sources = make(map[string]int)
+	}
+	r.Extra.Sources[source]++
 }
 
 // applyInstitutionFilter rearranges cited and citing documents in-place based
@@ -189,18 +499,76 @@ func (r *Response) updateCounts() {
 	r.Extra.UnmatchedCitedCount = len(r.Unmatched.Cited)
 }
 
-// Routes sets up routes.
+// encode writes the response in a given format, JSON or turtle ("ttl").
+func (r *Response) encode(w io.Writer, format string) error {
+	switch format {
+	case "ttl":
+		return r.WriteTurtle(w)
+	default:
+		return json.NewEncoder(w).Encode(r)
+	}
+}
+
+// Routes sets up routes, optionally under a path prefix. Index data is
+// wrapped in a LimitFetcher here, if IndexDataConcurrency is set.
 func (s *Server) Routes() {
-	s.Router.HandleFunc("/", s.handleIndex()).Methods("GET")
-	s.Router.HandleFunc("/cache", s.handleCacheInfo()).Methods("GET")
-	s.Router.HandleFunc("/cache", s.handleCachePurge()).Methods("DELETE")
-	s.Router.HandleFunc("/doi/{doi:.*}", s.handleDOI()).Methods("GET")
-	s.Router.HandleFunc("/id/{id}", s.handleLocalIdentifier()).Methods("GET")
-	s.Router.HandleFunc("/stats", s.handleStats()).Methods("GET")
+	if s.IndexDataConcurrency > 0 && s.IndexData != nil && s.limit == nil {
+		s.limit = NewLimitFetcher(s.IndexData, s.IndexDataConcurrency)
+		s.IndexData = s.limit
+	}
+	router := s.Router
+	if s.PathPrefix = strings.TrimRight(s.PathPrefix, "/"); s.PathPrefix != "" {
+		if !strings.HasPrefix(s.PathPrefix, "/") {
+			s.PathPrefix = "/" + s.PathPrefix
+		}
+		router = s.Router.PathPrefix(s.PathPrefix).Subrouter()
+	}
+	router.HandleFunc("/", s.handleIndex()).Methods("GET")
+	router.HandleFunc("/buildinfo", s.handleBuildInfo()).Methods("GET")
+	router.HandleFunc("/cache", s.handleCacheInfo()).Methods("GET")
+	router.HandleFunc("/cache", s.admin(s.handleCachePurge())).Methods("DELETE")
+	router.HandleFunc("/cache/{id}", s.admin(s.handleCacheEvict())).Methods("DELETE")
+	router.HandleFunc("/counts/{doi:.*}", s.handleCounts()).Methods("GET")
+	router.HandleFunc("/doi/{doi:.*}/exists", s.handleDOIExists()).Methods("GET", "HEAD")
+	router.HandleFunc("/doi/{doi:.*}", s.handleDOI()).Methods("GET")
+	router.HandleFunc("/doi/{doi:.*}", s.handleDOIHead()).Methods("HEAD")
+	router.HandleFunc("/health", s.handleHealth()).Methods("GET")
+	router.HandleFunc("/healthz", s.handleReadiness()).Methods("GET")
+	router.HandleFunc("/id/{id}", s.handleLocalIdentifier()).Methods("GET")
+	router.HandleFunc("/id/{id}", s.handleLocalIdentifierHead()).Methods("HEAD")
+	router.HandleFunc("/id/{id}/events", s.handleEvents()).Methods("GET")
+	router.HandleFunc("/id/{id}/timeline", s.handleTimeline()).Methods("GET")
+	router.HandleFunc("/jobs", s.handleJobCreate()).Methods("POST")
+	router.HandleFunc("/jobs/{id}", s.handleJobStatus()).Methods("GET")
+	router.HandleFunc("/jobs/{id}/result", s.handleJobResult()).Methods("GET")
+	router.HandleFunc("/maintenance", s.admin(s.handleMaintenance())).Methods("POST")
+	router.HandleFunc("/metrics", s.handleMetrics()).Methods("GET")
+	router.HandleFunc("/path", s.handlePath()).Methods("GET")
+	router.HandleFunc("/prefix/{prefix}/stats", s.handlePrefixStats()).Methods("GET")
+	router.HandleFunc("/prefix/{prefix}/top", s.handlePrefixTop()).Methods("GET")
+	router.HandleFunc("/rotate", s.admin(s.handleRotate())).Methods("POST")
+	router.HandleFunc("/settings", s.admin(s.handleSettings())).Methods("GET")
+	router.HandleFunc("/settings", s.admin(s.handleSettingsUpdate())).Methods("PATCH")
+	router.HandleFunc("/stats", s.handleStats()).Methods("GET")
+	router.HandleFunc("/top", s.handleTop()).Methods("GET")
+	router.HandleFunc("/usage", s.handleUsage()).Methods("GET")
+	router.HandleFunc("/version", s.handleVersion()).Methods("GET")
+	s.corporaRoutes()
+	s.handler = Chain(s.Router, s.Middleware...)
 }
 
 // ServeHTTP turns the server into an HTTP handler.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Keep the databases open, until the request is done, cf. Rotate.
+	defer s.acquire()()
+	r = s.withClientIP(r)
+	ctx, t := withTrace(r.Context(), r)
+	w.Header().Set(RequestIDHeader, t.id)
+	r = r.WithContext(ctx)
+	if s.handler != nil {
+		s.handler.ServeHTTP(w, r)
+		return
+	}
 	s.Router.ServeHTTP(w, r)
 }
 
@@ -216,35 +584,61 @@ func (s *Server) handleIndex() http.HandlerFunc {
   \:\__\     /:/  /   \::/  /   \:\/  /   \::/  /
    \/__/     \/__/     \/__/     \/__/     \/__/
 
-Pid: {{ .PID }} | https://github.com/slub/labe
+Pid: {{ .PID }} | Version: {{ .Build.Tag }} {{ .Build.Version }} {{ .Build.Buildtime }} | https://github.com/slub/labe
 
-Available endpoints:
+Available endpoints (admin routes require the admin token as bearer token):
 
-    /              GET
-    /cache         DELETE
-    /cache         GET
-    /doi/{doi}     GET
-    /id/{id}       GET
-    /stats         GET
+    /                    GET
+    /buildinfo           GET
+    /cache               DELETE (admin)
+    /cache               GET
+    /cache/{id}          DELETE (admin)
+    /c/{corpus}/...      GET
+    /counts/{doi}        GET
+    /doi/{doi}           GET, HEAD
+    /doi/{doi}/exists    GET, HEAD
+    /health              GET
+    /healthz             GET
+    /id/{id}             GET, HEAD
+    /id/{id}/events      GET
+    /id/{id}/timeline    GET
+    /jobs                POST
+    /jobs/{id}           GET
+    /jobs/{id}/result    GET
+    /maintenance         POST (admin)
+    /metrics             GET
+    /path                GET
+    /prefix/{prefix}/stats GET
+    /prefix/{prefix}/top GET
+    /rotate              POST (admin)
+    /settings            GET, PATCH (admin)
+    /stats               GET
+    /top                 GET
+    /usage               GET
+    /version             GET
 
 Examples:
 
-  http://{{ .Hostport }}/id/ai-49-aHR0cDovL2R4LmRvaS5vcmcvMTAuMTA3My9wbmFzLjg1LjguMjQ0NA
-  http://{{ .Hostport }}/id/ai-49-aHR0cDovL2R4LmRvaS5vcmcvMTAuMTAwMS9qYW1hLjI4Mi4xNi4xNTE5
-  http://{{ .Hostport }}/id/ai-49-aHR0cDovL2R4LmRvaS5vcmcvMTAuMTAwNi9qbXJlLjE5OTkuMTcxNQ
-  http://{{ .Hostport }}/id/ai-49-aHR0cDovL2R4LmRvaS5vcmcvMTAuMTE3Ny8xMDQ5NzMyMzA1Mjc2Njg3
-  http://{{ .Hostport }}/id/ai-49-aHR0cDovL2R4LmRvaS5vcmcvMTAuMTIxMC9qYy4yMDExLTAzODU
-  http://{{ .Hostport }}/id/ai-49-aHR0cDovL2R4LmRvaS5vcmcvMTAuMTIxNC9hb3MvMTE3NjM0Nzk2Mw
-  http://{{ .Hostport }}/id/ai-49-aHR0cDovL2R4LmRvaS5vcmcvMTAuMjMwNy8yMDk1NTIx
+  http://{{ .Hostport }}{{ .Prefix }}/id/ai-49-aHR0cDovL2R4LmRvaS5vcmcvMTAuMTA3My9wbmFzLjg1LjguMjQ0NA
+  http://{{ .Hostport }}{{ .Prefix }}/id/ai-49-aHR0cDovL2R4LmRvaS5vcmcvMTAuMTAwMS9qYW1hLjI4Mi4xNi4xNTE5
+  http://{{ .Hostport }}{{ .Prefix }}/id/ai-49-aHR0cDovL2R4LmRvaS5vcmcvMTAuMTAwNi9qbXJlLjE5OTkuMTcxNQ
+  http://{{ .Hostport }}{{ .Prefix }}/id/ai-49-aHR0cDovL2R4LmRvaS5vcmcvMTAuMTE3Ny8xMDQ5NzMyMzA1Mjc2Njg3
+  http://{{ .Hostport }}{{ .Prefix }}/id/ai-49-aHR0cDovL2R4LmRvaS5vcmcvMTAuMTIxMC9qYy4yMDExLTAzODU
+  http://{{ .Hostport }}{{ .Prefix }}/id/ai-49-aHR0cDovL2R4LmRvaS5vcmcvMTAuMTIxNC9hb3MvMTE3NjM0Nzk2Mw
+  http://{{ .Hostport }}{{ .Prefix }}/id/ai-49-aHR0cDovL2R4LmRvaS5vcmcvMTAuMjMwNy8yMDk1NTIx
 
 `
 		t := template.Must(template.New("index").Parse(docs))
 		err := t.Execute(w, struct {
 			PID      int
 			Hostport string
+			Prefix   string
+			Build    BuildInfo
 		}{
 			PID:      os.Getpid(),
 			Hostport: r.Host,
+			Prefix:   s.PathPrefix,
+			Build:    s.BuildInfo,
 		})
 		if err != nil {
 			httpErrLog(w, http.StatusInternalServerError, err)
@@ -263,10 +657,20 @@ func (s *Server) handleCacheInfo() http.HandlerFunc {
 			httpErrLog(w, http.StatusInternalServerError, err)
 			return
 		}
-		err = json.NewEncoder(w).Encode(map[string]interface{}{
+		info := map[string]interface{}{
 			"count": count,
-			"path":  s.Cache.Path,
-		})
+			"skipped": map[string]int64{
+				"read_only": s.cacheSkips.count(cache.ErrReadOnly),
+				"too_large": s.cacheSkips.count(cache.ErrTooLarge),
+			},
+		}
+		switch c := s.Cache.(type) {
+		case *cache.Cache:
+			info["path"] = c.Path
+		case *cache.Memcache:
+			info["servers"] = c.Servers
+		}
+		err = json.NewEncoder(w).Encode(info)
 		if err != nil {
 			httpErrLog(w, http.StatusInternalServerError, err)
 			return
@@ -277,6 +681,9 @@ func (s *Server) handleCacheInfo() http.HandlerFunc {
 // handleCachePurge empties the cache.
 func (s *Server) handleCachePurge() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if s.NotFound != nil {
+			s.NotFound.Flush()
+		}
 		if s.Cache == nil {
 			return
 		}
@@ -286,6 +693,29 @@ func (s *Server) handleCachePurge() http.HandlerFunc {
 		} else {
 			log.Println("flushed cached")
 		}
+		s.Notifier.Notify(notify.NewEvent(notify.EventCacheFlushed))
+	}
+}
+
+// handleCacheEvict removes a single id from the cache, e.g. after the
+// metadata of a record has been corrected.
+func (s *Server) handleCacheEvict() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if s.NotFound != nil {
+			s.NotFound.Remove("id:" + id)
+		}
+		if s.Cache == nil {
+			return
+		}
+		if err := s.Cache.Delete(id); err != nil {
+			httpErrLog(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.Printf("evicted %s from cache", id)
+		e := notify.NewEvent(notify.EventCacheEvicted)
+		e.ID = id
+		s.Notifier.Notify(e)
 	}
 }
 
@@ -317,49 +747,144 @@ func (s *Server) handleDOI() http.HandlerFunc {
 				DOI: vars["doi"],
 			}
 		)
-		err := s.IdentifierDatabase.GetContext(ctx, &response.ID, "SELECT k FROM map WHERE v = ?", response.DOI)
+		if s.notFound("doi:" + response.DOI) {
+			http.Error(w, `{"msg": "no id found", "status": 404}`, http.StatusNotFound)
+			return
+		}
+		ctx, cancel := withTimeout(ctx, s.IdentifierTimeout)
+		defer cancel()
+		err := s.getContext(ctx, s.identifierDB(), &response.ID, "SELECT k FROM map WHERE "+s.doiColumn("v")+" = ?", response.DOI)
 		if err != nil {
 			switch {
 			case err == context.Canceled:
 				log.Printf("handle doi: %v", err)
+			case errors.Is(err, context.DeadlineExceeded):
+				httpErrLogf(w, http.StatusGatewayTimeout, "handle doi: %w", err)
 			default:
+				if err == sql.ErrNoRows && s.NotFound != nil {
+					s.NotFound.Add("doi:" + response.DOI)
+				}
 				http.Error(w, `{"msg": "no id found", "status": 404}`, http.StatusNotFound)
 			}
 		} else {
-			loc := fmt.Sprintf("/id/%s", response.ID)
+			loc := fmt.Sprintf("%s/id/%s", s.PathPrefix, response.ID)
+			if r.URL.RawQuery != "" {
+				loc = loc + "?" + r.URL.RawQuery
+			}
 			w.Header().Set("Content-Type", "text/plain") // disable http snippet
 			http.Redirect(w, r, loc, http.StatusTemporaryRedirect)
 		}
 	}
 }
 
+// notFound returns true, if a key is ruled out by the identifier filter or
+// is a recently recorded miss.
+func (s *Server) notFound(key string) bool {
+	if s.filterMiss(key) {
+		return true
+	}
+	if s.NotFound == nil || !s.NotFound.Contains(key) {
+		return false
+	}
+	if s.Stats != nil {
+		s.Stats.MeasureSinceWithLabels("not_found_cache_hit", time.Now(), nil)
+	}
+	return true
+}
+
 // serveFromCache tries to serve a response from cache. If this method returns
-// nil, the response has been successfully served from the cache.
-func (s *Server) serveFromCache(w http.ResponseWriter, r *http.Request) error {
+// nil, the response has been successfully served from the cache. Values older
+// than maxAge are not served, unless maxAge is negative.
+func (s *Server) serveFromCache(w http.ResponseWriter, r *http.Request, maxAge time.Duration) error {
 	var (
-		t    = time.Now()
 		vars = mux.Vars(r)
 		id   = vars["id"]
-		isil = r.URL.Query().Get("i")
 	)
-	b, err := s.Cache.Get(id)
+	b, modified, err := s.Cache.GetWithTime(id)
 	if err != nil {
 		return err
 	}
+	if maxAge >= 0 && time.Since(modified) > maxAge {
+		return errStale
+	}
+	return s.serveCompressed(w, r, b)
+}
+
+// cacheControlAllowed returns true, if the client may control caching via
+// the Cache-Control header, i.e. it sent the configured bearer token.
+func (s *Server) cacheControlAllowed(r *http.Request) bool {
+	return hasBearerToken(r, s.CacheControlToken)
+}
+
+// parseCacheControl returns whether a Cache-Control header value contains a
+// no-cache directive and the max-age, if any, -1 otherwise.
+func parseCacheControl(v string) (noCache bool, maxAge time.Duration) {
+	maxAge = -1
+	for _, directive := range strings.Split(v, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache":
+			noCache = true
+		case strings.HasPrefix(directive, "max-age="):
+			n, err := strconv.Atoi(strings.Trim(directive[8:], `"`))
+			if err == nil && n >= 0 {
+				maxAge = time.Duration(n) * time.Second
+			}
+		}
+	}
+	return noCache, maxAge
+}
+
+// serveCompressed serves a zstd compressed JSON response, as found in the
+// cache, applying institution filter, filter expression, output format and
+// omission of unmatched documents, if requested. If enabled and the client accepts zstd, unfiltered JSON is passed
+// through as is; the "took" value is then the one of the original request.
+func (s *Server) serveCompressed(w http.ResponseWriter, r *http.Request, b []byte) error {
+	var (
+		t      = time.Now()
+		isil   = r.URL.Query().Get("i")
+		filter = r.URL.Query().Get("filter")
+		format = r.URL.Query().Get("format")
+		// Invalid values have been rejected by the handler.
+		unmatched, _ = s.includeUnmatched(r)
+	)
+	if s.ZstdPassThrough && isil == "" && filter == "" && format != "ttl" && unmatched && acceptsEncoding(r, "zstd") {
+		w.Header().Set("Content-Encoding", "zstd")
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		if _, err := w.Write(b); err != nil {
+			return fmt.Errorf("cache write: %w", err)
+		}
+		return nil
+	}
 	zr, err := zstd.NewReader(bytes.NewReader(b))
 	if err != nil {
 		return fmt.Errorf("cache decompress: %w", err)
 	}
+	defer zr.Close()
 	took := fmt.Sprintf(`"took":%f`, time.Since(t).Seconds())
 	replacer := transform.NewReader(zr, replace.RegexpString(regexp.MustCompile(`"took":[0-9.]+`), took))
 	switch {
-	case isil != "":
+	case isil != "" || filter != "" || format == "ttl" || !unmatched:
 		var resp Response
 		if err := json.NewDecoder(replacer).Decode(&resp); err != nil {
 			return fmt.Errorf("cache json decode: %w", err)
 		}
-		resp.applyInstitutionFilter(isil)
-		if err := json.NewEncoder(w).Encode(resp); err != nil {
+		if isil != "" {
+			resp.applyInstitutionFilter(isil)
+		}
+		if filter != "" {
+			f, err := ParseFilter(filter)
+			if err != nil {
+				return err
+			}
+			if err := resp.applyFilter(f); err != nil {
+				return err
+			}
+		}
+		if !unmatched {
+			resp.Unmatched.Citing, resp.Unmatched.Cited = nil, nil
+		}
+		if err := resp.encode(w, format); err != nil {
 			return fmt.Errorf("encode: %w", err)
 		}
 	default:
@@ -367,46 +892,121 @@ func (s *Server) serveFromCache(w http.ResponseWriter, r *http.Request) error {
 			return fmt.Errorf("cache copy: %w", err)
 		}
 	}
-	zr.Close()
 	return nil
 }
 
-// cacheResponse prepares and caches a response. If the cache is read-only no
-// error is returned (but the value is not cached). Other caching errors are
-// returned.
-func (s *Server) cacheResponse(response *Response) error {
+// acceptsEncoding returns true, if the client accepts a given content coding,
+// cf. RFC 7231, section 5.3.4.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(v, ",") {
+			var (
+				fields = strings.Split(part, ";")
+				name   = strings.TrimSpace(fields[0])
+			)
+			if !strings.EqualFold(name, coding) {
+				continue
+			}
+			for _, param := range fields[1:] {
+				param = strings.TrimSpace(param)
+				if !strings.HasPrefix(param, "q=") {
+					continue
+				}
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// compressResponse marks a response as cached and returns its zstd
+// compressed JSON serialization.
+func compressResponse(response *Response) ([]byte, error) {
 	response.Extra.Cached = true
-	var (
-		t   = time.Now()
-		buf = bufPool.Get().(*bytes.Buffer)
-	)
-	buf.Reset()
-	defer bufPool.Put(buf)
-	zw, err := zstd.NewWriter(buf)
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
 	if err != nil {
-		return fmt.Errorf("cache compress: %w", err)
+		return nil, fmt.Errorf("cache compress: %w", err)
 	}
-	// We cache the unfiltered response (otherwise the cache would
-	// waste disk space).
 	if err := json.NewEncoder(zw).Encode(response); err != nil {
-		return fmt.Errorf("cache json encode: %w", err)
+		return nil, fmt.Errorf("cache json encode: %w", err)
 	}
 	if err := zw.Close(); err != nil {
-		return fmt.Errorf("cache close: %w", err)
+		return nil, fmt.Errorf("cache close: %w", err)
 	}
-	if err := s.Cache.Set(response.ID, buf.Bytes()); err != nil {
-		if err == cache.ErrReadOnly {
+	return buf.Bytes(), nil
+}
+
+// cacheResponse caches a compressed response. If the cache is read-only or
+// the value too large, no error is returned (but the value is not cached). Other caching errors are
+// returned.
+func (s *Server) cacheResponse(id string, b []byte) error {
+	t := time.Now()
+	if err := s.Cache.Set(id, b); err != nil {
+		if err == cache.ErrReadOnly || err == cache.ErrTooLarge {
+			// Not an error for the client, but operators should know, that
+			// values are not cached any more.
+			s.Stats.MeasureSinceWithLabels("cache_skipped", t, nil)
+			if s.cacheSkips.add(err) {
+				log.Printf("not caching %s (%d bytes): %v; skipped %d read only, %d too large so far",
+					id, len(b), err, s.cacheSkips.count(cache.ErrReadOnly), s.cacheSkips.count(cache.ErrTooLarge))
+			}
 			return nil
 		} else {
 			// TODO: we would not need to fail, if cache fails; but do for now
-			return fmt.Errorf("failed to cache value for %s: %v", response.ID, err)
+			return fmt.Errorf("failed to cache value for %s: %v", id, err)
 		}
 	}
 	s.Stats.MeasureSinceWithLabels("cached", t, nil)
 	return nil
 }
 
-// handleLocalIdentifier does all the lookups and assembles a JSON response.
+// cacheSkipLogInterval limits logging of values, that could not be cached.
+const cacheSkipLogInterval = time.Minute
+
+// cacheSkips counts values, that were not cached, because the cache is read
+// only or the value too large, cf. cacheResponse.
+type cacheSkips struct {
+	readOnly int64
+	tooLarge int64
+	lastLog  int64 // unix nanoseconds
+}
+
+// add counts a skipped value; it returns true, if the skip should be logged,
+// which happens at most once per cacheSkipLogInterval.
+func (c *cacheSkips) add(err error) bool {
+	switch err {
+	case cache.ErrReadOnly:
+		atomic.AddInt64(&c.readOnly, 1)
+	case cache.ErrTooLarge:
+		atomic.AddInt64(&c.tooLarge, 1)
+	}
+	var (
+		now  = time.Now().UnixNano()
+		last = atomic.LoadInt64(&c.lastLog)
+	)
+	if now-last < int64(cacheSkipLogInterval) {
+		return false
+	}
+	return atomic.CompareAndSwapInt64(&c.lastLog, last, now)
+}
+
+// count returns the number of values skipped for a given reason.
+func (c *cacheSkips) count(err error) int64 {
+	switch err {
+	case cache.ErrReadOnly:
+		return atomic.LoadInt64(&c.readOnly)
+	case cache.ErrTooLarge:
+		return atomic.LoadInt64(&c.tooLarge)
+	}
+	return 0
+}
+
+// handleLocalIdentifier decodes the request, serves cached or shared values,
+// if possible, and encodes the response assembled by a Resolver.
 func (s *Server) handleLocalIdentifier() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// (0) check for cached value
@@ -420,117 +1020,207 @@ func (s *Server) handleLocalIdentifier() http.HandlerFunc {
 		// (8) optional: apply institution filter
 		// (9) send response
 		var (
-			ctx          = r.Context()
-			started      = time.Now()
-			vars         = mux.Vars(r)
-			ids          []Map
-			outbound     = set.New()
-			inbound      = set.New()
-			matched      []string
-			unmatchedSet = set.New()
-			response     = &Response{
-				ID: vars["id"],
-			}
-			sw StopWatch
+			ctx     = r.Context()
+			started = time.Now()
+			id      = mux.Vars(r)["id"]
+			sw      StopWatch
 			// Experimental, hacky support for limiting results to the documents of
 			// a particular institution, given as it appears in the "institution"
 			// field of the index data, e.g. "DE-14".
 			isil = r.URL.Query().Get("i")
+			// Optional expression, citing and cited documents must match.
+			filterQuery = r.URL.Query().Get("filter")
+			filter      *Filter
+			// Output format, JSON by default, "ttl" for RDF/Turtle.
+			format = r.URL.Query().Get("format")
+			// Background refresh requests recompute and replace the cached
+			// value, as do authorized clients sending "Cache-Control: no-cache".
+			refresh = isRefresh(ctx)
+			// Authorized clients may limit the age of a cached value.
+			maxAge time.Duration = -1
+			// Outcome of the cache lookup, if any, cf. Server.workload.
+			hit, miss bool
+			sized     = &sizeWriter{ResponseWriter: w}
 		)
-		sw.SetEnabled(s.StopWatchEnabled)
-		sw.Recordf("[%s] started query: %s", isil, response.ID)
-		// Ganz sicher application/json.
-		w.Header().Add("Content-Type", "application/json")
-		// (0) Check cache first.
-		if s.Cache != nil {
-			err := s.serveFromCache(w, r)
-			switch {
-			case err == cache.ErrCacheMiss:
-				break
-			case err != nil:
-				httpErrLog(w, http.StatusInternalServerError, err)
-				return
-			default:
-				s.Stats.MeasureSinceWithLabels("cache_hit", started, nil)
-				sw.Record("sent cached value")
-				sw.LogTable()
+		w = sized
+		defer func() {
+			if sized.n == 0 {
 				return
 			}
-		}
-		// (1) Get the DOI for the local id; or get out.
-		t := time.Now()
-		err := s.IdentifierDatabase.GetContext(ctx, &response.DOI, "SELECT v FROM map WHERE k = ?", response.ID)
-		if err != nil {
+			s.workload.observeBytes(sized.n)
 			switch {
-			case err == sql.ErrNoRows:
-				log.Printf("doi lookup (%s): %v", response.ID, err)
-				httpErrLogf(w, http.StatusNotFound, "doi lookup (%s): %w", response.ID, err)
-			case err == context.Canceled:
-				log.Printf("doi lookup (%s): %v", response.ID, err)
-			default:
-				httpErrLogf(w, http.StatusInternalServerError, "select id: %w", err)
+			case hit:
+				s.workload.observeCache(true, time.Since(started), sized.n)
+			case miss:
+				s.workload.observeCache(false, time.Since(started), sized.n)
 			}
+		}()
+		// Benchmark mode, which measures a fresh response, cf. Server.Bench.
+		bench, err := queryBool(r, "bench", false)
+		if err != nil {
+			httpErrLog(w, http.StatusBadRequest, err)
 			return
 		}
-		s.Stats.MeasureSinceWithLabels("sql_query", t, nil)
-		sw.Recordf("found doi: %s", response.DOI)
-		// (2) Get outbound and inbound edges.
-		citing, cited, err := s.edges(ctx, response.DOI)
-		if err != nil {
-			switch {
-			case err == context.Canceled:
-				log.Println(err)
-			default:
-				httpErrLogf(w, http.StatusInternalServerError, "edges: %w", err)
-			}
+		if bench && !s.Bench {
+			httpErrLogf(w, http.StatusBadRequest, "bench mode not enabled")
 			return
 		}
-		sw.Recordf("found %d outbound and %d inbound edges", len(citing), len(cited))
-		// (3) We want to collect the unique set of DOI to get the complete
-		// indexed documents.
-		for _, v := range citing {
-			outbound.Add(v.Value)
+		var (
+			settings = s.Settings()
+			tm       *timings
+		)
+		if bench {
+			refresh = true
+		}
+		if bench || settings.Debug {
+			ctx, tm = withTimings(ctx)
+		}
+		if settings.Debug {
+			defer tm.log(id, RequestID(ctx))
 		}
-		for _, v := range cited {
-			inbound.Add(v.Key)
+		sw.SetEnabled(settings.StopWatch || bench)
+		sw.SetThreshold(s.StopWatchThreshold)
+		sw.SetJSON(s.StopWatchJSON)
+		sw.SetID(RequestID(ctx))
+		if s.Hot != nil && !refresh {
+			s.Hot.Hit(id)
 		}
-		ds := outbound.Union(inbound)
-		if ds.IsEmpty() {
-			log.Printf("no citations found: %s", response.ID)
-			w.WriteHeader(http.StatusNotFound)
+		if s.cacheControlAllowed(r) {
+			var noCache bool
+			noCache, maxAge = parseCacheControl(r.Header.Get("Cache-Control"))
+			refresh = refresh || noCache
+		}
+		sw.Recordf("[%s] started query: %s", isil, id)
+		if s.ZstdPassThrough {
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+		switch format {
+		case "", "json":
+			// Ganz sicher application/json.
+			w.Header().Add("Content-Type", "application/json")
+		case "ttl":
+			w.Header().Add("Content-Type", "text/turtle; charset=utf-8")
+		default:
+			httpErrLogf(w, http.StatusBadRequest, "unsupported format: %s", format)
 			return
 		}
-		// (4) Map relevant DOI back to local identifiers.
-		if ids, err = s.mapToLocal(ctx, ds.Slice()); err != nil {
-			switch {
-			case err == context.Canceled:
-				log.Println(err)
-			default:
-				httpErrLogf(w, http.StatusInternalServerError, "map: %w", err)
+		opts := ResolveOptions{Query: r.URL.Query()}
+		// Pages are neither cached nor shared, cf. whole.
+		if opts.Offset, err = parseOffset(r); err != nil {
+			httpErrLog(w, http.StatusBadRequest, err)
+			return
+		}
+		// Alternatively, clients can walk large neighborhoods with a cursor.
+		opts.Cursor, opts.Paged = parseCursor(r)
+		if opts.Paged && opts.Offset > 0 {
+			httpErrLogf(w, http.StatusBadRequest, "offset and cursor cannot be combined")
+			return
+		}
+		// Sorted responses can be diffed between data builds.
+		if opts.Sorted, err = queryBool(r, "sorted", s.SortedResponses); err != nil {
+			httpErrLog(w, http.StatusBadRequest, err)
+			return
+		}
+		// whole is set for the complete response in the default order, which
+		// may be cached and shared.
+		whole := opts.Offset == 0 && !opts.Paged && opts.Sorted == s.SortedResponses && !bench
+		if filterQuery != "" {
+			if filter, err = ParseFilter(filterQuery); err != nil {
+				httpErrLog(w, http.StatusBadRequest, err)
+				return
 			}
+		}
+		// With blobs=false, we skip the index data and respond with local ids
+		// and DOI only; such responses are neither cached nor shared.
+		if opts.Blobs, err = queryBool(r, "blobs", true); err != nil {
+			httpErrLog(w, http.StatusBadRequest, err)
 			return
 		}
-		sw.Recordf("mapped %d dois back to ids", ds.Len())
-		// (5) Here, we can find unmatched items, via DOI.
-		for _, v := range ids {
-			matched = append(matched, v.Value)
-		}
-		unmatchedSet = ds.Difference(set.FromSlice(matched))
-		for k := range unmatchedSet {
-			// We shortcut and do not use a proper JSON marshaller to save a
-			// bit of time. TODO: may switch to proper JSON encoding, if other
-			// parts are more optimized.
-			b := []byte(fmt.Sprintf(`{"doi_str_mv": %q}`, k))
+		// Unmatched documents are always computed and cached, but may be
+		// left out of the response.
+		unmatched, err := s.includeUnmatched(r)
+		if err != nil {
+			httpErrLog(w, http.StatusBadRequest, err)
+			return
+		}
+		var (
+			rv       = s.NewResolver(id, opts, &sw)
+			response = rv.Response()
+		)
+		rv.started = started
+		response.omitUnmatched = !unmatched
+		if bench {
+			response.stopwatch = &sw
+		}
+		// (0) Check cache first, starting with known misses.
+		if !refresh && s.notFound("id:"+id) {
+			http.Error(w, `{"msg": "not found", "status": 404}`, http.StatusNotFound)
+			sw.Record("sent cached not found")
+			sw.LogTable()
+			return
+		}
+		if settings.Cache && !refresh && whole && opts.Blobs {
+			err := s.serveFromCache(w, r, maxAge)
 			switch {
-			case outbound.Contains(k):
-				response.Unmatched.Citing = append(response.Unmatched.Citing, b)
-			case inbound.Contains(k):
-				response.Unmatched.Cited = append(response.Unmatched.Cited, b)
+			case err == cache.ErrCacheMiss:
+				miss = true
+			case err == errStale:
+				miss, refresh = true, true
+			case err != nil:
+				httpErrLog(w, http.StatusInternalServerError, err)
+				return
 			default:
-				panic("cosmic rays detected (in-flight change of inbound or outbound values)")
+				hit = true
+				s.Stats.MeasureSinceWithLabels("cache_hit", started, nil)
+				sw.Record("sent cached value")
+				sw.LogTable()
+				return
+			}
+		}
+		// (0a) Collapse concurrent requests for the same id; only the first
+		// request does the work, the others wait for its result.
+		var (
+			fl     *flight
+			leader bool
+			shared []byte // result to share with waiting requests
+		)
+		if s.SingleFlight && !refresh && whole && opts.Blobs {
+			if fl, leader = s.flights.join(id); leader {
+				defer func() { s.flights.finish(id, fl, shared) }()
+			} else {
+				val, err := s.flights.wait(ctx, fl)
+				if err != nil {
+					log.Printf("single flight wait (%s): %v", id, err)
+					return
+				}
+				if val != nil {
+					if err := s.serveCompressed(w, r, val); err != nil {
+						httpErrLog(w, http.StatusInternalServerError, err)
+						return
+					}
+					s.Stats.MeasureSinceWithLabels("single_flight_hit", started, nil)
+					sw.Record("sent shared value")
+					sw.LogTable()
+					return
+				}
+				// The leader failed or had nothing to share, go on.
+			}
+		}
+		// (1-5) Get the DOI for the local id, its edges and the local ids of
+		// the related DOI; (5b) reject huge neighborhoods early.
+		for _, step := range []func(context.Context) error{rv.Resolve, rv.CheckDegree, rv.Edges, rv.Map} {
+			if err := step(ctx); err != nil {
+				s.writeResolveError(w, id, err)
+				return
+			}
+		}
+		if !isJob(ctx) {
+			if err := rv.CheckSize(); err != nil {
+				s.Stats.MeasureSinceWithLabels("too_large", started, nil)
+				s.writeResolveError(w, id, err)
+				return
 			}
 		}
-		sw.Record("recorded unmatched ids")
 		// (6) At this point, we need to assemble the result. For each
 		// identifier we want the full metadata. We currently use an local
 		// sqlite copy of the index data as this seems to be the fastest
@@ -538,79 +1228,191 @@ func (s *Server) handleLocalIdentifier() http.HandlerFunc {
 		//
 		// This is agnostic to the index data content, it can contain
 		// the full metadata record, or just a few fields.
-		for _, v := range ids {
-			t := time.Now()
-			b, err := s.IndexData.Fetch(v.Key)
-			if errors.Is(err, ErrBlobNotFound) {
-				continue
-			}
-			if err != nil {
-				httpErrLogf(w, http.StatusInternalServerError, "index data fetch: %w", err)
-				return
-			}
-			s.Stats.MeasureSinceWithLabels("index_data_fetch", t, nil)
+		if err := rv.Prepare(ctx); err != nil {
+			s.writeResolveError(w, id, err)
+			return
+		}
+		// (6a) Plain JSON responses are streamed; blobs go straight from the
+		// index data store into the response writer.
+		if format != "ttl" && isil == "" && filter == nil && rv.Streamable() {
+			keep := (settings.Cache && whole) || leader
+			compressed, err := rv.Stream(ctx, w, keep)
+			var serr *StreamError
 			switch {
-			case outbound.Contains(v.Value):
-				response.Citing = append(response.Citing, b)
-			case inbound.Contains(v.Value):
-				response.Cited = append(response.Cited, b)
+			case errors.As(err, &serr) && serr.Sent > 0:
+				// Headers and parts of the body are out, abort the
+				// connection, so the client sees an error.
+				log.Printf("stream (%s): %v", id, err)
+				panic(http.ErrAbortHandler)
+			case err != nil:
+				s.writeResolveError(w, id, err)
+				return
+			case !rv.Degraded():
+				s.workload.observe(rv)
+				// (7) Cache expensive results.
+				if settings.Cache && whole && (refresh || time.Since(started) > s.CacheTriggerDuration) {
+					if err := s.cacheResponse(id, compressed); err != nil {
+						log.Printf("stream (%s): %v", id, err)
+					}
+					sw.Record("cached value")
+				}
+				shared = compressed
+				sw.LogTable()
+				return
 			}
+			// Nothing has been sent yet, fall back to ids and DOI.
+		}
+		// (6b) Otherwise, we collect all blobs first.
+		if err := rv.Fetch(ctx); err != nil {
+			s.writeResolveError(w, id, err)
+			return
 		}
-		sw.Recordf("fetched %d blob from index data store", len(ids))
-		// Finalize response.
-		response.updateCounts()
-		response.Extra.Took = time.Since(started).Seconds()
-		// (7) Cache expensive results.
-		if s.Cache != nil && time.Since(started) > s.CacheTriggerDuration {
-			if err := s.cacheResponse(response); err != nil {
+		rv.Assemble()
+		s.workload.observe(rv)
+		// (7) Cache expensive results and share the result with waiting
+		// requests. We cache the unfiltered response (otherwise the cache
+		// would waste disk space).
+		var (
+			// Degraded responses are not cached, we want the full response,
+			// once the index data is back.
+			expensive = settings.Cache && whole && opts.Blobs && !rv.Degraded() && (refresh || time.Since(started) > s.CacheTriggerDuration)
+			waited    = leader && s.flights.hasWaiters(fl)
+		)
+		if expensive || waited {
+			compressed, err := compressResponse(response)
+			if err != nil {
 				httpErrLog(w, http.StatusInternalServerError, err)
 				return
 			}
-			sw.Record("cached value")
+			if expensive {
+				if err := s.cacheResponse(id, compressed); err != nil {
+					httpErrLog(w, http.StatusInternalServerError, err)
+					return
+				}
+				sw.Record("cached value")
+			}
+			shared = compressed
 		}
 		// (8) Optional: Apply institution filter.
 		if isil != "" {
 			response.applyInstitutionFilter(isil)
 			sw.Record("applied institution filter")
 		}
-		// (9) Send response.
-		if err := json.NewEncoder(w).Encode(response); err != nil {
+		// (8b) Optional: Apply filter expression.
+		if filter != nil {
+			if err := response.applyFilter(filter); err != nil {
+				httpErrLog(w, http.StatusInternalServerError, err)
+				return
+			}
+			sw.Record("applied filter expression")
+		}
+		// (8c) Optional: Drop unmatched documents, keeping their counts.
+		if !unmatched {
+			response.Unmatched.Citing, response.Unmatched.Cited = nil, nil
+		}
+		if bench {
+			sw.Record("assembled response")
+			response.Extra.Bench = sw.BenchStat()
+			response.Extra.Timings = tm.Timings()
+		}
+		// (9) Send response; we encode into a pooled buffer first, so
+		// encoding errors do not result in partial responses.
+		buf := getBuffer()
+		defer putBuffer(buf)
+		if err := response.encode(buf, format); err != nil {
 			httpErrLogf(w, http.StatusInternalServerError, "encode: %w", err)
 			return
 		}
+		if _, err := buf.WriteTo(w); err != nil {
+			log.Printf("write response: %v", err)
+			return
+		}
 		sw.Record("sent response")
 		sw.LogTable()
 	}
 }
 
+// writeResolveError sends the error of a resolver step to the client; ids
+// without a DOI or without citations are recorded as not found.
+func (s *Server) writeResolveError(w http.ResponseWriter, id string, err error) {
+	var tooLarge *TooLargeMessage
+	switch {
+	case errors.As(err, &tooLarge):
+		log.Printf("rejected %s: %s", id, tooLarge.Msg)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		if err := json.NewEncoder(w).Encode(tooLarge); err != nil {
+			log.Printf("encode: %v", err)
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		log.Println(err)
+		if s.NotFound != nil {
+			s.NotFound.Add("id:" + id)
+		}
+		httpErrLog(w, http.StatusNotFound, err)
+	case errors.Is(err, errNoCitations):
+		log.Println(err)
+		if s.NotFound != nil {
+			s.NotFound.Add("id:" + id)
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, context.Canceled):
+		log.Println(err)
+	case errors.Is(err, context.DeadlineExceeded):
+		httpErrLog(w, http.StatusGatewayTimeout, err)
+	default:
+		httpErrLog(w, http.StatusInternalServerError, err)
+	}
+}
+
 // Ping returns an error, if any of the datastores is not available.
 func (s *Server) Ping() error {
-	if err := s.IdentifierDatabase.Ping(); err != nil {
+	if err := s.identifierDB().Ping(); err != nil {
 		return err
 	}
-	if err := s.OciDatabase.Ping(); err != nil {
+	if err := s.ociDB().Ping(); err != nil {
 		return err
 	}
-	if pinger, ok := s.IndexData.(Pinger); ok {
-		if err := pinger.Ping(); err != nil {
+	if f := s.indexData(); f != nil && f.Capabilities().Ping {
+		if err := PingFetcher(f); err != nil {
 			return fmt.Errorf("could not reach index data service: %w", err)
 		}
 	} else {
 		log.Printf("index data service: unknown status")
 	}
+	if s.Edges != nil {
+		if err := s.Edges.Ping(); err != nil {
+			return fmt.Errorf("could not reach edge store: %w", err)
+		}
+	}
+	if s.Analytics != nil {
+		if err := s.Analytics.Ping(); err != nil {
+			return fmt.Errorf("could not reach analytics database: %w", err)
+		}
+	}
+	for name, c := range s.Corpora {
+		if err := c.Ping(); err != nil {
+			return fmt.Errorf("corpus %s: %w", name, err)
+		}
+	}
 	return nil
 }
 
 // OpenDatabase first ensures a file does actually exists, then create as
-// read-only connection.
+// read-only connection. The file may be a shard manifest with a single
+// shard, cf. makta -manifest.
 func OpenDatabase(filename string) (*sqlx.DB, error) {
 	if len(filename) == 0 {
 		return nil, fmt.Errorf("empty file")
 	}
+	filename, err := resolveShardManifest(filename)
+	if err != nil {
+		return nil, err
+	}
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
 		return nil, fmt.Errorf("file not found: %s", filename)
 	}
-	return sqlx.Open("sqlite3", tabutils.WithReadOnly(filename))
+	return sqlx.Open(sqliteDriver, tabutils.WithReadOnly(filename))
 }
 
 // SliceContains returns true, if a string slice contains a given value.
@@ -623,20 +1425,49 @@ func SliceContains(ss []string, v string) bool {
 	return false
 }
 
+// withTimeout returns a context with a timeout, if the duration is positive.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// identifierToDOI looks up the DOI for a local identifier.
+func (s *Server) identifierToDOI(ctx context.Context, id string, doi *string) error {
+	ctx, cancel := withTimeout(ctx, s.IdentifierTimeout)
+	defer cancel()
+	if s.filterMiss("id:" + id) {
+		return sql.ErrNoRows
+	}
+	return s.getContext(ctx, s.identifierDB(), doi, "SELECT v FROM map WHERE k = ?", id)
+}
+
 // edges returns citing (outbound) and cited (inbound) edges for a given DOI.
 func (s *Server) edges(ctx context.Context, doi string) (citing, cited []Map, err error) {
-	t := time.Now()
-	if err := s.OciDatabase.SelectContext(
-		ctx, &citing, "SELECT * FROM map WHERE k = ?", doi); err != nil {
+	ctx, cancel := withTimeout(ctx, s.OciTimeout)
+	defer cancel()
+	if s.Edges != nil {
+		t := time.Now()
+		citing, cited, err = s.Edges.Edges(ctx, doi)
+		s.Stats.MeasureSinceWithLabels("sql_query", t, nil)
+		return citing, cited, err
+	}
+	var (
+		db = s.ociDB()
+		t  = time.Now()
+	)
+	if err := s.selectContext(ctx, db, &citing, "SELECT * FROM map WHERE "+s.doiColumn("k")+" = ?", doi); err != nil {
 		return nil, nil, err
 	}
 	s.Stats.MeasureSinceWithLabels("sql_query", t, nil)
 	t = time.Now()
-	if err := s.OciDatabase.SelectContext(
-		ctx, &cited, "SELECT * FROM map WHERE v = ?", doi); err != nil {
+	if err := s.selectContext(ctx, db, &cited, s.inboundQuery(ctx, db, "= ?"), doi); err != nil {
 		return nil, nil, err
 	}
 	s.Stats.MeasureSinceWithLabels("sql_query", t, nil)
+	s.foldMaps(citing, true, true)
+	s.foldMaps(cited, true, true)
 	return citing, cited, nil
 }
 
@@ -647,6 +1478,8 @@ func (s *Server) mapToLocal(ctx context.Context, dois []string) (ids []Map, err
 	// lead to "too many SQL variables", SQLITE_LIMIT_VARIABLE_NUMBER (default:
 	// 999; https://www.daemon-systems.org/man/sqlite3_bind_blob.3.html).
 	const size = 500 // Anything between 1 and 999.
+	ctx, cancel := withTimeout(ctx, s.IdentifierTimeout)
+	defer cancel()
 	var (
 		t     time.Time
 		query string
@@ -654,22 +1487,76 @@ func (s *Server) mapToLocal(ctx context.Context, dois []string) (ids []Map, err
 	)
 	for _, batch := range batchedStrings(dois, size) {
 		t = time.Now()
-		query, args, err = sqlx.In("SELECT * FROM map WHERE v IN (?)", batch)
+		query, args, err = sqlx.In("SELECT * FROM map WHERE "+s.doiColumn("v")+" IN (?)", batch)
 		if err != nil {
 			return nil, fmt.Errorf("query (%d): %v", len(dois), err)
 		}
-		query = s.IdentifierDatabase.Rebind(query)
+		query = s.identifierDB().Rebind(query)
 		var result []Map // TODO: select into a portion of the final slice directly
-		err = s.IdentifierDatabase.SelectContext(ctx, &result, query, args...)
+		err = s.selectContext(ctx, s.identifierDB(), &result, query, args...)
 		if err != nil {
-			return nil, fmt.Errorf("select (%d): %v", len(dois), err)
+			return nil, fmt.Errorf("select (%d): %w", len(dois), err)
 		}
 		s.Stats.MeasureSinceWithLabels("sql_query", t, nil)
+		s.foldMaps(result, false, true)
 		ids = append(ids, result...)
 	}
 	return ids, nil
 }
 
+// parseOffset returns the value of the offset parameter, used for paging.
+func parseOffset(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("offset")
+	if v == "" {
+		return 0, nil
+	}
+	offset, err := strconv.Atoi(v)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid offset: %q", v)
+	}
+	return offset, nil
+}
+
+// queryBool returns the boolean value of a query parameter, or a default
+// value, if the parameter is not set.
+func queryBool(r *http.Request, key string, value bool) (bool, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return value, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %q", key, v)
+	}
+	return b, nil
+}
+
+// includeUnmatched returns whether a response should contain unmatched
+// documents, as requested with the unmatched parameter or by default, cf.
+// Server.OmitUnmatched.
+func (s *Server) includeUnmatched(r *http.Request) (bool, error) {
+	return queryBool(r, "unmatched", !s.OmitUnmatched)
+}
+
+// nextPage returns the URL path and query of the page at a given offset,
+// keeping all other parameters.
+func (s *Server) nextPage(query url.Values, id string, offset int) string {
+	q := url.Values{}
+	for k, vs := range query {
+		q[k] = vs
+	}
+	q.Set("offset", strconv.Itoa(offset))
+	return s.PathPrefix + "/id/" + url.PathEscape(id) + "?" + q.Encode()
+}
+
+// minInt returns the smaller of two ints.
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 // batchedStrings turns one string slice into one or more smaller strings
 // slices, each with size of at most n.
 func batchedStrings(ss []string, n int) (result [][]string) {
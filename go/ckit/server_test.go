@@ -2,13 +2,17 @@ package ckit
 
 import (
 	"log"
+	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/gorilla/mux"
 	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/cache"
+	"github.com/thoas/stats"
 )
 
 func TestBatchedStrings(t *testing.T) {
@@ -152,6 +156,244 @@ func TestServerBasic(t *testing.T) {
 	// TODO: execute handlers
 }
 
+func TestServerPathPrefix(t *testing.T) {
+	var cases = []struct {
+		prefix string
+		path   string
+		status int
+	}{
+		{"", "/", http.StatusOK},
+		{"/labe/v1", "/labe/v1/", http.StatusOK},
+		{"labe/v1/", "/labe/v1/", http.StatusOK},
+		{"/labe/v1", "/", http.StatusNotFound},
+		{"/labe/v1", "/labe/v1/stats", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		srv := &Server{
+			Router:     mux.NewRouter(),
+			PathPrefix: c.prefix,
+		}
+		srv.Routes()
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", c.path, nil))
+		if rr.Code != c.status {
+			t.Fatalf("[%s] %s: got %v, want %v", c.prefix, c.path, rr.Code, c.status)
+		}
+	}
+}
+
+func TestServerCacheEvict(t *testing.T) {
+	srv := &Server{
+		Router:     mux.NewRouter(),
+		NotFound:   cache.NewTTLSet(time.Minute),
+		AdminToken: "secret",
+	}
+	srv.Routes()
+	srv.NotFound.Add("id:a")
+	srv.NotFound.Add("id:b")
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("DELETE", "/cache/a", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v, want %v", rr.Code, http.StatusOK)
+	}
+	if srv.NotFound.Contains("id:a") {
+		t.Fatalf("got true, want false (evicted)")
+	}
+	if !srv.NotFound.Contains("id:b") {
+		t.Fatalf("got false, want true")
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	// Status of authorized requests; the server has no manifest configured.
+	var routes = []struct {
+		method string
+		target string
+		status int
+	}{
+		{"DELETE", "/cache", http.StatusOK},
+		{"DELETE", "/cache/a", http.StatusOK},
+		{"POST", "/maintenance", http.StatusOK},
+		{"POST", "/rotate", http.StatusBadRequest},
+		{"GET", "/settings", http.StatusOK},
+		{"PATCH", "/settings", http.StatusBadRequest},
+	}
+	var cases = []struct {
+		token  string
+		header string
+		status int
+	}{
+		{"", "", http.StatusForbidden},
+		{"", "Bearer ", http.StatusForbidden},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "Bearer secret", http.StatusOK},
+	}
+	for _, c := range cases {
+		srv := &Server{
+			IdentifierDatabase: memoryDatabase(t, nil),
+			OciDatabase:        memoryDatabase(t, nil),
+			Router:             mux.NewRouter(),
+			NotFound:           cache.NewTTLSet(time.Minute),
+			AdminToken:         c.token,
+		}
+		srv.Routes()
+		srv.NotFound.Add("id:a")
+		for _, route := range routes {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(route.method, route.target, nil)
+			if c.header != "" {
+				req.Header.Set("Authorization", c.header)
+			}
+			srv.ServeHTTP(rr, req)
+			want := c.status
+			if want == http.StatusOK {
+				want = route.status
+			}
+			if rr.Code != want {
+				t.Fatalf("[%s] %s %s: got %v, want %v", c.token, c.header, route.target, rr.Code, want)
+			}
+		}
+		if got := srv.NotFound.Contains("id:a"); got != (c.status != http.StatusOK) {
+			t.Fatalf("[%s] %s: got %v, want %v", c.token, c.header, got, c.status != http.StatusOK)
+		}
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	var cases = []struct {
+		header string
+		result bool
+	}{
+		{"", false},
+		{"gzip", false},
+		{"zstd", true},
+		{"gzip, deflate, br, zstd", true},
+		{"gzip, ZSTD;q=0.5", true},
+		{"gzip, zstd;q=0", false},
+		{"gzip, zstd; q=0.0", false},
+		{"zstdx", false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.header != "" {
+			r.Header.Set("Accept-Encoding", c.header)
+		}
+		if got := acceptsEncoding(r, "zstd"); got != c.result {
+			t.Fatalf("[%s] got %v, want %v", c.header, got, c.result)
+		}
+	}
+}
+
+func TestParseCacheControl(t *testing.T) {
+	var cases = []struct {
+		header  string
+		noCache bool
+		maxAge  time.Duration
+	}{
+		{"", false, -1},
+		{"no-cache", true, -1},
+		{"No-Cache, max-age=60", true, time.Minute},
+		{"max-age=0", false, 0},
+		{"max-age=x", false, -1},
+		{"max-age=-1", false, -1},
+	}
+	for _, c := range cases {
+		noCache, maxAge := parseCacheControl(c.header)
+		if noCache != c.noCache || maxAge != c.maxAge {
+			t.Fatalf("[%s] got %v %v, want %v %v", c.header, noCache, maxAge, c.noCache, c.maxAge)
+		}
+	}
+}
+
+func TestCacheControlAllowed(t *testing.T) {
+	var cases = []struct {
+		token  string
+		header string
+		result bool
+	}{
+		{"", "", false},
+		{"", "Bearer ", false},
+		{"secret", "", false},
+		{"secret", "Bearer wrong", false},
+		{"secret", "Bearer secret", true},
+	}
+	for _, c := range cases {
+		srv := &Server{CacheControlToken: c.token}
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.header != "" {
+			r.Header.Set("Authorization", c.header)
+		}
+		if got := srv.cacheControlAllowed(r); got != c.result {
+			t.Fatalf("[%s] %s: got %v, want %v", c.token, c.header, got, c.result)
+		}
+	}
+}
+
+func TestParseOffset(t *testing.T) {
+	var cases = []struct {
+		target string
+		offset int
+		err    bool
+	}{
+		{"/id/a", 0, false},
+		{"/id/a?offset=10", 10, false},
+		{"/id/a?offset=-1", 0, true},
+		{"/id/a?offset=x", 0, true},
+	}
+	for _, c := range cases {
+		offset, err := parseOffset(httptest.NewRequest("GET", c.target, nil))
+		if offset != c.offset || (err != nil) != c.err {
+			t.Fatalf("[%s] got %v %v, want %v (err: %v)", c.target, offset, err, c.offset, c.err)
+		}
+	}
+}
+
+func TestQueryBool(t *testing.T) {
+	var cases = []struct {
+		target string
+		value  bool
+		result bool
+		err    bool
+	}{
+		{"/id/a", true, true, false},
+		{"/id/a", false, false, false},
+		{"/id/a?blobs=false", true, false, false},
+		{"/id/a?blobs=0", true, false, false},
+		{"/id/a?blobs=true", false, true, false},
+		{"/id/a?blobs=no", true, false, true},
+	}
+	for _, c := range cases {
+		result, err := queryBool(httptest.NewRequest("GET", c.target, nil), "blobs", c.value)
+		if result != c.result || (err != nil) != c.err {
+			t.Fatalf("[%s] got %v %v, want %v (err: %v)", c.target, result, err, c.result, c.err)
+		}
+	}
+}
+
+func TestNextPage(t *testing.T) {
+	var cases = []struct {
+		prefix string
+		target string
+		id     string
+		offset int
+		next   string
+	}{
+		{"", "/id/a", "a", 10, "/id/a?offset=10"},
+		{"/labe", "/labe/id/a?format=ttl&offset=10", "a", 20, "/labe/id/a?format=ttl&offset=20"},
+		{"", "/id/a%2Fb?i=DE-14", "a/b", 5, "/id/a%2Fb?i=DE-14&offset=5"},
+	}
+	for _, c := range cases {
+		srv := &Server{PathPrefix: c.prefix}
+		r := httptest.NewRequest("GET", c.target, nil)
+		if got := srv.nextPage(r.URL.Query(), c.id, c.offset); got != c.next {
+			t.Fatalf("[%s] got %v, want %v", c.target, got, c.next)
+		}
+	}
+}
+
 func mustMarshal(v interface{}) []byte {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -159,3 +401,40 @@ func mustMarshal(v interface{}) []byte {
 	}
 	return b
 }
+
+// fullStore is a cache store, that does not take any more values.
+type fullStore struct {
+	*memoryStore
+	err error
+}
+
+func (s *fullStore) Set(key string, value []byte) error { return s.err }
+
+func TestCacheResponseSkipped(t *testing.T) {
+	srv := &Server{
+		Router: mux.NewRouter(),
+		Cache:  &fullStore{memoryStore: newMemoryStore(), err: cache.ErrReadOnly},
+		Stats:  stats.New(),
+	}
+	srv.Routes()
+	for _, id := range []string{"a", "b"} {
+		if err := srv.cacheResponse(id, []byte("{}")); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+	}
+	srv.Cache.(*fullStore).err = cache.ErrTooLarge
+	if err := srv.cacheResponse("c", []byte("{}")); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/cache", nil))
+	var info struct {
+		Skipped map[string]int64 `json:"skipped"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if info.Skipped["read_only"] != 2 || info.Skipped["too_large"] != 1 {
+		t.Fatalf("got %v, want 2 read only, 1 too large", info.Skipped)
+	}
+}
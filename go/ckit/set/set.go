@@ -36,6 +36,12 @@ func (s Set) Add(v string) Set {
 	return s
 }
 
+// Remove removes an element, if it exists.
+func (s Set) Remove(v string) Set {
+	delete(s, v)
+	return s
+}
+
 // Len returns number of elements in set.
 func (s Set) Len() int {
 	return len(s)
@@ -37,6 +37,10 @@ func TestSet(t *testing.T) {
 	top.Add("3")
 	is.Equal(r.TopK(2), top)
 
+	r.Remove("8").Remove("9")
+	is.Equal(r.Len(), 6)
+	is.True(!r.Contains("8"))
+
 	r.Clear()
 	is.Equal(r.Len(), 0)
 }
@@ -0,0 +1,133 @@
+package ckit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// ErrNotConfigured is returned for settings, that cannot be changed, because
+// the feature has not been set up at startup, e.g. a cache.
+var ErrNotConfigured = errors.New("not configured")
+
+// Settings can be changed at runtime with PATCH /settings, without a
+// restart, which would lose the in-memory state, e.g. a warm page cache.
+type Settings struct {
+	// Cache enables cache lookups and writes; a disabled cache keeps its
+	// values. Requires Server.Cache.
+	Cache bool `json:"cache"`
+	// StopWatch is Server.StopWatchEnabled.
+	StopWatch bool `json:"stopwatch"`
+	// Debug logs every SQL query and blob fetch of a request, cf. Timing.
+	Debug bool `json:"debug"`
+	// BlobConcurrency is the limit of concurrent index data fetches, zero,
+	// if not limited, cf. Server.IndexDataConcurrency.
+	BlobConcurrency int `json:"blob_concurrency"`
+}
+
+// SettingsUpdate changes the settings, that are set.
+type SettingsUpdate struct {
+	Cache           *bool `json:"cache"`
+	StopWatch       *bool `json:"stopwatch"`
+	Debug           *bool `json:"debug"`
+	BlobConcurrency *int  `json:"blob_concurrency"`
+}
+
+// limitFetcher returns the current index data limit, if any.
+func (s *Server) limitFetcher() *LimitFetcher {
+	s.dataMu.RLock()
+	defer s.dataMu.RUnlock()
+	return s.limit
+}
+
+// Settings returns the current settings, initially taken from the server
+// configuration.
+func (s *Server) Settings() Settings {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	return *s.currentSettings()
+}
+
+// currentSettings returns the settings; s.settingsMu must be held.
+func (s *Server) currentSettings() *Settings {
+	if s.settings == nil {
+		s.settings = &Settings{
+			Cache:     s.Cache != nil,
+			StopWatch: s.StopWatchEnabled,
+			Debug:     s.Debug,
+		}
+	}
+	if lf := s.limitFetcher(); lf != nil {
+		s.settings.BlobConcurrency = lf.Limit()
+	} else {
+		s.settings.BlobConcurrency = 0
+	}
+	return s.settings
+}
+
+// UpdateSettings applies an update, all or nothing, and returns the new
+// settings.
+func (s *Server) UpdateSettings(u SettingsUpdate) (Settings, error) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	lf := s.limitFetcher()
+	switch {
+	case u.Cache != nil && *u.Cache && s.Cache == nil:
+		return Settings{}, fmt.Errorf("cache: %w", ErrNotConfigured)
+	case u.BlobConcurrency != nil && lf == nil:
+		return Settings{}, fmt.Errorf("blob concurrency: %w", ErrNotConfigured)
+	case u.BlobConcurrency != nil && *u.BlobConcurrency < 1:
+		return Settings{}, fmt.Errorf("blob concurrency must be positive")
+	}
+	v := s.currentSettings()
+	if u.Cache != nil {
+		v.Cache = *u.Cache
+	}
+	if u.StopWatch != nil {
+		v.StopWatch = *u.StopWatch
+	}
+	if u.Debug != nil {
+		v.Debug = *u.Debug
+	}
+	if u.BlobConcurrency != nil {
+		lf.SetLimit(*u.BlobConcurrency)
+		v.BlobConcurrency = *u.BlobConcurrency
+	}
+	return *v, nil
+}
+
+// handleSettings returns the current settings.
+func (s *Server) handleSettings() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.Settings()); err != nil {
+			log.Printf("settings: %v", err)
+		}
+	}
+}
+
+// handleSettingsUpdate changes settings, e.g. {"cache": false}, and returns
+// the new settings.
+func (s *Server) handleSettingsUpdate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var u SettingsUpdate
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&u); err != nil {
+			httpErrLogf(w, http.StatusBadRequest, "settings: %w", err)
+			return
+		}
+		v, err := s.UpdateSettings(u)
+		if err != nil {
+			httpErrLogf(w, http.StatusBadRequest, "settings: %w", err)
+			return
+		}
+		log.Printf("settings changed: %+v", v)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			log.Printf("settings: %v", err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package ckit
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestUpdateSettings(t *testing.T) {
+	var (
+		srv = &Server{
+			IndexData:            mapFetcher{},
+			IndexDataConcurrency: 4,
+			Router:               mux.NewRouter(),
+			StopWatchEnabled:     true,
+		}
+		yes = true
+		n   = 8
+	)
+	srv.Routes()
+	lf := srv.limitFetcher()
+	if got, want := srv.Settings(), (Settings{StopWatch: true, BlobConcurrency: 4}); got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	// Without a cache, it cannot be switched on; updates are all or nothing.
+	_, err := srv.UpdateSettings(SettingsUpdate{Cache: &yes, Debug: &yes})
+	if !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("got %v, want %v", err, ErrNotConfigured)
+	}
+	if srv.Settings().Debug {
+		t.Fatalf("got debug after failed update")
+	}
+	v, err := srv.UpdateSettings(SettingsUpdate{Debug: &yes, BlobConcurrency: &n})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Debug || v.BlobConcurrency != 8 || lf.Limit() != 8 {
+		t.Fatalf("got %+v, limit %d", v, lf.Limit())
+	}
+	srv = &Server{IndexData: mapFetcher{}}
+	if _, err := srv.UpdateSettings(SettingsUpdate{BlobConcurrency: &n}); !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("got %v, want %v", err, ErrNotConfigured)
+	}
+}
+
+func TestSettingsCacheOff(t *testing.T) {
+	store := newMemoryStore()
+	h := newHarness(t, func(s *Server) {
+		s.Cache = store
+	})
+	no := false
+	if _, err := h.srv.UpdateSettings(SettingsUpdate{Cache: &no}); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := h.someID(t)
+	h.getResponse(t, "/id/"+id)
+	if r := h.getResponse(t, "/id/"+id); r.Extra.Cached {
+		t.Fatalf("got cached response with cache off")
+	}
+	if n, _ := store.ItemCount(); n != 0 {
+		t.Fatalf("got %d cached items with cache off, want 0", n)
+	}
+}
@@ -0,0 +1,129 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/slub/labe/go/ckit/tabutils"
+)
+
+// ShardFetcher fetches blobs from an index database split by key, cf. makta
+// -shards; each id is looked up only in the shard it hashes to.
+type ShardFetcher struct {
+	Shards []*SqliteFetcher
+}
+
+// OpenShards opens all shards listed in a shard manifest, cf.
+// tabutils.ReadShardManifest.
+func OpenShards(filename string, busyRetries int) (*ShardFetcher, error) {
+	m, err := tabutils.ReadShardManifest(filename)
+	if err != nil {
+		return nil, err
+	}
+	f := &ShardFetcher{}
+	for _, file := range m.Files {
+		db, err := OpenDatabase(file)
+		if err != nil {
+			closeFetcher(f)
+			return nil, err
+		}
+		f.Shards = append(f.Shards, &SqliteFetcher{DB: db, BusyRetries: busyRetries})
+	}
+	return f, nil
+}
+
+// shard returns the shard responsible for an id.
+func (f *ShardFetcher) shard(id string) *SqliteFetcher {
+	return f.Shards[tabutils.ShardFor([]byte(id), len(f.Shards))]
+}
+
+// Fetch document.
+func (f *ShardFetcher) Fetch(id string) ([]byte, error) {
+	return f.FetchContext(context.Background(), id)
+}
+
+// FetchContext fetches a document from its shard.
+func (f *ShardFetcher) FetchContext(ctx context.Context, id string) ([]byte, error) {
+	return f.shard(id).FetchContext(ctx, id)
+}
+
+// FetchBatch groups ids by shard and fetches them in batches per shard.
+func (f *ShardFetcher) FetchBatch(ctx context.Context, ids []string) (map[string][]byte, error) {
+	byShard := make(map[*SqliteFetcher][]string)
+	for _, id := range ids {
+		sf := f.shard(id)
+		byShard[sf] = append(byShard[sf], id)
+	}
+	result := make(map[string][]byte, len(ids))
+	for sf, batch := range byShard {
+		m, err := sf.FetchBatch(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range m {
+			result[k] = v
+		}
+	}
+	return result, nil
+}
+
+// ApproximateCount sums the counts of all shards.
+func (f *ShardFetcher) ApproximateCount(ctx context.Context) (int64, error) {
+	var total int64
+	for _, sf := range f.Shards {
+		n, err := sf.ApproximateCount(ctx)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// Ping pings all shards.
+func (f *ShardFetcher) Ping() error {
+	for _, sf := range f.Shards {
+		if err := sf.Ping(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Capabilities of sharded sqlite databases.
+func (f *ShardFetcher) Capabilities() Capabilities {
+	return Capabilities{Batch: true, Ping: true, Count: true}
+}
+
+// resolveShardManifest returns the file of a shard manifest with a single shard, cf.
+// makta -manifest; other filenames are returned as they are. Identifier and
+// citation databases are queried in many ways, so only index data can be
+// split into several shards.
+func resolveShardManifest(filename string) (string, error) {
+	if !tabutils.IsShardManifest(filename) {
+		return filename, nil
+	}
+	m, err := tabutils.ReadShardManifest(filename)
+	if err != nil {
+		return "", err
+	}
+	if m.Shards != 1 {
+		return "", fmt.Errorf("%s: got %d shards, only index data can be sharded", filepath.Base(filename), m.Shards)
+	}
+	return m.Files[0], nil
+}
+
+// openShardsOrDatabase opens a shard manifest or a single sqlite database as
+// a fetcher.
+func openShardsOrDatabase(filename string, busyRetries int) (Fetcher, error) {
+	if tabutils.IsShardManifest(filename) {
+		return OpenShards(filename, busyRetries)
+	}
+	db, err := sqlx.Open(sqliteDriver, tabutils.WithReadOnly(filename))
+	if err != nil {
+		return nil, fmt.Errorf("database: %w", err)
+	}
+	return &SqliteFetcher{DB: db, BusyRetries: busyRetries}, nil
+}
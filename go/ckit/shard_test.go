@@ -0,0 +1,96 @@
+package ckit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/slub/labe/go/ckit/tabutils"
+)
+
+// writeShards writes rows into n shard databases next to output, as makta
+// -shards does, and returns the manifest filename.
+func writeShards(t *testing.T, output string, n int, rows map[string]string) string {
+	t.Helper()
+	m := tabutils.NewShardManifest(output, n)
+	dbs := make([]*sqlx.DB, n)
+	for i, name := range m.Files {
+		db, err := sqlx.Open(sqliteDriver, filepath.Join(filepath.Dir(output), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if _, err := db.Exec("CREATE TABLE map (k TEXT, v TEXT); CREATE INDEX idx_k ON map(k);"); err != nil {
+			t.Fatal(err)
+		}
+		dbs[i] = db
+	}
+	for k, v := range rows {
+		db := dbs[tabutils.ShardFor([]byte(k), n)]
+		if _, err := db.Exec("INSERT INTO map VALUES (?, ?)", k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filename, err := tabutils.WriteShardManifest(output, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestShardFetcher(t *testing.T) {
+	rows := map[string]string{"a": "1", "b": "2", "c": "3", "d": "4", "e": "5"}
+	manifest := writeShards(t, filepath.Join(t.TempDir(), "index.db"), 3, rows)
+	g := &FetchGroup{}
+	if err := g.FromFiles(manifest); err != nil {
+		t.Fatal(err)
+	}
+	defer closeFetcher(g)
+	if n := len(sqliteBackends(g)); n != 3 {
+		t.Fatalf("got %d sqlite backends, want 3", n)
+	}
+	ctx := context.Background()
+	for k, v := range rows {
+		b, err := g.FetchContext(ctx, k)
+		if err != nil {
+			t.Fatalf("fetch %s: %v", k, err)
+		}
+		if string(b) != v {
+			t.Fatalf("fetch %s: got %s, want %s", k, b, v)
+		}
+	}
+	if _, err := g.FetchContext(ctx, "x"); err != ErrBlobNotFound {
+		t.Fatalf("got %v, want %v", err, ErrBlobNotFound)
+	}
+	sf := g.Backends[0].(*ShardFetcher)
+	result, err := sf.FetchBatch(ctx, []string{"a", "c", "e", "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 3 || string(result["c"]) != "3" {
+		t.Fatalf("got %v, want a, c and e", result)
+	}
+	if n, err := sf.ApproximateCount(ctx); err != nil || n != int64(len(rows)) {
+		t.Fatalf("got %d, %v, want %d", n, err, len(rows))
+	}
+}
+
+func TestOpenDatabaseManifest(t *testing.T) {
+	dir := t.TempDir()
+	single := writeShards(t, filepath.Join(dir, "i.db"), 1, map[string]string{"a": "10.1/a"})
+	db, err := OpenDatabase(single)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var v string
+	if err := db.Get(&v, "SELECT v FROM map WHERE k = 'a'"); err != nil || v != "10.1/a" {
+		t.Fatalf("got %v, %v, want 10.1/a", v, err)
+	}
+	// Only index data can be split.
+	multi := writeShards(t, filepath.Join(dir, "o.db"), 2, map[string]string{"a": "b"})
+	if _, err := OpenDatabase(multi); err == nil {
+		t.Fatalf("want error for a manifest with two shards")
+	}
+}
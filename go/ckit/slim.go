@@ -0,0 +1,162 @@
+package ckit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// StripRule removes a top level field from index data blobs, e.g. a fulltext
+// or internal fields, to make responses smaller without rebuilding the index
+// database. With MaxSize set, the field is only removed, if its JSON value is
+// larger than MaxSize bytes, e.g. long abstracts.
+type StripRule struct {
+	Field   string
+	MaxSize int
+}
+
+// String returns the rule in the format understood by ParseStripRule.
+func (r StripRule) String() string {
+	if r.MaxSize > 0 {
+		return fmt.Sprintf("%s:%d", r.Field, r.MaxSize)
+	}
+	return r.Field
+}
+
+// ParseStripRule parses a field name, optionally followed by a colon and a
+// size in bytes, e.g. "fulltext" or "abstract:2048".
+func ParseStripRule(s string) (StripRule, error) {
+	var rule StripRule
+	i := strings.LastIndex(s, ":")
+	if i == -1 {
+		rule.Field = s
+	} else {
+		size, err := strconv.Atoi(s[i+1:])
+		if err != nil || size < 1 {
+			return rule, fmt.Errorf("invalid strip rule size: %q", s)
+		}
+		rule.Field, rule.MaxSize = s[:i], size
+	}
+	if rule.Field == "" {
+		return rule, fmt.Errorf("invalid strip rule: %q", s)
+	}
+	return rule, nil
+}
+
+// stripBlob applies rules to a blob. Blobs without any of the fields are
+// returned as is; so are blobs, that are not JSON objects.
+func stripBlob(b []byte, rules []StripRule) []byte {
+	var candidate bool
+	for _, rule := range rules {
+		if bytes.Contains(b, []byte(strconv.Quote(rule.Field))) {
+			candidate = true
+			break
+		}
+	}
+	if !candidate {
+		return b
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return b
+	}
+	var changed bool
+	for _, rule := range rules {
+		v, ok := doc[rule.Field]
+		if !ok || (rule.MaxSize > 0 && len(v) <= rule.MaxSize) {
+			continue
+		}
+		delete(doc, rule.Field)
+		changed = true
+	}
+	if !changed {
+		return b
+	}
+	p, err := json.Marshal(doc)
+	if err != nil {
+		return b
+	}
+	return p
+}
+
+// slim applies the strip rules of the server to a blob.
+func (s *Server) slim(b []byte) []byte {
+	if len(s.StripRules) == 0 {
+		return b
+	}
+	return stripBlob(b, s.StripRules)
+}
+
+// fetchBlob fetches a blob from the index data and prepares it, cf.
+// prepareBlob; the backend it came from is counted in the response. Dropped
+// blobs result in ErrBlobNotFound.
+func (s *Server) fetchBlob(ctx context.Context, response *Response, id string) ([]byte, error) {
+	t := time.Now()
+	b, source, err := fetchSource(ctx, s.indexData(), id)
+	recordTiming(ctx, "fetch", id, 0, t, err)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = s.prepareBlob(response, id, b); err != nil {
+		return nil, err
+	}
+	response.addSource(source)
+	return b, nil
+}
+
+// prepareBlob checks a blob, cf. checkBlob, applies the strip rules and
+// transformers and adds annotations. Dropped blobs result in ErrBlobNotFound;
+// so do blobs a transformer failed on, these are listed as invalid.
+func (s *Server) prepareBlob(response *Response, id string, b []byte) ([]byte, error) {
+	if s.checkBlob(response, id, b) {
+		return nil, ErrBlobNotFound
+	}
+	s.flagSelfCitation(response, id, b)
+	if len(s.YearFields) > 0 {
+		if year, ok := s.blobYear(b); ok {
+			response.recordYear(id, year)
+		}
+	}
+	b = s.slim(b)
+	var err error
+	for _, t := range s.Transformers {
+		if b, err = t.Transform(b); err != nil {
+			log.Printf("transform %s: %v", id, err)
+			response.Extra.Invalid = append(response.Extra.Invalid, id)
+			return nil, ErrBlobNotFound
+		}
+	}
+	return response.annotated(id, b), nil
+}
+
+// prepareSource fetches the requested document, if it is needed to flag
+// self-citations or to compute citation ages; a missing document is not an
+// error.
+func (s *Server) prepareSource(ctx context.Context, response *Response) error {
+	if s.SelfCitation == nil && len(s.YearFields) == 0 {
+		return nil
+	}
+	b, err := FetchContext(ctx, s.indexData(), response.ID)
+	switch {
+	case errors.Is(err, ErrBlobNotFound):
+		return nil
+	case err != nil:
+		return err
+	}
+	if s.SelfCitation != nil {
+		keys := s.SelfCitation.keys(b)
+		response.self = &keys
+		response.Extra.SelfCitations = map[string]int{"author": 0, "journal": 0, "total": 0}
+	}
+	if len(s.YearFields) > 0 {
+		response.year, _ = s.blobYear(b)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package ckit
+
+import "testing"
+
+func TestParseStripRule(t *testing.T) {
+	var cases = []struct {
+		s    string
+		rule StripRule
+		err  bool
+	}{
+		{"fulltext", StripRule{Field: "fulltext"}, false},
+		{"abstract:2048", StripRule{Field: "abstract", MaxSize: 2048}, false},
+		{"a:b:10", StripRule{Field: "a:b", MaxSize: 10}, false},
+		{"", StripRule{}, true},
+		{":10", StripRule{}, true},
+		{"abstract:x", StripRule{}, true},
+		{"abstract:0", StripRule{}, true},
+	}
+	for _, c := range cases {
+		rule, err := ParseStripRule(c.s)
+		if (err != nil) != c.err {
+			t.Fatalf("%q: got %v, want error %v", c.s, err, c.err)
+		}
+		if err == nil && rule != c.rule {
+			t.Fatalf("%q: got %v, want %v", c.s, rule, c.rule)
+		}
+	}
+}
+
+func TestStripBlob(t *testing.T) {
+	rules := []StripRule{{Field: "fulltext"}, {Field: "abstract", MaxSize: 10}}
+	var cases = []struct {
+		blob   string
+		result string
+	}{
+		{`{"id":"1"}`, `{"id":"1"}`},
+		{`{"id":"1","fulltext":"..."}`, `{"id":"1"}`},
+		{`{"abstract":"short","id":"1"}`, `{"abstract":"short","id":"1"}`},
+		{`{"abstract":"a rather long abstract","id":"1"}`, `{"id":"1"}`},
+		{`{"title":"fulltext"}`, `{"title":"fulltext"}`},
+		{`"fulltext"`, `"fulltext"`},
+	}
+	for _, c := range cases {
+		if result := string(stripBlob([]byte(c.blob), rules)); result != c.result {
+			t.Fatalf("%s: got %s, want %s", c.blob, result, c.result)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package ckit
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver is the driver used by OpenDatabase and FetchGroup.FromFiles;
+// it is the sqlite3 driver, which applies SqliteOptions to every new
+// connection.
+const sqliteDriver = "sqlite3_ckit"
+
+var (
+	sqliteMu      sync.RWMutex
+	sqlitePragmas []string // applied to new connections, cf. SetSqliteOptions
+)
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			sqliteMu.RLock()
+			defer sqliteMu.RUnlock()
+			for _, p := range sqlitePragmas {
+				if _, err := conn.Exec(p, nil); err != nil {
+					return fmt.Errorf("%s: %w", p, err)
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// SqliteOptions tune the memory usage of sqlite connections; zero values
+// keep the sqlite defaults. With mmap, pages of large databases, like the
+// index data, are served from the operating system page cache, instead of
+// being copied into the page cache of each connection, cf.
+// https://www.sqlite.org/mmap.html.
+type SqliteOptions struct {
+	// MmapSize is the maximum number of bytes of a database to memory map.
+	MmapSize int64
+	// CacheSize is the page cache size per connection; in pages, if
+	// positive, in KiB, if negative.
+	CacheSize int64
+	// TempStore is default, file or memory.
+	TempStore string
+}
+
+// pragmas returns the statements to run on a new connection.
+func (o SqliteOptions) pragmas() ([]string, error) {
+	var result []string
+	if o.MmapSize < 0 {
+		return nil, fmt.Errorf("invalid mmap size: %d", o.MmapSize)
+	}
+	if o.MmapSize > 0 {
+		result = append(result, fmt.Sprintf("PRAGMA mmap_size = %d", o.MmapSize))
+	}
+	if o.CacheSize != 0 {
+		result = append(result, fmt.Sprintf("PRAGMA cache_size = %d", o.CacheSize))
+	}
+	switch v := strings.ToLower(o.TempStore); v {
+	case "":
+	case "default", "file", "memory":
+		result = append(result, "PRAGMA temp_store = "+v)
+	default:
+		return nil, fmt.Errorf("invalid temp store: %s", o.TempStore)
+	}
+	return result, nil
+}
+
+// SetSqliteOptions sets options for all connections opened afterwards
+// by OpenDatabase or FetchGroup.FromFiles; connections are opened lazily, so
+// set options before opening databases.
+func SetSqliteOptions(o SqliteOptions) error {
+	pragmas, err := o.pragmas()
+	if err != nil {
+		return err
+	}
+	sqliteMu.Lock()
+	defer sqliteMu.Unlock()
+	sqlitePragmas = pragmas
+	return nil
+}
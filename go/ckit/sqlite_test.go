@@ -0,0 +1,54 @@
+package ckit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSqliteOptionsPragmas(t *testing.T) {
+	var cases = []struct {
+		o    SqliteOptions
+		want []string
+		err  bool
+	}{
+		{SqliteOptions{}, nil, false},
+		{SqliteOptions{MmapSize: 1 << 30}, []string{"PRAGMA mmap_size = 1073741824"}, false},
+		{SqliteOptions{CacheSize: -2000, TempStore: "Memory"}, []string{
+			"PRAGMA cache_size = -2000",
+			"PRAGMA temp_store = memory",
+		}, false},
+		{SqliteOptions{MmapSize: -1}, nil, true},
+		{SqliteOptions{TempStore: "disk"}, nil, true},
+	}
+	for i, c := range cases {
+		got, err := c.o.pragmas()
+		if (err != nil) != c.err {
+			t.Fatalf("[%d] got %v, want error %v", i, err, c.err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("[%d] got %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestSetSqliteOptions(t *testing.T) {
+	defer SetSqliteOptions(SqliteOptions{})
+	if err := SetSqliteOptions(SqliteOptions{TempStore: "disk"}); err == nil {
+		t.Fatalf("got nil, want error")
+	}
+	if err := SetSqliteOptions(SqliteOptions{MmapSize: 1 << 20}); err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenDatabase("testdata/id_doi.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var size int64
+	if err := db.Get(&size, "PRAGMA mmap_size"); err != nil {
+		t.Fatal(err)
+	}
+	if size != 1<<20 {
+		t.Fatalf("got %d, want %d", size, 1<<20)
+	}
+}
@@ -0,0 +1,128 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/slub/labe/go/ckit/notify"
+)
+
+// DefaultRefreshIntervals are the expected update intervals of the datasets,
+// with some slack for the pipeline: index data (and the identifier database
+// derived from it) is updated daily, OCI monthly.
+var DefaultRefreshIntervals = map[string]time.Duration{
+	"identifier": 48 * time.Hour,
+	"index":      48 * time.Hour,
+	"oci":        32 * 24 * time.Hour,
+}
+
+// ParseRefreshInterval parses an expected refresh interval given as
+// name=duration, e.g. oci=768h.
+func ParseRefreshInterval(s string) (name string, d time.Duration, err error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", 0, fmt.Errorf("want name=duration, got %s", s)
+	}
+	if d, err = time.ParseDuration(parts[1]); err != nil {
+		return "", 0, err
+	}
+	return parts[0], d, nil
+}
+
+// refreshInterval returns the expected refresh interval of a dataset;
+// additional index databases, e.g. index-1, share the interval of index.
+// Zero means, the dataset is not checked.
+func (s *Server) refreshInterval(name string) time.Duration {
+	intervals := s.RefreshIntervals
+	if intervals == nil {
+		intervals = DefaultRefreshIntervals
+	}
+	if d, ok := intervals[name]; ok {
+		return d
+	}
+	if i := strings.Index(name, "-"); i > 0 {
+		return intervals[name[:i]]
+	}
+	return 0
+}
+
+// StaleDatasets returns the datasets, which have not been updated within
+// their expected refresh interval.
+func (s *Server) StaleDatasets(ctx context.Context) ([]DatasetHealth, error) {
+	resp := s.Health(ctx)
+	if resp.Status == HealthDown {
+		return nil, fmt.Errorf("health: %s", resp.Error)
+	}
+	var stale []DatasetHealth
+	for _, dh := range resp.Datasets {
+		if d := s.refreshInterval(dh.Name); d > 0 && dh.age > d {
+			stale = append(stale, dh)
+		}
+	}
+	return stale, nil
+}
+
+// checkStale logs and publishes datasets, which became stale since the last
+// check; reported keeps track of datasets already reported, so a dataset is
+// reported again only after it has been replaced with data, that is stale
+// as well.
+func (s *Server) checkStale(ctx context.Context, reported map[string]string) error {
+	stale, err := s.StaleDatasets(ctx)
+	if err != nil {
+		return err
+	}
+	var (
+		e    = notify.NewEvent(notify.EventStale)
+		seen = make(map[string]bool)
+	)
+	for _, dh := range stale {
+		seen[dh.Name] = true
+		key := dh.Path + "@" + dh.Date + "@" + dh.Modified.String()
+		if reported[dh.Name] == key {
+			continue
+		}
+		reported[dh.Name] = key
+		maxAge := s.refreshInterval(dh.Name)
+		log.Printf("[xx] stale dataset: %s (%s) is %s old, expected update every %s",
+			dh.Name, dh.Path, dh.Age, maxAge)
+		ds := notify.Stat(dh.Name, dh.Path)
+		ds.Age, ds.MaxAge = dh.Age, maxAge.String()
+		e.Datasets = append(e.Datasets, ds)
+	}
+	for name := range reported {
+		if !seen[name] {
+			log.Printf("[ok] dataset %s is up to date again", name)
+			delete(reported, name)
+		}
+	}
+	if len(e.Datasets) > 0 {
+		s.Notifier.Notify(e)
+	}
+	return nil
+}
+
+// WatchStale checks all datasets against their expected refresh interval
+// right away and then at a given interval, until the context is cancelled.
+// Datasets not updated in time are logged as an error and published as a
+// stale event, so failed updates do not go unnoticed.
+func (s *Server) WatchStale(ctx context.Context, interval time.Duration) {
+	reported := make(map[string]string)
+	if err := s.checkStale(ctx, reported); err != nil {
+		log.Printf("stale: %v", err)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.checkStale(ctx, reported); err != nil {
+				log.Printf("stale: %v", err)
+			}
+		}
+	}
+}
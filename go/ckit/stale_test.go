@@ -0,0 +1,112 @@
+package ckit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slub/labe/go/ckit/notify"
+)
+
+func TestParseRefreshInterval(t *testing.T) {
+	var cases = []struct {
+		s    string
+		name string
+		d    time.Duration
+		err  bool
+	}{
+		{"oci=768h", "oci", 768 * time.Hour, false},
+		{"index=36h", "index", 36 * time.Hour, false},
+		{"index", "", 0, true},
+		{"=36h", "", 0, true},
+		{"oci=monthly", "", 0, true},
+	}
+	for _, c := range cases {
+		name, d, err := ParseRefreshInterval(c.s)
+		if (err != nil) != c.err {
+			t.Fatalf("[%s] got %v", c.s, err)
+		}
+		if name != c.name || d != c.d {
+			t.Fatalf("[%s] got %v %v, want %v %v", c.s, name, d, c.name, c.d)
+		}
+	}
+}
+
+func TestRefreshInterval(t *testing.T) {
+	var cases = []struct {
+		intervals map[string]time.Duration
+		name      string
+		d         time.Duration
+	}{
+		{nil, "oci", 32 * 24 * time.Hour},
+		{nil, "index", 48 * time.Hour},
+		{nil, "index-1", 48 * time.Hour},
+		{nil, "other", 0},
+		{map[string]time.Duration{"index": time.Hour}, "index-2", time.Hour},
+		{map[string]time.Duration{"index": time.Hour}, "oci", 0},
+	}
+	for _, c := range cases {
+		srv := &Server{RefreshIntervals: c.intervals}
+		if d := srv.refreshInterval(c.name); d != c.d {
+			t.Fatalf("[%s] got %v, want %v", c.name, d, c.d)
+		}
+	}
+}
+
+// recorder records published events.
+type recorder struct {
+	mu     sync.Mutex
+	events []notify.Event
+}
+
+func (r *recorder) Publish(ctx context.Context, e notify.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func TestCheckStale(t *testing.T) {
+	a, err := OpenDatabase("testdata/id_doi.db")
+	if err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	b, err := OpenDatabase("testdata/doi_doi.db")
+	if err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	g := &FetchGroup{}
+	if err := g.FromFiles("testdata/id_metadata.db"); err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	rec := &recorder{}
+	srv := &Server{
+		IdentifierDatabase: a,
+		OciDatabase:        b,
+		IndexData:          g,
+		RefreshIntervals:   map[string]time.Duration{"oci": time.Nanosecond},
+		Notifier:           &notify.Notifier{Publishers: []notify.Publisher{rec}},
+	}
+	reported := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		if err := srv.checkStale(context.Background(), reported); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+	}
+	srv.Notifier.Wait()
+	if len(rec.events) != 1 {
+		t.Fatalf("got %d events, want 1, stale data is reported once", len(rec.events))
+	}
+	e := rec.events[0]
+	if e.Type != notify.EventStale || len(e.Datasets) != 1 || e.Datasets[0].Name != "oci" {
+		t.Fatalf("got %#v, want a stale event for oci", e)
+	}
+	srv.RefreshIntervals = map[string]time.Duration{}
+	if err := srv.checkStale(context.Background(), reported); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if len(reported) != 0 {
+		t.Fatalf("got %v, want no reported datasets", reported)
+	}
+}
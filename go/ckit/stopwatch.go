@@ -8,6 +8,8 @@ import (
 	"sync"
 	"text/tabwriter"
 	"time"
+
+	"github.com/segmentio/encoding/json"
 )
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyz"
@@ -46,6 +48,10 @@ type StopWatch struct {
 	id       string
 	entries  []*Entry
 	disabled bool
+	// threshold is the minimum total duration of a table to be logged.
+	threshold time.Duration
+	// json logs the timings as a single JSON line, cf. SetJSON.
+	json bool
 }
 
 // SetEnabled enables or disables the stopwatch. If disabled, any call will be
@@ -56,6 +62,30 @@ func (s *StopWatch) SetEnabled(enabled bool) {
 	s.disabled = !enabled
 }
 
+// SetThreshold sets a minimum total duration; LogTable will only log tables
+// of slower requests. Zero logs all tables.
+func (s *StopWatch) SetThreshold(d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.threshold = d
+}
+
+// SetJSON switches LogTable to log the timings as a single JSON line, like
+// BenchStat, instead of a table, so they can be aggregated in a log pipeline.
+func (s *StopWatch) SetJSON(enabled bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.json = enabled
+}
+
+// SetID sets the id, tables and timings are logged with, e.g. the request
+// id; by default, a random id is used.
+func (s *StopWatch) SetID(id string) {
+	s.Lock()
+	defer s.Unlock()
+	s.id = id
+}
+
 // Record records a message.
 func (s *StopWatch) Record(msg string) {
 	s.Recordf(msg)
@@ -98,14 +128,79 @@ func (s *StopWatch) Entries() []*Entry {
 	return s.entries
 }
 
-// LogTable write a table using standard library log facilities.
+// total returns the duration between the first and last entry.
+func (s *StopWatch) total() time.Duration {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.entries) == 0 {
+		return 0
+	}
+	return s.entries[len(s.entries)-1].T.Sub(s.entries[0].T)
+}
+
+// LogTable write a table using standard library log facilities, if the
+// total duration reaches the threshold.
 func (s *StopWatch) LogTable() {
-	if s.disabled {
+	if s.disabled || s.total() < s.threshold {
+		return
+	}
+	if s.json {
+		b, err := json.Marshal(s.BenchStat())
+		if err != nil {
+			log.Printf("stopwatch: %v", err)
+			return
+		}
+		log.Printf("%s", b)
 		return
 	}
 	log.Printf("timings for %s\n\n"+s.Table()+"\n", s.id)
 }
 
+// BenchStat are the timings of a single request by phase, as recorded by a
+// stopwatch.
+type BenchStat struct {
+	ID     string       `json:"id"`
+	Total  float64      `json:"total"` // seconds
+	Phases []BenchPhase `json:"phases"`
+}
+
+// BenchPhase is the time spent in a phase, up to a recorded message.
+type BenchPhase struct {
+	Message string  `json:"msg"`
+	Took    float64 `json:"took"` // seconds
+	Pct     float64 `json:"pct"`
+}
+
+// BenchStat returns the recorded timings; nil, if the stopwatch is disabled
+// or nothing has been recorded.
+func (s *StopWatch) BenchStat() *BenchStat {
+	s.Lock()
+	defer s.Unlock()
+	if s.disabled || len(s.entries) == 0 {
+		return nil
+	}
+	var (
+		total = s.entries[len(s.entries)-1].T.Sub(s.entries[0].T)
+		stat  = &BenchStat{ID: s.id, Total: total.Seconds()}
+	)
+	for i, entry := range s.entries {
+		var (
+			diff time.Duration
+			pct  float64
+		)
+		if i > 0 {
+			diff = s.entries[i].T.Sub(s.entries[i-1].T)
+			pct = float64(diff) / float64(total)
+		}
+		stat.Phases = append(stat.Phases, BenchPhase{
+			Message: entry.Message,
+			Took:    diff.Seconds(),
+			Pct:     pct,
+		})
+	}
+	return stat
+}
+
 // Table format the timings as table.
 func (s *StopWatch) Table() string {
 	s.Lock()
@@ -1,6 +1,15 @@
 package ckit
 
-import "testing"
+import (
+	"bytes"
+	"log"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+)
 
 func TestRandString(t *testing.T) {
 	// Only test for correct length.
@@ -42,3 +51,75 @@ func TestStopWatch(t *testing.T) {
 		t.Fatalf("got %v, want ", len(entries))
 	}
 }
+
+func TestStopWatchBenchStat(t *testing.T) {
+	var sw StopWatch
+	if stat := sw.BenchStat(); stat != nil {
+		t.Fatalf("got %v, want nil", stat)
+	}
+	sw.Record("started")
+	sw.Record("found doi")
+	sw.Record("fetched blobs")
+	stat := sw.BenchStat()
+	if stat == nil || len(stat.Phases) != 3 {
+		t.Fatalf("got %v, want 3 phases", stat)
+	}
+	if stat.Phases[0].Took != 0 || stat.Phases[2].Message != "fetched blobs" {
+		t.Fatalf("unexpected phases: %v", stat.Phases)
+	}
+	var sum float64
+	for _, p := range stat.Phases {
+		sum += p.Took
+	}
+	if math.Abs(sum-stat.Total) > 1e-9 {
+		t.Fatalf("got total %v, want %v", stat.Total, sum)
+	}
+	sw.SetEnabled(false)
+	if stat := sw.BenchStat(); stat != nil {
+		t.Fatalf("got %v, want nil for disabled stopwatch", stat)
+	}
+}
+
+func TestStopWatchThreshold(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	var sw StopWatch
+	sw.SetThreshold(time.Hour)
+	sw.Record("started")
+	sw.Record("done")
+	sw.LogTable()
+	if buf.Len() > 0 {
+		t.Fatalf("got %q, want no output below threshold", buf.String())
+	}
+	sw.SetThreshold(0)
+	sw.LogTable()
+	if !bytes.Contains(buf.Bytes(), []byte("done")) {
+		t.Fatalf("got %q, want table", buf.String())
+	}
+}
+
+func TestStopWatchJSON(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+	var sw StopWatch
+	sw.SetJSON(true)
+	sw.Record("started")
+	sw.Record("done")
+	sw.LogTable()
+	if n := bytes.Count(buf.Bytes(), []byte("\n")); n != 1 {
+		t.Fatalf("got %d lines, want 1", n)
+	}
+	var stat BenchStat
+	if err := json.Unmarshal(buf.Bytes(), &stat); err != nil {
+		t.Fatal(err)
+	}
+	if len(stat.Phases) != 2 || stat.Phases[1].Message != "done" {
+		t.Fatalf("got %+v, want two phases", stat)
+	}
+}
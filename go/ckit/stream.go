@@ -0,0 +1,215 @@
+package ckit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/segmentio/encoding/json"
+)
+
+// streamBufferSize is the size of the write buffer in front of the response
+// writer, when streaming.
+const streamBufferSize = 64 << 10
+
+// countingWriter keeps track of the number of bytes written.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// errWriter remembers the first error and turns subsequent writes into noops,
+// so we can check for errors only once, cf. https://go.dev/blog/errors-are-values.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	var n int
+	n, w.err = w.w.Write(p)
+	return n, w.err
+}
+
+func (w *errWriter) WriteString(s string) {
+	_, _ = io.WriteString(w, s)
+}
+
+// StreamError is returned by streamResponse; it records, whether parts of
+// the response have already been sent, in which case we cannot send a proper
+// error response any more.
+type StreamError struct {
+	Err  error
+	Sent int64
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("stream (sent %d bytes): %v", e.Sent, e.Err)
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// streamResponse writes a JSON response to w, fetching citing and cited blobs
+// (given as local identifiers) one by one and writing them out as array
+// elements directly, instead of collecting them in memory first. The output
+// is equivalent to the JSON encoding of a Response. If keep is true, the
+// output is compressed on the fly and returned, marked as cached, in the same
+// form we would put into the cache.
+func (s *Server) streamResponse(ctx context.Context, w io.Writer, response *Response,
+	citing, cited []string, started time.Time, keep bool) ([]byte, error) {
+	var (
+		cw   = &countingWriter{w: w}
+		bw   = bufio.NewWriterSize(cw, streamBufferSize)
+		out  = &errWriter{w: bw}
+		zw   *zstd.Encoder
+		zbuf bytes.Buffer
+		err  error
+	)
+	wrap := func(err error) error {
+		return &StreamError{Err: err, Sent: cw.n}
+	}
+	if keep {
+		if zw, err = zstd.NewWriter(&zbuf); err != nil {
+			return nil, wrap(fmt.Errorf("cache compress: %w", err))
+		}
+		defer func() {
+			if zw != nil {
+				zw.Close()
+			}
+		}()
+		out = &errWriter{w: io.MultiWriter(bw, zw)}
+	}
+	head, err := json.Marshal(struct {
+		ID  string `json:"id,omitempty"`
+		DOI string `json:"doi,omitempty"`
+	}{response.ID, response.DOI})
+	if err != nil {
+		return nil, wrap(err)
+	}
+	// We keep the object open, all other fields follow with a leading comma.
+	_, _ = out.Write(head[:len(head)-1])
+	sep := ","
+	if len(head) == 2 {
+		sep = ""
+	}
+	if response.Extra.CitingCount, err = s.streamBlobs(ctx, out, response, sep, "citing", citing); err != nil {
+		return nil, wrap(err)
+	}
+	if response.Extra.CitingCount > 0 {
+		sep = ","
+	}
+	if response.Extra.CitedCount, err = s.streamBlobs(ctx, out, response, sep, "cited", cited); err != nil {
+		return nil, wrap(err)
+	}
+	b, err := json.Marshal(response.Unmatched)
+	if err != nil {
+		return nil, wrap(err)
+	}
+	if response.omitUnmatched && out.err == nil {
+		// The kept value always contains the unmatched documents.
+		sent := &errWriter{w: bw}
+		sent.WriteString(`,"unmatched":{}`)
+		if out.err = sent.err; out.err == nil && zw != nil {
+			kept := &errWriter{w: zw}
+			kept.WriteString(`,"unmatched":`)
+			_, _ = kept.Write(b)
+			out.err = kept.err
+		}
+	} else {
+		out.WriteString(`,"unmatched":`)
+		_, _ = out.Write(b)
+	}
+	if out.err != nil {
+		return nil, wrap(out.err)
+	}
+	response.Extra.UnmatchedCitingCount = len(response.Unmatched.Citing)
+	response.Extra.UnmatchedCitedCount = len(response.Unmatched.Cited)
+	response.updateCitationAges(citing, cited)
+	response.Extra.Took = time.Since(started).Seconds()
+	if response.stopwatch != nil {
+		response.stopwatch.Recordf("streamed %d blobs", response.Extra.CitingCount+response.Extra.CitedCount)
+		response.Extra.Bench = response.stopwatch.BenchStat()
+		response.Extra.Timings = timingsFrom(ctx).Timings()
+	}
+	// The extra field differs between the response and the kept value.
+	if err := writeExtra(bw, response); err != nil {
+		return nil, wrap(err)
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, wrap(err)
+	}
+	if zw == nil {
+		return nil, nil
+	}
+	response.Extra.Cached = true
+	if err := writeExtra(zw, response); err != nil {
+		return nil, wrap(fmt.Errorf("cache json encode: %w", err))
+	}
+	err = zw.Close()
+	zw = nil
+	if err != nil {
+		return nil, wrap(fmt.Errorf("cache close: %w", err))
+	}
+	return zbuf.Bytes(), nil
+}
+
+// writeExtra writes the extra field and closes the response object.
+func writeExtra(w io.Writer, response *Response) error {
+	b, err := json.Marshal(response.Extra)
+	if err != nil {
+		return err
+	}
+	ew := &errWriter{w: w}
+	ew.WriteString(`,"extra":`)
+	_, _ = ew.Write(b)
+	ew.WriteString("}\n")
+	return ew.err
+}
+
+// streamBlobs fetches blobs for the given identifiers and writes them as a
+// JSON array under a given key; the key is omitted, if no blob was found. It
+// returns the number of blobs written.
+func (s *Server) streamBlobs(ctx context.Context, w *errWriter, response *Response, sep, key string, ids []string) (n int, err error) {
+	for _, id := range ids {
+		t := time.Now()
+		b, err := s.fetchBlob(ctx, response, id)
+		if errors.Is(err, ErrBlobNotFound) {
+			continue
+		}
+		if err != nil {
+			return n, fmt.Errorf("index data fetch: %w", err)
+		}
+		s.Stats.MeasureSinceWithLabels("index_data_fetch", t, nil)
+		progressFrom(ctx).blobFetched()
+		if n == 0 {
+			fmt.Fprintf(w, "%s%q:[", sep, key)
+		} else {
+			w.WriteString(",")
+		}
+		_, _ = w.Write(b)
+		if w.err != nil {
+			return n, w.err
+		}
+		n++
+	}
+	if n > 0 {
+		w.WriteString("]")
+	}
+	return n, w.err
+}
@@ -0,0 +1,117 @@
+package ckit
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/segmentio/encoding/json"
+	"github.com/thoas/stats"
+)
+
+// mapFetcher serves blobs from a map.
+type mapFetcher map[string]string
+
+func (f mapFetcher) Fetch(id string) ([]byte, error) {
+	if v, ok := f[id]; ok {
+		return []byte(v), nil
+	}
+	return nil, ErrBlobNotFound
+}
+
+func (f mapFetcher) Capabilities() Capabilities { return Capabilities{} }
+
+func TestStreamResponse(t *testing.T) {
+	var cases = []struct {
+		desc      string
+		citing    []string
+		cited     []string
+		unmatched []string
+	}{
+		{"empty", nil, nil, nil},
+		{"citing only", []string{"1", "2"}, nil, nil},
+		{"cited only", nil, []string{"3"}, nil},
+		{"both, with missing blob", []string{"1", "x"}, []string{"2", "3"}, nil},
+		{"unmatched", []string{"1"}, nil, []string{"10.1/a"}},
+	}
+	srv := &Server{
+		IndexData: mapFetcher{
+			"1": `{"id":"1"}`,
+			"2": `{"id":"2"}`,
+			"3": `{"id":"3"}`,
+		},
+		Stats: stats.New(),
+	}
+	for _, c := range cases {
+		var (
+			buf      bytes.Buffer
+			response = &Response{ID: "0", DOI: "10.1/0"}
+			expected = &Response{ID: "0", DOI: "10.1/0"}
+		)
+		for _, v := range c.unmatched {
+			b := json.RawMessage(`{"doi_str_mv":"` + v + `"}`)
+			response.Unmatched.Citing = append(response.Unmatched.Citing, b)
+			expected.Unmatched.Citing = append(expected.Unmatched.Citing, b)
+		}
+		for _, id := range c.citing {
+			if b, err := srv.IndexData.Fetch(id); err == nil {
+				expected.Citing = append(expected.Citing, b)
+			}
+		}
+		for _, id := range c.cited {
+			if b, err := srv.IndexData.Fetch(id); err == nil {
+				expected.Cited = append(expected.Cited, b)
+			}
+		}
+		_, err := srv.streamResponse(context.Background(), &buf, response, c.citing, c.cited, time.Now(), false)
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.desc, err)
+		}
+		var got Response
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("[%s] invalid JSON: %v: %s", c.desc, err, buf.String())
+		}
+		expected.updateCounts()
+		got.Extra.Took, expected.Extra.Took = 0, 0
+		if string(mustMarshal(got)) != string(mustMarshal(expected)) {
+			t.Fatalf("[%s] got %s, want %s", c.desc, mustMarshal(got), mustMarshal(expected))
+		}
+	}
+}
+
+func TestStreamResponseOmitUnmatched(t *testing.T) {
+	var (
+		srv = &Server{
+			IndexData: mapFetcher{"1": `{"id":"1"}`},
+			Stats:     stats.New(),
+		}
+		response = &Response{ID: "0", DOI: "10.1/0", omitUnmatched: true}
+		buf      bytes.Buffer
+	)
+	response.Unmatched.Citing = []json.RawMessage{json.RawMessage(`{"doi_str_mv":"10.1/a"}`)}
+	kept, err := srv.streamResponse(context.Background(), &buf, response, []string{"1"}, nil, time.Now(), true)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	var sent Response
+	if err := json.Unmarshal(buf.Bytes(), &sent); err != nil {
+		t.Fatalf("invalid JSON: %v: %s", err, buf.String())
+	}
+	if len(sent.Unmatched.Citing) != 0 || sent.Extra.UnmatchedCitingCount != 1 {
+		t.Fatalf("got %d unmatched, count %d, want 0, 1", len(sent.Unmatched.Citing), sent.Extra.UnmatchedCitingCount)
+	}
+	zr, err := zstd.NewReader(bytes.NewReader(kept))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var cached Response
+	if err := json.NewDecoder(zr).Decode(&cached); err != nil {
+		t.Fatalf("invalid kept value: %v", err)
+	}
+	if len(cached.Unmatched.Citing) != 1 || len(cached.Citing) != 1 {
+		t.Fatalf("got %d unmatched, %d citing in kept value, want 1, 1", len(cached.Unmatched.Citing), len(cached.Citing))
+	}
+}
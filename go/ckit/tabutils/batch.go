@@ -0,0 +1,89 @@
+package tabutils
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Batcher collects lines into batches of a fixed size and passes full
+// batches to an import function, running in the background. Only two
+// buffers are used: one being filled, one being imported; writes block while
+// the import is behind, so memory stays bounded, regardless of input size.
+type Batcher struct {
+	size int
+	cur  *bytes.Buffer
+	free chan *bytes.Buffer
+	full chan *bytes.Buffer
+	done chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewBatcher starts a batcher, importing batches of about size bytes with f.
+// The batcher must be closed to import the last batch.
+func NewBatcher(size int, f func(*bytes.Buffer) error) *Batcher {
+	b := &Batcher{
+		size: size,
+		free: make(chan *bytes.Buffer, 2),
+		full: make(chan *bytes.Buffer),
+		done: make(chan struct{}),
+	}
+	for i := 0; i < 2; i++ {
+		b.free <- new(bytes.Buffer)
+	}
+	go func() {
+		defer close(b.done)
+		for buf := range b.full {
+			if b.Err() == nil {
+				if err := f(buf); err != nil {
+					b.mu.Lock()
+					b.err = err
+					b.mu.Unlock()
+				}
+			}
+			// A few very long lines should not keep a large buffer around.
+			if buf.Cap() > 2*b.size {
+				buf = new(bytes.Buffer)
+			}
+			buf.Reset()
+			b.free <- buf
+		}
+	}()
+	return b
+}
+
+// Err returns the first import error, if any.
+func (b *Batcher) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// Write adds a line, or any other unit that must not be split across
+// batches, and hands the batch over for import, once it is full.
+func (b *Batcher) Write(p []byte) (int, error) {
+	if err := b.Err(); err != nil {
+		return 0, err
+	}
+	if b.cur == nil {
+		b.cur = <-b.free
+	}
+	n, _ := b.cur.Write(p)
+	if b.cur.Len() >= b.size {
+		b.full <- b.cur
+		b.cur = nil
+	}
+	return n, nil
+}
+
+// Close imports the last batch and waits for all imports to finish.
+func (b *Batcher) Close() error {
+	if b.cur != nil && b.cur.Len() > 0 {
+		b.full <- b.cur
+		b.cur = nil
+	}
+	close(b.full)
+	<-b.done
+	return b.Err()
+}
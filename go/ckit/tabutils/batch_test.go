@@ -0,0 +1,61 @@
+package tabutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBatcher(t *testing.T) {
+	var (
+		got     bytes.Buffer
+		batches int
+		maxLen  int
+	)
+	b := NewBatcher(10, func(buf *bytes.Buffer) error {
+		batches++
+		if buf.Len() > maxLen {
+			maxLen = buf.Len()
+		}
+		_, err := got.ReadFrom(buf)
+		return err
+	})
+	var want strings.Builder
+	for i := 0; i < 100; i++ {
+		line := fmt.Sprintf("%d\t%d\n", i, i*i)
+		want.WriteString(line)
+		if _, err := b.Write([]byte(line)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if got.String() != want.String() {
+		t.Fatalf("got %q, want %q", got.String(), want.String())
+	}
+	if batches < 2 {
+		t.Fatalf("expected several batches, got %d", batches)
+	}
+	// A batch is handed over as soon as it reaches the size, so it exceeds
+	// it by less than a line.
+	if maxLen >= 10+len("99\t9801\n") {
+		t.Fatalf("batch too large: %d", maxLen)
+	}
+}
+
+func TestBatcherError(t *testing.T) {
+	failed := errors.New("failed")
+	b := NewBatcher(4, func(buf *bytes.Buffer) error {
+		return failed
+	})
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		_, err = b.Write([]byte("a\tb\n"))
+	}
+	if cerr := b.Close(); cerr != failed {
+		t.Fatalf("got %v, want %v", cerr, failed)
+	}
+}
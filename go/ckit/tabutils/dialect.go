@@ -0,0 +1,183 @@
+package tabutils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Dialect describes tabular input, that is turned into the two column TSV
+// sqlite3 imports, e.g. CSV with a header row.
+type Dialect struct {
+	// Comma separates fields, e.g. '\t' or ','.
+	Comma rune
+	// Quoted fields may be enclosed in double quotes, as in CSV.
+	Quoted bool
+	// Header is set, if the first row contains column names.
+	Header bool
+	// Key and Value select columns by name (requires Header) or by 1-based
+	// number; they default to the first and second column. Value may list
+	// more than one column, separated by commas, e.g. "id,year,type"; the
+	// value is then a JSON object with the column names (or numbers) as keys.
+	Key, Value string
+}
+
+// ParseComma parses a field separator: a single character or "tab".
+func ParseComma(s string) (rune, error) {
+	switch s {
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError || size != len(s) || r == '\n' || r == '"' {
+		return 0, fmt.Errorf("invalid separator: %q", s)
+	}
+	return r, nil
+}
+
+// IsDefault returns true, if input is two column TSV already, and can be
+// imported as is.
+func (d Dialect) IsDefault() bool {
+	return (d.Comma == 0 || d.Comma == '\t') && !d.Quoted && !d.Header &&
+		(d.Key == "" || d.Key == "1") && (d.Value == "" || d.Value == "2")
+}
+
+// columnIndex resolves a column name or 1-based number to an index.
+func columnIndex(v string, header []string, fallback int) (int, error) {
+	if v == "" {
+		return fallback, nil
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		if n < 1 {
+			return 0, fmt.Errorf("invalid column number: %d", n)
+		}
+		return n - 1, nil
+	}
+	for i, name := range header {
+		if name == v {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("column not found: %s", v)
+}
+
+// ToTSV reads rows in the given dialect and writes the selected key and
+// value columns as TSV. Tabs and newlines inside fields are replaced by
+// spaces; rows without the selected columns are an error.
+func (d Dialect) ToTSV(w io.Writer, r io.Reader) error {
+	comma := d.Comma
+	if comma == 0 {
+		comma = '\t'
+	}
+	var next func() ([]string, error)
+	if d.Quoted {
+		cr := csv.NewReader(r)
+		cr.Comma = comma
+		cr.FieldsPerRecord = -1
+		cr.LazyQuotes = true
+		cr.ReuseRecord = true
+		next = cr.Read
+	} else {
+		br := bufio.NewReader(r)
+		sep := string(comma)
+		next = func() ([]string, error) {
+			line, err := br.ReadString('\n')
+			if err == io.EOF && line == "" {
+				return nil, io.EOF
+			}
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			return strings.Split(strings.TrimRight(line, "\r\n"), sep), nil
+		}
+	}
+	var header []string
+	if d.Header {
+		record, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		header = append(header, record...)
+		if len(header) > 0 {
+			header[0] = strings.TrimPrefix(header[0], "\ufeff")
+		}
+	}
+	ki, err := columnIndex(d.Key, header, 0)
+	if err != nil {
+		return err
+	}
+	var (
+		names = strings.Split(d.Value, ",")
+		vis   = make([]int, len(names))
+		maxi  = ki
+	)
+	for i, name := range names {
+		if vis[i], err = columnIndex(name, header, 1); err != nil {
+			return err
+		}
+		if vis[i] > maxi {
+			maxi = vis[i]
+		}
+	}
+	var (
+		bw      = bufio.NewWriter(w)
+		cleaner = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+		buf     bytes.Buffer
+	)
+	for i := 1; ; i++ {
+		record, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) == 1 && record[0] == "" {
+			continue
+		}
+		if maxi >= len(record) {
+			return fmt.Errorf("row %d: got %d columns, want at least %d", i, len(record), maxi+1)
+		}
+		bw.WriteString(cleaner.Replace(record[ki]))
+		bw.WriteByte('\t')
+		if len(vis) == 1 {
+			bw.WriteString(cleaner.Replace(record[vis[0]]))
+		} else {
+			// JSON escapes tabs and newlines, no need to replace them.
+			buf.Reset()
+			writeObject(&buf, names, record, vis)
+			bw.Write(buf.Bytes())
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// writeObject writes selected fields of a record as JSON object, keeping the
+// order of the columns.
+func writeObject(w *bytes.Buffer, names, record []string, indices []int) {
+	w.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		// Marshaling a string cannot fail.
+		k, _ := json.Marshal(name)
+		v, _ := json.Marshal(record[indices[i]])
+		w.Write(k)
+		w.WriteByte(':')
+		w.Write(v)
+	}
+	w.WriteByte('}')
+}
@@ -0,0 +1,93 @@
+package tabutils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDialectToTSV(t *testing.T) {
+	var cases = []struct {
+		about   string
+		dialect Dialect
+		input   string
+		want    string
+		err     bool
+	}{
+		{
+			about:   "semicolon",
+			dialect: Dialect{Comma: ';'},
+			input:   "a;1\nb;2;x\n",
+			want:    "a\t1\nb\t2\n",
+		},
+		{
+			about:   "csv with header, columns by name",
+			dialect: Dialect{Comma: ',', Quoted: true, Header: true, Key: "doi", Value: "id"},
+			input:   "\ufeffid,title,doi\n1,\"A, \"\"B\"\"\",10.1/a\n2,\"multi\nline\",10.1/b\n",
+			want:    "10.1/a\t1\n10.1/b\t2\n",
+		},
+		{
+			about:   "columns by number",
+			dialect: Dialect{Comma: ',', Key: "3", Value: "1"},
+			input:   "1,x,10.1/a\r\n\n2,y,10.1/b",
+			want:    "10.1/a\t1\n10.1/b\t2\n",
+		},
+		{
+			about:   "tabs in quoted fields",
+			dialect: Dialect{Comma: ',', Quoted: true},
+			input:   "\"a\tb\",c\n",
+			want:    "a b\tc\n",
+		},
+		{
+			about:   "multiple value columns",
+			dialect: Dialect{Comma: ',', Quoted: true, Header: true, Key: "doi", Value: "id,year,title"},
+			input:   "id,year,title,doi\n1,2001,\"A\tB \"\"C\"\"\",10.1/a\n",
+			want:    "10.1/a\t{\"id\":\"1\",\"year\":\"2001\",\"title\":\"A\\tB \\\"C\\\"\"}\n",
+		},
+		{
+			about:   "multiple value columns by number",
+			dialect: Dialect{Comma: ';', Value: "3,2"},
+			input:   "a;x;y\n",
+			want:    "a\t{\"3\":\"y\",\"2\":\"x\"}\n",
+		},
+		{
+			about:   "unknown column",
+			dialect: Dialect{Comma: ',', Header: true, Key: "doi"},
+			input:   "id,title\n1,x\n",
+			err:     true,
+		},
+		{
+			about:   "short row",
+			dialect: Dialect{Comma: ','},
+			input:   "a,1\nb\n",
+			err:     true,
+		},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		err := c.dialect.ToTSV(&buf, strings.NewReader(c.input))
+		if (err != nil) != c.err {
+			t.Fatalf("[%s] got %v, want error %v", c.about, err, c.err)
+		}
+		if err == nil && buf.String() != c.want {
+			t.Fatalf("[%s] got %q, want %q", c.about, buf.String(), c.want)
+		}
+	}
+}
+
+func TestParseComma(t *testing.T) {
+	for s, want := range map[string]rune{"tab": '\t', `\t`: '\t', ",": ',', ";": ';', "|": '|'} {
+		got, err := ParseComma(s)
+		if err != nil || got != want {
+			t.Fatalf("%q: got %q, %v, want %q", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", ",,", "\n", `"`} {
+		if _, err := ParseComma(s); err == nil {
+			t.Fatalf("%q: got nil, want error", s)
+		}
+	}
+	if !(Dialect{}).IsDefault() || (Dialect{Header: true}).IsDefault() {
+		t.Fatalf("IsDefault: got unexpected result")
+	}
+}
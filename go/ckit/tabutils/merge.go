@@ -0,0 +1,20 @@
+package tabutils
+
+import "fmt"
+
+// CopyMapScript returns a script copying all rows of the map table of
+// another database into the map table of the current one; with distinct,
+// duplicate rows are copied only once.
+func CopyMapScript(pragma, src string, distinct bool) string {
+	var modifier string
+	if distinct {
+		modifier = "DISTINCT "
+	}
+	return fmt.Sprintf(`
+%s
+PRAGMA temp_store = FILE;
+ATTACH DATABASE %s AS src;
+INSERT INTO map (k, v) SELECT %sk, v FROM src.map;
+DETACH DATABASE src;
+`, pragma, quoteSQL(src), modifier)
+}
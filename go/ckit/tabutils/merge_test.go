@@ -0,0 +1,17 @@
+package tabutils
+
+import "testing"
+
+func TestCopyMapScript(t *testing.T) {
+	got := CopyMapScript("", "slice's.db", true)
+	want := `
+
+PRAGMA temp_store = FILE;
+ATTACH DATABASE 'slice''s.db' AS src;
+INSERT INTO map (k, v) SELECT DISTINCT k, v FROM src.map;
+DETACH DATABASE src;
+`
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
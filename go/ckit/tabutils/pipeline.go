@@ -0,0 +1,154 @@
+package tabutils
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Pipeline transforms lines concurrently in three stages: a reader cutting
+// the input into batches of lines, a number of workers applying a function
+// to each line and a writer. At most two batches per worker are in flight,
+// so memory is bounded. With Ordered, output follows input order, otherwise
+// batches are written as soon as they are done.
+type Pipeline struct {
+	Workers   int  // number of workers, defaults to the number of CPUs
+	BatchSize int  // lines per batch, defaults to 10000
+	Ordered   bool // keep input order
+}
+
+// pipelineBatch is a unit of work, numbered in input order.
+type pipelineBatch struct {
+	seq   int
+	lines [][]byte
+	out   bytes.Buffer
+	err   error
+}
+
+// Run reads lines from r, applies f to each line, including its newline, and
+// writes the results to w. It stops at the first error.
+func (p *Pipeline) Run(r io.Reader, w io.Writer, f func([]byte) ([]byte, error)) error {
+	var (
+		workers   = p.Workers
+		batchSize = p.BatchSize
+	)
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	if batchSize < 1 {
+		batchSize = 10000
+	}
+	var (
+		queue   = make(chan *pipelineBatch)
+		results = make(chan *pipelineBatch)
+		tokens  = make(chan struct{}, 2*workers) // bounds batches in flight
+		done    = make(chan struct{})            // closed on error, stops the reader
+		wg      sync.WaitGroup
+		readErr error
+	)
+	// Reader.
+	go func() {
+		defer close(queue)
+		var (
+			br  = bufio.NewReader(r)
+			b   = &pipelineBatch{}
+			seq int
+		)
+		send := func() bool {
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
+				return false
+			}
+			b.seq = seq
+			seq++
+			queue <- b
+			b = &pipelineBatch{}
+			return true
+		}
+		for {
+			line, err := br.ReadBytes('\n')
+			if len(line) > 0 {
+				if line[len(line)-1] != '\n' {
+					line = append(line, '\n')
+				}
+				b.lines = append(b.lines, line)
+				if len(b.lines) >= batchSize && !send() {
+					return
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				readErr = err
+				return
+			}
+		}
+		if len(b.lines) > 0 {
+			send()
+		}
+	}()
+	// Workers.
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range queue {
+				for _, line := range b.lines {
+					v, err := f(line)
+					if err != nil {
+						b.err = err
+						break
+					}
+					b.out.Write(v)
+				}
+				b.lines = nil
+				results <- b
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	// Writer; after an error, results are still drained, so no stage blocks.
+	var (
+		err     error
+		next    int
+		pending = make(map[int]*pipelineBatch)
+	)
+	write := func(b *pipelineBatch) {
+		if err == nil {
+			if err = b.err; err == nil {
+				_, err = w.Write(b.out.Bytes())
+			}
+			if err != nil {
+				close(done)
+			}
+		}
+		<-tokens
+	}
+	for b := range results {
+		if !p.Ordered {
+			write(b)
+			continue
+		}
+		pending[b.seq] = b
+		for {
+			b, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			write(b)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return readErr
+}
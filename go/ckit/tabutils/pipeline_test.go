@@ -0,0 +1,67 @@
+package tabutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	var input, want strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "%d\n", i)
+		fmt.Fprintf(&want, "x%d\n", i)
+	}
+	prefix := func(p []byte) ([]byte, error) {
+		return append([]byte("x"), p...), nil
+	}
+	var buf bytes.Buffer
+	p := Pipeline{Workers: 4, BatchSize: 7, Ordered: true}
+	if err := p.Run(strings.NewReader(input.String()), &buf, prefix); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if buf.String() != want.String() {
+		t.Fatalf("ordered output differs")
+	}
+	buf.Reset()
+	p.Ordered = false
+	if err := p.Run(strings.NewReader(input.String()), &buf, prefix); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	got := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	exp := strings.Split(strings.TrimSpace(want.String()), "\n")
+	sort.Strings(got)
+	sort.Strings(exp)
+	if strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("unordered output differs")
+	}
+}
+
+func TestPipelineLastLine(t *testing.T) {
+	var buf bytes.Buffer
+	p := Pipeline{Workers: 2, Ordered: true}
+	err := p.Run(strings.NewReader("a\nb"), &buf, func(p []byte) ([]byte, error) {
+		return p, nil
+	})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if buf.String() != "a\nb\n" {
+		t.Fatalf("got %q", buf.String())
+	}
+}
+
+func TestPipelineError(t *testing.T) {
+	failed := errors.New("failed")
+	input := strings.Repeat("a\n", 10000)
+	p := Pipeline{Workers: 3, BatchSize: 10, Ordered: true}
+	err := p.Run(strings.NewReader(input), &bytes.Buffer{}, func(p []byte) ([]byte, error) {
+		return nil, failed
+	})
+	if err != failed {
+		t.Fatalf("got %v, want %v", err, failed)
+	}
+}
@@ -0,0 +1,113 @@
+package tabutils
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// tableNamePattern restricts Postgres table names, which are not quoted.
+var tableNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// IsPostgres returns true, if an output names a Postgres database, e.g.
+// postgres://user@host/db, instead of a sqlite3 file.
+func IsPostgres(output string) bool {
+	return strings.HasPrefix(output, "postgres://") || strings.HasPrefix(output, "postgresql://")
+}
+
+// ValidTableName returns an error, if a name cannot be used as Postgres
+// table name as is.
+func ValidTableName(name string) error {
+	if !tableNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid table name: %q", name)
+	}
+	return nil
+}
+
+// PostgresType maps a sqlite3 column type to a Postgres type.
+func PostgresType(t string) string {
+	switch t {
+	case "INTEGER":
+		return "BIGINT"
+	case "READ", "REAL":
+		return "DOUBLE PRECISION"
+	case "BLOB":
+		return "BYTEA"
+	default:
+		return "TEXT"
+	}
+}
+
+// redact removes a password from a database URL, for logging.
+func redact(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "postgres://..."
+	}
+	return u.Redacted()
+}
+
+// RunPsql runs a script on a Postgres database with psql.
+func RunPsql(dsn, script, message string) error {
+	cmd := exec.Command("psql", "-X", "-q", "-v", "ON_ERROR_STOP=1", dsn)
+	cmd.Stdin = strings.NewReader(script)
+	b, err := cmd.CombinedOutput()
+	if err == nil {
+		log.Printf("[ok] %s · %s", message, redact(dsn))
+	} else {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", string(b))
+	}
+	return err
+}
+
+// RunCopy reads two column TSV from a reader into a Postgres table, with
+// psql \copy. The data is read as CSV with a quote character, that does not
+// occur in TSV, so backslashes and quotes are kept as is, e.g. in JSON.
+func RunCopy(r io.Reader, dsn, table string) (int64, error) {
+	copyCmd := fmt.Sprintf(`\copy %s (k, v) FROM STDIN WITH (FORMAT csv, DELIMITER E'\t', QUOTE E'\x01')`, table)
+	return runWithInput(exec.Command("psql", "-X", "-q", "-v", "ON_ERROR_STOP=1", "-c", copyCmd, dsn), r)
+}
+
+// PostgresTableScript returns a script, which creates a two column table.
+func PostgresTableScript(table, valueType string) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (k TEXT, v %s);\n", table, PostgresType(valueType))
+}
+
+// PostgresIndexScript returns a script, which creates an index on the key
+// ("k") or value ("v") column; a case insensitive index is on lower(column).
+func PostgresIndexScript(table, column string, nocase bool) string {
+	if nocase {
+		return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_idx_%s_nocase ON %s (lower(%s));\n",
+			table, column, table, column)
+	}
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_idx_%s ON %s (%s);\n", table, column, table, column)
+}
+
+// PostgresMetaScript is like MetaScript for a Postgres table; the meta table
+// of a table other than "map" is called {table}_meta.
+func PostgresMetaScript(table string, kv map[string]string) string {
+	meta := "meta"
+	if table != "map" {
+		meta = table + "_meta"
+	}
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "CREATE TABLE IF NOT EXISTS %s (k TEXT PRIMARY KEY, v TEXT);\n", meta)
+	upsert := "INSERT INTO %s (k, v) VALUES (%s, %s) ON CONFLICT (k) DO UPDATE SET v = EXCLUDED.v;\n"
+	for _, k := range keys {
+		fmt.Fprintf(&sb, upsert, meta, quoteSQL(k), quoteSQL(kv[k]))
+	}
+	fmt.Fprintf(&sb, "INSERT INTO %s (k, v) SELECT 'rows', count(*)::text FROM %s"+
+		" ON CONFLICT (k) DO UPDATE SET v = EXCLUDED.v;\n", meta, table)
+	return sb.String()
+}
@@ -0,0 +1,28 @@
+package tabutils
+
+import "testing"
+
+func TestPostgresMetaScript(t *testing.T) {
+	script := PostgresMetaScript("oci", map[string]string{"source": "oci-2022-01'x"})
+	want := `CREATE TABLE IF NOT EXISTS oci_meta (k TEXT PRIMARY KEY, v TEXT);
+INSERT INTO oci_meta (k, v) VALUES ('source', 'oci-2022-01''x') ON CONFLICT (k) DO UPDATE SET v = EXCLUDED.v;
+INSERT INTO oci_meta (k, v) SELECT 'rows', count(*)::text FROM oci ON CONFLICT (k) DO UPDATE SET v = EXCLUDED.v;
+`
+	if script != want {
+		t.Fatalf("got %q, want %q", script, want)
+	}
+	if got := PostgresIndexScript("map", "v", true); got != "CREATE INDEX IF NOT EXISTS map_idx_v_nocase ON map (lower(v));\n" {
+		t.Fatalf("got %q", got)
+	}
+	for name, valid := range map[string]bool{"map": true, "oci_2022": true, "Map": false, "a;b": false, "": false} {
+		if err := ValidTableName(name); (err == nil) != valid {
+			t.Fatalf("%q: got %v, want valid %v", name, err, valid)
+		}
+	}
+	if !IsPostgres("postgres://u@h/db") || IsPostgres("data.db") {
+		t.Fatalf("IsPostgres: got unexpected result")
+	}
+	if got := redact("postgres://u:secret@h/db"); got != "postgres://u:xxxxx@h/db" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,164 @@
+package tabutils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ShardHash names the hash function used to assign keys to shards, recorded
+// in the manifest, so readers can check they route keys the same way.
+const ShardHash = "fnv1a32-lower"
+
+// ShardManifest describes a database split into a number of shards by key.
+// A single database, which is not split, has one shard.
+type ShardManifest struct {
+	Hash   string   `json:"hash"`
+	Column string   `json:"column"`
+	Shards int      `json:"shards"`
+	Files  []string `json:"files"`
+	// Details about the files, if known, e.g. not for shards built
+	// elsewhere, cf. makta -shard.
+	Details []ShardFile `json:"details,omitempty"`
+}
+
+// ShardFile describes a single file of a manifest, so readers can check,
+// they got the complete file.
+type ShardFile struct {
+	File   string `json:"file"`
+	Rows   int64  `json:"rows"`
+	MinKey string `json:"min_key"`
+	MaxKey string `json:"max_key"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ShardFor returns the shard, in [0, n), for a key. Keys are lowercased
+// before hashing, so case insensitive lookups, e.g. for DOI, find the same
+// shard.
+func ShardFor(key []byte, n int) int {
+	if n < 2 {
+		return 0
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(bytes.ToLower(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// KeyOf returns the first column of a TSV line.
+func KeyOf(line []byte) []byte {
+	if i := bytes.IndexByte(line, '\t'); i >= 0 {
+		return line[:i]
+	}
+	return bytes.TrimRight(line, "\r\n")
+}
+
+// ShardName returns the filename of shard i of n for an output filename,
+// e.g. oci-03.db for oci.db, shard 3 of 16.
+func ShardName(output string, i, n int) string {
+	var (
+		ext   = filepath.Ext(output)
+		base  = strings.TrimSuffix(output, ext)
+		width = len(strconv.Itoa(n - 1))
+	)
+	return fmt.Sprintf("%s-%0*d%s", base, width, i, ext)
+}
+
+// ShardManifestName returns the manifest filename for an output filename,
+// e.g. oci.shards.json for oci.db.
+func ShardManifestName(output string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + ".shards.json"
+}
+
+// IsShardManifest returns true, if a filename looks like a shard manifest,
+// cf. ShardManifestName.
+func IsShardManifest(filename string) bool {
+	return strings.HasSuffix(filename, ".shards.json")
+}
+
+// NewShardManifest returns the manifest for an output split into n shards.
+// Files are relative to the manifest.
+func NewShardManifest(output string, n int) *ShardManifest {
+	m := &ShardManifest{Hash: ShardHash, Column: "k", Shards: n}
+	if n < 2 {
+		m.Shards, m.Files = 1, []string{filepath.Base(output)}
+		return m
+	}
+	for i := 0; i < n; i++ {
+		m.Files = append(m.Files, filepath.Base(ShardName(output, i, n)))
+	}
+	return m
+}
+
+// WriteShardManifest writes a manifest for an output next to it; it returns
+// the manifest filename.
+func WriteShardManifest(output string, m *ShardManifest) (string, error) {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	filename := ShardManifestName(output)
+	return filename, ioutil.WriteFile(filename, append(b, '\n'), 0644)
+}
+
+// ReadShardManifest reads a manifest and checks, that it is complete and
+// uses the same hash function as ShardFor. Files are turned into paths
+// relative to the working directory. If the manifest has details about a
+// file, its size must match; checksums are not verified, since that would
+// read whole files, cf. FileSHA256.
+func ReadShardManifest(filename string) (*ShardManifest, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var m ShardManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("shard manifest: %w", err)
+	}
+	switch {
+	case m.Hash != ShardHash:
+		return nil, fmt.Errorf("shard manifest: unsupported hash %q", m.Hash)
+	case m.Shards < 1 || m.Shards != len(m.Files):
+		return nil, fmt.Errorf("shard manifest: got %d files for %d shards", len(m.Files), m.Shards)
+	}
+	dir := filepath.Dir(filename)
+	for i, f := range m.Files {
+		if !filepath.IsAbs(f) {
+			m.Files[i] = filepath.Join(dir, f)
+		}
+	}
+	for _, d := range m.Details {
+		fi, err := os.Stat(filepath.Join(dir, d.File))
+		if err != nil {
+			return nil, fmt.Errorf("shard manifest: %w", err)
+		}
+		if fi.Size() != d.Size {
+			return nil, fmt.Errorf("shard manifest: %s: got %d bytes, want %d", d.File, fi.Size(), d.Size)
+		}
+	}
+	return &m, nil
+}
+
+// FileSHA256 returns the hex encoded SHA-256 checksum of a file, as
+// recorded in a manifest; compare with sha256sum.
+func FileSHA256(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,131 @@
+package tabutils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShardFor(t *testing.T) {
+	for _, k := range []string{"10.1/a", "10.1/b", "10.1/c", "10.2/d", "10.3/e", "10.4/f", "10.5/g", "10.6/h"} {
+		i := ShardFor([]byte(k), 4)
+		if i < 0 || i >= 4 {
+			t.Fatalf("shard out of range: %d", i)
+		}
+	}
+	if ShardFor([]byte("10.1/ABC"), 7) != ShardFor([]byte("10.1/abc"), 7) {
+		t.Fatalf("expected case insensitive shard assignment")
+	}
+	if got := ShardFor([]byte("x"), 1); got != 0 {
+		t.Fatalf("got %d, want 0", got)
+	}
+}
+
+func TestKeyOf(t *testing.T) {
+	var cases = []struct {
+		line string
+		want string
+	}{
+		{"a\tb\n", "a"},
+		{"a\n", "a"},
+		{"\tb\n", ""},
+		{"a", "a"},
+	}
+	for _, c := range cases {
+		if got := string(KeyOf([]byte(c.line))); got != c.want {
+			t.Fatalf("got %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestShardName(t *testing.T) {
+	var cases = []struct {
+		output string
+		i, n   int
+		want   string
+	}{
+		{"oci.db", 3, 16, "oci-03.db"},
+		{"oci.db", 0, 4, "oci-0.db"},
+		{"/tmp/data", 12, 100, "/tmp/data-12"},
+	}
+	for _, c := range cases {
+		if got := ShardName(c.output, c.i, c.n); got != c.want {
+			t.Fatalf("got %v, want %v", got, c.want)
+		}
+	}
+	if got := ShardManifestName("/tmp/oci.db"); got != "/tmp/oci.shards.json" {
+		t.Fatalf("got %v", got)
+	}
+	m := NewShardManifest("/tmp/oci.db", 3)
+	want := &ShardManifest{
+		Hash:   ShardHash,
+		Column: "k",
+		Shards: 3,
+		Files:  []string{"oci-0.db", "oci-1.db", "oci-2.db"},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("got %v, want %v", m, want)
+	}
+	if m := NewShardManifest("/tmp/oci.db", 1); m.Shards != 1 || !reflect.DeepEqual(m.Files, []string{"oci.db"}) {
+		t.Fatalf("got %v, want a single oci.db shard", m)
+	}
+}
+
+func TestReadShardManifest(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "oci.db")
+	for _, name := range []string{"oci-0.db", "oci-1.db"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum, err := FileSHA256(filepath.Join(dir, "oci-0.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sha256 of "data"
+	if want := "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7"; sum != want {
+		t.Fatalf("got %v, want %v", sum, want)
+	}
+	m := NewShardManifest(output, 2)
+	m.Details = []ShardFile{{File: "oci-0.db", Rows: 1, MinKey: "a", MaxKey: "a", Size: 4, SHA256: sum}}
+	filename, err := WriteShardManifest(output, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsShardManifest(filename) {
+		t.Fatalf("got %v, want a shard manifest name", filename)
+	}
+	got, err := ReadShardManifest(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "oci-0.db"), filepath.Join(dir, "oci-1.db")}; !reflect.DeepEqual(got.Files, want) {
+		t.Fatalf("got %v, want %v", got.Files, want)
+	}
+	// A truncated file does not match the recorded size.
+	if err := ioutil.WriteFile(filepath.Join(dir, "oci-0.db"), []byte("dat"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadShardManifest(filename); err == nil || !strings.Contains(err.Error(), "got 3 bytes, want 4") {
+		t.Fatalf("got %v, want size mismatch", err)
+	}
+	var cases = []struct {
+		content string
+		err     string
+	}{
+		{`{"hash": "md5", "shards": 1, "files": ["a.db"]}`, "unsupported hash"},
+		{`{"hash": "` + ShardHash + `", "shards": 2, "files": ["a.db"]}`, "got 1 files for 2 shards"},
+		{`xxx`, "shard manifest"},
+	}
+	for _, c := range cases {
+		if err := ioutil.WriteFile(filename, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadShardManifest(filename); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s: got %v, want %v", c.content, err, c.err)
+		}
+	}
+}
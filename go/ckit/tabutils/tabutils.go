@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -35,7 +36,12 @@ func RunScript(path, script, message string) error {
 // given database. Before importing, read commands from a given init file.
 func RunImport(r io.Reader, initFile, outputFile string) (int64, error) {
 	// TODO: Unify parameter order, e.g. put outputFile first.
-	cmd := exec.Command("sqlite3", "--init", initFile, outputFile)
+	return runWithInput(exec.Command("sqlite3", "--init", initFile, outputFile), r)
+}
+
+// runWithInput runs a command, copying its input from a reader; it returns
+// the number of bytes copied.
+func runWithInput(cmd *exec.Cmd, r io.Reader) (int64, error) {
 	cmdStdin, err := cmd.StdinPipe()
 	if err != nil {
 		return 0, fmt.Errorf("stdin pipe: %w", err)
@@ -140,3 +146,84 @@ func ByteSize(bytes int) string {
 	result = strings.TrimSuffix(result, ".0")
 	return result + u
 }
+
+// MetaScript returns a script, which stores key value pairs in a meta table,
+// replacing existing values. The number of rows of the map table is stored
+// as "rows"; the maximum rowid is used instead of a count, which would need a
+// full scan. Rows are never deleted, so both are the same.
+func MetaScript(kv map[string]string) string {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString("CREATE TABLE IF NOT EXISTS meta (k TEXT PRIMARY KEY, v TEXT);\n")
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "INSERT OR REPLACE INTO meta (k, v) VALUES (%s, %s);\n",
+			quoteSQL(k), quoteSQL(kv[k]))
+	}
+	sb.WriteString("INSERT OR REPLACE INTO meta (k, v) SELECT 'rows', coalesce(max(rowid), 0) FROM map;\n")
+	return sb.String()
+}
+
+// quoteSQL returns a string as SQL string literal.
+func quoteSQL(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// Pragma returns settings for fast bulk imports into sqlite3, with a given
+// cache size in pages.
+func Pragma(cacheSize int) string {
+	return fmt.Sprintf(`
+PRAGMA journal_mode = OFF;
+PRAGMA synchronous = 0;
+PRAGMA cache_size = %d;
+PRAGMA locking_mode = EXCLUSIVE;`, cacheSize)
+}
+
+// IndexScripts returns scripts creating indices on the map table, for index
+// modes 0=none, 1=k, 2=v, 3=kv; nocaseMode adds case insensitive indices,
+// in the same way.
+func IndexScripts(pragma string, mode, nocaseMode int) (scripts []string) {
+	index := func(column, suffix, collate string) string {
+		return fmt.Sprintf(`
+%s
+CREATE INDEX IF NOT EXISTS idx_%s%s ON map(%s%s);`, pragma, column, suffix, column, collate)
+	}
+	for _, v := range []struct {
+		mode    int
+		suffix  string
+		collate string
+	}{{mode, "", ""}, {nocaseMode, "_nocase", " COLLATE NOCASE"}} {
+		if v.mode == 1 || v.mode == 3 {
+			scripts = append(scripts, index("k", v.suffix, v.collate))
+		}
+		if v.mode == 2 || v.mode == 3 {
+			scripts = append(scripts, index("v", v.suffix, v.collate))
+		}
+	}
+	return scripts
+}
+
+// ReverseTable is the name of the reverse table, cf. ReverseTableScript.
+const ReverseTable = "rmap"
+
+// ReverseTableScript returns a script, which copies the map table into a
+// reverse table with swapped columns, clustered by the former value, so rows
+// with the same value are stored next to each other, which is faster for
+// lookups by value than an index on the map table. Duplicate rows are stored
+// once. With nocase, there is an additional case insensitive index.
+func ReverseTableScript(pragma, valueType string, nocase bool) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `
+%s
+PRAGMA temp_store = FILE;
+CREATE TABLE IF NOT EXISTS %s (k %s, v TEXT, PRIMARY KEY (k, v)) WITHOUT ROWID;
+INSERT OR IGNORE INTO %s (k, v) SELECT v, k FROM map ORDER BY v, k;`, pragma, ReverseTable, valueType, ReverseTable)
+	if nocase {
+		fmt.Fprintf(&sb, `
+CREATE INDEX IF NOT EXISTS idx_%s_k_nocase ON %s(k COLLATE NOCASE);`, ReverseTable, ReverseTable)
+	}
+	return sb.String()
+}
@@ -0,0 +1,59 @@
+package tabutils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetaScript(t *testing.T) {
+	script := MetaScript(map[string]string{"source": "oci-2022-01'x", "date": "2022-01-30"})
+	want := `CREATE TABLE IF NOT EXISTS meta (k TEXT PRIMARY KEY, v TEXT);
+INSERT OR REPLACE INTO meta (k, v) VALUES ('date', '2022-01-30');
+INSERT OR REPLACE INTO meta (k, v) VALUES ('source', 'oci-2022-01''x');
+INSERT OR REPLACE INTO meta (k, v) SELECT 'rows', coalesce(max(rowid), 0) FROM map;
+`
+	if script != want {
+		t.Fatalf("got %q, want %q", script, want)
+	}
+}
+
+func TestReverseTableScript(t *testing.T) {
+	script := ReverseTableScript("", "TEXT", false)
+	for _, want := range []string{
+		"CREATE TABLE IF NOT EXISTS rmap (k TEXT, v TEXT, PRIMARY KEY (k, v)) WITHOUT ROWID;",
+		"INSERT OR IGNORE INTO rmap (k, v) SELECT v, k FROM map ORDER BY v, k;",
+	} {
+		if !strings.Contains(script, want) {
+			t.Fatalf("got %q, want %q", script, want)
+		}
+	}
+	if strings.Contains(script, "NOCASE") {
+		t.Fatalf("got %q, want no case insensitive index", script)
+	}
+	script = ReverseTableScript("", "TEXT", true)
+	if !strings.Contains(script, "CREATE INDEX IF NOT EXISTS idx_rmap_k_nocase ON rmap(k COLLATE NOCASE);") {
+		t.Fatalf("got %q, want case insensitive index", script)
+	}
+}
+
+func TestIndexScripts(t *testing.T) {
+	var cases = []struct {
+		mode, nocaseMode int
+		want             []string
+	}{
+		{0, 0, nil},
+		{1, 0, []string{"idx_k ON map(k)"}},
+		{3, 2, []string{"idx_k ON map(k)", "idx_v ON map(v)", "idx_v_nocase ON map(v COLLATE NOCASE)"}},
+	}
+	for _, c := range cases {
+		scripts := IndexScripts("", c.mode, c.nocaseMode)
+		if len(scripts) != len(c.want) {
+			t.Fatalf("got %d scripts, want %d", len(scripts), len(c.want))
+		}
+		for i, s := range scripts {
+			if !strings.Contains(s, "CREATE INDEX IF NOT EXISTS "+c.want[i]+";") {
+				t.Fatalf("got %q, want %v", s, c.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package ckit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/segmentio/encoding/json"
+	"github.com/slub/labe/go/ckit/set"
+)
+
+// TimelineResponse contains the number of citations received per year, as
+// derived from the publication years of the citing documents.
+type TimelineResponse struct {
+	ID    string      `json:"id"`
+	DOI   string      `json:"doi"`
+	Years map[int]int `json:"years"`
+	Extra struct {
+		// CitedCount is the number of citing DOI, Unknown the number of
+		// those, for which we have no document or no year.
+		CitedCount int     `json:"cited_count"`
+		Unknown    int     `json:"unknown"`
+		Took       float64 `json:"took"`
+	} `json:"extra"`
+}
+
+// citationYears counts citing documents per publication year; a DOI with
+// more than one local id counts once, with the first year found.
+func (s *Server) citationYears(ctx context.Context, ids []Map) (years map[int]int, known int, err error) {
+	years = make(map[int]int)
+	seen := make(map[string]bool)
+	for _, v := range ids {
+		if seen[v.Value] {
+			continue
+		}
+		b, err := FetchContext(ctx, s.indexData(), v.Key)
+		if errors.Is(err, ErrBlobNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if year, ok := s.blobYear(b); ok {
+			years[year]++
+			seen[v.Value] = true
+			known++
+		}
+	}
+	return years, known, nil
+}
+
+// handleTimeline returns the number of citations received per year for a
+// local identifier, e.g. for "cited by over time" charts; requires
+// Server.YearFields.
+func (s *Server) handleTimeline() http.HandlerFunc {
+	if len(s.YearFields) == 0 {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "not configured", 400)
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			started = time.Now()
+			ctx     = r.Context()
+			resp    = &TimelineResponse{ID: mux.Vars(r)["id"]}
+		)
+		if err := s.identifierToDOI(ctx, resp.ID, &resp.DOI); err != nil {
+			switch {
+			case err == sql.ErrNoRows:
+				httpErrLogf(w, http.StatusNotFound, "doi lookup (%s): %w", resp.ID, err)
+			case errors.Is(err, context.DeadlineExceeded):
+				httpErrLogf(w, http.StatusGatewayTimeout, "doi lookup (%s): %w", resp.ID, err)
+			default:
+				httpErrLogf(w, http.StatusInternalServerError, "doi lookup (%s): %w", resp.ID, err)
+			}
+			return
+		}
+		citing, cited, err := s.edges(ctx, resp.DOI)
+		if err == nil && len(s.EdgeSources) > 0 {
+			_, cited, _, err = s.sourceEdges(ctx, &Response{ID: resp.ID, DOI: resp.DOI}, citing, cited)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, context.Canceled):
+				return
+			case errors.Is(err, context.DeadlineExceeded):
+				httpErrLogf(w, http.StatusGatewayTimeout, "timeline: %w", err)
+			default:
+				httpErrLogf(w, http.StatusInternalServerError, "timeline: %w", err)
+			}
+			return
+		}
+		citingSet := set.New()
+		for _, v := range cited {
+			citingSet.Add(v.Key)
+		}
+		dois := citingSet.Slice()
+		ids, err := s.mapToLocal(ctx, dois)
+		if err != nil {
+			httpErrLogf(w, http.StatusInternalServerError, "map: %w", err)
+			return
+		}
+		fetchCtx, cancel := withTimeout(ctx, s.IndexDataTimeout)
+		defer cancel()
+		years, known, err := s.citationYears(fetchCtx, ids)
+		switch {
+		case errors.Is(err, context.Canceled):
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			httpErrLogf(w, http.StatusGatewayTimeout, "timeline: %w", err)
+			return
+		case err != nil:
+			httpErrLogf(w, http.StatusInternalServerError, "timeline: %w", err)
+			return
+		}
+		resp.Years = years
+		resp.Extra.CitedCount = len(dois)
+		resp.Extra.Unknown = len(dois) - known
+		resp.Extra.Took = time.Since(started).Seconds()
+		s.Stats.MeasureSinceWithLabels("timeline", started, nil)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			httpErrLog(w, http.StatusInternalServerError, err)
+		}
+	}
+}
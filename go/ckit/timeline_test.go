@@ -0,0 +1,36 @@
+package ckit
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCitationYears(t *testing.T) {
+	s := &Server{
+		IndexData: mapFetcher{
+			"1": `{"publishDateSort": "2001"}`,
+			"2": `{"publishDateSort": "2001"}`,
+			"3": `{"publishDateSort": "2005"}`,
+			"4": `{"title": "no year"}`,
+		},
+		YearFields: []string{"publishDateSort"},
+	}
+	ids := []Map{
+		{Key: "1", Value: "10.1/a"},
+		{Key: "3", Value: "10.1/a"}, // same DOI, counted once
+		{Key: "2", Value: "10.1/b"},
+		{Key: "4", Value: "10.1/c"},
+		{Key: "5", Value: "10.1/d"}, // no blob
+	}
+	years, known, err := s.citationYears(context.Background(), ids)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if want := map[int]int{2001: 2}; !reflect.DeepEqual(years, want) {
+		t.Fatalf("got %v, want %v", years, want)
+	}
+	if known != 2 {
+		t.Fatalf("got %d, want 2", known)
+	}
+}
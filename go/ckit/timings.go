@@ -0,0 +1,93 @@
+package ckit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Timing is the duration of a single SQL query or blob fetch of a request,
+// cf. Server.Bench.
+type Timing struct {
+	// Kind is sql, fetch or batch, for a batch of blob fetches.
+	Kind string `json:"kind"`
+	// What is the query, with long parameter lists shortened, or the id of
+	// the fetched blob.
+	What string `json:"what"`
+	// N is the number of query parameters or ids in a batch.
+	N     int     `json:"n,omitempty"`
+	Start float64 `json:"start"` // seconds since the first recorded timing
+	Took  float64 `json:"took"`  // seconds
+	Err   string  `json:"err,omitempty"`
+}
+
+// timings collects the timings of a request; concurrent fetches record
+// their timings as well.
+type timings struct {
+	mu      sync.Mutex
+	started time.Time
+	list    []Timing
+}
+
+// withTimings returns a context, in which SQL queries and blob fetches are
+// recorded, and the timings recorded into.
+func withTimings(ctx context.Context) (context.Context, *timings) {
+	t := &timings{started: time.Now()}
+	return context.WithValue(ctx, timingsKey, t), t
+}
+
+// timingsFrom returns the timings collected in a context, or nil.
+func timingsFrom(ctx context.Context) *timings {
+	t, _ := ctx.Value(timingsKey).(*timings)
+	return t
+}
+
+// paramList matches lists of query parameters, e.g. from sqlx.In.
+var paramList = regexp.MustCompile(`\?(, ?\?)+`)
+
+// recordTiming records an operation, that started at a given time, if the
+// context collects timings.
+func recordTiming(ctx context.Context, kind, what string, n int, started time.Time, err error) {
+	t := timingsFrom(ctx)
+	if t == nil {
+		return
+	}
+	if kind == "sql" {
+		what = paramList.ReplaceAllString(what, "?, ...")
+	}
+	v := Timing{Kind: kind, What: what, N: n, Took: time.Since(started).Seconds()}
+	if err != nil {
+		v.Err = err.Error()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v.Start = started.Sub(t.started).Seconds()
+	t.list = append(t.list, v)
+}
+
+// log logs all recorded timings of a request, one per line.
+func (t *timings) log(id, requestID string) {
+	for _, v := range t.Timings() {
+		msg := fmt.Sprintf("debug [%s] %s: %s %0.6fs %s", requestID, id, v.Kind, v.Took, v.What)
+		if v.N > 0 {
+			msg += fmt.Sprintf(" (%d)", v.N)
+		}
+		if v.Err != "" {
+			msg += " failed: " + v.Err
+		}
+		log.Println(msg)
+	}
+}
+
+// Timings returns the recorded timings, in the order they finished.
+func (t *timings) Timings() []Timing {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Timing(nil), t.list...)
+}
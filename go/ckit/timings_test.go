@@ -0,0 +1,50 @@
+package ckit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTimings(t *testing.T) {
+	// Without timings in the context, nothing is recorded.
+	recordTiming(context.Background(), "sql", "SELECT 1", 0, time.Now(), nil)
+	ctx, tm := withTimings(context.Background())
+	recordTiming(ctx, "sql", "SELECT * FROM map WHERE v IN (?, ?, ?)", 3, time.Now(), nil)
+	recordTiming(ctx, "fetch", "ai-1", 0, time.Now(), errors.New("boom"))
+	got := tm.Timings()
+	if len(got) != 2 {
+		t.Fatalf("got %d timings, want 2", len(got))
+	}
+	if want := "SELECT * FROM map WHERE v IN (?, ...)"; got[0].What != want || got[0].N != 3 {
+		t.Fatalf("got %q (%d), want %q (3)", got[0].What, got[0].N, want)
+	}
+	if got[1].Kind != "fetch" || got[1].Err != "boom" {
+		t.Fatalf("got %+v, want failed fetch", got[1])
+	}
+}
+
+func TestIntegrationTimings(t *testing.T) {
+	h := newHarness(t, func(s *Server) {
+		s.Bench = true
+	})
+	id, _ := h.someID(t)
+	r := h.getResponse(t, "/id/"+id+"?bench=1")
+	kinds := make(map[string]int)
+	for _, v := range r.Extra.Timings {
+		kinds[v.Kind]++
+	}
+	// At least the id lookup, citing and cited DOI and the mapping back to
+	// ids, one fetch per blob.
+	if kinds["sql"] < 4 {
+		t.Fatalf("got %d sql timings, want at least 4: %v", kinds["sql"], r.Extra.Timings)
+	}
+	if n := len(r.Citing) + len(r.Cited); kinds["fetch"] < n {
+		t.Fatalf("got %d fetch timings, want at least %d", kinds["fetch"], n)
+	}
+	// Regular requests do not carry timings.
+	if r := h.getResponse(t, "/id/"+id); len(r.Extra.Timings) > 0 {
+		t.Fatalf("got %d timings without bench, want none", len(r.Extra.Timings))
+	}
+}
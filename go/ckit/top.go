@@ -0,0 +1,340 @@
+package ckit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/segmentio/encoding/json"
+)
+
+const (
+	// DefaultMaxRankedScan limits the number of DOI we look at, when
+	// searching for the most cited records of an institution.
+	DefaultMaxRankedScan = 100000
+	// maxTopN is the maximum number of records in a most cited list.
+	maxTopN = 1000
+	// topCacheTTL is the time we keep computed lists; the ranked list only
+	// changes with a data update.
+	topCacheTTL = time.Hour
+)
+
+// zstdMagic starts every zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// TopEntry is a single record in a most cited list.
+type TopEntry struct {
+	ID    string          `json:"id"`
+	DOI   string          `json:"doi"`
+	Count int             `json:"count"`
+	Doc   json.RawMessage `json:"doc"`
+}
+
+// TopResponse is a list of the most cited records held by an institution.
+type TopResponse struct {
+	Institution string     `json:"institution"`
+	Docs        []TopEntry `json:"docs"`
+	Extra       struct {
+		// Scanned is the number of DOI from the ranked list we looked at.
+		Scanned int     `json:"scanned"`
+		Took    float64 `json:"took"`
+	} `json:"extra"`
+}
+
+// topCache keeps computed lists for a while.
+type topCache struct {
+	mu sync.Mutex
+	m  map[string]topCacheEntry
+}
+
+type topCacheEntry struct {
+	b       []byte
+	expires time.Time
+}
+
+func (c *topCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.m[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.b, true
+}
+
+func (c *topCache) set(key string, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = make(map[string]topCacheEntry)
+	}
+	for k, e := range c.m {
+		if time.Now().After(e.expires) {
+			delete(c.m, k)
+		}
+	}
+	c.m[key] = topCacheEntry{b: b, expires: time.Now().Add(topCacheTTL)}
+}
+
+// parseRankedLine parses a line of the ranked list, which contains the number
+// of citations and a DOI, as produced by "uniq -c".
+func parseRankedLine(line string) (count int, doi string, err error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return 0, "", fmt.Errorf("invalid ranked line: %q", line)
+	}
+	if count, err = strconv.Atoi(fields[0]); err != nil {
+		return 0, "", fmt.Errorf("invalid ranked line: %q", line)
+	}
+	return count, fields[1], nil
+}
+
+// openRanked opens the ranked list, which may be zstd compressed.
+func openRanked(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, err
+	}
+	if !bytes.Equal(magic, zstdMagic) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{br, f}, nil
+	}
+	zr, err := zstd.NewReader(br)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{zr, closerFunc(func() error {
+		zr.Close()
+		return f.Close()
+	})}, nil
+}
+
+// closerFunc turns a function into an io.Closer.
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// rankedList yields DOI by descending citation count; Next returns io.EOF
+// at the end of the list.
+type rankedList interface {
+	Next() (count int, doi string, err error)
+	Close() error
+}
+
+// rankedFile reads a ranked list file, cf. openRanked.
+type rankedFile struct {
+	rc      io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+func (r *rankedFile) Next() (int, string, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return 0, "", err
+		}
+		return 0, "", io.EOF
+	}
+	return parseRankedLine(r.scanner.Text())
+}
+
+func (r *rankedFile) Close() error {
+	return r.rc.Close()
+}
+
+// rankedRows reads DOI ranked by cited count from a counts database.
+type rankedRows struct {
+	rows *sql.Rows
+}
+
+func (r *rankedRows) Next() (count int, doi string, err error) {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return 0, "", err
+		}
+		return 0, "", io.EOF
+	}
+	err = r.rows.Scan(&doi, &count)
+	return count, doi, err
+}
+
+func (r *rankedRows) Close() error {
+	return r.rows.Close()
+}
+
+// openRankedList returns up to limit DOI ranked by citation count, from the
+// counts database, if any, as it is replaced together with the other data on
+// rotation, or from the ranked list file.
+func (s *Server) openRankedList(ctx context.Context, limit int) (rankedList, error) {
+	if db := s.countsDB(); db != nil {
+		rows, err := db.QueryContext(ctx, "SELECT doi, cited FROM counts ORDER BY cited DESC LIMIT ?", limit)
+		if err != nil {
+			return nil, err
+		}
+		return &rankedRows{rows: rows}, nil
+	}
+	rc, err := openRanked(s.RankedPath)
+	if err != nil {
+		return nil, err
+	}
+	return &rankedFile{rc: rc, scanner: bufio.NewScanner(rc)}, nil
+}
+
+// topForInstitution walks the ranked list from the top and collects up to n
+// records, which are held by a given institution.
+func (s *Server) topForInstitution(ctx context.Context, isil string, n int) (*TopResponse, error) {
+	maxScan := s.MaxRankedScan
+	if maxScan == 0 {
+		maxScan = DefaultMaxRankedScan
+	}
+	list, err := s.openRankedList(ctx, maxScan)
+	if err != nil {
+		return nil, err
+	}
+	defer list.Close()
+	var (
+		resp      = &TopResponse{Institution: isil, Docs: []TopEntry{}}
+		batch     []string
+		counts    = make(map[string]int)
+		batchSize = 500
+	)
+	// flush looks up a batch of DOI, in ranked order.
+	flush := func() error {
+		ids, err := s.mapToLocal(ctx, batch)
+		if err != nil {
+			return err
+		}
+		byDOI := make(map[string][]string)
+		for _, v := range ids {
+			byDOI[v.Value] = append(byDOI[v.Value], v.Key)
+		}
+		for _, doi := range batch {
+			for _, id := range byDOI[s.foldDOI(doi)] {
+				b, err := FetchContext(ctx, s.indexData(), id)
+				if errors.Is(err, ErrBlobNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				var snippet Snippet
+				if err := json.Unmarshal(b, &snippet); err != nil {
+					return fmt.Errorf("internal data broken: %w", err)
+				}
+				if !SliceContains(snippet.Institutions, isil) {
+					continue
+				}
+				resp.Docs = append(resp.Docs, TopEntry{ID: id, DOI: doi, Count: counts[doi], Doc: s.slim(b)})
+				if len(resp.Docs) == n {
+					return nil
+				}
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for resp.Extra.Scanned < maxScan {
+		count, doi, err := list.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		resp.Extra.Scanned++
+		counts[doi] = count
+		if batch = append(batch, doi); len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			if len(resp.Docs) == n {
+				return resp, nil
+			}
+		}
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// handleTop returns the most cited records held by an institution, e.g.
+// /top?i=DE-14&n=100, based on a precomputed list of DOI ranked by citation
+// count or a counts database; the n defaults to 10.
+func (s *Server) handleTop() http.HandlerFunc {
+	if s.RankedPath == "" && s.countsDB() == nil {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "not configured", 400)
+		}
+	}
+	var cache topCache
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			started = time.Now()
+			isil    = r.URL.Query().Get("i")
+			n       = 10
+			err     error
+		)
+		if isil == "" {
+			httpErrLogf(w, http.StatusBadRequest, "top: institution (i) required")
+			return
+		}
+		if v := r.URL.Query().Get("n"); v != "" {
+			if n, err = strconv.Atoi(v); err != nil || n < 1 || n > maxTopN {
+				httpErrLogf(w, http.StatusBadRequest, "top: n must be between 1 and %d", maxTopN)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		key := fmt.Sprintf("%s@%d", isil, n)
+		if b, ok := cache.get(key); ok {
+			w.Write(b)
+			return
+		}
+		resp, err := s.topForInstitution(r.Context(), isil, n)
+		switch {
+		case errors.Is(err, context.Canceled):
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			httpErrLogf(w, http.StatusGatewayTimeout, "top: %w", err)
+			return
+		case err != nil:
+			httpErrLogf(w, http.StatusInternalServerError, "top: %w", err)
+			return
+		}
+		resp.Extra.Took = time.Since(started).Seconds()
+		b, err := json.Marshal(resp)
+		if err != nil {
+			httpErrLog(w, http.StatusInternalServerError, err)
+			return
+		}
+		b = append(b, '\n')
+		cache.set(key, b)
+		s.Stats.MeasureSinceWithLabels("top", started, nil)
+		w.Write(b)
+	}
+}
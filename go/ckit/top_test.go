@@ -0,0 +1,73 @@
+package ckit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestParseRankedLine(t *testing.T) {
+	var cases = []struct {
+		line  string
+		count int
+		doi   string
+		err   bool
+	}{
+		{"  1234 10.123/456", 1234, "10.123/456", false},
+		{"1 10.1/x", 1, "10.1/x", false},
+		{"10.1/x", 0, "", true},
+		{"x 10.1/x", 0, "", true},
+	}
+	for _, c := range cases {
+		count, doi, err := parseRankedLine(c.line)
+		if count != c.count || doi != c.doi || (err != nil) != c.err {
+			t.Fatalf("[%s] got %v %v %v, want %v %v (err: %v)", c.line, count, doi, err, c.count, c.doi, c.err)
+		}
+	}
+}
+
+func TestOpenRanked(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		data = "  2 10.1/a\n  1 10.1/b\n"
+	)
+	plain := filepath.Join(dir, "ranked.txt")
+	if err := ioutil.WriteFile(plain, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	compressed := filepath.Join(dir, "ranked.txt.zst")
+	f, err := os.Create(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw, err := zstd.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{plain, compressed} {
+		rc, err := openRanked(filename)
+		if err != nil {
+			t.Fatalf("%s: %v", filename, err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		if err != nil {
+			t.Fatalf("%s: %v", filename, err)
+		}
+		rc.Close()
+		if string(b) != data {
+			t.Fatalf("%s: got %q, want %q", filename, b, data)
+		}
+	}
+}
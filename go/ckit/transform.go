@@ -0,0 +1,107 @@
+package ckit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// Transformer rewrites an index data blob before it is included in a
+// response, e.g. to map internal field names to a public schema.
+type Transformer interface {
+	Transform(b []byte) ([]byte, error)
+}
+
+// TransformerFunc adapts a function to a Transformer.
+type TransformerFunc func(b []byte) ([]byte, error)
+
+// Transform calls f.
+func (f TransformerFunc) Transform(b []byte) ([]byte, error) {
+	return f(b)
+}
+
+// TransformerFactory creates a transformer from a deployment specific
+// configuration string, which may be empty.
+type TransformerFactory func(config string) (Transformer, error)
+
+var (
+	transformersMu sync.RWMutex
+	transformers   = make(map[string]TransformerFactory)
+)
+
+func init() {
+	RegisterTransformer("rename", newRenameTransformer)
+}
+
+// RegisterTransformer makes a transformer available by name, usually from
+// an init function of a package compiled into the server. It panics, if the
+// name is registered twice.
+func RegisterTransformer(name string, factory TransformerFactory) {
+	transformersMu.Lock()
+	defer transformersMu.Unlock()
+	if factory == nil {
+		panic("ckit: register transformer factory is nil")
+	}
+	if _, dup := transformers[name]; dup {
+		panic("ckit: register transformer twice: " + name)
+	}
+	transformers[name] = factory
+}
+
+// Transformers returns the names of the registered transformers.
+func Transformers() []string {
+	transformersMu.RLock()
+	defer transformersMu.RUnlock()
+	var names []string
+	for name := range transformers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewTransformer creates a registered transformer, given as name, optionally
+// followed by a colon and its configuration, e.g. "rename:a=b,c=d".
+func NewTransformer(s string) (Transformer, error) {
+	var name, config = s, ""
+	if i := strings.Index(s, ":"); i != -1 {
+		name, config = s[:i], s[i+1:]
+	}
+	transformersMu.RLock()
+	factory, ok := transformers[name]
+	transformersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown transformer %q, available: %v", name, Transformers())
+	}
+	return factory(config)
+}
+
+// newRenameTransformer renames top level fields, configured as a comma
+// separated list of old=new pairs, e.g. "title_full=title,author2=authors".
+func newRenameTransformer(config string) (Transformer, error) {
+	names := make(map[string]string)
+	for _, pair := range strings.Split(config, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("rename: want old=new, got %q", pair)
+		}
+		names[parts[0]] = parts[1]
+	}
+	return TransformerFunc(func(b []byte) ([]byte, error) {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return nil, err
+		}
+		result := make(map[string]json.RawMessage, len(doc))
+		for k, v := range doc {
+			if name, ok := names[k]; ok {
+				k = name
+			}
+			result[k] = v
+		}
+		return json.Marshal(result)
+	}), nil
+}
@@ -0,0 +1,56 @@
+package ckit
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestNewTransformer(t *testing.T) {
+	for _, s := range []string{"unknown", "rename", "rename:a", "rename:=b", "rename:a=b,"} {
+		if _, err := NewTransformer(s); err == nil {
+			t.Fatalf("%q: want error", s)
+		}
+	}
+	tr, err := NewTransformer("rename:title_full=title,author2=authors")
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	b, err := tr.Transform([]byte(`{"id":"1","title_full":"T","author2":["A"]}`))
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if want := `{"authors":["A"],"id":"1","title":"T"}`; string(b) != want {
+		t.Fatalf("got %s, want %s", b, want)
+	}
+	if _, err := tr.Transform([]byte(`{"id"`)); err == nil {
+		t.Fatalf("want error for broken blob")
+	}
+}
+
+func TestRegisterTransformer(t *testing.T) {
+	RegisterTransformer("test-upper", func(config string) (Transformer, error) {
+		return TransformerFunc(func(b []byte) ([]byte, error) {
+			return bytes.ToUpper(b), nil
+		}), nil
+	})
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatalf("want panic on duplicate registration")
+		}
+	}()
+	tr, err := NewTransformer("test-upper")
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	srv := &Server{
+		IndexData:    mapFetcher{"1": `{"id":"a"}`},
+		Transformers: []Transformer{tr},
+	}
+	var response Response
+	b, err := srv.fetchBlob(context.Background(), &response, "1")
+	if err != nil || string(b) != `{"ID":"A"}` {
+		t.Fatalf("got %s, %v", b, err)
+	}
+	RegisterTransformer("test-upper", newRenameTransformer)
+}
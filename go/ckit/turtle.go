@@ -0,0 +1,115 @@
+package ckit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// TurtlePrefixes are written at the start of every turtle document. We use
+// the Citation Typing Ontology (CiTO), https://sparontologies.github.io/cito/.
+const TurtlePrefixes = `@prefix cito: <http://purl.org/spar/cito/> .
+@prefix dcterms: <http://purl.org/dc/terms/> .
+
+`
+
+// docRef is the part of an index or unmatched document we need to emit
+// triples, the local id (if any) and one or more DOI.
+type docRef struct {
+	ID  string          `json:"id"`
+	DOI json.RawMessage `json:"doi_str_mv"`
+}
+
+// DOIs returns the DOI found in the document; index data uses a list of
+// strings, while unmatched entries carry a single string.
+func (d *docRef) DOIs() []string {
+	if len(d.DOI) == 0 {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(d.DOI, &s); err == nil {
+		if s == "" {
+			return nil
+		}
+		return []string{s}
+	}
+	var ss []string
+	if err := json.Unmarshal(d.DOI, &ss); err == nil {
+		return ss
+	}
+	return nil
+}
+
+// WriteTurtle writes the citation edges of a response as RDF triples in
+// turtle format, using cito:cites for citing and cito:isCitedBy for cited
+// documents. DOI are turned into IRIs by prefixing them with
+// https://doi.org/. Documents without a DOI are skipped.
+func (r *Response) WriteTurtle(w io.Writer) error {
+	var (
+		bw      = bufio.NewWriter(w)
+		subject = doiIRI(r.DOI)
+	)
+	if _, err := io.WriteString(bw, TurtlePrefixes); err != nil {
+		return err
+	}
+	if r.ID != "" {
+		fmt.Fprintf(bw, "%s dcterms:identifier %s .\n", subject, turtleLiteral(r.ID))
+	}
+	var groups = []struct {
+		predicate string
+		docs      []json.RawMessage
+	}{
+		{"cito:cites", r.Citing},
+		{"cito:cites", r.Unmatched.Citing},
+		{"cito:isCitedBy", r.Cited},
+		{"cito:isCitedBy", r.Unmatched.Cited},
+	}
+	for _, g := range groups {
+		for _, b := range g.docs {
+			var ref docRef
+			if err := json.Unmarshal(b, &ref); err != nil {
+				return fmt.Errorf("turtle: %w", err)
+			}
+			for _, v := range ref.DOIs() {
+				object := doiIRI(v)
+				fmt.Fprintf(bw, "%s %s %s .\n", subject, g.predicate, object)
+				if ref.ID != "" {
+					fmt.Fprintf(bw, "%s dcterms:identifier %s .\n", object, turtleLiteral(ref.ID))
+				}
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// doiIRI turns a DOI into an IRI reference, with characters not allowed in
+// turtle IRIREF percent-encoded.
+func doiIRI(doi string) string {
+	var sb strings.Builder
+	sb.WriteString("<https://doi.org/")
+	for _, c := range []byte(doi) {
+		switch {
+		case c <= 0x20, strings.IndexByte("<>\"{}|^`\\%", c) >= 0:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteString(">")
+	return sb.String()
+}
+
+// turtleLiteral returns a quoted turtle string literal.
+func turtleLiteral(s string) string {
+	var r = strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"\t", `\t`,
+	)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,56 @@
+package ckit
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+)
+
+func TestWriteTurtle(t *testing.T) {
+	var cases = []struct {
+		desc     string
+		resp     []byte
+		expected string
+	}{
+		{
+			desc:     "empty",
+			resp:     []byte(`{"doi": "10.1/a"}`),
+			expected: TurtlePrefixes,
+		},
+		{
+			desc: "citing, cited and unmatched",
+			resp: []byte(`
+			{
+			  "id": "0-1",
+			  "doi": "10.1/a",
+			  "citing": [{"id": "0-2", "doi_str_mv": ["10.1/b"]}],
+			  "cited": [{"id": "0-3", "doi_str_mv": ["10.1/c"]}],
+			  "unmatched": {"cited": [{"doi_str_mv": "10.1/<d>"}]}
+			}
+			`),
+			expected: TurtlePrefixes + `<https://doi.org/10.1/a> dcterms:identifier "0-1" .
+<https://doi.org/10.1/a> cito:cites <https://doi.org/10.1/b> .
+<https://doi.org/10.1/b> dcterms:identifier "0-2" .
+<https://doi.org/10.1/a> cito:isCitedBy <https://doi.org/10.1/c> .
+<https://doi.org/10.1/c> dcterms:identifier "0-3" .
+<https://doi.org/10.1/a> cito:isCitedBy <https://doi.org/10.1/%3Cd%3E> .
+`,
+		},
+	}
+	for _, c := range cases {
+		var (
+			resp Response
+			buf  bytes.Buffer
+		)
+		if err := json.Unmarshal(c.resp, &resp); err != nil {
+			t.Fatalf("could not unmarshal test response: %v", err)
+		}
+		if err := resp.WriteTurtle(&buf); err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.desc, err)
+		}
+		if buf.String() != c.expected {
+			t.Fatalf("[%s] got %v, want %v", c.desc, buf.String(), c.expected)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package ckit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/slub/labe/go/ckit/tabutils"
+)
+
+// VerifyDatabase runs checks on a database as created by makta: the map
+// table and the given indices (e.g. idx_k, idx_v) must exist and the table
+// must not be empty. With integrity set, it also runs "PRAGMA quick_check",
+// which reads the whole file and may take a while for large databases.
+func VerifyDatabase(ctx context.Context, db *sqlx.DB, integrity bool, indices ...string) error {
+	var n int
+	if err := db.GetContext(ctx, &n,
+		"SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'map'"); err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("table map not found")
+	}
+	var names []string
+	if err := db.SelectContext(ctx, &names,
+		"SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'map'"); err != nil {
+		return err
+	}
+	for _, index := range indices {
+		if !SliceContains(names, index) {
+			return fmt.Errorf("index %s not found", index)
+		}
+	}
+	var v int
+	switch err := db.GetContext(ctx, &v, "SELECT 1 FROM map LIMIT 1"); {
+	case err == sql.ErrNoRows:
+		return fmt.Errorf("table map is empty")
+	case err != nil:
+		return err
+	}
+	if !integrity {
+		return nil
+	}
+	var problems []string
+	if err := db.SelectContext(ctx, &problems, "PRAGMA quick_check"); err != nil {
+		return err
+	}
+	if len(problems) != 1 || problems[0] != "ok" {
+		return fmt.Errorf("quick check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// verifyInbound checks, that lookups by value, e.g. inbound citations, are
+// indexed: either the map table has an index on its values (idx_v or, with
+// nocase, idx_v_nocase) or there is a reverse table with its key, cf.
+// inboundQuery; with nocase, the reverse table needs idx_rmap_k_nocase.
+func verifyInbound(ctx context.Context, db *sqlx.DB, nocase bool) error {
+	index := "idx_v"
+	if nocase {
+		index = "idx_v_nocase"
+	}
+	var n int
+	if err := db.GetContext(ctx, &n,
+		"SELECT count(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = 'map' AND name = ?", index); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	var (
+		query = "SELECT count(*) FROM pragma_index_list(?) WHERE origin = 'pk'"
+		args  = []interface{}{tabutils.ReverseTable}
+	)
+	if nocase {
+		query = "SELECT count(*) FROM pragma_index_list(?) WHERE name = ?"
+		args = append(args, "idx_"+tabutils.ReverseTable+"_k_nocase")
+	}
+	if err := db.GetContext(ctx, &n, query, args...); err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("index %s not found and no indexed %s table", index, tabutils.ReverseTable)
+	}
+	return nil
+}
+
+// verifyAll verifies identifier, citation and sqlite index databases.
+func verifyAll(ctx context.Context, identifier, oci *sqlx.DB, index Fetcher, integrity bool) error {
+	// Lookups go both ways in the identifier and citation databases.
+	if err := VerifyDatabase(ctx, identifier, integrity, "idx_k", "idx_v"); err != nil {
+		return fmt.Errorf("identifier database: %w", err)
+	}
+	if err := VerifyDatabase(ctx, oci, integrity, "idx_k"); err != nil {
+		return fmt.Errorf("oci database: %w", err)
+	}
+	if err := verifyInbound(ctx, oci, false); err != nil {
+		return fmt.Errorf("oci database: %w", err)
+	}
+	for i, sf := range sqliteBackends(index) {
+		if err := VerifyDatabase(ctx, sf.DB, integrity, "idx_k"); err != nil {
+			return fmt.Errorf("index database #%d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// VerifyDatabases checks all databases currently in use, including those of
+// all corpora, cf. VerifyDatabase.
+func (s *Server) VerifyDatabases(ctx context.Context, integrity bool) error {
+	defer s.acquire()()
+	s.dataMu.RLock()
+	identifier, oci, index := s.IdentifierDatabase, s.OciDatabase, s.IndexData
+	s.dataMu.RUnlock()
+	if err := verifyAll(ctx, identifier, oci, index, integrity); err != nil {
+		return err
+	}
+	if s.NoCase {
+		if err := VerifyDatabase(ctx, identifier, false, "idx_v_nocase"); err != nil {
+			return fmt.Errorf("identifier database: %w", err)
+		}
+		if err := VerifyDatabase(ctx, oci, false, "idx_k_nocase"); err != nil {
+			return fmt.Errorf("oci database: %w", err)
+		}
+		if err := verifyInbound(ctx, oci, true); err != nil {
+			return fmt.Errorf("oci database: %w", err)
+		}
+	}
+	for name, c := range s.Corpora {
+		if err := c.VerifyDatabases(ctx, integrity); err != nil {
+			return fmt.Errorf("corpus %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// sqliteBackends returns the sqlite fetchers a fetcher consists of.
+func sqliteBackends(f Fetcher) (result []*SqliteFetcher) {
+	switch v := f.(type) {
+	case *FetchGroup:
+		for _, b := range v.Backends {
+			result = append(result, sqliteBackends(b)...)
+		}
+	case *ShardFetcher:
+		result = append(result, v.Shards...)
+	case *SqliteFetcher:
+		result = append(result, v)
+	case *LimitFetcher:
+		result = append(result, sqliteBackends(v.Fetcher)...)
+	}
+	return result
+}
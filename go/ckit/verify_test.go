@@ -0,0 +1,120 @@
+package ckit
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/slub/labe/go/ckit/tabutils"
+)
+
+func TestVerifyDatabase(t *testing.T) {
+	var cases = []struct {
+		filename string
+		indices  []string
+		err      string
+	}{
+		{"testdata/id_doi.db", []string{"idx_k", "idx_v"}, ""},
+		{"testdata/doi_doi.db", []string{"idx_k", "idx_v"}, ""},
+		{"testdata/id_metadata.db", []string{"idx_k"}, ""},
+		{"testdata/id_metadata.db", []string{"idx_k", "idx_v"}, "index idx_v not found"},
+	}
+	for _, c := range cases {
+		db, err := OpenDatabase(c.filename)
+		if err != nil {
+			t.Fatalf("test data: %v", err)
+		}
+		err = VerifyDatabase(context.Background(), db, true, c.indices...)
+		db.Close()
+		switch {
+		case c.err == "" && err != nil:
+			t.Fatalf("%s: got %v", c.filename, err)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Fatalf("%s: got %v, want %v", c.filename, err, c.err)
+		}
+	}
+	// An empty file is a valid, but empty sqlite database.
+	filename := filepath.Join(t.TempDir(), "empty.db")
+	if err := ioutil.WriteFile(filename, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenDatabase(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := VerifyDatabase(context.Background(), db, false); err == nil {
+		t.Fatalf("want error for empty database")
+	}
+}
+
+func TestVerifyInbound(t *testing.T) {
+	ctx := context.Background()
+	var cases = []struct {
+		about  string
+		script string
+		nocase bool
+		err    string
+	}{
+		{"value index", "CREATE INDEX idx_v ON map(v)", false, ""},
+		{"reverse table only", tabutils.ReverseTableScript("", "TEXT", false), false, ""},
+		{"reverse table without nocase index", tabutils.ReverseTableScript("", "TEXT", false), true, "index idx_v_nocase not found"},
+		{"reverse table with nocase index", tabutils.ReverseTableScript("", "TEXT", true), true, ""},
+		{"neither", "", false, "index idx_v not found"},
+	}
+	for _, c := range cases {
+		db, err := sqlx.Open(sqliteDriver, filepath.Join(t.TempDir(), "o.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`CREATE TABLE map (k TEXT, v TEXT);
+			CREATE INDEX idx_k ON map(k);
+			INSERT INTO map VALUES ('10.1/a', '10.1/b');`); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+		if c.script != "" {
+			if _, err := db.Exec(c.script); err != nil {
+				t.Fatalf("%s: %v", c.about, err)
+			}
+		}
+		err = verifyInbound(ctx, db, c.nocase)
+		db.Close()
+		switch {
+		case c.err == "" && err != nil:
+			t.Fatalf("%s: got %v", c.about, err)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Fatalf("%s: got %v, want %v", c.about, err, c.err)
+		}
+	}
+}
+
+func TestVerifyDatabasesReverseOnly(t *testing.T) {
+	// A citation database with a reverse table instead of a value index,
+	// cf. makta -R.
+	filename := filepath.Join(t.TempDir(), "o.db")
+	oci, err := sqlx.Open(sqliteDriver, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer oci.Close()
+	if _, err := oci.Exec(`CREATE TABLE map (k TEXT, v TEXT);
+		CREATE INDEX idx_k ON map(k);
+		INSERT INTO map VALUES ('10.1/a', '10.1/b');`); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	if _, err := oci.Exec(tabutils.ReverseTableScript("", "TEXT", false)); err != nil {
+		t.Fatalf("reverse: %v", err)
+	}
+	identifier, err := OpenDatabase("testdata/id_doi.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer identifier.Close()
+	srv := &Server{IdentifierDatabase: identifier, OciDatabase: oci}
+	if err := srv.VerifyDatabases(context.Background(), true); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+}
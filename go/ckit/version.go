@@ -0,0 +1,91 @@
+package ckit
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/segmentio/encoding/json"
+)
+
+// DatasetVersion describes a database in use, with the metadata makta
+// recorded in it, if any.
+type DatasetVersion struct {
+	Path string            `json:"path,omitempty"`
+	Meta map[string]string `json:"meta"`
+}
+
+// VersionResponse lists the versions of all databases in use.
+type VersionResponse struct {
+	Identifier DatasetVersion   `json:"identifier"`
+	Oci        DatasetVersion   `json:"oci"`
+	Index      []DatasetVersion `json:"index"`
+}
+
+// readMeta reads the meta table written by makta; returns an empty map for
+// databases without one.
+func readMeta(ctx context.Context, db *sqlx.DB) (map[string]string, error) {
+	meta := make(map[string]string)
+	var n int
+	if err := db.GetContext(ctx, &n,
+		"SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'meta'"); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return meta, nil
+	}
+	var rows []Map
+	if err := db.SelectContext(ctx, &rows, "SELECT k, v FROM meta"); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		meta[row.Key] = row.Value
+	}
+	return meta, nil
+}
+
+// versions collects the metadata of all databases in use.
+func (s *Server) versions(ctx context.Context) (*VersionResponse, error) {
+	s.dataMu.RLock()
+	identifier, oci, index, ds := s.IdentifierDatabase, s.OciDatabase, s.IndexData, s.Datasets
+	s.dataMu.RUnlock()
+	var (
+		resp = &VersionResponse{Index: []DatasetVersion{}}
+		err  error
+	)
+	if ds != nil {
+		resp.Identifier.Path, resp.Oci.Path = ds.Identifier, ds.Oci
+	}
+	if resp.Identifier.Meta, err = readMeta(ctx, identifier); err != nil {
+		return nil, err
+	}
+	if resp.Oci.Meta, err = readMeta(ctx, oci); err != nil {
+		return nil, err
+	}
+	for _, sf := range sqliteBackends(index) {
+		var dv DatasetVersion
+		if dv.Meta, err = readMeta(ctx, sf.DB); err != nil {
+			return nil, err
+		}
+		if ds != nil && len(ds.Index) > len(resp.Index) {
+			dv.Path = ds.Index[len(resp.Index)]
+		}
+		resp.Index = append(resp.Index, dv)
+	}
+	return resp, nil
+}
+
+// handleVersion returns the metadata of all databases in use, cf. makta.
+func (s *Server) handleVersion() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp, err := s.versions(r.Context())
+		if err != nil {
+			httpErrLogf(w, http.StatusInternalServerError, "version: %w", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			httpErrLog(w, http.StatusInternalServerError, err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package ckit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestVersions(t *testing.T) {
+	ds := &Datasets{
+		Identifier: "testdata/id_doi.db",
+		Oci:        "testdata/doi_doi.db",
+		Index:      []string{"testdata/id_metadata.db"},
+	}
+	identifier, oci, index, err := openDatasets(ds, false, 0)
+	if err != nil {
+		t.Fatalf("test data: %v", err)
+	}
+	srv := &Server{
+		IdentifierDatabase: identifier,
+		OciDatabase:        oci,
+		IndexData:          index,
+		Router:             mux.NewRouter(),
+		Datasets:           ds,
+	}
+	resp, err := srv.versions(context.Background())
+	if err != nil {
+		t.Fatalf("versions: %v", err)
+	}
+	// Test data has been created without meta table.
+	if resp.Oci.Path != ds.Oci || resp.Oci.Meta == nil || len(resp.Oci.Meta) != 0 {
+		t.Fatalf("unexpected oci version: %#v", resp.Oci)
+	}
+	if len(resp.Index) != 1 || resp.Index[0].Path != ds.Index[0] {
+		t.Fatalf("unexpected index versions: %#v", resp.Index)
+	}
+}